@@ -2,11 +2,16 @@ package api
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"net/http"
 	"netmaker-sync/internal/config"
 	"netmaker-sync/internal/models"
 	"netmaker-sync/swagger"
+	"sort"
+	"strconv"
 	"time"
 
 	"github.com/go-resty/resty/v2"
@@ -19,7 +24,6 @@ type Client struct {
 	restClient    *resty.Client
 	swaggerClient *swagger.APIClient
 	config        *config.NetmakerAPIConfig
-	ctx           context.Context
 }
 
 // New creates a new Netmaker API client
@@ -33,6 +37,11 @@ func New(cfg *config.NetmakerAPIConfig, loggingCfg *config.LoggingConfig) *Clien
 	restClient.SetRetryWaitTime(5 * time.Second)
 	restClient.SetRetryMaxWaitTime(20 * time.Second)
 
+	// Share one request budget between the REST and Swagger clients, so that
+	// concurrent syncs stay under the configured rate
+	limiter := newRateLimiter(cfg.RateLimit, cfg.RateBurst)
+	restClient.SetTransport(&rateLimitedTransport{base: &instrumentedTransport{base: tracedTransport(restClient.GetClient().Transport)}, limiter: limiter})
+
 	// Only enable Resty debug mode if we're in debug log level AND disable_resty_debug is false
 	isDebug := logrus.GetLevel() <= logrus.DebugLevel && !loggingCfg.DisableRestyDebug
 	restClient.SetDebug(isDebug)
@@ -61,7 +70,8 @@ func New(cfg *config.NetmakerAPIConfig, loggingCfg *config.LoggingConfig) *Clien
 	)
 
 	// Create an HTTP client with the token source
-	oauth2Client := oauth2.NewClient(context.Background(), tokenSource)
+	baseClient := &http.Client{Transport: &rateLimitedTransport{base: &instrumentedTransport{base: tracedTransport(http.DefaultTransport)}, limiter: limiter}}
+	oauth2Client := oauth2.NewClient(context.WithValue(context.Background(), oauth2.HTTPClient, baseClient), tokenSource)
 	swaggerConfig.HTTPClient = oauth2Client
 
 	// Create Swagger client
@@ -73,17 +83,17 @@ func New(cfg *config.NetmakerAPIConfig, loggingCfg *config.LoggingConfig) *Clien
 		restClient:    restClient,
 		swaggerClient: swaggerClient,
 		config:        cfg,
-		ctx:           context.Background(),
 	}
 }
 
 // GetNetworks retrieves all networks from the Netmaker API
-func (c *Client) GetNetworks() ([]models.Network, error) {
+func (c *Client) GetNetworks(ctx context.Context) ([]models.Network, error) {
+	ctx = withOperation(ctx, "get_networks")
 	logrus.Info("Retrieving networks from Netmaker API")
 
 	// Use the REST client directly since we're having issues with the Swagger client
 	// We'll use a map to parse the raw JSON first
-	resp, err := c.restClient.R().Get("/api/networks")
+	resp, err := c.restClient.R().SetContext(ctx).Get("/api/networks")
 	if err != nil {
 		logrus.Errorf("REST API error: %v", err)
 		return nil, fmt.Errorf("failed to get networks: %w", err)
@@ -159,11 +169,12 @@ func (c *Client) GetNetworks() ([]models.Network, error) {
 }
 
 // GetNodes retrieves all nodes for a network from the Netmaker API
-func (c *Client) GetNodes(networkID string) ([]models.Node, error) {
+func (c *Client) GetNodes(ctx context.Context, networkID string) ([]models.Node, error) {
+	ctx = withOperation(ctx, "get_nodes")
 	logrus.Debugf("Getting nodes for network %s using Swagger client", networkID)
 
 	// Use the Swagger client to get nodes
-	swaggerNodes, resp, err := c.swaggerClient.NodesApi.GetNetworkNodes(c.ctx, networkID)
+	swaggerNodes, resp, err := c.swaggerClient.NodesApi.GetNetworkNodes(ctx, networkID)
 	if err != nil {
 		logrus.Errorf("Swagger API error getting nodes: %v", err)
 		return nil, fmt.Errorf("failed to get nodes for network %s: %w", networkID, err)
@@ -220,11 +231,12 @@ func (c *Client) GetNodes(networkID string) ([]models.Node, error) {
 }
 
 // GetExtClients retrieves all external clients for a network from the Netmaker API
-func (c *Client) GetExtClients(networkID string) ([]models.ExtClient, error) {
+func (c *Client) GetExtClients(ctx context.Context, networkID string) ([]models.ExtClient, error) {
+	ctx = withOperation(ctx, "get_ext_clients")
 	logrus.Debugf("Getting ext clients for network %s using Swagger client", networkID)
 
 	// Use the Swagger client
-	swaggerExtClients, resp, err := c.swaggerClient.ExtClientApi.GetNetworkExtClients(c.ctx, networkID)
+	swaggerExtClients, resp, err := c.swaggerClient.ExtClientApi.GetNetworkExtClients(ctx, networkID)
 	if err != nil {
 		logrus.Errorf("Swagger API error getting ext clients: %v", err)
 		return nil, fmt.Errorf("failed to get external clients for network %s: %w", networkID, err)
@@ -277,37 +289,69 @@ func (c *Client) GetExtClients(networkID string) ([]models.ExtClient, error) {
 }
 
 // GetDNSEntries retrieves all DNS entries for a network from the Netmaker API
-func (c *Client) GetDNSEntries(networkID string) ([]models.DNSEntry, error) {
+func (c *Client) GetDNSEntries(ctx context.Context, networkID string) ([]models.DNSEntry, error) {
+	ctx = withOperation(ctx, "get_dns_entries")
 	logrus.Infof("Retrieving DNS entries for network %s from Netmaker API", networkID)
 
 	// Note: The Swagger client doesn't have a direct method for retrieving DNS entries
 	// so we'll use the REST client directly
-	var dnsEntries []models.DNSEntry
-	resp, err := c.restClient.R().SetResult(&dnsEntries).Get(fmt.Sprintf("/api/dns/%s", networkID))
+	var swaggerDNSEntries []swagger.DnsEntry
+	resp, err := c.restClient.R().SetContext(ctx).SetResult(&swaggerDNSEntries).Get(fmt.Sprintf("/api/dns/%s", networkID))
 	if err != nil {
 		return nil, fmt.Errorf("failed to retrieve DNS entries: %w", err)
 	}
 
+	// An error response must not be mistaken for an empty list, otherwise every
+	// entry in the network would be marked as deleted
+	if resp.IsError() {
+		logrus.Errorf("REST API error response: %s", resp.String())
+		return nil, fmt.Errorf("failed to retrieve DNS entries: %s", resp.Status())
+	}
+
 	logrus.Debugf("REST API response status for DNS entries: %s", resp.Status())
-	logrus.Debugf("Retrieved %d DNS entries from REST API", len(dnsEntries))
-	// No need to convert as we're using the REST client directly
+	logrus.Debugf("Retrieved %d DNS entries from REST API", len(swaggerDNSEntries))
+
+	// Convert to our internal model
+	dnsEntries := make([]models.DNSEntry, len(swaggerDNSEntries))
+	for i, swaggerDNSEntry := range swaggerDNSEntries {
+		// Netmaker has no ID for DNS entries, they are unique by name within a network
+		dnsEntries[i] = models.DNSEntry{
+			ID:           fmt.Sprintf("%s.%s", swaggerDNSEntry.Name, swaggerDNSEntry.Network),
+			Version:      1, // Default to version 1 for new DNS entries
+			NetworkID:    swaggerDNSEntry.Network,
+			Name:         swaggerDNSEntry.Name,
+			Address:      swaggerDNSEntry.Address,
+			Address6:     swaggerDNSEntry.Address6,
+			IsCurrent:    true,
+			LastModified: time.Now(),
+			CreatedAt:    time.Now(),
+		}
+	}
 
 	logrus.Infof("Retrieved and converted %d DNS entries for network %s from Netmaker API", len(dnsEntries), networkID)
 	return dnsEntries, nil
 }
 
 // GetACLs retrieves all ACLs for a network from the Netmaker API
-func (c *Client) GetACLs(networkID string) (map[string]map[string]int, error) {
+func (c *Client) GetACLs(ctx context.Context, networkID string) (map[string]map[string]int, error) {
+	ctx = withOperation(ctx, "get_acls")
 	logrus.Infof("Retrieving ACLs for network %s from Netmaker API", networkID)
 
 	// Note: The Swagger client doesn't have a direct method for retrieving ACLs
 	// so we'll use the REST client directly
 	var aclsMap map[string]map[string]int
-	resp, err := c.restClient.R().SetResult(&aclsMap).Get(fmt.Sprintf("/api/networks/%s/acls", networkID))
+	resp, err := c.restClient.R().SetContext(ctx).SetResult(&aclsMap).Get(fmt.Sprintf("/api/networks/%s/acls", networkID))
 	if err != nil {
 		return nil, fmt.Errorf("failed to retrieve ACLs: %w", err)
 	}
 
+	// An error response must not be mistaken for an empty map, otherwise every
+	// ACL in the network would be marked as deleted
+	if resp.IsError() {
+		logrus.Errorf("REST API error response: %s", resp.String())
+		return nil, fmt.Errorf("failed to retrieve ACLs: %s", resp.Status())
+	}
+
 	logrus.Debugf("REST API response status for ACLs: %s", resp.Status())
 	logrus.Debugf("Retrieved ACL map from REST API")
 
@@ -316,11 +360,12 @@ func (c *Client) GetACLs(networkID string) (map[string]map[string]int, error) {
 }
 
 // GetHosts retrieves all hosts from the Netmaker API
-func (c *Client) GetHosts() ([]models.Host, error) {
+func (c *Client) GetHosts(ctx context.Context) ([]models.Host, error) {
+	ctx = withOperation(ctx, "get_hosts")
 	logrus.Info("Retrieving hosts from Netmaker API")
 
 	// Use the Swagger client to get hosts
-	swaggerHosts, resp, err := c.swaggerClient.HostsApi.GetHosts(c.ctx)
+	swaggerHosts, resp, err := c.swaggerClient.HostsApi.GetHosts(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to retrieve hosts: %w", err)
 	}
@@ -374,3 +419,220 @@ func (c *Client) GetHosts() ([]models.Host, error) {
 	logrus.Infof("Retrieved and converted %d hosts from Netmaker API", len(hosts))
 	return hosts, nil
 }
+
+// GetStatus checks that the Netmaker API is reachable by querying its status endpoint
+func (c *Client) GetStatus(ctx context.Context) error {
+	ctx = withOperation(ctx, "get_status")
+
+	// Only the response status matters, so a body that does not decode as the
+	// documented model still shows that the API is up
+	_, resp, err := c.swaggerClient.ServerApi.GetStatus(ctx)
+	if resp != nil && resp.StatusCode < http.StatusMultipleChoices {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get Netmaker server status: %w", err)
+	}
+	return fmt.Errorf("failed to get Netmaker server status: %s", resp.Status)
+}
+
+// enrollmentKeyTypes names the Netmaker enrollment key types
+var enrollmentKeyTypes = map[int]string{
+	0: models.EnrollmentKeyTypeUndefined,
+	1: models.EnrollmentKeyTypeTimeExpiration,
+	2: models.EnrollmentKeyTypeUses,
+	3: models.EnrollmentKeyTypeUnlimited,
+}
+
+// enrollmentKey is an enrollment key as returned by the Netmaker API. The generated
+// swagger.KeyType has no fields, while Netmaker sends the key type as a number.
+type enrollmentKey struct {
+	swagger.EnrollmentKey
+	Type_ int `json:"type"`
+}
+
+// enrollmentKeyID derives a stable ID for an enrollment key from its value, which is a
+// secret and so cannot be used as the ID itself
+func enrollmentKeyID(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:16])
+}
+
+// GetEnrollmentKeys retrieves all enrollment keys from the Netmaker API
+func (c *Client) GetEnrollmentKeys(ctx context.Context) ([]models.EnrollmentKey, error) {
+	ctx = withOperation(ctx, "get_enrollment_keys")
+	logrus.Info("Retrieving enrollment keys from Netmaker API")
+
+	// Use the REST client since the Swagger client cannot decode the key type
+	resp, err := c.restClient.R().SetContext(ctx).Get("/api/v1/enrollment-keys")
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve enrollment keys: %w", err)
+	}
+
+	// An error response must not be mistaken for an empty list, otherwise every
+	// key would be marked as deleted
+	if resp.IsError() {
+		logrus.Errorf("REST API error response: %s", resp.Status())
+		return nil, fmt.Errorf("failed to retrieve enrollment keys: %s", resp.Status())
+	}
+
+	var swaggerKeys []enrollmentKey
+	if err := json.Unmarshal(resp.Body(), &swaggerKeys); err != nil {
+		return nil, fmt.Errorf("failed to parse enrollment keys response: %w", err)
+	}
+
+	// Keep every field returned in the data, the value and token are protected before
+	// they are stored
+	var keysData []models.JSONB
+	if err := json.Unmarshal(resp.Body(), &keysData); err != nil {
+		return nil, fmt.Errorf("failed to parse enrollment keys response: %w", err)
+	}
+
+	logrus.Debugf("Retrieved %d enrollment keys from REST API", len(swaggerKeys))
+
+	keys := make([]models.EnrollmentKey, len(swaggerKeys))
+	for i, swaggerKey := range swaggerKeys {
+		keyType, ok := enrollmentKeyTypes[swaggerKey.Type_]
+		if !ok {
+			keyType = strconv.Itoa(swaggerKey.Type_)
+		}
+
+		// Keys that never expire have a zero expiration
+		var expiration *time.Time
+		if !swaggerKey.Expiration.IsZero() {
+			t := swaggerKey.Expiration.UTC()
+			expiration = &t
+		}
+
+		keys[i] = models.EnrollmentKey{
+			ID:            enrollmentKeyID(swaggerKey.Value),
+			Version:       1, // Default to version 1 for new enrollment keys
+			Networks:      models.StringList(swaggerKey.Networks),
+			Tags:          models.StringList(swaggerKey.Tags),
+			Type:          keyType,
+			UsesRemaining: int(swaggerKey.UsesRemaining),
+			Unlimited:     swaggerKey.Unlimited,
+			Expiration:    expiration,
+			IsCurrent:     true,
+			LastModified:  time.Now(),
+			CreatedAt:     time.Now(),
+			Data:          keysData[i],
+		}
+	}
+
+	logrus.Infof("Retrieved and converted %d enrollment keys from Netmaker API", len(keys))
+	return keys, nil
+}
+
+// GetUsers retrieves all users from the Netmaker API
+func (c *Client) GetUsers(ctx context.Context) ([]models.User, error) {
+	ctx = withOperation(ctx, "get_users")
+	logrus.Info("Retrieving users from Netmaker API")
+
+	// Use the REST client since the Swagger client decodes the list of users as a
+	// single user
+	resp, err := c.restClient.R().SetContext(ctx).Get("/api/users")
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve users: %w", err)
+	}
+
+	// An error response must not be mistaken for an empty list, otherwise every
+	// user would be marked as deleted
+	if resp.IsError() {
+		logrus.Errorf("REST API error response: %s", resp.String())
+		return nil, fmt.Errorf("failed to retrieve users: %s", resp.Status())
+	}
+
+	var swaggerUsers []swagger.User
+	if err := json.Unmarshal(resp.Body(), &swaggerUsers); err != nil {
+		return nil, fmt.Errorf("failed to parse users response: %w", err)
+	}
+
+	// Keep every field returned in the data, the password is protected before it is
+	// stored
+	var usersData []models.JSONB
+	if err := json.Unmarshal(resp.Body(), &usersData); err != nil {
+		return nil, fmt.Errorf("failed to parse users response: %w", err)
+	}
+
+	logrus.Debugf("Retrieved %d users from REST API", len(swaggerUsers))
+
+	users := make([]models.User, len(swaggerUsers))
+	for i, swaggerUser := range swaggerUsers {
+		// remote_gw_ids is a set of gateway node IDs
+		gatewayIDs := make(models.StringList, 0, len(swaggerUser.RemoteGwIds))
+		for gatewayID := range swaggerUser.RemoteGwIds {
+			gatewayIDs = append(gatewayIDs, gatewayID)
+		}
+		sort.Strings(gatewayIDs)
+
+		users[i] = models.User{
+			ID:               swaggerUser.Username,
+			Version:          1, // Default to version 1 for new users
+			IsAdmin:          swaggerUser.Isadmin,
+			IsSuperAdmin:     swaggerUser.Issuperadmin,
+			RemoteGatewayIDs: gatewayIDs,
+			IsCurrent:        true,
+			LastModified:     time.Now(),
+			CreatedAt:        time.Now(),
+			Data:             usersData[i],
+		}
+	}
+
+	logrus.Infof("Retrieved and converted %d users from Netmaker API", len(users))
+	return users, nil
+}
+
+// serverConfig is the server configuration as returned by the Netmaker API. The
+// generated swagger.Duration has no fields, while Netmaker sends durations as numbers.
+type serverConfig struct {
+	swagger.ServerConfig
+	JwtValidityDuration json.RawMessage `json:"JwtValidityDuration,omitempty"`
+}
+
+// GetServerConfig retrieves the configuration of the Netmaker server from the Netmaker API
+func (c *Client) GetServerConfig(ctx context.Context) (*models.ServerConfig, error) {
+	ctx = withOperation(ctx, "get_server_config")
+	logrus.Info("Retrieving server configuration from Netmaker API")
+
+	// Use the REST client since the Swagger client cannot decode the durations
+	resp, err := c.restClient.R().SetContext(ctx).Get("/api/server/getconfig")
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve server config: %w", err)
+	}
+
+	if resp.IsError() {
+		logrus.Errorf("REST API error response: %s", resp.Status())
+		return nil, fmt.Errorf("failed to retrieve server config: %s", resp.Status())
+	}
+
+	var swaggerConfig serverConfig
+	if err := json.Unmarshal(resp.Body(), &swaggerConfig); err != nil {
+		return nil, fmt.Errorf("failed to parse server config response: %w", err)
+	}
+
+	// Keep every field returned in the data, the credentials are dropped before they
+	// are stored
+	var configData models.JSONB
+	if err := json.Unmarshal(resp.Body(), &configData); err != nil {
+		return nil, fmt.Errorf("failed to parse server config response: %w", err)
+	}
+
+	logrus.Infof("Retrieved configuration of Netmaker server version %s", swaggerConfig.Version)
+	return &models.ServerConfig{
+		ID:             models.ServerConfigID,
+		Version:        1, // Default to version 1 for a new server configuration
+		ServerVersion:  swaggerConfig.Version,
+		IsEE:           swaggerConfig.IsEE == "yes",
+		DNSMode:        swaggerConfig.DNSMode,
+		NetworksLimit:  int(swaggerConfig.NetworksLimit),
+		MachinesLimit:  int(swaggerConfig.MachinesLimit),
+		UsersLimit:     int(swaggerConfig.UsersLimit),
+		IngressesLimit: int(swaggerConfig.IngressesLimit),
+		EgressesLimit:  int(swaggerConfig.EgressesLimit),
+		IsCurrent:      true,
+		LastModified:   time.Now(),
+		CreatedAt:      time.Now(),
+		Data:           configData,
+	}, nil
+}
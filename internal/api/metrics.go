@@ -0,0 +1,40 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"netmaker-sync/internal/metrics"
+	"time"
+)
+
+// operationKey is the context key of the Client method a request is sent for
+type operationKey struct{}
+
+// withOperation returns a copy of ctx naming the Client method its requests are sent for,
+// which labels their metrics
+func withOperation(ctx context.Context, operation string) context.Context {
+	return context.WithValue(ctx, operationKey{}, operation)
+}
+
+// instrumentedTransport records the latency and status code of each request, including
+// retries
+type instrumentedTransport struct {
+	base http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	operation, ok := req.Context().Value(operationKey{}).(string)
+	if !ok {
+		operation = "other"
+	}
+
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	statusCode := 0
+	if err == nil {
+		statusCode = resp.StatusCode
+	}
+	metrics.ObserveAPIRequest(operation, req.Method, statusCode, time.Since(start))
+	return resp, err
+}
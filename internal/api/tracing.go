@@ -0,0 +1,20 @@
+package api
+
+import (
+	"net/http"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// tracedTransport traces each request, including retries, in a span named after the
+// Client method it is sent for (e.g. netmaker.get_nodes) and propagates the trace
+// context to the Netmaker API
+func tracedTransport(base http.RoundTripper) http.RoundTripper {
+	return otelhttp.NewTransport(base, otelhttp.WithSpanNameFormatter(func(_ string, req *http.Request) string {
+		operation, ok := req.Context().Value(operationKey{}).(string)
+		if !ok {
+			operation = "other"
+		}
+		return "netmaker." + operation
+	}))
+}
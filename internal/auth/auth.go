@@ -0,0 +1,267 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"netmaker-sync/internal/config"
+	"netmaker-sync/internal/db"
+	"netmaker-sync/internal/models"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrUnauthenticated is returned when a request has no valid credentials
+var ErrUnauthenticated = errors.New("missing or invalid credentials")
+
+// Role is the level of access of a principal. Each role includes the ones below it.
+type Role int
+
+// Role constants, from least to most privileged
+const (
+	RoleNone Role = iota
+	// RoleReader can read the mirrored data, sync runs, jobs and change events
+	RoleReader
+	// RoleSyncer can also trigger and cancel syncs
+	RoleSyncer
+	// RoleAdmin can also manage webhooks, read the audit log and decrypt sensitive fields
+	RoleAdmin
+)
+
+// roleNames maps the roles to the names used in configuration and tokens
+var roleNames = map[Role]string{
+	RoleNone:   "none",
+	RoleReader: "reader",
+	RoleSyncer: "syncer",
+	RoleAdmin:  "admin",
+}
+
+// String returns the name of the role
+func (r Role) String() string {
+	return roleNames[r]
+}
+
+// ParseRole returns the role with the given name
+func ParseRole(name string) (Role, error) {
+	for role, roleName := range roleNames {
+		if role != RoleNone && strings.EqualFold(name, roleName) {
+			return role, nil
+		}
+	}
+	return RoleNone, fmt.Errorf("unknown role %q, expected reader, syncer or admin", name)
+}
+
+// Authentication methods of a principal
+const (
+	MethodAnonymous = "anonymous"
+	MethodAPIKey    = "api_key"
+	MethodOIDC      = "oidc"
+)
+
+// Principal is the caller of a request
+type Principal struct {
+	Subject string
+	Method  string
+	Role    Role
+}
+
+// principalKey is the context key of the principal of a request
+type principalKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying the principal of a request
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFromContext returns the principal of a request, or nil
+func PrincipalFromContext(ctx context.Context) *Principal {
+	principal, _ := ctx.Value(principalKey{}).(*Principal)
+	return principal
+}
+
+// apiKey is a configured API key, kept as a digest so that keys are compared in constant time
+type apiKey struct {
+	name   string
+	role   Role
+	digest [sha256.Size]byte
+}
+
+// Authenticator authenticates HTTP API requests with API keys or OIDC bearer tokens,
+// and keeps the audit log
+type Authenticator struct {
+	db            db.AuditStore
+	disabled      bool
+	apiKeys       []apiKey
+	verifier      *oidc.IDTokenVerifier
+	rolesClaim    string
+	usernameClaim string
+}
+
+// New creates an authenticator. When an OIDC issuer is configured without a JWKS URL,
+// the keys are discovered from the issuer, which must be reachable. ctx bounds the
+// lifetime of the key set. Without API keys or an OIDC issuer it fails unless
+// authentication is explicitly disabled.
+func New(ctx context.Context, database db.AuditStore, cfg *config.AuthConfig) (*Authenticator, error) {
+	a := &Authenticator{
+		db:            database,
+		disabled:      cfg.Disabled,
+		rolesClaim:    cfg.OIDCRolesClaim,
+		usernameClaim: cfg.OIDCUsernameClaim,
+	}
+
+	if a.disabled {
+		logrus.Warn("Authentication is disabled, anyone who can reach the HTTP API can read it")
+		return a, nil
+	}
+
+	for _, key := range cfg.APIKeys {
+		role, err := ParseRole(key.Role)
+		if err != nil {
+			return nil, fmt.Errorf("invalid role of API key %s: %w", key.Name, err)
+		}
+		a.apiKeys = append(a.apiKeys, apiKey{name: key.Name, role: role, digest: sha256.Sum256([]byte(key.Key))})
+	}
+
+	if cfg.OIDCIssuer != "" {
+		oidcConfig := &oidc.Config{ClientID: cfg.OIDCAudience, SkipClientIDCheck: cfg.OIDCAudience == ""}
+		if cfg.OIDCJWKSURL != "" {
+			a.verifier = oidc.NewVerifier(cfg.OIDCIssuer, oidc.NewRemoteKeySet(ctx, cfg.OIDCJWKSURL), oidcConfig)
+		} else {
+			provider, err := oidc.NewProvider(ctx, cfg.OIDCIssuer)
+			if err != nil {
+				return nil, fmt.Errorf("failed to discover OIDC issuer %s: %w", cfg.OIDCIssuer, err)
+			}
+			a.verifier = provider.Verifier(oidcConfig)
+		}
+	}
+
+	if len(a.apiKeys) == 0 && a.verifier == nil {
+		return nil, errors.New("no API keys or OIDC issuer configured, set auth.api_keys or auth.oidc_issuer, or auth.disabled to allow anonymous access on a loopback address")
+	}
+	return a, nil
+}
+
+// Enabled reports whether requests must be authenticated
+func (a *Authenticator) Enabled() bool {
+	return !a.disabled
+}
+
+// Authenticate returns the principal of a request. API keys are read from the
+// X-API-Key header or as a bearer token; other bearer tokens must be JWTs signed by
+// the OIDC issuer. When authentication is disabled every request is an anonymous reader.
+func (a *Authenticator) Authenticate(r *http.Request) (*Principal, error) {
+	if !a.Enabled() {
+		return &Principal{Subject: MethodAnonymous, Method: MethodAnonymous, Role: RoleReader}, nil
+	}
+
+	token := r.Header.Get("X-API-Key")
+	if token == "" {
+		scheme, value, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") {
+			return nil, ErrUnauthenticated
+		}
+		token = strings.TrimSpace(value)
+	}
+	if token == "" {
+		return nil, ErrUnauthenticated
+	}
+
+	if principal := a.authenticateAPIKey(token); principal != nil {
+		return principal, nil
+	}
+	if a.verifier == nil || r.Header.Get("X-API-Key") != "" {
+		return nil, ErrUnauthenticated
+	}
+	return a.authenticateToken(r.Context(), token)
+}
+
+// authenticateAPIKey returns the principal of an API key, or nil if it is not configured
+func (a *Authenticator) authenticateAPIKey(token string) *Principal {
+	digest := sha256.Sum256([]byte(token))
+	var match *apiKey
+	for i := range a.apiKeys {
+		// Compare with every key so that the time taken does not reveal which one matched
+		if subtle.ConstantTimeCompare(digest[:], a.apiKeys[i].digest[:]) == 1 {
+			match = &a.apiKeys[i]
+		}
+	}
+	if match == nil {
+		return nil
+	}
+	return &Principal{Subject: match.name, Method: MethodAPIKey, Role: match.role}
+}
+
+// authenticateToken verifies an OIDC bearer token and maps its roles claim to a role.
+// A token without a known role authenticates with RoleNone.
+func (a *Authenticator) authenticateToken(ctx context.Context, token string) (*Principal, error) {
+	idToken, err := a.verifier.Verify(ctx, token)
+	if err != nil {
+		logrus.Debugf("Rejected bearer token: %v", err)
+		return nil, ErrUnauthenticated
+	}
+
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("failed to decode token claims: %w", err)
+	}
+
+	subject, _ := claimValue(claims, a.usernameClaim).(string)
+	if subject == "" {
+		subject = idToken.Subject
+	}
+
+	principal := &Principal{Subject: subject, Method: MethodOIDC, Role: RoleNone}
+	var roles []interface{}
+	switch value := claimValue(claims, a.rolesClaim).(type) {
+	case string:
+		roles = []interface{}{value}
+	case []interface{}:
+		roles = value
+	}
+	for _, value := range roles {
+		name, _ := value.(string)
+		if role, err := ParseRole(name); err == nil && role > principal.Role {
+			principal.Role = role
+		}
+	}
+	return principal, nil
+}
+
+// claimValue looks up a claim by name. Dots in the name select nested claims, as in
+// realm_access.roles.
+func claimValue(claims map[string]interface{}, name string) interface{} {
+	var value interface{} = claims
+	for _, key := range strings.Split(name, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = object[key]
+	}
+	return value
+}
+
+// RecordAudit appends a request to the audit log
+func (a *Authenticator) RecordAudit(principal *Principal, r *http.Request, statusCode int) error {
+	return a.db.CreateAuditEntry(&models.AuditEntry{
+		Principal:  principal.Subject,
+		AuthMethod: principal.Method,
+		Role:       principal.Role.String(),
+		Method:     r.Method,
+		Path:       r.URL.RequestURI(),
+		StatusCode: statusCode,
+		RemoteAddr: r.RemoteAddr,
+		OccurredAt: time.Now(),
+	})
+}
+
+// GetAuditLog retrieves the most recent entries of the audit log, newest first
+func (a *Authenticator) GetAuditLog(limit int) ([]models.AuditEntry, error) {
+	return a.db.GetAuditEntries(limit)
+}
@@ -1,6 +1,9 @@
 package config
 
 import (
+	"fmt"
+	"net"
+	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -14,34 +17,116 @@ type Config struct {
 	Database    DatabaseConfig
 	Sync        SyncConfig
 	API         APIConfig
+	Auth        AuthConfig
+	Secrets     SecretsConfig
 	Logging     LoggingConfig
+	Tracing     TracingConfig
+	Webhooks    WebhooksConfig
 }
 
 // NetmakerAPIConfig holds Netmaker API specific configuration
 type NetmakerAPIConfig struct {
-	URL string
-	Key string
+	URL       string
+	Key       string
+	RateLimit float64
+	RateBurst int
 }
 
 // DatabaseConfig holds database specific configuration
 type DatabaseConfig struct {
-	Host     string
-	Port     int
-	Name     string
-	User     string
-	Password string
+	Driver        string
+	Path          string
+	Host          string
+	Port          int
+	Name          string
+	User          string
+	Password      string
+	NotifyChannel string
+	AutoMigrate   bool
 }
 
 // SyncConfig holds synchronization specific configuration
 type SyncConfig struct {
-	Interval    time.Duration
-	IncludeAcls bool
+	Interval            time.Duration
+	IncludeAcls         bool
+	Incremental         bool
+	FullSyncInterval    time.Duration
+	Concurrency         int
+	StaleIntervals      int
+	VolatileFields      []VolatileFieldConfig
+	HeartbeatStaleAfter time.Duration
+	HeartbeatRetention  time.Duration
+}
+
+// VolatileFieldConfig is a JSON path of the data of a resource type whose changes do not
+// create a new version
+type VolatileFieldConfig struct {
+	ResourceType string
+	Path         string
 }
 
 // APIConfig holds API server specific configuration
 type APIConfig struct {
-	Host string
-	Port int
+	Host        string
+	Port        int
+	CORSOrigins []string
+}
+
+// AuthConfig holds HTTP API authentication specific configuration. At least one API
+// key or an OIDC issuer must be configured unless authentication is explicitly disabled.
+type AuthConfig struct {
+	Disabled          bool
+	APIKeys           []APIKeyConfig
+	OIDCIssuer        string
+	OIDCAudience      string
+	OIDCJWKSURL       string
+	OIDCRolesClaim    string
+	OIDCUsernameClaim string
+}
+
+// APIKeyConfig is a static API key and the role it grants
+type APIKeyConfig struct {
+	Name string
+	Role string
+	Key  string
+}
+
+// SecretsConfig holds the protection of sensitive Netmaker fields before they are stored.
+// The first encryption key encrypts new values; the others only decrypt older ones.
+type SecretsConfig struct {
+	EncryptionKeys []EncryptionKeyConfig
+	HashKey        string
+	Policies       []FieldPolicyConfig
+}
+
+// EncryptionKeyConfig is a base64-encoded 256-bit AES key and the ID it is stored under
+type EncryptionKeyConfig struct {
+	ID  string
+	Key string
+}
+
+// FieldPolicyConfig overrides how a JSON path of a resource type is stored
+type FieldPolicyConfig struct {
+	ResourceType string
+	Path         string
+	Action       string
+}
+
+// WebhooksConfig holds outbound webhook specific configuration
+type WebhooksConfig struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Timeout        time.Duration
+	PollInterval   time.Duration
+}
+
+// TracingConfig holds OpenTelemetry tracing specific configuration. Spans are exported
+// over OTLP/HTTP when an endpoint is configured.
+type TracingConfig struct {
+	OTLPEndpoint string
+	ServiceName  string
+	SampleRatio  float64
 }
 
 // LoggingConfig holds logging specific configuration
@@ -71,30 +156,95 @@ func Load() (*Config, error) {
 	// Set default values
 	viper.SetDefault("netmaker_api.url", "https://api.netmaker.example.com")
 	viper.SetDefault("netmaker_api.key", "")
+	viper.SetDefault("netmaker_api.rate_limit", 10)
+	viper.SetDefault("netmaker_api.rate_burst", 10)
+	viper.SetDefault("database.driver", "postgres")
+	viper.SetDefault("database.path", "netmaker_sync.db")
 	viper.SetDefault("database.host", "localhost")
 	viper.SetDefault("database.port", 5432)
 	viper.SetDefault("database.name", "netmaker_sync")
 	viper.SetDefault("database.user", "postgres")
 	viper.SetDefault("database.password", "postgres")
+	viper.SetDefault("database.notify_channel", "netmaker_sync_changes")
 	viper.SetDefault("sync.interval", "5m")
+	viper.SetDefault("sync.incremental", true)
+	viper.SetDefault("sync.concurrency", 4)
+	viper.SetDefault("sync.full_sync_interval", "1h")
+	viper.SetDefault("sync.stale_intervals", 3)
+	viper.SetDefault("sync.heartbeat_stale_after", "15m")
+	viper.SetDefault("sync.heartbeat_retention", "720h")
+	viper.SetDefault("sync.volatile_fields", "network:nodeslastmodified,network:networklastmodified,"+
+		"node:lastcheckin,node:lastpeerupdate,node:lastmodified,ext_client:lastmodified,user:last_login_time")
 	viper.SetDefault("api.host", "0.0.0.0")
 	viper.SetDefault("api.port", 8080)
+	viper.SetDefault("api.cors_origins", "")
+	viper.SetDefault("auth.disabled", false)
+	viper.SetDefault("auth.api_keys", "")
+	viper.SetDefault("auth.oidc_issuer", "")
+	viper.SetDefault("auth.oidc_audience", "")
+	viper.SetDefault("auth.oidc_jwks_url", "")
+	viper.SetDefault("auth.oidc_roles_claim", "roles")
+	viper.SetDefault("auth.oidc_username_claim", "sub")
+	viper.SetDefault("secrets.encryption_keys", "")
+	viper.SetDefault("secrets.hash_key", "")
+	viper.SetDefault("secrets.policies", "")
 	viper.SetDefault("logging.level", "info")
 	viper.SetDefault("logging.disable_resty_debug", true)
+	viper.SetDefault("database.auto_migrate", true)
+	viper.SetDefault("tracing.otlp_endpoint", "")
+	viper.SetDefault("tracing.service_name", "netmaker-sync")
+	viper.SetDefault("tracing.sample_ratio", 1.0)
+	viper.SetDefault("webhooks.max_attempts", 8)
+	viper.SetDefault("webhooks.initial_backoff", "10s")
+	viper.SetDefault("webhooks.max_backoff", "1h")
+	viper.SetDefault("webhooks.timeout", "10s")
+	viper.SetDefault("webhooks.poll_interval", "5s")
 
 	// Map environment variables to viper keys
 	viper.BindEnv("netmaker_api.url", "NETMAKER_API_URL")
 	viper.BindEnv("netmaker_api.key", "NETMAKER_API_KEY")
+	viper.BindEnv("netmaker_api.rate_limit", "NETMAKER_API_RATE_LIMIT")
+	viper.BindEnv("netmaker_api.rate_burst", "NETMAKER_API_RATE_BURST")
+	viper.BindEnv("database.driver", "DB_DRIVER")
+	viper.BindEnv("database.path", "DB_PATH")
 	viper.BindEnv("database.host", "DB_HOST")
 	viper.BindEnv("database.port", "DB_PORT")
 	viper.BindEnv("database.name", "DB_NAME")
 	viper.BindEnv("database.user", "DB_USER")
 	viper.BindEnv("database.password", "DB_PASSWORD")
+	viper.BindEnv("database.notify_channel", "DB_NOTIFY_CHANNEL")
+	viper.BindEnv("database.auto_migrate", "DB_AUTO_MIGRATE")
 	viper.BindEnv("sync.interval", "SYNC_INTERVAL")
+	viper.BindEnv("sync.incremental", "SYNC_INCREMENTAL")
+	viper.BindEnv("sync.concurrency", "SYNC_CONCURRENCY")
+	viper.BindEnv("sync.full_sync_interval", "SYNC_FULL_SYNC_INTERVAL")
+	viper.BindEnv("sync.stale_intervals", "SYNC_STALE_INTERVALS")
+	viper.BindEnv("sync.volatile_fields", "SYNC_VOLATILE_FIELDS")
+	viper.BindEnv("sync.heartbeat_stale_after", "SYNC_HEARTBEAT_STALE_AFTER")
+	viper.BindEnv("sync.heartbeat_retention", "SYNC_HEARTBEAT_RETENTION")
 	viper.BindEnv("api.host", "API_HOST")
 	viper.BindEnv("api.port", "API_PORT")
+	viper.BindEnv("api.cors_origins", "API_CORS_ORIGINS")
+	viper.BindEnv("auth.disabled", "AUTH_DISABLED")
+	viper.BindEnv("auth.api_keys", "AUTH_API_KEYS")
+	viper.BindEnv("auth.oidc_issuer", "AUTH_OIDC_ISSUER")
+	viper.BindEnv("auth.oidc_audience", "AUTH_OIDC_AUDIENCE")
+	viper.BindEnv("auth.oidc_jwks_url", "AUTH_OIDC_JWKS_URL")
+	viper.BindEnv("auth.oidc_roles_claim", "AUTH_OIDC_ROLES_CLAIM")
+	viper.BindEnv("auth.oidc_username_claim", "AUTH_OIDC_USERNAME_CLAIM")
+	viper.BindEnv("secrets.encryption_keys", "SECRETS_ENCRYPTION_KEYS")
+	viper.BindEnv("secrets.hash_key", "SECRETS_HASH_KEY")
+	viper.BindEnv("secrets.policies", "SECRETS_POLICIES")
 	viper.BindEnv("logging.level", "LOG_LEVEL")
 	viper.BindEnv("logging.disable_resty_debug", "DISABLE_RESTY_DEBUG")
+	viper.BindEnv("tracing.otlp_endpoint", "TRACING_OTLP_ENDPOINT")
+	viper.BindEnv("tracing.service_name", "TRACING_SERVICE_NAME")
+	viper.BindEnv("tracing.sample_ratio", "TRACING_SAMPLE_RATIO")
+	viper.BindEnv("webhooks.max_attempts", "WEBHOOK_MAX_ATTEMPTS")
+	viper.BindEnv("webhooks.initial_backoff", "WEBHOOK_INITIAL_BACKOFF")
+	viper.BindEnv("webhooks.max_backoff", "WEBHOOK_MAX_BACKOFF")
+	viper.BindEnv("webhooks.timeout", "WEBHOOK_TIMEOUT")
+	viper.BindEnv("webhooks.poll_interval", "WEBHOOK_POLL_INTERVAL")
 
 	// Enable environment variables
 	viper.AutomaticEnv()
@@ -110,10 +260,35 @@ func Load() (*Config, error) {
 		logrus.Infof("Using config file: %s", viper.ConfigFileUsed())
 	}
 
-	syncInterval, err := time.ParseDuration(viper.GetString("sync.interval"))
+	syncInterval := getDuration("sync.interval", 5*time.Minute)
+
+	apiKeys, err := parseAPIKeys(getList("auth.api_keys"))
+	if err != nil {
+		return nil, err
+	}
+
+	// Anonymous access is only allowed when the API cannot be reached from other hosts
+	authDisabled := viper.GetBool("auth.disabled")
+	if authDisabled && (len(apiKeys) > 0 || viper.GetString("auth.oidc_issuer") != "") {
+		return nil, fmt.Errorf("auth.disabled cannot be combined with auth.api_keys or auth.oidc_issuer")
+	}
+	if authDisabled && !isLoopback(viper.GetString("api.host")) {
+		return nil, fmt.Errorf("auth.disabled requires api.host to be a loopback address, got %q", viper.GetString("api.host"))
+	}
+
+	encryptionKeys, err := parseEncryptionKeys(getList("secrets.encryption_keys"))
+	if err != nil {
+		return nil, err
+	}
+
+	policies, err := parseFieldPolicies(getList("secrets.policies"))
+	if err != nil {
+		return nil, err
+	}
+
+	volatileFields, err := parseVolatileFields(getList("sync.volatile_fields"))
 	if err != nil {
-		logrus.Warnf("Invalid sync.interval: %s, using default 5m", viper.GetString("sync.interval"))
-		syncInterval = 5 * time.Minute
+		return nil, err
 	}
 
 	// Log the configuration values for debugging
@@ -124,27 +299,151 @@ func Load() (*Config, error) {
 
 	return &Config{
 		NetmakerAPI: NetmakerAPIConfig{
-			URL: viper.GetString("netmaker_api.url"),
-			Key: viper.GetString("netmaker_api.key"),
+			URL:       viper.GetString("netmaker_api.url"),
+			Key:       viper.GetString("netmaker_api.key"),
+			RateLimit: viper.GetFloat64("netmaker_api.rate_limit"),
+			RateBurst: viper.GetInt("netmaker_api.rate_burst"),
 		},
 		Database: DatabaseConfig{
-			Host:     viper.GetString("database.host"),
-			Port:     viper.GetInt("database.port"),
-			Name:     viper.GetString("database.name"),
-			User:     viper.GetString("database.user"),
-			Password: viper.GetString("database.password"),
+			Driver:        viper.GetString("database.driver"),
+			Path:          viper.GetString("database.path"),
+			Host:          viper.GetString("database.host"),
+			Port:          viper.GetInt("database.port"),
+			Name:          viper.GetString("database.name"),
+			User:          viper.GetString("database.user"),
+			Password:      viper.GetString("database.password"),
+			NotifyChannel: viper.GetString("database.notify_channel"),
+			AutoMigrate:   viper.GetBool("database.auto_migrate"),
 		},
 		Sync: SyncConfig{
-			Interval:    syncInterval,
-			IncludeAcls: viper.GetBool("sync.include_acls"),
+			Interval:            syncInterval,
+			IncludeAcls:         viper.GetBool("sync.include_acls"),
+			Incremental:         viper.GetBool("sync.incremental"),
+			FullSyncInterval:    getDuration("sync.full_sync_interval", time.Hour),
+			Concurrency:         viper.GetInt("sync.concurrency"),
+			StaleIntervals:      viper.GetInt("sync.stale_intervals"),
+			VolatileFields:      volatileFields,
+			HeartbeatStaleAfter: getDuration("sync.heartbeat_stale_after", 15*time.Minute),
+			HeartbeatRetention:  getDuration("sync.heartbeat_retention", 30*24*time.Hour),
 		},
 		API: APIConfig{
-			Host: viper.GetString("api.host"),
-			Port: viper.GetInt("api.port"),
+			Host:        viper.GetString("api.host"),
+			Port:        viper.GetInt("api.port"),
+			CORSOrigins: getList("api.cors_origins"),
+		},
+		Auth: AuthConfig{
+			Disabled:          authDisabled,
+			APIKeys:           apiKeys,
+			OIDCIssuer:        viper.GetString("auth.oidc_issuer"),
+			OIDCAudience:      viper.GetString("auth.oidc_audience"),
+			OIDCJWKSURL:       viper.GetString("auth.oidc_jwks_url"),
+			OIDCRolesClaim:    viper.GetString("auth.oidc_roles_claim"),
+			OIDCUsernameClaim: viper.GetString("auth.oidc_username_claim"),
+		},
+		Secrets: SecretsConfig{
+			EncryptionKeys: encryptionKeys,
+			HashKey:        viper.GetString("secrets.hash_key"),
+			Policies:       policies,
 		},
 		Logging: LoggingConfig{
 			Level:             viper.GetString("logging.level"),
 			DisableRestyDebug: viper.GetBool("logging.disable_resty_debug"),
 		},
+		Tracing: TracingConfig{
+			OTLPEndpoint: viper.GetString("tracing.otlp_endpoint"),
+			ServiceName:  viper.GetString("tracing.service_name"),
+			SampleRatio:  viper.GetFloat64("tracing.sample_ratio"),
+		},
+		Webhooks: WebhooksConfig{
+			MaxAttempts:    viper.GetInt("webhooks.max_attempts"),
+			InitialBackoff: getDuration("webhooks.initial_backoff", 10*time.Second),
+			MaxBackoff:     getDuration("webhooks.max_backoff", time.Hour),
+			Timeout:        getDuration("webhooks.timeout", 10*time.Second),
+			PollInterval:   getDuration("webhooks.poll_interval", 5*time.Second),
+		},
 	}, nil
 }
+
+// getDuration parses a duration setting, falling back to the given default if it is invalid
+func getDuration(key string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(viper.GetString(key))
+	if err != nil {
+		logrus.Warnf("Invalid %s: %s, using default %s", key, viper.GetString(key), fallback)
+		return fallback
+	}
+	return value
+}
+
+// getList reads a list setting, given either as a YAML list or as a comma-separated string
+func getList(key string) []string {
+	var list []string
+	for _, value := range viper.GetStringSlice(key) {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+	}
+	return list
+}
+
+// parseAPIKeys parses API keys given as name:role:key
+func parseAPIKeys(values []string) ([]APIKeyConfig, error) {
+	apiKeys := make([]APIKeyConfig, 0, len(values))
+	for i, value := range values {
+		parts := strings.SplitN(value, ":", 3)
+		if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+			return nil, fmt.Errorf("invalid API key #%d in auth.api_keys, expected name:role:key", i+1)
+		}
+		apiKeys = append(apiKeys, APIKeyConfig{Name: parts[0], Role: parts[1], Key: parts[2]})
+	}
+	return apiKeys, nil
+}
+
+// parseEncryptionKeys parses encryption keys given as id:base64-key
+func parseEncryptionKeys(values []string) ([]EncryptionKeyConfig, error) {
+	keys := make([]EncryptionKeyConfig, 0, len(values))
+	for i, value := range values {
+		parts := strings.SplitN(value, ":", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid encryption key #%d in secrets.encryption_keys, expected id:key", i+1)
+		}
+		keys = append(keys, EncryptionKeyConfig{ID: parts[0], Key: parts[1]})
+	}
+	return keys, nil
+}
+
+// parseFieldPolicies parses field policies given as resource_type:path:action
+func parseFieldPolicies(values []string) ([]FieldPolicyConfig, error) {
+	policies := make([]FieldPolicyConfig, 0, len(values))
+	for i, value := range values {
+		parts := strings.SplitN(value, ":", 3)
+		if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+			return nil, fmt.Errorf("invalid field policy #%d in secrets.policies, expected resource_type:path:action", i+1)
+		}
+		policies = append(policies, FieldPolicyConfig{ResourceType: parts[0], Path: parts[1], Action: parts[2]})
+	}
+	return policies, nil
+}
+
+// isLoopback reports whether a listen host only accepts connections from the local machine
+func isLoopback(host string) bool {
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// parseVolatileFields parses volatile fields given as resource_type:path
+func parseVolatileFields(values []string) ([]VolatileFieldConfig, error) {
+	fields := make([]VolatileFieldConfig, 0, len(values))
+	for i, value := range values {
+		parts := strings.SplitN(value, ":", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid volatile field #%d in sync.volatile_fields, expected resource_type:path", i+1)
+		}
+		fields = append(fields, VolatileFieldConfig{ResourceType: parts[0], Path: parts[1]})
+	}
+	return fields, nil
+}
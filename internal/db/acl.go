@@ -2,22 +2,32 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"netmaker-sync/internal/models"
-	"reflect"
+	"netmaker-sync/internal/tracing"
 	"time"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/sirupsen/logrus"
 )
 
-func (db *DB) UpsertACL(acl *models.ACL) error {
+// aclID builds the stable ID of the ACL from a source to a destination node of a network
+func aclID(networkID, sourceNode, destNode string) string {
+	return fmt.Sprintf("%s:%s:%s", networkID, sourceNode, destNode)
+}
+
+// UpsertACL inserts or updates an ACL in the database
+func (db *DB) UpsertACL(ctx context.Context, acl *models.ACL) (result UpsertResult, err error) {
+	ctx, span := startSpan(ctx, "UpsertACL", "acls", acl.ID)
+	defer func() { tracing.End(span, err) }()
+
 	// Get the current ACL if it exists
 	var currentACL models.ACL
-	err := db.Get(&currentACL, `
-		SELECT * FROM acls 
+	err = db.GetContext(ctx, &currentACL, `
+		SELECT * FROM acls
 		WHERE id = $1 AND is_current = true
 	`, acl.ID)
 
@@ -26,7 +36,7 @@ func (db *DB) UpsertACL(acl *models.ACL) error {
 	equalsFn := func(current, new interface{}) bool {
 		currentACL := current.(*models.ACL)
 		newACL := new.(*models.ACL)
-		return aclsEqual(*currentACL, *newACL)
+		return db.aclsEqual(*currentACL, *newACL)
 	}
 
 	// Function to get the version from an ACL
@@ -48,11 +58,11 @@ func (db *DB) UpsertACL(acl *models.ACL) error {
 	insertFn := func(tx interface{}, record interface{}) error {
 		_, err := tx.(*sqlx.Tx).NamedExec(`
 			INSERT INTO acls (
-				id, version, network_id, node_id, is_current, 
-				last_modified, created_at, data
+				id, version, network_id, node_id, is_current, is_deleted,
+				deleted_at, last_modified, created_at, data
 			) VALUES (
-				:id, :version, :network_id, :node_id, true, 
-				:last_modified, NOW(), :data
+				:id, :version, :network_id, :node_id, true, :is_deleted,
+				:deleted_at, :last_modified, NOW(), :data
 			)
 		`, record)
 		return err
@@ -64,11 +74,12 @@ func (db *DB) UpsertACL(acl *models.ACL) error {
 		currentACLPtr = &currentACL
 	} else if !errors.Is(err, sql.ErrNoRows) {
 		// If there was an error other than not finding the record, return it
-		return fmt.Errorf("failed to get current ACL: %w", err)
+		return UpsertUnchanged, fmt.Errorf("failed to get current ACL: %w", err)
 	}
 
 	// Call the generic upsert function
-	_, err = db.GenericUpsert(
+	return db.GenericUpsert(
+		ctx,
 		"acls",
 		"id",
 		acl.ID,
@@ -80,62 +91,90 @@ func (db *DB) UpsertACL(acl *models.ACL) error {
 		setLastModifiedFn,
 		insertFn,
 	)
-
-	return err
 }
 
 // aclsEqual compares two ACLs to determine if there are meaningful changes
-func aclsEqual(a, b models.ACL) bool {
+func (db *DB) aclsEqual(a, b models.ACL) bool {
 	// Compare relevant fields, ignoring metadata like LastModified
 	return a.NetworkID == b.NetworkID &&
 		a.NodeID == b.NodeID &&
-		reflect.DeepEqual(a.Data, b.Data)
+		a.IsDeleted == b.IsDeleted &&
+		db.dataEqual(models.ResourceTypeACL, a.Data, b.Data)
 }
 
 func (db *DB) GetACLs(networkID string) ([]models.ACL, error) {
 	// Get only the current versions of ACLs for a network
 	var acls []models.ACL
 	err := db.Select(&acls, `
-		SELECT * FROM acls 
-		WHERE network_id = $1 AND is_current = true
+		SELECT * FROM acls
+		WHERE network_id = $1 AND is_current = true AND is_deleted = false
 	`, networkID)
 	return acls, err
 }
 
 // GetACLHistory retrieves the version history of an ACL
-func (db *DB) GetACLHistory(aclID int) ([]models.ACL, error) {
+func (db *DB) GetACLHistory(aclID string) ([]models.ACL, error) {
 	var acls []models.ACL
 	err := db.Select(&acls, `
-		SELECT * FROM acls 
+		SELECT * FROM acls
 		WHERE id = $1
 		ORDER BY version DESC
 	`, aclID)
 	return acls, err
 }
 
-func (db *DB) UpsertACLs(networkID string, aclsMap map[string]map[string]int) error {
-	// First, delete all existing ACLs for this network without a transaction
-	// This is safer than trying to do everything in a single transaction
-	_, err := db.Exec(`DELETE FROM acls WHERE network_id = $1`, networkID)
+// DeleteACL records a new, deleted version of an ACL so that its removal from Netmaker is kept in the history
+func (db *DB) DeleteACL(ctx context.Context, aclID string) (err error) {
+	ctx, span := startSpan(ctx, "DeleteACL", "acls", aclID)
+	defer func() { tracing.End(span, err) }()
+
+	var acl models.ACL
+	err = db.GetContext(ctx, &acl, `
+		SELECT * FROM acls
+		WHERE id = $1 AND is_current = true
+	`, aclID)
 	if err != nil {
-		return fmt.Errorf("failed to delete existing ACLs: %w", err)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("ACL not found: %s", aclID)
+		}
+		return fmt.Errorf("failed to get current ACL: %w", err)
 	}
 
-	// Get the next ID to use for new ACLs
-	var nextID int
-	err = db.Get(&nextID, `SELECT COALESCE(MAX(id), 0) + 1 FROM acls`)
-	if err != nil {
-		return fmt.Errorf("failed to get next ACL ID: %w", err)
+	// Nothing to do if the ACL has already been marked as deleted
+	if acl.IsDeleted {
+		return nil
 	}
 
+	deletedAt := time.Now()
+	acl.IsDeleted = true
+	acl.DeletedAt = &deletedAt
+	_, err = db.UpsertACL(ctx, &acl)
+	return err
+}
+
+// DeleteMissingACLs marks the ACLs of a network that are no longer returned by the Netmaker API as deleted
+func (db *DB) DeleteMissingACLs(ctx context.Context, networkID string, seenIDs []string) (deletedIDs []string, err error) {
+	ctx, span := startSpan(ctx, "DeleteMissingACLs", "acls", nil)
+	defer func() { tracing.End(span, err) }()
+
+	return db.GenericTombstoneMissing(ctx, "acls", "network_id", networkID, seenIDs, db.DeleteACL)
+}
+
+// UpsertACLs upserts the ACLs of a network and returns their IDs along with how many were
+// created, updated, left unchanged or failed. ACLs that could not be upserted are listed in
+// the returned ItemErrors.
+func (db *DB) UpsertACLs(ctx context.Context, networkID string, aclsMap map[string]map[string]int) (seenIDs []string, counts models.ResourceCounts, err error) {
+	ctx, span := startSpan(ctx, "UpsertACLs", "acls", nil)
+	defer func() { tracing.End(span, err) }()
+
 	// Get all nodes for this network to check if they exist
 	var nodes []models.Node
-	err = db.Select(&nodes, `
-		SELECT id, name FROM nodes 
-		WHERE network_id = $1 AND is_current = true
+	err = db.SelectContext(ctx, &nodes, `
+		SELECT id, name FROM nodes
+		WHERE network_id = $1 AND is_current = true AND is_deleted = false
 	`, networkID)
 	if err != nil {
-		return fmt.Errorf("failed to get nodes for network: %w", err)
+		return nil, models.ResourceCounts{}, fmt.Errorf("failed to get nodes for network: %w", err)
 	}
 
 	// Create a map of node names to node IDs for quick lookup
@@ -145,21 +184,26 @@ func (db *DB) UpsertACLs(networkID string, aclsMap map[string]map[string]int) er
 	}
 
 	// Process each ACL from the map
-	successCount := 0
-	failureCount := 0
+	var failures ItemErrors
 	for sourceNode, destMap := range aclsMap {
 		// Check if the source node exists in our database
 		sourceID, exists := nodeMap[sourceNode]
 		if !exists {
 			logrus.Warnf("Source node '%s' not found in database, skipping ACLs", sourceNode)
-			failureCount += len(destMap)
+			for destNode := range destMap {
+				// Still returned by the API, so not to be marked as deleted
+				id := aclID(networkID, sourceNode, destNode)
+				seenIDs = append(seenIDs, id)
+				counts.Failed++
+				failures = append(failures, ItemError{ID: id, Err: fmt.Errorf("source node %s not found", sourceNode)})
+			}
 			continue
 		}
 
 		for destNode, allowed := range destMap {
 			// Create a new ACL object using the node ID instead of the node name
 			acl := &models.ACL{
-				ID:        nextID,
+				ID:        aclID(networkID, sourceNode, destNode),
 				NetworkID: networkID,
 				NodeID:    sourceID, // Use the actual node ID from the database
 				Data: models.JSONB{
@@ -168,22 +212,31 @@ func (db *DB) UpsertACLs(networkID string, aclsMap map[string]map[string]int) er
 					"is_allowed":  allowed == 1,
 				},
 			}
+			seenIDs = append(seenIDs, acl.ID)
 
-			// Use the UpsertACL function which now uses the generic approach
-			err := db.UpsertACL(acl)
+			result, err := db.UpsertACL(ctx, acl)
 			if err != nil {
 				logrus.Warnf("Failed to upsert ACL for source %s and dest %s: %v", sourceNode, destNode, err)
-				failureCount++
+				counts.Failed++
+				failures = append(failures, ItemError{ID: acl.ID, Err: err})
 				continue
 			}
 
-			logrus.Infof("Created new record in acls with id = %d", nextID)
-			// Increment the ID for the next ACL
-			nextID++
-			successCount++
+			switch result {
+			case UpsertCreated:
+				counts.Created++
+			case UpsertUpdated:
+				counts.Updated++
+			default:
+				counts.Unchanged++
+			}
 		}
 	}
 
-	logrus.Infof("Inserted %d ACLs for network %s (failed: %d)", successCount, networkID, failureCount)
-	return nil
+	logrus.Infof("Upserted %d ACLs for network %s (created: %d, updated: %d, failed: %d)",
+		len(seenIDs), networkID, counts.Created, counts.Updated, counts.Failed)
+	if len(failures) > 0 {
+		return seenIDs, counts, failures
+	}
+	return seenIDs, counts, nil
 }
@@ -0,0 +1,188 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"netmaker-sync/internal/models"
+	"time"
+)
+
+// asOfQuery returns a query that selects, for every record in a table, the latest
+// version that was written at or before the timestamp passed as $1. The result
+// is aliased as "v" so callers can append their own WHERE clause.
+func (db *DB) asOfQuery(tableName string) string {
+	return fmt.Sprintf(`
+		SELECT * FROM (
+			SELECT t.* FROM %[1]s AS t
+			JOIN (
+				SELECT id, MAX(version) AS version FROM %[1]s
+				WHERE %[2]s <= %[3]s
+				GROUP BY id
+			) AS latest ON latest.id = t.id AND latest.version = t.version
+		) AS v
+	`, tableName, db.timestamp("last_modified"), db.timestamp("$1"))
+}
+
+// GetNetworksAsOf retrieves all networks as they were at the given time
+func (db *DB) GetNetworksAsOf(asOf time.Time) ([]models.Network, error) {
+	var networks []models.Network
+	err := db.Select(&networks, db.asOfQuery("networks")+`
+		WHERE is_deleted = false
+		ORDER BY id
+	`, asOf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get networks as of %s: %w", asOf.Format(time.RFC3339), err)
+	}
+	return networks, nil
+}
+
+// GetNetworkAsOf retrieves a specific network as it was at the given time
+func (db *DB) GetNetworkAsOf(networkID string, asOf time.Time) (*models.Network, error) {
+	var network models.Network
+	err := db.Get(&network, db.asOfQuery("networks")+`
+		WHERE id = $2 AND is_deleted = false
+	`, asOf, networkID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w: network %s as of %s", ErrRecordNotFound, networkID, asOf.Format(time.RFC3339))
+		}
+		return nil, fmt.Errorf("failed to get network as of %s: %w", asOf.Format(time.RFC3339), err)
+	}
+	return &network, nil
+}
+
+// GetNodesAsOf retrieves the nodes of a network as they were at the given time
+func (db *DB) GetNodesAsOf(networkID string, asOf time.Time) ([]models.Node, error) {
+	var nodes []models.Node
+	err := db.Select(&nodes, db.asOfQuery("nodes")+`
+		WHERE network_id = $2 AND is_deleted = false
+		ORDER BY id
+	`, asOf, networkID)
+	return nodes, err
+}
+
+// GetExtClientsAsOf retrieves the external clients of a network as they were at the given time
+func (db *DB) GetExtClientsAsOf(networkID string, asOf time.Time) ([]models.ExtClient, error) {
+	var extClients []models.ExtClient
+	err := db.Select(&extClients, db.asOfQuery("ext_clients")+`
+		WHERE network_id = $2 AND is_deleted = false
+		ORDER BY id
+	`, asOf, networkID)
+	return extClients, err
+}
+
+// GetDNSEntriesAsOf retrieves the DNS entries of a network as they were at the given time
+func (db *DB) GetDNSEntriesAsOf(networkID string, asOf time.Time) ([]models.DNSEntry, error) {
+	var dnsEntries []models.DNSEntry
+	err := db.Select(&dnsEntries, db.asOfQuery("dns_entries")+`
+		WHERE network_id = $2 AND is_deleted = false
+		ORDER BY id
+	`, asOf, networkID)
+	return dnsEntries, err
+}
+
+// GetACLsAsOf retrieves the ACLs of a network as they were at the given time
+func (db *DB) GetACLsAsOf(networkID string, asOf time.Time) ([]models.ACL, error) {
+	var acls []models.ACL
+	err := db.Select(&acls, db.asOfQuery("acls")+`
+		WHERE network_id = $2 AND is_deleted = false
+		ORDER BY id
+	`, asOf, networkID)
+	return acls, err
+}
+
+// GetHostsAsOf retrieves the given hosts as they were at the given time
+func (db *DB) GetHostsAsOf(hostIDs []string, asOf time.Time) ([]models.Host, error) {
+	var hosts []models.Host
+	if len(hostIDs) == 0 {
+		return hosts, nil
+	}
+
+	args := []interface{}{asOf}
+	for _, hostID := range hostIDs {
+		args = append(args, hostID)
+	}
+
+	err := db.Select(&hosts, db.asOfQuery("hosts")+`
+		WHERE id IN (`+placeholders(2, len(hostIDs))+`) AND is_deleted = false
+		ORDER BY id
+	`, args...)
+	return hosts, err
+}
+
+// GetResourceAsOf retrieves a record by resource name as it was at the given time.
+// The returned value is a pointer to the model for the resource (e.g. *models.Node).
+func (db *DB) GetResourceAsOf(resource string, id string, asOf time.Time) (interface{}, error) {
+	r, err := lookupResource(resource)
+	if err != nil {
+		return nil, err
+	}
+
+	query := db.asOfQuery(r.tableName) + " WHERE id = $2"
+	if r.tombstoned {
+		query += " AND is_deleted = false"
+	}
+
+	record := r.newRecord()
+	if err := db.Get(record, query, asOf, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w: %s %s as of %s", ErrRecordNotFound, resource, id, asOf.Format(time.RFC3339))
+		}
+		return nil, fmt.Errorf("failed to get %s %s as of %s: %w", resource, id, asOf.Format(time.RFC3339), err)
+	}
+	return record, nil
+}
+
+// GetNetworkStateAsOf reconstructs the full state of a network, including its nodes,
+// external clients, DNS entries, ACLs and the hosts behind its nodes, as it was at
+// the given time
+func (db *DB) GetNetworkStateAsOf(networkID string, asOf time.Time) (*models.NetworkState, error) {
+	network, err := db.GetNetworkAsOf(networkID, asOf)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes, err := db.GetNodesAsOf(networkID, asOf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get nodes as of %s: %w", asOf.Format(time.RFC3339), err)
+	}
+
+	extClients, err := db.GetExtClientsAsOf(networkID, asOf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ext clients as of %s: %w", asOf.Format(time.RFC3339), err)
+	}
+
+	dnsEntries, err := db.GetDNSEntriesAsOf(networkID, asOf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get DNS entries as of %s: %w", asOf.Format(time.RFC3339), err)
+	}
+
+	acls, err := db.GetACLsAsOf(networkID, asOf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ACLs as of %s: %w", asOf.Format(time.RFC3339), err)
+	}
+
+	// Hosts are global, so only include the ones backing a node in this network
+	hostIDs := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		if hostID, ok := node.Data["hostid"].(string); ok && hostID != "" {
+			hostIDs = append(hostIDs, hostID)
+		}
+	}
+
+	hosts, err := db.GetHostsAsOf(hostIDs, asOf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get hosts as of %s: %w", asOf.Format(time.RFC3339), err)
+	}
+
+	return &models.NetworkState{
+		AsOf:       asOf,
+		Network:    *network,
+		Nodes:      nodes,
+		ExtClients: extClients,
+		DNSEntries: dnsEntries,
+		ACLs:       acls,
+		Hosts:      hosts,
+	}, nil
+}
@@ -0,0 +1,33 @@
+package db
+
+import (
+	"fmt"
+	"netmaker-sync/internal/models"
+)
+
+// CreateAuditEntry appends an entry to the audit log
+func (db *DB) CreateAuditEntry(entry *models.AuditEntry) error {
+	err := db.QueryRow(`
+		INSERT INTO audit_log (
+			principal, auth_method, role, method, path, status_code, remote_addr, occurred_at
+		) VALUES (
+			$1, $2, $3, $4, $5, $6, $7, $8
+		)
+		RETURNING id
+	`, entry.Principal, entry.AuthMethod, entry.Role, entry.Method, entry.Path,
+		entry.StatusCode, entry.RemoteAddr, entry.OccurredAt).Scan(&entry.ID)
+	if err != nil {
+		return fmt.Errorf("failed to create audit entry: %w", err)
+	}
+	return nil
+}
+
+// GetAuditEntries retrieves the most recent entries of the audit log, newest first
+func (db *DB) GetAuditEntries(limit int) ([]models.AuditEntry, error) {
+	entries := []models.AuditEntry{}
+	err := db.Select(&entries, `SELECT * FROM audit_log ORDER BY id DESC LIMIT $1`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get audit log: %w", err)
+	}
+	return entries, nil
+}
@@ -0,0 +1,76 @@
+package db
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"modernc.org/sqlite"
+)
+
+// Supported values of database.driver
+const (
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
+)
+
+// sqliteTimeFormat is the layout the SQLite driver writes times in (_time_format=sqlite)
+const sqliteTimeFormat = "2006-01-02 15:04:05.999999999-07:00"
+
+var registerSQLiteOnce sync.Once
+
+// registerSQLite teaches sqlx the SQLite bind style and adds the NOW() function used
+// by the shared queries, so that they run unchanged on both backends
+func registerSQLite() error {
+	var err error
+	registerSQLiteOnce.Do(func() {
+		sqlx.BindDriver(DriverSQLite, sqlx.QUESTION)
+		err = sqlite.RegisterScalarFunction("now", 0, func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+			return time.Now().UTC().Format(sqliteTimeFormat), nil
+		})
+	})
+	return err
+}
+
+// sqliteDSN builds the connection string for a SQLite database file. WAL mode lets
+// readers run alongside the single writer, and immediate transactions wait for the
+// write lock up front instead of failing when two writers collide.
+func sqliteDSN(path string) string {
+	return "file:" + path +
+		"?_pragma=busy_timeout(10000)" +
+		"&_pragma=journal_mode(WAL)" +
+		"&_pragma=foreign_keys(1)" +
+		"&_time_format=sqlite" +
+		"&_txlock=immediate"
+}
+
+// timestamp wraps a timestamp expression so that comparisons are chronological.
+// SQLite stores timestamps as text with their UTC offset, so they are compared as
+// Julian day numbers rather than as strings.
+func (db *DB) timestamp(expr string) string {
+	if db.driver == DriverSQLite {
+		return "julianday(" + expr + ")"
+	}
+	return expr
+}
+
+// jsonField returns an expression for a top-level key of a JSON column as text.
+// SQLite stores JSON columns as blobs, which its JSON functions only read as text.
+func (db *DB) jsonField(column, key string) string {
+	if db.driver == DriverSQLite {
+		return fmt.Sprintf("json_extract(CAST(%s AS TEXT), '$.%s')", column, key)
+	}
+	return fmt.Sprintf("%s->>'%s'", column, key)
+}
+
+// placeholders returns n comma separated positional parameters starting at $start
+func placeholders(start, n int) string {
+	params := make([]string, n)
+	for i := range params {
+		params[i] = fmt.Sprintf("$%d", start+i)
+	}
+	return strings.Join(params, ", ")
+}
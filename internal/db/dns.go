@@ -1,36 +1,41 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"netmaker-sync/internal/models"
+	"netmaker-sync/internal/tracing"
 	"time"
 
 	"github.com/sirupsen/logrus"
 )
 
-func (db *DB) UpsertDNSEntry(dnsEntry *models.DNSEntry) error {
+func (db *DB) UpsertDNSEntry(ctx context.Context, dnsEntry *models.DNSEntry) (result UpsertResult, err error) {
+	ctx, span := startSpan(ctx, "UpsertDNSEntry", "dns_entries", dnsEntry.ID)
+	defer func() { tracing.End(span, err) }()
+
 	// Check if the DNS entry exists with any version
 	var exists bool
-	err := db.QueryRow(`
+	err = db.QueryRowContext(ctx, `
 		SELECT EXISTS(SELECT 1 FROM dns_entries WHERE id = $1)
 	`, dnsEntry.ID).Scan(&exists)
 
 	if err != nil {
-		return fmt.Errorf("failed to check if DNS entry exists: %w", err)
+		return UpsertUnchanged, fmt.Errorf("failed to check if DNS entry exists: %w", err)
 	}
 
 	// If DNS entry exists, get the current version
 	if exists {
 		var currentDNSEntry models.DNSEntry
-		err := db.Get(&currentDNSEntry, `
+		err := db.GetContext(ctx, &currentDNSEntry, `
 			SELECT * FROM dns_entries 
 			WHERE id = $1 AND is_current = true
 		`, dnsEntry.ID)
 
 		if err != nil && !errors.Is(err, sql.ErrNoRows) {
-			return fmt.Errorf("failed to get current DNS entry: %w", err)
+			return UpsertUnchanged, fmt.Errorf("failed to get current DNS entry: %w", err)
 		}
 
 		// If we found a current version, check for changes
@@ -39,13 +44,13 @@ func (db *DB) UpsertDNSEntry(dnsEntry *models.DNSEntry) error {
 			if dnsEntriesEqual(currentDNSEntry, *dnsEntry) {
 				// No changes, nothing to do
 				logrus.Debugf("No changes for DNS entry %s, skipping update", dnsEntry.ID)
-				return nil
+				return UpsertUnchanged, nil
 			}
 
 			// Start a transaction
-			tx, err := db.Beginx()
+			tx, err := db.BeginTxx(ctx, nil)
 			if err != nil {
-				return fmt.Errorf("failed to begin transaction: %w", err)
+				return UpsertUnchanged, fmt.Errorf("failed to begin transaction: %w", err)
 			}
 			defer tx.Rollback()
 
@@ -57,7 +62,7 @@ func (db *DB) UpsertDNSEntry(dnsEntry *models.DNSEntry) error {
 			`, dnsEntry.ID)
 
 			if err != nil {
-				return fmt.Errorf("failed to update current DNS entry: %w", err)
+				return UpsertUnchanged, fmt.Errorf("failed to update current DNS entry: %w", err)
 			}
 
 			// Get the next version number
@@ -67,18 +72,19 @@ func (db *DB) UpsertDNSEntry(dnsEntry *models.DNSEntry) error {
 			`, dnsEntry.ID)
 
 			if err != nil {
-				return fmt.Errorf("failed to get next version: %w", err)
+				return UpsertUnchanged, fmt.Errorf("failed to get next version: %w", err)
 			}
 
 			// Insert the new version
+			dnsEntry.Version = nextVersion
 			dnsEntry.LastModified = time.Now()
 			_, err = tx.NamedExec(`
 				INSERT INTO dns_entries (
 					id, version, name, network_id, address, address6,
-					is_current, last_modified, created_at
+					is_current, is_deleted, deleted_at, last_modified, created_at
 				) VALUES (
 					:id, :version, :name, :network_id, :address, :address6,
-					true, :last_modified, NOW()
+					true, :is_deleted, :deleted_at, :last_modified, NOW()
 				)
 			`, map[string]interface{}{
 				"id":            dnsEntry.ID,
@@ -87,35 +93,48 @@ func (db *DB) UpsertDNSEntry(dnsEntry *models.DNSEntry) error {
 				"network_id":    dnsEntry.NetworkID,
 				"address":       dnsEntry.Address,
 				"address6":      dnsEntry.Address6,
+				"is_deleted":    dnsEntry.IsDeleted,
+				"deleted_at":    dnsEntry.DeletedAt,
 				"last_modified": dnsEntry.LastModified,
 			})
 
 			if err != nil {
-				return fmt.Errorf("failed to insert new DNS entry version: %w", err)
+				return UpsertUnchanged, fmt.Errorf("failed to insert new DNS entry version: %w", err)
+			}
+
+			// Notify database listeners once the transaction commits
+			if err := db.notifyChange(tx, "dns_entries", dnsEntry.ID, nextVersion, dnsEntry); err != nil {
+				return UpsertUnchanged, err
+			}
+
+			if err := tx.Commit(); err != nil {
+				return UpsertUnchanged, fmt.Errorf("failed to commit transaction: %w", err)
 			}
 
 			logrus.Infof("Updated DNS entry %s to version %d", dnsEntry.ID, nextVersion)
-			return tx.Commit()
+			db.publishChange("dns_entries", dnsEntry.ID, &currentDNSEntry, dnsEntry, currentDNSEntry.Version, nextVersion)
+			return UpsertUpdated, nil
 		}
 	}
 
 	// DNS entry doesn't exist or no current version, create it
+	dnsEntry.Version = 1
 	dnsEntry.LastModified = time.Now()
 
 	// Start a transaction
-	tx, err := db.Beginx()
+	tx, err := db.BeginTxx(ctx, nil)
 	if err != nil {
-		return fmt.Errorf("failed to begin transaction: %w", err)
+		return UpsertUnchanged, fmt.Errorf("failed to begin transaction: %w", err)
 	}
 	defer tx.Rollback()
 
 	_, err = tx.NamedExec(`
 		INSERT INTO dns_entries (
 			id, version, name, network_id, address, address6,
-			is_current, last_modified, created_at
+			is_current, is_deleted, deleted_at, last_modified, created_at
 		) VALUES (
 			:id, 1, :name, :network_id, :address, :address6,
-			true, :last_modified, NOW()
+			true, :is_deleted, :deleted_at, :last_modified, NOW()
 		)
 	`, map[string]interface{}{
 		"id":            dnsEntry.ID,
@@ -123,15 +142,27 @@ func (db *DB) UpsertDNSEntry(dnsEntry *models.DNSEntry) error {
 		"network_id":    dnsEntry.NetworkID,
 		"address":       dnsEntry.Address,
 		"address6":      dnsEntry.Address6,
+		"is_deleted":    dnsEntry.IsDeleted,
+		"deleted_at":    dnsEntry.DeletedAt,
 		"last_modified": dnsEntry.LastModified,
 	})
 
 	if err != nil {
-		return fmt.Errorf("failed to insert first DNS entry version: %w", err)
+		return UpsertUnchanged, fmt.Errorf("failed to insert first DNS entry version: %w", err)
+	}
+
+	// Notify database listeners once the transaction commits
+	if err := db.notifyChange(tx, "dns_entries", dnsEntry.ID, 1, dnsEntry); err != nil {
+		return UpsertUnchanged, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return UpsertUnchanged, fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
 	logrus.Infof("Created new DNS entry %s", dnsEntry.ID)
-	return tx.Commit()
+	db.publishChange("dns_entries", dnsEntry.ID, nil, dnsEntry, 0, 1)
+	return UpsertCreated, nil
 }
 
 // dnsEntriesEqual compares two DNS entries to determine if there are meaningful changes
@@ -140,7 +171,8 @@ func dnsEntriesEqual(a, b models.DNSEntry) bool {
 	return a.Name == b.Name &&
 		a.NetworkID == b.NetworkID &&
 		a.Address == b.Address &&
-		a.Address6 == b.Address6
+		a.Address6 == b.Address6 &&
+		a.IsDeleted == b.IsDeleted
 }
 
 func (db *DB) GetDNSEntries(networkID string) ([]models.DNSEntry, error) {
@@ -148,7 +180,7 @@ func (db *DB) GetDNSEntries(networkID string) ([]models.DNSEntry, error) {
 	var dnsEntries []models.DNSEntry
 	err := db.Select(&dnsEntries, `
 		SELECT * FROM dns_entries 
-		WHERE network_id = $1 AND is_current = true
+		WHERE network_id = $1 AND is_current = true AND is_deleted = false
 	`, networkID)
 	return dnsEntries, err
 }
@@ -163,3 +195,40 @@ func (db *DB) GetDNSEntryHistory(dnsEntryID string) ([]models.DNSEntry, error) {
 	`, dnsEntryID)
 	return dnsEntries, err
 }
+
+// DeleteDNSEntry records a new, deleted version of a DNS entry so that its removal from Netmaker is kept in the history
+func (db *DB) DeleteDNSEntry(ctx context.Context, dnsEntryID string) (err error) {
+	ctx, span := startSpan(ctx, "DeleteDNSEntry", "dns_entries", dnsEntryID)
+	defer func() { tracing.End(span, err) }()
+
+	var dnsEntry models.DNSEntry
+	err = db.GetContext(ctx, &dnsEntry, `
+		SELECT * FROM dns_entries 
+		WHERE id = $1 AND is_current = true
+	`, dnsEntryID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("DNS entry not found: %s", dnsEntryID)
+		}
+		return fmt.Errorf("failed to get current DNS entry: %w", err)
+	}
+
+	// Nothing to do if the DNS entry has already been marked as deleted
+	if dnsEntry.IsDeleted {
+		return nil
+	}
+
+	deletedAt := time.Now()
+	dnsEntry.IsDeleted = true
+	dnsEntry.DeletedAt = &deletedAt
+	_, err = db.UpsertDNSEntry(ctx, &dnsEntry)
+	return err
+}
+
+// DeleteMissingDNSEntries marks the DNS entries of a network that are no longer returned by the Netmaker API as deleted
+func (db *DB) DeleteMissingDNSEntries(ctx context.Context, networkID string, seenIDs []string) (deletedIDs []string, err error) {
+	ctx, span := startSpan(ctx, "DeleteMissingDNSEntries", "dns_entries", nil)
+	defer func() { tracing.End(span, err) }()
+
+	return db.GenericTombstoneMissing(ctx, "dns_entries", "network_id", networkID, seenIDs, db.DeleteDNSEntry)
+}
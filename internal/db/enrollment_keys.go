@@ -0,0 +1,160 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"netmaker-sync/internal/models"
+	"netmaker-sync/internal/tracing"
+	"slices"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// UpsertEnrollmentKey inserts or updates an enrollment key in the database
+func (db *DB) UpsertEnrollmentKey(ctx context.Context, key *models.EnrollmentKey) (result UpsertResult, err error) {
+	ctx, span := startSpan(ctx, "UpsertEnrollmentKey", "enrollment_keys", key.ID)
+	defer func() { tracing.End(span, err) }()
+
+	// Protect the sensitive fields before comparing with the current version
+	if err := db.protectFields(ctx, models.ResourceTypeEnrollmentKey, "enrollment_keys", key.ID, key.Data); err != nil {
+		return UpsertUnchanged, err
+	}
+
+	// Get the current enrollment key if it exists
+	var currentKey models.EnrollmentKey
+	err = db.GetContext(ctx, &currentKey, `
+		SELECT * FROM enrollment_keys
+		WHERE id = $1 AND is_current = true
+	`, key.ID)
+
+	var currentKeyPtr *models.EnrollmentKey
+	if err == nil {
+		currentKeyPtr = &currentKey
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return UpsertUnchanged, fmt.Errorf("failed to get current enrollment key: %w", err)
+	}
+
+	result, err = db.GenericUpsert(
+		ctx,
+		"enrollment_keys",
+		"id",
+		key.ID,
+		currentKeyPtr,
+		key,
+		func(current, new interface{}) bool {
+			return db.enrollmentKeysEqual(*current.(*models.EnrollmentKey), *new.(*models.EnrollmentKey))
+		},
+		func(record interface{}) int {
+			return record.(*models.EnrollmentKey).Version
+		},
+		func(record interface{}, version int) {
+			record.(*models.EnrollmentKey).Version = version
+		},
+		func(record interface{}, lastModified time.Time) {
+			record.(*models.EnrollmentKey).LastModified = lastModified
+		},
+		func(tx interface{}, record interface{}) error {
+			_, err := tx.(*sqlx.Tx).NamedExec(`
+				INSERT INTO enrollment_keys (
+					id, version, networks, tags, type, uses_remaining, unlimited,
+					expiration, is_current, is_deleted, deleted_at, last_modified,
+					created_at, data
+				) VALUES (
+					:id, :version, :networks, :tags, :type, :uses_remaining, :unlimited,
+					:expiration, true, :is_deleted, :deleted_at, :last_modified,
+					NOW(), :data
+				)
+			`, record)
+			return err
+		},
+	)
+
+	// No changes but to the volatile fields, whose latest values are recorded apart from the data
+	if err == nil && result == UpsertUnchanged && currentKeyPtr != nil {
+		err = db.RefreshVolatileFields(ctx, "enrollment_keys", key.ID, currentKey.Data, currentKey.ObservedData, key.Data)
+	}
+	return result, err
+}
+
+// enrollmentKeysEqual compares two enrollment keys to determine if there are meaningful changes
+func (db *DB) enrollmentKeysEqual(a, b models.EnrollmentKey) bool {
+	// A key that never expires has no expiration
+	sameExpiration := a.Expiration == nil && b.Expiration == nil ||
+		a.Expiration != nil && b.Expiration != nil && a.Expiration.Equal(*b.Expiration)
+
+	return slices.Equal(a.Networks, b.Networks) &&
+		slices.Equal(a.Tags, b.Tags) &&
+		a.Type == b.Type &&
+		a.UsesRemaining == b.UsesRemaining &&
+		a.Unlimited == b.Unlimited &&
+		sameExpiration &&
+		a.IsDeleted == b.IsDeleted &&
+		db.dataEqual(models.ResourceTypeEnrollmentKey, a.Data, b.Data)
+}
+
+// GetEnrollmentKeys retrieves the current versions of the enrollment keys
+func (db *DB) GetEnrollmentKeys() ([]models.EnrollmentKey, error) {
+	keys := []models.EnrollmentKey{}
+	err := db.Select(&keys, `
+		SELECT * FROM enrollment_keys
+		WHERE is_current = true AND is_deleted = false
+		ORDER BY id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get enrollment keys: %w", err)
+	}
+	return keys, nil
+}
+
+// GetEnrollmentKeyHistory retrieves the version history of an enrollment key
+func (db *DB) GetEnrollmentKeyHistory(keyID string) ([]models.EnrollmentKey, error) {
+	var keys []models.EnrollmentKey
+	err := db.Select(&keys, `
+		SELECT * FROM enrollment_keys
+		WHERE id = $1
+		ORDER BY version DESC
+	`, keyID)
+	return keys, err
+}
+
+// DeleteEnrollmentKey records a new, deleted version of an enrollment key so that its
+// removal from Netmaker, once used up, expired or revoked, is kept in the history
+func (db *DB) DeleteEnrollmentKey(ctx context.Context, keyID string) (err error) {
+	ctx, span := startSpan(ctx, "DeleteEnrollmentKey", "enrollment_keys", keyID)
+	defer func() { tracing.End(span, err) }()
+
+	var key models.EnrollmentKey
+	err = db.GetContext(ctx, &key, `
+		SELECT * FROM enrollment_keys
+		WHERE id = $1 AND is_current = true
+	`, keyID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("enrollment key not found: %s", keyID)
+		}
+		return fmt.Errorf("failed to get current enrollment key: %w", err)
+	}
+
+	// Nothing to do if the key has already been marked as deleted
+	if key.IsDeleted {
+		return nil
+	}
+
+	deletedAt := time.Now()
+	key.IsDeleted = true
+	key.DeletedAt = &deletedAt
+	_, err = db.UpsertEnrollmentKey(ctx, &key)
+	return err
+}
+
+// DeleteMissingEnrollmentKeys marks enrollment keys that are no longer returned by the
+// Netmaker API as deleted
+func (db *DB) DeleteMissingEnrollmentKeys(ctx context.Context, seenIDs []string) (deletedIDs []string, err error) {
+	ctx, span := startSpan(ctx, "DeleteMissingEnrollmentKeys", "enrollment_keys", nil)
+	defer func() { tracing.End(span, err) }()
+
+	return db.GenericTombstoneMissing(ctx, "enrollment_keys", "", nil, seenIDs, db.DeleteEnrollmentKey)
+}
@@ -0,0 +1,102 @@
+package db
+
+import (
+	"fmt"
+	"netmaker-sync/internal/diff"
+	"netmaker-sync/internal/models"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Publisher receives a change event for every new version written to the database
+type Publisher interface {
+	Publish(event models.ChangeEvent)
+}
+
+// tableResourceTypes maps versioned tables to the resource type reported in change events
+var tableResourceTypes = map[string]string{
+	"networks":                 models.ResourceTypeNetwork,
+	"nodes":                    models.ResourceTypeNode,
+	"ext_clients":              models.ResourceTypeExtClient,
+	"dns_entries":              models.ResourceTypeDNS,
+	"hosts":                    models.ResourceTypeHost,
+	"acls":                     models.ResourceTypeACL,
+	"enrollment_keys":          models.ResourceTypeEnrollmentKey,
+	"users":                    models.ResourceTypeUser,
+	"user_gateway_assignments": models.ResourceTypeUserGatewayAssignment,
+	"server_config":            models.ResourceTypeServerConfig,
+}
+
+// SyncFailurePublisher receives sync history records that completed with a failure
+type SyncFailurePublisher interface {
+	PublishSyncFailure(history models.SyncHistory)
+}
+
+// SetPublisher sets the publishers that are notified, in order, whenever a new version is written
+func (db *DB) SetPublisher(publishers ...Publisher) {
+	db.publishers = publishers
+}
+
+// SetSyncFailurePublisher sets the publisher that is notified whenever a sync fails
+func (db *DB) SetSyncFailurePublisher(publisher SyncFailurePublisher) {
+	db.syncFailurePublisher = publisher
+}
+
+// publishChange notifies the publishers, if any, about a new version of a record.
+// oldRecord is nil when the record was created.
+func (db *DB) publishChange(tableName string, id interface{}, oldRecord, newRecord interface{}, oldVersion, newVersion int) {
+	if len(db.publishers) == 0 {
+		return
+	}
+
+	event := models.ChangeEvent{
+		ResourceType:  tableResourceTypes[tableName],
+		ID:            fmt.Sprint(id),
+		NetworkID:     networkIDOf(newRecord),
+		Kind:          models.ChangeKindCreated,
+		OldVersion:    oldVersion,
+		NewVersion:    newVersion,
+		ChangedFields: []string{},
+		Timestamp:     time.Now(),
+	}
+
+	if oldRecord != nil {
+		event.Kind = models.ChangeKindUpdated
+
+		changes, err := diff.Compare(oldRecord, newRecord, diff.DefaultIgnoredFields...)
+		if err != nil {
+			logrus.Warnf("Failed to compute changed fields for %s %v: %v", tableName, id, err)
+		}
+
+		event.Changes = changes
+		for _, change := range changes {
+			event.ChangedFields = append(event.ChangedFields, change.Path)
+			if deleted, ok := change.Value.(bool); ok && change.Path == "/is_deleted" && deleted {
+				event.Kind = models.ChangeKindDeleted
+			}
+		}
+	}
+
+	for _, publisher := range db.publishers {
+		publisher.Publish(event)
+	}
+}
+
+// networkIDOf returns the network a versioned record belongs to, if any
+func networkIDOf(record interface{}) string {
+	switch r := record.(type) {
+	case *models.Network:
+		return r.ID
+	case *models.Node:
+		return r.NetworkID
+	case *models.ExtClient:
+		return r.NetworkID
+	case *models.DNSEntry:
+		return r.NetworkID
+	case *models.ACL:
+		return r.NetworkID
+	default:
+		return ""
+	}
+}
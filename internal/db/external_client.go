@@ -1,52 +1,61 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"netmaker-sync/internal/models"
-	"reflect"
+	"netmaker-sync/internal/tracing"
 	"time"
 
 	"github.com/sirupsen/logrus"
 )
 
-func (db *DB) UpsertExtClient(extClient *models.ExtClient) error {
+func (db *DB) UpsertExtClient(ctx context.Context, extClient *models.ExtClient) (result UpsertResult, err error) {
+	ctx, span := startSpan(ctx, "UpsertExtClient", "ext_clients", extClient.ID)
+	defer func() { tracing.End(span, err) }()
+
+	// Protect the sensitive fields before comparing with the current version
+	if err := db.protectFields(ctx, models.ResourceTypeExtClient, "ext_clients", extClient.ID, extClient.Data); err != nil {
+		return UpsertUnchanged, err
+	}
+
 	// Check if the ext client exists with any version
 	var exists bool
-	err := db.QueryRow(`
+	err = db.QueryRowContext(ctx, `
 		SELECT EXISTS(SELECT 1 FROM ext_clients WHERE id = $1)
 	`, extClient.ID).Scan(&exists)
 
 	if err != nil {
-		return fmt.Errorf("failed to check if ext client exists: %w", err)
+		return UpsertUnchanged, fmt.Errorf("failed to check if ext client exists: %w", err)
 	}
 
 	// If ext client exists, get the current version
 	if exists {
 		var currentExtClient models.ExtClient
-		err := db.Get(&currentExtClient, `
+		err := db.GetContext(ctx, &currentExtClient, `
 			SELECT * FROM ext_clients 
 			WHERE id = $1 AND is_current = true
 		`, extClient.ID)
 
 		if err != nil && !errors.Is(err, sql.ErrNoRows) {
-			return fmt.Errorf("failed to get current ext client: %w", err)
+			return UpsertUnchanged, fmt.Errorf("failed to get current ext client: %w", err)
 		}
 
 		// If we found a current version, check for changes
 		if err == nil {
 			// Ext client exists, check if there are meaningful changes
-			if extClientsEqual(currentExtClient, *extClient) {
-				// No changes, nothing to do
+			if db.extClientsEqual(currentExtClient, *extClient) {
+				// No changes but to the volatile fields, whose latest values are recorded apart from the data
 				logrus.Debugf("No changes for ext client %s, skipping update", extClient.ID)
-				return nil
+				return UpsertUnchanged, db.RefreshVolatileFields(ctx, "ext_clients", extClient.ID, currentExtClient.Data, currentExtClient.ObservedData, extClient.Data)
 			}
 
 			// Start a transaction
-			tx, err := db.Beginx()
+			tx, err := db.BeginTxx(ctx, nil)
 			if err != nil {
-				return fmt.Errorf("failed to begin transaction: %w", err)
+				return UpsertUnchanged, fmt.Errorf("failed to begin transaction: %w", err)
 			}
 			defer tx.Rollback()
 
@@ -58,7 +67,7 @@ func (db *DB) UpsertExtClient(extClient *models.ExtClient) error {
 			`, extClient.ID)
 
 			if err != nil {
-				return fmt.Errorf("failed to update current ext client: %w", err)
+				return UpsertUnchanged, fmt.Errorf("failed to update current ext client: %w", err)
 			}
 
 			// Get the next version number
@@ -68,7 +77,7 @@ func (db *DB) UpsertExtClient(extClient *models.ExtClient) error {
 			`, extClient.ID)
 
 			if err != nil {
-				return fmt.Errorf("failed to get next version: %w", err)
+				return UpsertUnchanged, fmt.Errorf("failed to get next version: %w", err)
 			}
 
 			// Insert the new version
@@ -77,19 +86,31 @@ func (db *DB) UpsertExtClient(extClient *models.ExtClient) error {
 			_, err = tx.NamedExec(`
 				INSERT INTO ext_clients (
 					id, version, network_id, name, address, address6, public_key,
-					enabled, is_current, last_modified, created_at, data
+					enabled, is_current, is_deleted, deleted_at, last_modified,
+					created_at, data
 				) VALUES (
 					:id, :version, :network_id, :name, :address, :address6, :public_key,
-					:enabled, true, :last_modified, NOW(), :data
+					:enabled, true, :is_deleted, :deleted_at, :last_modified,
+					NOW(), :data
 				)
 			`, extClient)
 
 			if err != nil {
-				return fmt.Errorf("failed to insert new ext client version: %w", err)
+				return UpsertUnchanged, fmt.Errorf("failed to insert new ext client version: %w", err)
+			}
+
+			// Notify database listeners once the transaction commits
+			if err := db.notifyChange(tx, "ext_clients", extClient.ID, nextVersion, extClient); err != nil {
+				return UpsertUnchanged, err
+			}
+
+			if err := tx.Commit(); err != nil {
+				return UpsertUnchanged, fmt.Errorf("failed to commit transaction: %w", err)
 			}
 
 			logrus.Infof("Updated ext client %s to version %d", extClient.ID, nextVersion)
-			return tx.Commit()
+			db.publishChange("ext_clients", extClient.ID, &currentExtClient, extClient, currentExtClient.Version, nextVersion)
+			return UpsertUpdated, nil
 		}
 	}
 
@@ -98,32 +119,44 @@ func (db *DB) UpsertExtClient(extClient *models.ExtClient) error {
 	extClient.LastModified = time.Now()
 
 	// Start a transaction
-	tx, err := db.Beginx()
+	tx, err := db.BeginTxx(ctx, nil)
 	if err != nil {
-		return fmt.Errorf("failed to begin transaction: %w", err)
+		return UpsertUnchanged, fmt.Errorf("failed to begin transaction: %w", err)
 	}
 	defer tx.Rollback()
 
 	_, err = tx.NamedExec(`
 		INSERT INTO ext_clients (
 			id, version, network_id, name, address, address6, public_key,
-			enabled, is_current, last_modified, created_at, data
+			enabled, is_current, is_deleted, deleted_at, last_modified,
+			created_at, data
 		) VALUES (
 			:id, :version, :network_id, :name, :address, :address6, :public_key,
-			:enabled, true, :last_modified, NOW(), :data
+			:enabled, true, :is_deleted, :deleted_at, :last_modified,
+			NOW(), :data
 		)
 	`, extClient)
 
 	if err != nil {
-		return fmt.Errorf("failed to insert first ext client version: %w", err)
+		return UpsertUnchanged, fmt.Errorf("failed to insert first ext client version: %w", err)
+	}
+
+	// Notify database listeners once the transaction commits
+	if err := db.notifyChange(tx, "ext_clients", extClient.ID, 1, extClient); err != nil {
+		return UpsertUnchanged, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return UpsertUnchanged, fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
 	logrus.Infof("Created new ext client %s", extClient.ID)
-	return tx.Commit()
+	db.publishChange("ext_clients", extClient.ID, nil, extClient, 0, 1)
+	return UpsertCreated, nil
 }
 
 // extClientsEqual compares two external clients to determine if there are meaningful changes
-func extClientsEqual(a, b models.ExtClient) bool {
+func (db *DB) extClientsEqual(a, b models.ExtClient) bool {
 	// Compare relevant fields, ignoring metadata like LastModified
 	return a.NetworkID == b.NetworkID &&
 		a.Name == b.Name &&
@@ -131,7 +164,8 @@ func extClientsEqual(a, b models.ExtClient) bool {
 		a.Address6 == b.Address6 &&
 		a.PublicKey == b.PublicKey &&
 		a.Enabled == b.Enabled &&
-		reflect.DeepEqual(a.Data, b.Data)
+		a.IsDeleted == b.IsDeleted &&
+		db.dataEqual(models.ResourceTypeExtClient, a.Data, b.Data)
 }
 
 func (db *DB) GetExtClients(networkID string) ([]models.ExtClient, error) {
@@ -139,7 +173,7 @@ func (db *DB) GetExtClients(networkID string) ([]models.ExtClient, error) {
 	var extClients []models.ExtClient
 	err := db.Select(&extClients, `
 		SELECT * FROM ext_clients 
-		WHERE network_id = $1 AND is_current = true
+		WHERE network_id = $1 AND is_current = true AND is_deleted = false
 	`, networkID)
 	return extClients, err
 }
@@ -154,3 +188,40 @@ func (db *DB) GetExtClientHistory(extClientID string) ([]models.ExtClient, error
 	`, extClientID)
 	return extClients, err
 }
+
+// DeleteExtClient records a new, deleted version of an ext client so that its removal from Netmaker is kept in the history
+func (db *DB) DeleteExtClient(ctx context.Context, extClientID string) (err error) {
+	ctx, span := startSpan(ctx, "DeleteExtClient", "ext_clients", extClientID)
+	defer func() { tracing.End(span, err) }()
+
+	var extClient models.ExtClient
+	err = db.GetContext(ctx, &extClient, `
+		SELECT * FROM ext_clients 
+		WHERE id = $1 AND is_current = true
+	`, extClientID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("ext client not found: %s", extClientID)
+		}
+		return fmt.Errorf("failed to get current ext client: %w", err)
+	}
+
+	// Nothing to do if the ext client has already been marked as deleted
+	if extClient.IsDeleted {
+		return nil
+	}
+
+	deletedAt := time.Now()
+	extClient.IsDeleted = true
+	extClient.DeletedAt = &deletedAt
+	_, err = db.UpsertExtClient(ctx, &extClient)
+	return err
+}
+
+// DeleteMissingExtClients marks the ext clients of a network that are no longer returned by the Netmaker API as deleted
+func (db *DB) DeleteMissingExtClients(ctx context.Context, networkID string, seenIDs []string) (deletedIDs []string, err error) {
+	ctx, span := startSpan(ctx, "DeleteMissingExtClients", "ext_clients", nil)
+	defer func() { tracing.End(span, err) }()
+
+	return db.GenericTombstoneMissing(ctx, "ext_clients", "network_id", networkID, seenIDs, db.DeleteExtClient)
+}
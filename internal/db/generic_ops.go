@@ -1,6 +1,7 @@
 package db
 
 import (
+	"context"
 	"fmt"
 	"reflect"
 	"time"
@@ -8,6 +9,16 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// UpsertResult describes what an upsert did to a record
+type UpsertResult int
+
+// UpsertResult values
+const (
+	UpsertUnchanged UpsertResult = iota
+	UpsertCreated
+	UpsertUpdated
+)
+
 // GenericUpsert provides a simplified approach to versioned record management
 // It follows these rules:
 // 1. Check if the record in the DB matches the record from the API - if so, do not update
@@ -15,6 +26,7 @@ import (
 //
 // Parameters:
 // - db: The database connection
+// - ctx: The context of the queries and the transaction, which is rolled back if ctx is cancelled
 // - tableName: The name of the table to operate on
 // - idField: The name of the ID field in the table (e.g., "id" or "network_id")
 // - idValue: The value of the ID field
@@ -27,9 +39,10 @@ import (
 // - insertFn: Function that inserts the record into the database
 //
 // Returns:
-// - bool: true if a new version was created, false if no changes were made
+// - UpsertResult: whether the record was created, updated to a new version, or unchanged
 // - error: any error that occurred during the operation
 func (db *DB) GenericUpsert(
+	ctx context.Context,
 	tableName string,
 	idField string,
 	idValue interface{},
@@ -40,13 +53,13 @@ func (db *DB) GenericUpsert(
 	setVersionFn func(record interface{}, version int),
 	setLastModifiedFn func(record interface{}, time time.Time),
 	insertFn func(tx interface{}, record interface{}) error,
-) (bool, error) {
+) (UpsertResult, error) {
 	// Check if a current version exists
 	var exists bool
 	query := fmt.Sprintf("SELECT EXISTS(SELECT 1 FROM %s WHERE %s = $1)", tableName, idField)
-	err := db.QueryRow(query, idValue).Scan(&exists)
+	err := db.QueryRowContext(ctx, query, idValue).Scan(&exists)
 	if err != nil {
-		return false, fmt.Errorf("failed to check if record exists: %w", err)
+		return UpsertUnchanged, fmt.Errorf("failed to check if record exists: %w", err)
 	}
 
 	// If no record exists, insert the first version
@@ -56,42 +69,48 @@ func (db *DB) GenericUpsert(
 		setLastModifiedFn(newRecord, time.Now())
 
 		// Start a transaction
-		tx, err := db.Beginx()
+		tx, err := db.BeginTxx(ctx, nil)
 		if err != nil {
-			return false, fmt.Errorf("failed to begin transaction: %w", err)
+			return UpsertUnchanged, fmt.Errorf("failed to begin transaction: %w", err)
 		}
 		defer tx.Rollback()
 
 		// Insert the new record
 		if err := insertFn(tx, newRecord); err != nil {
-			return false, fmt.Errorf("failed to insert first version: %w", err)
+			return UpsertUnchanged, fmt.Errorf("failed to insert first version: %w", err)
+		}
+
+		// Notify database listeners once the transaction commits
+		if err := db.notifyChange(tx, tableName, idValue, 1, newRecord); err != nil {
+			return UpsertUnchanged, err
 		}
 
 		// Commit the transaction
 		if err := tx.Commit(); err != nil {
-			return false, fmt.Errorf("failed to commit transaction: %w", err)
+			return UpsertUnchanged, fmt.Errorf("failed to commit transaction: %w", err)
 		}
 
 		logrus.Infof("Created new record in %s with %s = %v", tableName, idField, idValue)
-		return true, nil
+		db.publishChange(tableName, idValue, nil, newRecord, 0, 1)
+		return UpsertCreated, nil
 	}
 
 	// If the current record is nil or not of the expected type, we can't compare
 	if currentRecord == nil || reflect.ValueOf(currentRecord).IsNil() {
-		return false, fmt.Errorf("current record is nil, cannot compare")
+		return UpsertUnchanged, fmt.Errorf("current record is nil, cannot compare")
 	}
 
 	// Check if there are meaningful changes
 	if equalsFn(currentRecord, newRecord) {
 		// No changes, nothing to do
 		logrus.Debugf("No changes for record in %s with %s = %v, skipping update", tableName, idField, idValue)
-		return false, nil
+		return UpsertUnchanged, nil
 	}
 
 	// Start a transaction
-	tx, err := db.Beginx()
+	tx, err := db.BeginTxx(ctx, nil)
 	if err != nil {
-		return false, fmt.Errorf("failed to begin transaction: %w", err)
+		return UpsertUnchanged, fmt.Errorf("failed to begin transaction: %w", err)
 	}
 	defer tx.Rollback()
 
@@ -99,7 +118,7 @@ func (db *DB) GenericUpsert(
 	updateQuery := fmt.Sprintf("UPDATE %s SET is_current = false WHERE %s = $1 AND is_current = true", tableName, idField)
 	_, err = tx.Exec(updateQuery, idValue)
 	if err != nil {
-		return false, fmt.Errorf("failed to update current record: %w", err)
+		return UpsertUnchanged, fmt.Errorf("failed to update current record: %w", err)
 	}
 
 	// Get the next version number
@@ -111,14 +130,104 @@ func (db *DB) GenericUpsert(
 
 	// Insert the new version
 	if err := insertFn(tx, newRecord); err != nil {
-		return false, fmt.Errorf("failed to insert new version: %w", err)
+		return UpsertUnchanged, fmt.Errorf("failed to insert new version: %w", err)
+	}
+
+	// Notify database listeners once the transaction commits
+	if err := db.notifyChange(tx, tableName, idValue, nextVersion, newRecord); err != nil {
+		return UpsertUnchanged, err
 	}
 
 	// Commit the transaction
 	if err := tx.Commit(); err != nil {
-		return false, fmt.Errorf("failed to commit transaction: %w", err)
+		return UpsertUnchanged, fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
 	logrus.Infof("Updated record in %s with %s = %v to version %d", tableName, idField, idValue, nextVersion)
-	return true, nil
+	db.publishChange(tableName, idValue, currentRecord, newRecord, getVersionFn(currentRecord), nextVersion)
+	return UpsertUpdated, nil
+}
+
+// GenericTombstoneMissing marks records that are no longer returned by the Netmaker API as deleted
+// It follows these rules:
+// 1. Find every live record in the table, i.e. the current version of a record that is not already deleted
+// 2. For each live record whose ID is not in seenIDs, write a new deleted version using deleteFn
+//
+// Parameters:
+// - ctx: The context of the queries, passed on to deleteFn
+// - tableName: The name of the table to operate on
+// - scopeField: The name of the field that scopes the comparison (e.g., "network_id"), or "" for global resources
+// - scopeValue: The value of the scope field
+// - seenIDs: The IDs of the records returned by the Netmaker API
+// - deleteFn: Function that records a deleted version of the record with the given ID
+//
+// Returns:
+// - []string: the IDs of the records that were marked as deleted
+// - error: any error that occurred while looking up the live records, or ItemErrors for the failed records
+func (db *DB) GenericTombstoneMissing(
+	ctx context.Context,
+	tableName string,
+	scopeField string,
+	scopeValue interface{},
+	seenIDs []string,
+	deleteFn func(ctx context.Context, id string) error,
+) ([]string, error) {
+	// Get the IDs of all live records in scope
+	var liveIDs []string
+	query := fmt.Sprintf("SELECT id FROM %s WHERE is_current = true AND is_deleted = false", tableName)
+	args := []interface{}{}
+	if scopeField != "" {
+		query += fmt.Sprintf(" AND %s = $1", scopeField)
+		args = append(args, scopeValue)
+	}
+	if err := db.SelectContext(ctx, &liveIDs, query, args...); err != nil {
+		return nil, fmt.Errorf("failed to get live records from %s: %w", tableName, err)
+	}
+
+	// Build a set of the IDs returned by the API for quick lookup
+	seen := make(map[string]struct{}, len(seenIDs))
+	for _, id := range seenIDs {
+		seen[id] = struct{}{}
+	}
+
+	// Tombstone anything that has disappeared
+	var deleted []string
+	var failures ItemErrors
+	for _, id := range liveIDs {
+		if _, ok := seen[id]; ok {
+			continue
+		}
+
+		if err := deleteFn(ctx, id); err != nil {
+			logrus.Errorf("Failed to mark record in %s with id = %s as deleted: %v", tableName, id, err)
+			failures = append(failures, ItemError{ID: id, Err: err})
+			continue
+		}
+
+		logrus.Infof("Marked record in %s with id = %s as deleted", tableName, id)
+		deleted = append(deleted, id)
+	}
+
+	if len(failures) > 0 {
+		return deleted, failures
+	}
+	return deleted, nil
+}
+
+// ItemError is the failure of one record in a batch operation
+type ItemError struct {
+	ID  string
+	Err error
+}
+
+// ItemErrors is returned by batch operations that failed for some records but went
+// ahead with the others
+type ItemErrors []ItemError
+
+// Error implements the error interface
+func (e ItemErrors) Error() string {
+	if len(e) == 1 {
+		return fmt.Sprintf("failed for %s: %v", e[0].ID, e[0].Err)
+	}
+	return fmt.Sprintf("failed for %d records, first %s: %v", len(e), e[0].ID, e[0].Err)
 }
@@ -0,0 +1,89 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"netmaker-sync/internal/models"
+	"time"
+)
+
+// CreateNodeHeartbeats appends the check-in state of the nodes observed by a sync
+func (db *DB) CreateNodeHeartbeats(ctx context.Context, heartbeats []models.NodeHeartbeat) error {
+	if len(heartbeats) == 0 {
+		return nil
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, heartbeat := range heartbeats {
+		_, err := tx.NamedExec(`
+			INSERT INTO node_heartbeats (
+				node_id, network_id, connected, last_check_in, last_peer_update, observed_at
+			) VALUES (
+				:node_id, :network_id, :connected, :last_check_in, :last_peer_update, :observed_at
+			)
+		`, heartbeat)
+		if err != nil {
+			return fmt.Errorf("failed to create heartbeat of node %s: %w", heartbeat.NodeID, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// GetLatestNodeHeartbeats retrieves the latest heartbeat of every current node, of one
+// network or of all of them when networkID is empty
+func (db *DB) GetLatestNodeHeartbeats(networkID string) ([]models.NodeHeartbeat, error) {
+	query := `
+		SELECT h.* FROM node_heartbeats AS h
+		JOIN (
+			SELECT node_id, MAX(id) AS id FROM node_heartbeats GROUP BY node_id
+		) AS latest ON latest.id = h.id
+		JOIN nodes AS n ON n.id = h.node_id AND n.is_current = true AND n.is_deleted = false
+	`
+	var args []interface{}
+	if networkID != "" {
+		query += ` WHERE h.network_id = $1`
+		args = append(args, networkID)
+	}
+	query += ` ORDER BY h.network_id, h.node_id`
+
+	heartbeats := []models.NodeHeartbeat{}
+	if err := db.Select(&heartbeats, query, args...); err != nil {
+		return nil, fmt.Errorf("failed to get latest node heartbeats: %w", err)
+	}
+	return heartbeats, nil
+}
+
+// GetNodeHeartbeats retrieves the heartbeats of a node observed since a time, oldest first
+func (db *DB) GetNodeHeartbeats(nodeID string, since time.Time) ([]models.NodeHeartbeat, error) {
+	heartbeats := []models.NodeHeartbeat{}
+	err := db.Select(&heartbeats, fmt.Sprintf(`
+		SELECT * FROM node_heartbeats
+		WHERE node_id = $1 AND %s >= %s
+		ORDER BY observed_at
+	`, db.timestamp("observed_at"), db.timestamp("$2")), nodeID, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get heartbeats of node %s: %w", nodeID, err)
+	}
+	return heartbeats, nil
+}
+
+// PruneNodeHeartbeats deletes the heartbeats observed before a time and returns the
+// number deleted
+func (db *DB) PruneNodeHeartbeats(before time.Time) (int64, error) {
+	result, err := db.Exec(fmt.Sprintf(`
+		DELETE FROM node_heartbeats WHERE %s < %s
+	`, db.timestamp("observed_at"), db.timestamp("$1")), before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune node heartbeats: %w", err)
+	}
+	return result.RowsAffected()
+}
@@ -1,52 +1,61 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"netmaker-sync/internal/models"
-	"reflect"
+	"netmaker-sync/internal/tracing"
 	"time"
 
 	"github.com/sirupsen/logrus"
 )
 
-func (db *DB) UpsertHost(host *models.Host) error {
+func (db *DB) UpsertHost(ctx context.Context, host *models.Host) (result UpsertResult, err error) {
+	ctx, span := startSpan(ctx, "UpsertHost", "hosts", host.ID)
+	defer func() { tracing.End(span, err) }()
+
+	// Protect the sensitive fields before comparing with the current version
+	if err := db.protectFields(ctx, models.ResourceTypeHost, "hosts", host.ID, host.Data); err != nil {
+		return UpsertUnchanged, err
+	}
+
 	// Check if the host exists with any version
 	var exists bool
-	err := db.QueryRow(`
+	err = db.QueryRowContext(ctx, `
 		SELECT EXISTS(SELECT 1 FROM hosts WHERE id = $1)
 	`, host.ID).Scan(&exists)
 
 	if err != nil {
-		return fmt.Errorf("failed to check if host exists: %w", err)
+		return UpsertUnchanged, fmt.Errorf("failed to check if host exists: %w", err)
 	}
 
 	// If host exists, get the current version
 	if exists {
 		var currentHost models.Host
-		err := db.Get(&currentHost, `
+		err := db.GetContext(ctx, &currentHost, `
 			SELECT * FROM hosts 
 			WHERE id = $1 AND is_current = true
 		`, host.ID)
 
 		if err != nil && !errors.Is(err, sql.ErrNoRows) {
-			return fmt.Errorf("failed to get current host: %w", err)
+			return UpsertUnchanged, fmt.Errorf("failed to get current host: %w", err)
 		}
 
 		// If we found a current version, check for changes
 		if err == nil {
 			// Host exists, check if there are meaningful changes
-			if hostsEqual(currentHost, *host) {
-				// No changes, nothing to do
+			if db.hostsEqual(currentHost, *host) {
+				// No changes but to the volatile fields, whose latest values are recorded apart from the data
 				logrus.Debugf("No changes for host %s, skipping update", host.ID)
-				return nil
+				return UpsertUnchanged, db.RefreshVolatileFields(ctx, "hosts", host.ID, currentHost.Data, currentHost.ObservedData, host.Data)
 			}
 
 			// Start a transaction
-			tx, err := db.Beginx()
+			tx, err := db.BeginTxx(ctx, nil)
 			if err != nil {
-				return fmt.Errorf("failed to begin transaction: %w", err)
+				return UpsertUnchanged, fmt.Errorf("failed to begin transaction: %w", err)
 			}
 			defer tx.Rollback()
 
@@ -58,7 +67,7 @@ func (db *DB) UpsertHost(host *models.Host) error {
 			`, host.ID)
 
 			if err != nil {
-				return fmt.Errorf("failed to update current host: %w", err)
+				return UpsertUnchanged, fmt.Errorf("failed to update current host: %w", err)
 			}
 
 			// Get the next version number
@@ -68,7 +77,7 @@ func (db *DB) UpsertHost(host *models.Host) error {
 			`, host.ID)
 
 			if err != nil {
-				return fmt.Errorf("failed to get next version: %w", err)
+				return UpsertUnchanged, fmt.Errorf("failed to get next version: %w", err)
 			}
 
 			// Insert the new version
@@ -78,20 +87,30 @@ func (db *DB) UpsertHost(host *models.Host) error {
 				INSERT INTO hosts (
 					id, version, name, endpoint_ip, endpoint_ipv6, public_key,
 					listen_port, mtu, persistent_keepalive, is_current,
-					last_modified, created_at, data
+					is_deleted, deleted_at, last_modified, created_at, data
 				) VALUES (
 					:id, :version, :name, :endpoint_ip, :endpoint_ipv6, :public_key,
 					:listen_port, :mtu, :persistent_keepalive, true,
-					:last_modified, NOW(), :data
+					:is_deleted, :deleted_at, :last_modified, NOW(), :data
 				)
 			`, host)
 
 			if err != nil {
-				return fmt.Errorf("failed to insert new host version: %w", err)
+				return UpsertUnchanged, fmt.Errorf("failed to insert new host version: %w", err)
+			}
+
+			// Notify database listeners once the transaction commits
+			if err := db.notifyChange(tx, "hosts", host.ID, nextVersion, host); err != nil {
+				return UpsertUnchanged, err
+			}
+
+			if err := tx.Commit(); err != nil {
+				return UpsertUnchanged, fmt.Errorf("failed to commit transaction: %w", err)
 			}
 
 			logrus.Infof("Updated host %s to version %d", host.ID, nextVersion)
-			return tx.Commit()
+			db.publishChange("hosts", host.ID, &currentHost, host, currentHost.Version, nextVersion)
+			return UpsertUpdated, nil
 		}
 	}
 
@@ -100,9 +119,9 @@ func (db *DB) UpsertHost(host *models.Host) error {
 	host.LastModified = time.Now()
 
 	// Start a transaction
-	tx, err := db.Beginx()
+	tx, err := db.BeginTxx(ctx, nil)
 	if err != nil {
-		return fmt.Errorf("failed to begin transaction: %w", err)
+		return UpsertUnchanged, fmt.Errorf("failed to begin transaction: %w", err)
 	}
 	defer tx.Rollback()
 
@@ -110,24 +129,34 @@ func (db *DB) UpsertHost(host *models.Host) error {
 		INSERT INTO hosts (
 			id, version, name, endpoint_ip, endpoint_ipv6, public_key,
 			listen_port, mtu, persistent_keepalive, is_current,
-			last_modified, created_at, data
+			is_deleted, deleted_at, last_modified, created_at, data
 		) VALUES (
 			:id, :version, :name, :endpoint_ip, :endpoint_ipv6, :public_key,
 			:listen_port, :mtu, :persistent_keepalive, true,
-			:last_modified, NOW(), :data
+			:is_deleted, :deleted_at, :last_modified, NOW(), :data
 		)
 	`, host)
 
 	if err != nil {
-		return fmt.Errorf("failed to insert first host version: %w", err)
+		return UpsertUnchanged, fmt.Errorf("failed to insert first host version: %w", err)
+	}
+
+	// Notify database listeners once the transaction commits
+	if err := db.notifyChange(tx, "hosts", host.ID, 1, host); err != nil {
+		return UpsertUnchanged, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return UpsertUnchanged, fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
 	logrus.Infof("Created new host %s", host.ID)
-	return tx.Commit()
+	db.publishChange("hosts", host.ID, nil, host, 0, 1)
+	return UpsertCreated, nil
 }
 
 // hostsEqual compares two hosts to determine if there are meaningful changes
-func hostsEqual(a, b models.Host) bool {
+func (db *DB) hostsEqual(a, b models.Host) bool {
 	// Compare relevant fields, ignoring metadata like LastModified
 	return a.Name == b.Name &&
 		a.EndpointIP == b.EndpointIP &&
@@ -136,7 +165,8 @@ func hostsEqual(a, b models.Host) bool {
 		a.ListenPort == b.ListenPort &&
 		a.MTU == b.MTU &&
 		a.PersistentKeepalive == b.PersistentKeepalive &&
-		reflect.DeepEqual(a.Data, b.Data)
+		a.IsDeleted == b.IsDeleted &&
+		db.dataEqual(models.ResourceTypeHost, a.Data, b.Data)
 }
 
 func (db *DB) GetHosts() ([]models.Host, error) {
@@ -144,7 +174,7 @@ func (db *DB) GetHosts() ([]models.Host, error) {
 	var hosts []models.Host
 	err := db.Select(&hosts, `
 		SELECT * FROM hosts 
-		WHERE is_current = true
+		WHERE is_current = true AND is_deleted = false
 	`)
 	return hosts, err
 }
@@ -159,3 +189,40 @@ func (db *DB) GetHostHistory(hostID string) ([]models.Host, error) {
 	`, hostID)
 	return hosts, err
 }
+
+// DeleteHost records a new, deleted version of a host so that its removal from Netmaker is kept in the history
+func (db *DB) DeleteHost(ctx context.Context, hostID string) (err error) {
+	ctx, span := startSpan(ctx, "DeleteHost", "hosts", hostID)
+	defer func() { tracing.End(span, err) }()
+
+	var host models.Host
+	err = db.GetContext(ctx, &host, `
+		SELECT * FROM hosts 
+		WHERE id = $1 AND is_current = true
+	`, hostID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("host not found: %s", hostID)
+		}
+		return fmt.Errorf("failed to get current host: %w", err)
+	}
+
+	// Nothing to do if the host has already been marked as deleted
+	if host.IsDeleted {
+		return nil
+	}
+
+	deletedAt := time.Now()
+	host.IsDeleted = true
+	host.DeletedAt = &deletedAt
+	_, err = db.UpsertHost(ctx, &host)
+	return err
+}
+
+// DeleteMissingHosts marks hosts that are no longer returned by the Netmaker API as deleted
+func (db *DB) DeleteMissingHosts(ctx context.Context, seenIDs []string) (deletedIDs []string, err error) {
+	ctx, span := startSpan(ctx, "DeleteMissingHosts", "hosts", nil)
+	defer func() { tracing.End(span, err) }()
+
+	return db.GenericTombstoneMissing(ctx, "hosts", "", nil, seenIDs, db.DeleteHost)
+}
@@ -0,0 +1,120 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"netmaker-sync/internal/metrics"
+	"netmaker-sync/internal/tracing"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates the spans of the database operations
+var tracer = otel.Tracer("netmaker-sync/internal/db")
+
+// startSpan starts the span of an operation on a record of a table, or on the whole
+// table when id is nil
+func startSpan(ctx context.Context, name, tableName string, id interface{}) (context.Context, trace.Span) {
+	attrs := []attribute.KeyValue{attribute.String("db.sql.table", tableName)}
+	if id != nil {
+		attrs = append(attrs, attribute.String("netmaker.id", fmt.Sprint(id)))
+	}
+	return tracer.Start(ctx, "db."+name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+}
+
+// startQuery starts the span of a query
+func (db *DB) startQuery(ctx context.Context, query string) (context.Context, trace.Span) {
+	operation := queryOperation(query)
+	return tracer.Start(ctx, "db."+operation, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("db.system", db.driver),
+		attribute.String("db.operation", operation),
+		attribute.String("db.statement", strings.Join(strings.Fields(query), " ")),
+	))
+}
+
+// endQuery records the error of a query, unless it found no rows, and ends its span
+func endQuery(span trace.Span, err error) {
+	if errors.Is(err, sql.ErrNoRows) {
+		err = nil
+	}
+	tracing.End(span, err)
+}
+
+// observeQuery records the latency of a query
+func observeQuery(query string, start time.Time) {
+	metrics.ObserveDBQuery(queryOperation(query), time.Since(start))
+}
+
+// queryOperation returns the first keyword of a query, e.g. select
+func queryOperation(query string) string {
+	if fields := strings.Fields(query); len(fields) > 0 {
+		return strings.ToLower(fields[0])
+	}
+	return "other"
+}
+
+// The methods below shadow those of sqlx.DB to record the latency of every query run
+// outside a transaction. The context variants also trace the query.
+
+// Get runs a query returning one row and scans it into dest
+func (db *DB) Get(dest interface{}, query string, args ...interface{}) error {
+	defer observeQuery(query, time.Now())
+	return db.DB.Get(dest, query, args...)
+}
+
+// GetContext runs a query returning one row and scans it into dest
+func (db *DB) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) (err error) {
+	ctx, span := db.startQuery(ctx, query)
+	defer func() { endQuery(span, err) }()
+	defer observeQuery(query, time.Now())
+	return db.DB.GetContext(ctx, dest, query, args...)
+}
+
+// Select runs a query and scans every row into dest
+func (db *DB) Select(dest interface{}, query string, args ...interface{}) error {
+	defer observeQuery(query, time.Now())
+	return db.DB.Select(dest, query, args...)
+}
+
+// SelectContext runs a query and scans every row into dest
+func (db *DB) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) (err error) {
+	ctx, span := db.startQuery(ctx, query)
+	defer func() { endQuery(span, err) }()
+	defer observeQuery(query, time.Now())
+	return db.DB.SelectContext(ctx, dest, query, args...)
+}
+
+// Exec runs a statement without returning rows
+func (db *DB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	defer observeQuery(query, time.Now())
+	return db.DB.Exec(query, args...)
+}
+
+// ExecContext runs a statement without returning rows
+func (db *DB) ExecContext(ctx context.Context, query string, args ...interface{}) (result sql.Result, err error) {
+	ctx, span := db.startQuery(ctx, query)
+	defer func() { endQuery(span, err) }()
+	defer observeQuery(query, time.Now())
+	return db.DB.ExecContext(ctx, query, args...)
+}
+
+// QueryRow runs a query returning at most one row
+func (db *DB) QueryRow(query string, args ...interface{}) *sql.Row {
+	defer observeQuery(query, time.Now())
+	return db.DB.QueryRow(query, args...)
+}
+
+// QueryRowContext runs a query returning at most one row
+func (db *DB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	ctx, span := db.startQuery(ctx, query)
+	defer observeQuery(query, time.Now())
+	row := db.DB.QueryRowContext(ctx, query, args...)
+	endQuery(span, row.Err())
+	return row
+}
@@ -0,0 +1,457 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"netmaker-sync/internal/models"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidListOptions is returned when a list query has an unknown filter or sort
+// field, or a malformed filter value or cursor
+var ErrInvalidListOptions = errors.New("invalid list options")
+
+// ErrRecordNotFound is returned when a record does not exist
+var ErrRecordNotFound = errors.New("record not found")
+
+// Page sizes of list queries
+const (
+	DefaultListLimit = 100
+	MaxListLimit     = 1000
+)
+
+// ListOptions filter, sort and paginate the records returned by ListResources
+type ListOptions struct {
+	// Filters maps the filter names of the resource to the values to match
+	Filters map[string]string
+	// Sort is the field to sort by, prefixed with "-" for descending order
+	Sort string
+	// Limit is the maximum number of records to return
+	Limit int
+	// Cursor is the NextCursor of the previous page
+	Cursor string
+	// IncludeDeleted also returns records that have been deleted in Netmaker
+	IncludeDeleted bool
+}
+
+// listFilter is a filter of a list query. expr is compared with the filter value.
+type listFilter struct {
+	expr    string
+	boolean bool
+}
+
+// listResource describes a table that can be listed by resource name
+type listResource struct {
+	tableName   string
+	newList     func() interface{}
+	newRecord   func() interface{}
+	versioned   bool
+	tombstoned  bool
+	filters     map[string]listFilter
+	sorts       []string
+	defaultSort string
+}
+
+// listResources maps the resource names used by the HTTP API to their list queries
+func (db *DB) listResources() map[string]listResource {
+	networkFilter := listFilter{expr: "network_id"}
+	return map[string]listResource{
+		"nodes": {
+			tableName:  "nodes",
+			newList:    func() interface{} { return &[]models.Node{} },
+			newRecord:  func() interface{} { return &models.Node{} },
+			versioned:  true,
+			tombstoned: true,
+			filters: map[string]listFilter{
+				"network":            networkFilter,
+				"connected":          {expr: "connected", boolean: true},
+				"is_relay":           {expr: "is_relay", boolean: true},
+				"is_egress_gateway":  {expr: "is_egress_gateway", boolean: true},
+				"is_ingress_gateway": {expr: "is_ingress_gateway", boolean: true},
+				"host_os": {expr: fmt.Sprintf(
+					"(SELECT %s FROM hosts WHERE hosts.id = %s AND hosts.is_current = true)",
+					db.jsonField("hosts.data", "os"), db.jsonField("nodes.data", "hostid"))},
+			},
+			sorts:       []string{"id", "name", "address", "network_id", "version", "last_modified", "created_at"},
+			defaultSort: "id",
+		},
+		"ext_clients": {
+			tableName:  "ext_clients",
+			newList:    func() interface{} { return &[]models.ExtClient{} },
+			newRecord:  func() interface{} { return &models.ExtClient{} },
+			versioned:  true,
+			tombstoned: true,
+			filters: map[string]listFilter{
+				"network": networkFilter,
+				"enabled": {expr: "enabled", boolean: true},
+			},
+			sorts:       []string{"id", "name", "address", "network_id", "version", "last_modified", "created_at"},
+			defaultSort: "id",
+		},
+		"hosts": {
+			tableName:  "hosts",
+			newList:    func() interface{} { return &[]models.Host{} },
+			newRecord:  func() interface{} { return &models.Host{} },
+			versioned:  true,
+			tombstoned: true,
+			filters: map[string]listFilter{
+				"os": {expr: db.jsonField("data", "os")},
+			},
+			sorts:       []string{"id", "name", "endpoint_ip", "version", "last_modified", "created_at"},
+			defaultSort: "id",
+		},
+		"dns": {
+			tableName:  "dns_entries",
+			newList:    func() interface{} { return &[]models.DNSEntry{} },
+			newRecord:  func() interface{} { return &models.DNSEntry{} },
+			versioned:  true,
+			tombstoned: true,
+			filters: map[string]listFilter{
+				"network": networkFilter,
+			},
+			sorts:       []string{"id", "name", "address", "network_id", "version", "last_modified", "created_at"},
+			defaultSort: "id",
+		},
+		"acls": {
+			tableName:  "acls",
+			newList:    func() interface{} { return &[]models.ACL{} },
+			newRecord:  func() interface{} { return &models.ACL{} },
+			versioned:  true,
+			tombstoned: true,
+			filters: map[string]listFilter{
+				"network": networkFilter,
+				"node":    {expr: "node_id"},
+			},
+			sorts:       []string{"id", "node_id", "network_id", "version", "last_modified", "created_at"},
+			defaultSort: "id",
+		},
+		"enrollment_keys": {
+			tableName:  "enrollment_keys",
+			newList:    func() interface{} { return &[]models.EnrollmentKey{} },
+			newRecord:  func() interface{} { return &models.EnrollmentKey{} },
+			versioned:  true,
+			tombstoned: true,
+			filters: map[string]listFilter{
+				"type":      {expr: "type"},
+				"unlimited": {expr: "unlimited", boolean: true},
+			},
+			sorts:       []string{"id", "type", "uses_remaining", "expiration", "version", "last_modified", "created_at"},
+			defaultSort: "id",
+		},
+		"users": {
+			tableName:  "users",
+			newList:    func() interface{} { return &[]models.User{} },
+			newRecord:  func() interface{} { return &models.User{} },
+			versioned:  true,
+			tombstoned: true,
+			filters: map[string]listFilter{
+				"is_admin":       {expr: "is_admin", boolean: true},
+				"is_super_admin": {expr: "is_super_admin", boolean: true},
+			},
+			sorts:       []string{"id", "version", "last_modified", "created_at"},
+			defaultSort: "id",
+		},
+		"user_gateway_assignments": {
+			tableName:  "user_gateway_assignments",
+			newList:    func() interface{} { return &[]models.UserGatewayAssignment{} },
+			newRecord:  func() interface{} { return &models.UserGatewayAssignment{} },
+			versioned:  true,
+			tombstoned: true,
+			filters: map[string]listFilter{
+				"username": {expr: "username"},
+				"gateway":  {expr: "gateway_id"},
+			},
+			sorts:       []string{"id", "username", "gateway_id", "version", "last_modified", "created_at"},
+			defaultSort: "id",
+		},
+		"server_config": {
+			tableName:   "server_config",
+			newList:     func() interface{} { return &[]models.ServerConfig{} },
+			newRecord:   func() interface{} { return &models.ServerConfig{} },
+			versioned:   true,
+			sorts:       []string{"id", "version", "last_modified", "created_at"},
+			defaultSort: "id",
+		},
+		"sync_history": {
+			tableName: "sync_history",
+			newList:   func() interface{} { return &[]models.SyncHistory{} },
+			newRecord: func() interface{} { return &models.SyncHistory{} },
+			filters: map[string]listFilter{
+				"resource_type": {expr: "resource_type"},
+				"status":        {expr: "status"},
+				"run_id":        {expr: "CAST(run_id AS TEXT)"},
+			},
+			sorts:       []string{"id", "resource_type", "status", "started_at"},
+			defaultSort: "-id",
+		},
+	}
+}
+
+// listCursor is the position after the last record of a page. Values are kept as
+// JSON and decoded into the types of the record fields they came from.
+type listCursor struct {
+	Sort  string          `json:"s"`
+	Value json.RawMessage `json:"v"`
+	ID    json.RawMessage `json:"id"`
+}
+
+// ListResources returns a page of the current records of a resource, filtered and
+// sorted by opts. Records are ordered by the sort field and then by ID, so that the
+// cursor of a page continues exactly where it ended.
+func (db *DB) ListResources(resource string, opts ListOptions) (*models.Page, error) {
+	res, ok := db.listResources()[resource]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownResource, resource)
+	}
+
+	limit := opts.Limit
+	if limit < 1 {
+		limit = DefaultListLimit
+	}
+	if limit > MaxListLimit {
+		limit = MaxListLimit
+	}
+
+	var where []string
+	var args []interface{}
+	param := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if res.versioned {
+		where = append(where, "is_current = true")
+	}
+	if res.tombstoned && !opts.IncludeDeleted {
+		where = append(where, "is_deleted = false")
+	}
+
+	// Apply the filters in a stable order so that equal queries are identical
+	names := make([]string, 0, len(opts.Filters))
+	for name := range opts.Filters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		filter, ok := res.filters[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown filter %q for %s", ErrInvalidListOptions, name, resource)
+		}
+
+		var value interface{} = opts.Filters[name]
+		if filter.boolean {
+			b, err := strconv.ParseBool(opts.Filters[name])
+			if err != nil {
+				return nil, fmt.Errorf("%w: filter %s must be true or false", ErrInvalidListOptions, name)
+			}
+			value = b
+		}
+		where = append(where, fmt.Sprintf("%s = %s", filter.expr, param(value)))
+	}
+
+	// Resolve the sort field and the types of the values in the cursor
+	sortName := opts.Sort
+	if sortName == "" {
+		sortName = res.defaultSort
+	}
+	descending := strings.HasPrefix(sortName, "-")
+	column := strings.TrimPrefix(sortName, "-")
+	if !slices.Contains(res.sorts, column) {
+		return nil, fmt.Errorf("%w: cannot sort %s by %q", ErrInvalidListOptions, resource, column)
+	}
+
+	prototype := reflect.ValueOf(res.newRecord()).Elem()
+	sortField, _ := fieldByColumn(prototype, column)
+	idField, _ := fieldByColumn(prototype, "id")
+	// Pointer fields are nullable columns. NULLs sort last in ascending and first in
+	// descending order on both databases, which the cursor conditions below follow.
+	nullable := sortField.Type.Kind() == reflect.Ptr
+	sortType := sortField.Type
+	if nullable {
+		sortType = sortType.Elem()
+	}
+	sortExpr := column
+	if sortType == reflect.TypeOf(time.Time{}) {
+		sortExpr = db.timestamp(column)
+	}
+
+	direction, comparison := "ASC", ">"
+	if descending {
+		direction, comparison = "DESC", "<"
+	}
+
+	if opts.Cursor != "" {
+		value, id, err := decodeCursor(opts.Cursor, sortName, sortField.Type, idField.Type)
+		if err != nil {
+			return nil, err
+		}
+
+		idParam := param(id)
+		switch {
+		case column == "id":
+			where = append(where, fmt.Sprintf("id %s %s", comparison, idParam))
+		case nullable && reflect.ValueOf(value).IsNil():
+			// The page ended among the NULLs, which are followed by the remaining NULLs
+			// and, in descending order, by every value
+			if descending {
+				where = append(where, fmt.Sprintf("(%s IS NOT NULL OR id < %s)", column, idParam))
+			} else {
+				where = append(where, fmt.Sprintf("(%s IS NULL AND id > %s)", column, idParam))
+			}
+		default:
+			if nullable {
+				value = reflect.ValueOf(value).Elem().Interface()
+			}
+			valueExpr := param(value)
+			if sortExpr != column {
+				valueExpr = db.timestamp(valueExpr)
+			}
+			after := fmt.Sprintf("(%s %s %s OR (%s = %s AND id %s %s))",
+				sortExpr, comparison, valueExpr, sortExpr, valueExpr, comparison, idParam)
+			if nullable && descending {
+				after = fmt.Sprintf("(%s IS NOT NULL AND %s)", column, after)
+			} else if nullable {
+				after = fmt.Sprintf("(%s IS NULL OR %s)", column, after)
+			}
+			where = append(where, after)
+		}
+	}
+
+	query := "SELECT * FROM " + res.tableName
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY "
+	if nullable {
+		query += fmt.Sprintf("%s IS NULL %s, ", column, direction)
+	}
+	query += fmt.Sprintf("%s %s", sortExpr, direction)
+	if column != "id" {
+		query += fmt.Sprintf(", id %s", direction)
+	}
+	// Fetch one record more than requested to know whether there is a next page
+	query += " LIMIT " + param(limit+1)
+
+	list := res.newList()
+	if err := db.Select(list, query, args...); err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", resource, err)
+	}
+
+	items := reflect.ValueOf(list).Elem()
+	page := &models.Page{}
+	if items.Len() > limit {
+		items = items.Slice(0, limit)
+		cursor, err := encodeCursor(sortName, items.Index(limit-1), column)
+		if err != nil {
+			return nil, err
+		}
+		page.NextCursor = cursor
+	}
+	page.Items = items.Interface()
+	return page, nil
+}
+
+// GetCurrentResource retrieves the current version of a record by resource name.
+// The returned value is a pointer to the model for the resource (e.g. *models.Node).
+func (db *DB) GetCurrentResource(resource string, id string) (interface{}, error) {
+	r, err := lookupResource(resource)
+	if err != nil {
+		return nil, err
+	}
+
+	record := r.newRecord()
+	query := fmt.Sprintf("SELECT * FROM %s WHERE id = $1 AND is_current = true", r.tableName)
+	if err := db.Get(record, query, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w: %s %s", ErrRecordNotFound, resource, id)
+		}
+		return nil, fmt.Errorf("failed to get %s %s: %w", resource, id, err)
+	}
+	return record, nil
+}
+
+// GetResourceHistory retrieves every version of a record by resource name, oldest
+// first. The returned value is a slice of the model for the resource (e.g. []models.Node).
+func (db *DB) GetResourceHistory(resource string, id string) (interface{}, error) {
+	r, err := lookupResource(resource)
+	if err != nil {
+		return nil, err
+	}
+
+	list := reflect.New(reflect.SliceOf(reflect.TypeOf(r.newRecord()).Elem()))
+	query := fmt.Sprintf("SELECT * FROM %s WHERE id = $1 ORDER BY version", r.tableName)
+	if err := db.Select(list.Interface(), query, id); err != nil {
+		return nil, fmt.Errorf("failed to get history of %s %s: %w", resource, id, err)
+	}
+	if list.Elem().Len() == 0 {
+		return nil, fmt.Errorf("%w: %s %s", ErrRecordNotFound, resource, id)
+	}
+	return list.Elem().Interface(), nil
+}
+
+// encodeCursor returns the cursor positioned after a record
+func encodeCursor(sortName string, record reflect.Value, column string) (string, error) {
+	_, sortIndex := fieldByColumn(record, column)
+	_, idIndex := fieldByColumn(record, "id")
+
+	value, err := json.Marshal(record.FieldByIndex(sortIndex).Interface())
+	if err != nil {
+		return "", fmt.Errorf("failed to encode cursor: %w", err)
+	}
+	id, err := json.Marshal(record.FieldByIndex(idIndex).Interface())
+	if err != nil {
+		return "", fmt.Errorf("failed to encode cursor: %w", err)
+	}
+
+	cursor, err := json.Marshal(listCursor{Sort: sortName, Value: value, ID: id})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode cursor: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(cursor), nil
+}
+
+// decodeCursor returns the sort value and ID stored in a cursor
+func decodeCursor(encoded string, sortName string, valueType, idType reflect.Type) (interface{}, interface{}, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: malformed cursor", ErrInvalidListOptions)
+	}
+
+	var cursor listCursor
+	if err := json.Unmarshal(raw, &cursor); err != nil {
+		return nil, nil, fmt.Errorf("%w: malformed cursor", ErrInvalidListOptions)
+	}
+	if cursor.Sort != sortName {
+		return nil, nil, fmt.Errorf("%w: cursor was issued for sort %q", ErrInvalidListOptions, cursor.Sort)
+	}
+
+	value := reflect.New(valueType)
+	if err := json.Unmarshal(cursor.Value, value.Interface()); err != nil {
+		return nil, nil, fmt.Errorf("%w: malformed cursor", ErrInvalidListOptions)
+	}
+	id := reflect.New(idType)
+	if err := json.Unmarshal(cursor.ID, id.Interface()); err != nil {
+		return nil, nil, fmt.Errorf("%w: malformed cursor", ErrInvalidListOptions)
+	}
+	return value.Elem().Interface(), id.Elem().Interface(), nil
+}
+
+// fieldByColumn finds the struct field mapped to a column by its db tag
+func fieldByColumn(record reflect.Value, column string) (reflect.StructField, []int) {
+	recordType := record.Type()
+	for i := 0; i < recordType.NumField(); i++ {
+		field := recordType.Field(i)
+		if field.Tag.Get("db") == column {
+			return field, field.Index
+		}
+	}
+	panic(fmt.Sprintf("%s has no field for column %s", recordType, column))
+}
@@ -0,0 +1,94 @@
+package db
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"netmaker-sync/internal/config"
+	"netmaker-sync/internal/models"
+)
+
+// openTestDB opens an empty SQLite database
+func openTestDB(t *testing.T) *DB {
+	t.Helper()
+
+	database, err := New(&config.DatabaseConfig{Driver: DriverSQLite, Path: filepath.Join(t.TempDir(), "sync.db")})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() { database.Close() })
+	return database
+}
+
+// newTestDB opens a migrated SQLite database
+func newTestDB(t *testing.T) *DB {
+	t.Helper()
+
+	database := openTestDB(t)
+	if err := database.Initialize(true); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	return database
+}
+
+func TestListResourcesCursorCoversNullsAndTies(t *testing.T) {
+	database := newTestDB(t)
+
+	inOneHour := time.Date(2026, 1, 1, 1, 0, 0, 0, time.UTC)
+	inTwoHours := inOneHour.Add(time.Hour)
+	keys := []models.EnrollmentKey{
+		{ID: "k1", UsesRemaining: 1, Expiration: &inTwoHours},
+		{ID: "k2", UsesRemaining: 3},
+		{ID: "k3", UsesRemaining: 1, Expiration: &inOneHour},
+		{ID: "k4", UsesRemaining: 1},
+		{ID: "k5", UsesRemaining: 3, Expiration: &inOneHour},
+	}
+	for i := range keys {
+		keys[i].Data = models.JSONB{"value": keys[i].ID}
+		if _, err := database.UpsertEnrollmentKey(context.Background(), &keys[i]); err != nil {
+			t.Fatalf("failed to upsert enrollment key %s: %v", keys[i].ID, err)
+		}
+	}
+
+	tests := []struct {
+		sort string
+		want []string
+	}{
+		{sort: "id", want: []string{"k1", "k2", "k3", "k4", "k5"}},
+		{sort: "-id", want: []string{"k5", "k4", "k3", "k2", "k1"}},
+		{sort: "uses_remaining", want: []string{"k1", "k3", "k4", "k2", "k5"}},
+		{sort: "-uses_remaining", want: []string{"k5", "k2", "k4", "k3", "k1"}},
+		{sort: "expiration", want: []string{"k3", "k5", "k1", "k2", "k4"}},
+		{sort: "-expiration", want: []string{"k4", "k2", "k1", "k5", "k3"}},
+	}
+	for _, tt := range tests {
+		for _, limit := range []int{1, 2, 3, 5} {
+			var got []string
+			opts := ListOptions{Sort: tt.sort, Limit: limit}
+			for pages := 0; ; pages++ {
+				if pages > len(keys) {
+					t.Fatalf("sort %s, limit %d: cursor did not reach the end after %v", tt.sort, limit, got)
+				}
+
+				page, err := database.ListResources("enrollment_keys", opts)
+				if err != nil {
+					t.Fatalf("sort %s, limit %d: %v", tt.sort, limit, err)
+				}
+				for _, key := range page.Items.([]models.EnrollmentKey) {
+					got = append(got, key.ID)
+				}
+				if page.NextCursor == "" {
+					break
+				}
+				opts.Cursor = page.NextCursor
+			}
+
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("sort %s, limit %d: got %v, want %v", tt.sort, limit, got, tt.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,434 @@
+package db
+
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+)
+
+// migrationsFS holds the ordered schema migrations of each driver in
+// migrations/<driver>. Files are named <version>_<name>.up.sql and
+// <version>_<name>.down.sql, and both drivers share the same versions.
+//
+//go:embed migrations/*/*.sql
+var migrationsFS embed.FS
+
+// migrationLockID is the advisory lock key held while a migration is applied so
+// that concurrent instances do not race each other
+const migrationLockID = 7261636501
+
+// ErrChecksumMismatch is returned when an applied migration no longer matches its embedded SQL
+var ErrChecksumMismatch = errors.New("migration checksum mismatch")
+
+// ErrPendingMigrations is returned when the schema is behind and automatic migration is disabled
+var ErrPendingMigrations = errors.New("database has pending migrations")
+
+// Migration is a single versioned schema change
+type Migration struct {
+	Version  int
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+}
+
+// MigrationState describes a migration and whether it has been applied
+type MigrationState struct {
+	Migration
+	Applied         bool
+	AppliedAt       *time.Time
+	AppliedChecksum string
+}
+
+// appliedMigration is a row of the schema_migrations table
+type appliedMigration struct {
+	Version   int       `db:"version"`
+	Checksum  string    `db:"checksum"`
+	AppliedAt time.Time `db:"applied_at"`
+}
+
+// loadMigrations reads the embedded migrations of a driver ordered by version
+func loadMigrations(driver string) ([]Migration, error) {
+	files, err := fs.Glob(migrationsFS, path.Join("migrations", driver, "*.sql"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list migrations: %w", err)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, file := range files {
+		base := path.Base(file)
+
+		var direction string
+		switch {
+		case strings.HasSuffix(base, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(base, ".down.sql"):
+			direction = "down"
+		default:
+			return nil, fmt.Errorf("migration %s must end in .up.sql or .down.sql", base)
+		}
+
+		stem := strings.TrimSuffix(base, "."+direction+".sql")
+		versionPart, name, ok := strings.Cut(stem, "_")
+		if !ok {
+			return nil, fmt.Errorf("migration %s must be named <version>_<name>", base)
+		}
+		version, err := strconv.Atoi(versionPart)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s has an invalid version", base)
+		}
+
+		contents, err := migrationsFS.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", base, err)
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: name}
+			byVersion[version] = migration
+		} else if migration.Name != name {
+			return nil, fmt.Errorf("migration version %d is used by both %s and %s", version, migration.Name, name)
+		}
+
+		if direction == "up" {
+			migration.Up = string(contents)
+			sum := sha256.Sum256(contents)
+			migration.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			migration.Down = string(contents)
+		}
+	}
+
+	if len(byVersion) == 0 {
+		return nil, fmt.Errorf("no migrations for driver %s", driver)
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up migration", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// ensureMigrationsTable creates the schema_migrations table, upgrading the layout
+// used before migrations carried a name and checksum
+func (db *DB) ensureMigrationsTable() error {
+	if db.driver == DriverSQLite {
+		// SQLite support was added after checksums, so there is no older layout to upgrade
+		if _, err := db.Exec(`
+			CREATE TABLE IF NOT EXISTS schema_migrations (
+				version INTEGER PRIMARY KEY,
+				applied_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+				name TEXT NOT NULL DEFAULT '',
+				checksum TEXT NOT NULL DEFAULT ''
+			)
+		`); err != nil {
+			return fmt.Errorf("failed to create schema_migrations table: %w", err)
+		}
+		return nil
+	}
+
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			applied_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		)
+	`); err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+
+	if _, err := db.Exec(`
+		ALTER TABLE schema_migrations
+			ADD COLUMN IF NOT EXISTS name TEXT NOT NULL DEFAULT '',
+			ADD COLUMN IF NOT EXISTS checksum TEXT NOT NULL DEFAULT ''
+	`); err != nil {
+		return fmt.Errorf("failed to upgrade schema_migrations table: %w", err)
+	}
+
+	// Versions recorded before checksums existed are trusted as they are
+	migrations, err := loadMigrations(db.driver)
+	if err != nil {
+		return err
+	}
+	for _, migration := range migrations {
+		if _, err := db.Exec(`
+			UPDATE schema_migrations SET name = $2, checksum = $3
+			WHERE version = $1 AND checksum = ''
+		`, migration.Version, migration.Name, migration.Checksum); err != nil {
+			return fmt.Errorf("failed to record checksum of migration %d: %w", migration.Version, err)
+		}
+	}
+
+	return nil
+}
+
+// appliedMigrations returns the applied migrations keyed by version
+func (db *DB) appliedMigrations() (map[int]appliedMigration, error) {
+	var rows []appliedMigration
+	if err := db.Select(&rows, `SELECT version, checksum, applied_at FROM schema_migrations`); err != nil {
+		return nil, fmt.Errorf("failed to get applied migrations: %w", err)
+	}
+
+	applied := make(map[int]appliedMigration, len(rows))
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+	return applied, nil
+}
+
+// MigrationStatus returns every known migration and whether it has been applied
+func (db *DB) MigrationStatus() ([]MigrationState, error) {
+	if err := db.ensureMigrationsTable(); err != nil {
+		return nil, err
+	}
+
+	migrations, err := loadMigrations(db.driver)
+	if err != nil {
+		return nil, err
+	}
+	applied, err := db.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	states := make([]MigrationState, 0, len(migrations))
+	for _, migration := range migrations {
+		state := MigrationState{Migration: migration}
+		if row, ok := applied[migration.Version]; ok {
+			appliedAt := row.AppliedAt
+			state.Applied = true
+			state.AppliedAt = &appliedAt
+			state.AppliedChecksum = row.Checksum
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+// SchemaVersion returns the highest applied migration version, or 0 for an empty database
+func (db *DB) SchemaVersion() (int, error) {
+	if err := db.ensureMigrationsTable(); err != nil {
+		return 0, err
+	}
+
+	var version int
+	if err := db.Get(&version, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`); err != nil {
+		return 0, fmt.Errorf("failed to get schema version: %w", err)
+	}
+	return version, nil
+}
+
+// PendingMigrations returns the migrations that have not been applied yet
+func (db *DB) PendingMigrations() ([]Migration, error) {
+	states, err := db.MigrationStatus()
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []Migration
+	for _, state := range states {
+		if !state.Applied {
+			pending = append(pending, state.Migration)
+		}
+	}
+	return pending, nil
+}
+
+// MigrateUp applies every pending migration and returns the number applied
+func (db *DB) MigrateUp() (int, error) {
+	migrations, err := loadMigrations(db.driver)
+	if err != nil {
+		return 0, err
+	}
+	return db.MigrateTo(migrations[len(migrations)-1].Version)
+}
+
+// MigrateDown reverts the given number of most recently applied migrations and returns the number reverted
+func (db *DB) MigrateDown(steps int) (int, error) {
+	if steps <= 0 {
+		return 0, nil
+	}
+
+	states, err := db.MigrationStatus()
+	if err != nil {
+		return 0, err
+	}
+
+	var applied []int
+	for _, state := range states {
+		if state.Applied {
+			applied = append(applied, state.Version)
+		}
+	}
+	if steps > len(applied) {
+		steps = len(applied)
+	}
+
+	target := 0
+	if steps < len(applied) {
+		target = applied[len(applied)-steps-1]
+	}
+	return db.MigrateTo(target)
+}
+
+// MigrateTo applies or reverts migrations until the schema is at the given version.
+// Version 0 reverts every migration. It returns the number of migrations applied or reverted.
+func (db *DB) MigrateTo(target int) (int, error) {
+	states, err := db.MigrationStatus()
+	if err != nil {
+		return 0, err
+	}
+
+	known := target == 0
+	for _, state := range states {
+		if state.Version == target {
+			known = true
+		}
+		if state.Applied && state.AppliedChecksum != state.Checksum {
+			return 0, fmt.Errorf("%w: migration %d_%s was applied as %s but is now %s",
+				ErrChecksumMismatch, state.Version, state.Name, state.AppliedChecksum, state.Checksum)
+		}
+	}
+	if !known {
+		return 0, fmt.Errorf("unknown migration version %d", target)
+	}
+
+	count := 0
+
+	// Apply pending migrations up to the target in ascending order
+	for _, state := range states {
+		if state.Version > target || state.Applied {
+			continue
+		}
+		if err := db.applyMigration(state.Migration); err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	// Revert applied migrations above the target in descending order
+	for i := len(states) - 1; i >= 0; i-- {
+		state := states[i]
+		if state.Version <= target || !state.Applied {
+			continue
+		}
+		if err := db.revertMigration(state.Migration); err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	return count, nil
+}
+
+// lockMigrations serializes migrations across instances for the rest of the transaction.
+// SQLite transactions already hold the database write lock.
+func (db *DB) lockMigrations(tx *sqlx.Tx) error {
+	if db.driver != DriverPostgres {
+		return nil
+	}
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1)`, migrationLockID); err != nil {
+		return fmt.Errorf("failed to lock migrations: %w", err)
+	}
+	return nil
+}
+
+// applyMigration runs an up migration and records it in a single transaction
+func (db *DB) applyMigration(migration Migration) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction for migration %d: %w", migration.Version, err)
+	}
+	defer tx.Rollback()
+
+	if err := db.lockMigrations(tx); err != nil {
+		return err
+	}
+
+	// Another instance may have applied it while we waited for the lock
+	var applied bool
+	if err := tx.Get(&applied, `SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)`, migration.Version); err != nil {
+		return fmt.Errorf("failed to check migration %d: %w", migration.Version, err)
+	}
+	if applied {
+		return nil
+	}
+
+	logrus.Infof("Applying migration %d_%s", migration.Version, migration.Name)
+	if _, err := tx.Exec(migration.Up); err != nil {
+		return fmt.Errorf("failed to apply migration %d_%s: %w", migration.Version, migration.Name, err)
+	}
+
+	if _, err := tx.Exec(`
+		INSERT INTO schema_migrations (version, name, checksum) VALUES ($1, $2, $3)
+	`, migration.Version, migration.Name, migration.Checksum); err != nil {
+		return fmt.Errorf("failed to record migration %d: %w", migration.Version, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit migration %d: %w", migration.Version, err)
+	}
+
+	logrus.Infof("Migration %d_%s applied", migration.Version, migration.Name)
+	return nil
+}
+
+// revertMigration runs a down migration and removes its record in a single transaction
+func (db *DB) revertMigration(migration Migration) error {
+	if migration.Down == "" {
+		return fmt.Errorf("migration %d_%s cannot be reverted: it has no down migration", migration.Version, migration.Name)
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction for migration %d: %w", migration.Version, err)
+	}
+	defer tx.Rollback()
+
+	if err := db.lockMigrations(tx); err != nil {
+		return err
+	}
+
+	var applied bool
+	if err := tx.Get(&applied, `SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)`, migration.Version); err != nil {
+		return fmt.Errorf("failed to check migration %d: %w", migration.Version, err)
+	}
+	if !applied {
+		return nil
+	}
+
+	logrus.Infof("Reverting migration %d_%s", migration.Version, migration.Name)
+	if _, err := tx.Exec(migration.Down); err != nil {
+		return fmt.Errorf("failed to revert migration %d_%s: %w", migration.Version, migration.Name, err)
+	}
+
+	if _, err := tx.Exec(`DELETE FROM schema_migrations WHERE version = $1`, migration.Version); err != nil {
+		return fmt.Errorf("failed to remove record of migration %d: %w", migration.Version, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit revert of migration %d: %w", migration.Version, err)
+	}
+
+	logrus.Infof("Migration %d_%s reverted", migration.Version, migration.Name)
+	return nil
+}
@@ -0,0 +1,139 @@
+package db
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestDriversShareMigrationVersions(t *testing.T) {
+	postgres, err := loadMigrations(DriverPostgres)
+	if err != nil {
+		t.Fatalf("failed to load postgres migrations: %v", err)
+	}
+	sqlite, err := loadMigrations(DriverSQLite)
+	if err != nil {
+		t.Fatalf("failed to load sqlite migrations: %v", err)
+	}
+
+	if len(postgres) != len(sqlite) {
+		t.Fatalf("got %d postgres and %d sqlite migrations, want the same number", len(postgres), len(sqlite))
+	}
+	for i := range postgres {
+		if postgres[i].Version != sqlite[i].Version || postgres[i].Name != sqlite[i].Name {
+			t.Errorf("migration %d is %d_%s for postgres and %d_%s for sqlite", i,
+				postgres[i].Version, postgres[i].Name, sqlite[i].Version, sqlite[i].Name)
+		}
+		if postgres[i].Down == "" || sqlite[i].Down == "" {
+			t.Errorf("migration %d_%s has no down migration", postgres[i].Version, postgres[i].Name)
+		}
+	}
+}
+
+// tables returns the names of the tables of a SQLite database, other than schema_migrations
+func tables(t *testing.T, database *DB) []string {
+	t.Helper()
+
+	var names []string
+	err := database.Select(&names, `
+		SELECT name FROM sqlite_master
+		WHERE type = 'table' AND name NOT LIKE 'sqlite_%' AND name != 'schema_migrations'
+		ORDER BY name
+	`)
+	if err != nil {
+		t.Fatalf("failed to list tables: %v", err)
+	}
+	return names
+}
+
+func TestMigrateUpDownAndTo(t *testing.T) {
+	database := openTestDB(t)
+	migrations, err := loadMigrations(DriverSQLite)
+	if err != nil {
+		t.Fatalf("failed to load migrations: %v", err)
+	}
+	latest := migrations[len(migrations)-1].Version
+
+	steps := []struct {
+		name        string
+		migrate     func() (int, error)
+		wantCount   int
+		wantVersion int
+	}{
+		{name: "up", migrate: database.MigrateUp, wantCount: len(migrations), wantVersion: latest},
+		{name: "up again", migrate: database.MigrateUp, wantCount: 0, wantVersion: latest},
+		{name: "down one", migrate: func() (int, error) { return database.MigrateDown(1) }, wantCount: 1, wantVersion: latest - 1},
+		{name: "down none", migrate: func() (int, error) { return database.MigrateDown(0) }, wantCount: 0, wantVersion: latest - 1},
+		{name: "to 3", migrate: func() (int, error) { return database.MigrateTo(3) }, wantCount: latest - 4, wantVersion: 3},
+		{name: "to 5", migrate: func() (int, error) { return database.MigrateTo(5) }, wantCount: 2, wantVersion: 5},
+		{name: "down past the first", migrate: func() (int, error) { return database.MigrateDown(100) }, wantCount: 5, wantVersion: 0},
+		{name: "up from empty", migrate: database.MigrateUp, wantCount: len(migrations), wantVersion: latest},
+		{name: "to 0", migrate: func() (int, error) { return database.MigrateTo(0) }, wantCount: len(migrations), wantVersion: 0},
+	}
+
+	for _, step := range steps {
+		count, err := step.migrate()
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if count != step.wantCount {
+			t.Errorf("%s: migrated %d, want %d", step.name, count, step.wantCount)
+		}
+
+		version, err := database.SchemaVersion()
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if version != step.wantVersion {
+			t.Errorf("%s: schema version %d, want %d", step.name, version, step.wantVersion)
+		}
+
+		pending, err := database.PendingMigrations()
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if want := len(migrations) - step.wantVersion; len(pending) != want {
+			t.Errorf("%s: %d pending migrations, want %d", step.name, len(pending), want)
+		}
+	}
+
+	// Reverting every migration leaves no table behind
+	if remaining := tables(t, database); len(remaining) > 0 {
+		t.Errorf("tables left after reverting every migration: %v", remaining)
+	}
+}
+
+func TestMigrateDownRestoresTheSchema(t *testing.T) {
+	database := openTestDB(t)
+	if _, err := database.MigrateUp(); err != nil {
+		t.Fatalf("failed to migrate up: %v", err)
+	}
+	want := tables(t, database)
+
+	if _, err := database.MigrateDown(1); err != nil {
+		t.Fatalf("failed to migrate down: %v", err)
+	}
+	if _, err := database.MigrateUp(); err != nil {
+		t.Fatalf("failed to migrate up again: %v", err)
+	}
+	if got := tables(t, database); !slices.Equal(got, want) {
+		t.Errorf("got tables %v after down and up, want %v", got, want)
+	}
+}
+
+func TestMigrateToRejectsUnknownVersionsAndChangedMigrations(t *testing.T) {
+	database := openTestDB(t)
+	if _, err := database.MigrateTo(9999); err == nil {
+		t.Error("MigrateTo(9999) succeeded, want an error")
+	}
+
+	if _, err := database.MigrateTo(1); err != nil {
+		t.Fatalf("failed to migrate to 1: %v", err)
+	}
+	if _, err := database.Exec(`UPDATE schema_migrations SET checksum = 'changed' WHERE version = 1`); err != nil {
+		t.Fatalf("failed to change checksum: %v", err)
+	}
+	if _, err := database.MigrateUp(); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("MigrateUp() error = %v, want %v", err, ErrChecksumMismatch)
+	}
+}
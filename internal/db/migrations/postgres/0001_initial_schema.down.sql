@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS sync_history;
+DROP TABLE IF EXISTS hosts;
+DROP TABLE IF EXISTS dns_entries;
+DROP TABLE IF EXISTS ext_clients;
+DROP TABLE IF EXISTS nodes;
+DROP TABLE IF EXISTS networks;
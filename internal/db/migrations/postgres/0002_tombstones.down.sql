@@ -0,0 +1,8 @@
+-- The unique constraint on networks.id and the dns_entries foreign key are not
+-- restored: once a network has more than one version they can no longer hold.
+
+ALTER TABLE hosts DROP COLUMN IF EXISTS is_deleted, DROP COLUMN IF EXISTS deleted_at;
+ALTER TABLE dns_entries DROP COLUMN IF EXISTS is_deleted, DROP COLUMN IF EXISTS deleted_at;
+ALTER TABLE ext_clients DROP COLUMN IF EXISTS is_deleted, DROP COLUMN IF EXISTS deleted_at;
+ALTER TABLE nodes DROP COLUMN IF EXISTS is_deleted, DROP COLUMN IF EXISTS deleted_at;
+ALTER TABLE networks DROP COLUMN IF EXISTS is_deleted, DROP COLUMN IF EXISTS deleted_at;
@@ -0,0 +1,22 @@
+-- Record deletions in Netmaker as tombstone versions. Networks need more than
+-- one version per id for this, so drop the unique constraint on networks.id
+-- and the dns_entries foreign key that depended on it.
+
+ALTER TABLE dns_entries DROP CONSTRAINT IF EXISTS dns_entries_network_id_fkey;
+ALTER TABLE networks DROP CONSTRAINT IF EXISTS networks_id_unique;
+
+ALTER TABLE networks
+	ADD COLUMN IF NOT EXISTS is_deleted BOOLEAN NOT NULL DEFAULT FALSE,
+	ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE nodes
+	ADD COLUMN IF NOT EXISTS is_deleted BOOLEAN NOT NULL DEFAULT FALSE,
+	ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE ext_clients
+	ADD COLUMN IF NOT EXISTS is_deleted BOOLEAN NOT NULL DEFAULT FALSE,
+	ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE dns_entries
+	ADD COLUMN IF NOT EXISTS is_deleted BOOLEAN NOT NULL DEFAULT FALSE,
+	ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE hosts
+	ADD COLUMN IF NOT EXISTS is_deleted BOOLEAN NOT NULL DEFAULT FALSE,
+	ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP WITH TIME ZONE;
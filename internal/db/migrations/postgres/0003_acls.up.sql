@@ -0,0 +1,15 @@
+-- Create the acls table that UpsertACLs writes to and point-in-time queries
+-- read from. Neither networks.id nor nodes.id is unique across versions, so
+-- there are no foreign keys.
+
+CREATE TABLE IF NOT EXISTS acls (
+	id INTEGER NOT NULL,
+	version INTEGER NOT NULL,
+	network_id TEXT NOT NULL,
+	node_id TEXT NOT NULL,
+	is_current BOOLEAN NOT NULL DEFAULT TRUE,
+	data JSONB NOT NULL,
+	last_modified TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+	created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (id, version)
+);
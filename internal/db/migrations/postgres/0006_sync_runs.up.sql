@@ -0,0 +1,19 @@
+-- Sync runs group the per-resource sync history of one SyncAll, or of a single
+-- resource sync triggered through the API, and count what they changed
+
+CREATE TABLE IF NOT EXISTS sync_runs (
+	id SERIAL PRIMARY KEY,
+	scope TEXT NOT NULL,
+	network_id TEXT,
+	status TEXT NOT NULL,
+	message TEXT,
+	counts JSONB NOT NULL DEFAULT '{}',
+	started_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+	completed_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS sync_runs_started_at_idx ON sync_runs (started_at);
+
+ALTER TABLE sync_history ADD COLUMN IF NOT EXISTS run_id INTEGER REFERENCES sync_runs (id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS sync_history_run_id_idx ON sync_history (run_id);
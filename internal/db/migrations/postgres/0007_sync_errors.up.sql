@@ -0,0 +1,15 @@
+-- Failures of individual records, or of a whole resource sync, recorded by a sync run
+
+CREATE TABLE IF NOT EXISTS sync_errors (
+	id SERIAL PRIMARY KEY,
+	run_id INTEGER NOT NULL REFERENCES sync_runs (id) ON DELETE CASCADE,
+	sync_history_id INTEGER REFERENCES sync_history (id) ON DELETE SET NULL,
+	resource_type TEXT NOT NULL,
+	network_id TEXT,
+	resource_id TEXT,
+	operation TEXT NOT NULL,
+	error TEXT NOT NULL,
+	occurred_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS sync_errors_run_id_idx ON sync_errors (run_id);
@@ -0,0 +1,15 @@
+-- Requests that changed something through the HTTP API, or were denied, and who made them
+
+CREATE TABLE IF NOT EXISTS audit_log (
+	id SERIAL PRIMARY KEY,
+	principal TEXT NOT NULL,
+	auth_method TEXT NOT NULL,
+	role TEXT NOT NULL,
+	method TEXT NOT NULL,
+	path TEXT NOT NULL,
+	status_code INTEGER NOT NULL,
+	remote_addr TEXT NOT NULL,
+	occurred_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_occurred_at_idx ON audit_log (occurred_at);
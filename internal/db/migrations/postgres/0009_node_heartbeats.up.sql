@@ -0,0 +1,15 @@
+-- Check-in state of every node observed by each sync, kept for a retention period to
+-- tell when nodes last talked to the server and how often they were up
+
+CREATE TABLE IF NOT EXISTS node_heartbeats (
+	id BIGSERIAL PRIMARY KEY,
+	node_id TEXT NOT NULL,
+	network_id TEXT NOT NULL,
+	connected BOOLEAN NOT NULL,
+	last_check_in TIMESTAMP WITH TIME ZONE,
+	last_peer_update TIMESTAMP WITH TIME ZONE,
+	observed_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS node_heartbeats_node_id_idx ON node_heartbeats (node_id, observed_at);
+CREATE INDEX IF NOT EXISTS node_heartbeats_observed_at_idx ON node_heartbeats (observed_at);
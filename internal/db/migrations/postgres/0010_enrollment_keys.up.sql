@@ -0,0 +1,20 @@
+-- Versions of the Netmaker enrollment keys. The id is derived from the key value, and
+-- the value and token are only kept hashed in data.
+
+CREATE TABLE IF NOT EXISTS enrollment_keys (
+	id TEXT NOT NULL,
+	version INTEGER NOT NULL,
+	networks JSONB NOT NULL DEFAULT '[]',
+	tags JSONB NOT NULL DEFAULT '[]',
+	type TEXT NOT NULL,
+	uses_remaining INTEGER NOT NULL DEFAULT 0,
+	unlimited BOOLEAN NOT NULL DEFAULT FALSE,
+	expiration TIMESTAMP WITH TIME ZONE,
+	is_current BOOLEAN NOT NULL DEFAULT TRUE,
+	is_deleted BOOLEAN NOT NULL DEFAULT FALSE,
+	deleted_at TIMESTAMP WITH TIME ZONE,
+	last_modified TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+	created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+	data JSONB,
+	PRIMARY KEY (id, version)
+);
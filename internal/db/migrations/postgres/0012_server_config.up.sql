@@ -0,0 +1,20 @@
+-- Versions of the configuration of the Netmaker server, a single record whose
+-- credentials are dropped before it is stored
+
+CREATE TABLE IF NOT EXISTS server_config (
+	id TEXT NOT NULL,
+	version INTEGER NOT NULL,
+	server_version TEXT NOT NULL,
+	is_ee BOOLEAN NOT NULL DEFAULT FALSE,
+	dns_mode TEXT,
+	networks_limit INTEGER NOT NULL DEFAULT 0,
+	machines_limit INTEGER NOT NULL DEFAULT 0,
+	users_limit INTEGER NOT NULL DEFAULT 0,
+	ingresses_limit INTEGER NOT NULL DEFAULT 0,
+	egresses_limit INTEGER NOT NULL DEFAULT 0,
+	is_current BOOLEAN NOT NULL DEFAULT TRUE,
+	last_modified TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+	created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+	data JSONB,
+	PRIMARY KEY (id, version)
+);
@@ -0,0 +1,13 @@
+DROP TABLE IF EXISTS acls;
+
+CREATE TABLE acls (
+	id INTEGER NOT NULL,
+	version INTEGER NOT NULL,
+	network_id TEXT NOT NULL,
+	node_id TEXT NOT NULL,
+	is_current BOOLEAN NOT NULL DEFAULT TRUE,
+	data JSONB NOT NULL,
+	last_modified TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+	created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (id, version)
+);
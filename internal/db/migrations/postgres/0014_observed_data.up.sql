@@ -0,0 +1,21 @@
+-- Keep the latest values of the volatile fields of the current version of a record
+-- apart from its data, so that the data of every version stays as it was stored
+
+ALTER TABLE networks ADD COLUMN observed_data JSONB;
+ALTER TABLE networks ADD COLUMN observed_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE nodes ADD COLUMN observed_data JSONB;
+ALTER TABLE nodes ADD COLUMN observed_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE ext_clients ADD COLUMN observed_data JSONB;
+ALTER TABLE ext_clients ADD COLUMN observed_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE hosts ADD COLUMN observed_data JSONB;
+ALTER TABLE hosts ADD COLUMN observed_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE acls ADD COLUMN observed_data JSONB;
+ALTER TABLE acls ADD COLUMN observed_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE enrollment_keys ADD COLUMN observed_data JSONB;
+ALTER TABLE enrollment_keys ADD COLUMN observed_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE users ADD COLUMN observed_data JSONB;
+ALTER TABLE users ADD COLUMN observed_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE user_gateway_assignments ADD COLUMN observed_data JSONB;
+ALTER TABLE user_gateway_assignments ADD COLUMN observed_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE server_config ADD COLUMN observed_data JSONB;
+ALTER TABLE server_config ADD COLUMN observed_at TIMESTAMP WITH TIME ZONE;
@@ -0,0 +1,108 @@
+-- Initial schema: versioned Netmaker resources and the sync history.
+-- Timestamps are stored as text in the format the driver writes, so that the
+-- driver reads them back as times.
+
+CREATE TABLE networks (
+	id TEXT NOT NULL,
+	version INTEGER NOT NULL,
+	name TEXT NOT NULL,
+	address_range TEXT,
+	address_range6 TEXT,
+	local_range TEXT,
+	is_dual_stack BOOLEAN NOT NULL DEFAULT FALSE,
+	is_ipv4 BOOLEAN NOT NULL DEFAULT TRUE,
+	is_ipv6 BOOLEAN NOT NULL DEFAULT FALSE,
+	is_local BOOLEAN NOT NULL DEFAULT FALSE,
+	default_access_control TEXT,
+	default_udp_hole_punching BOOLEAN NOT NULL DEFAULT TRUE,
+	default_ext_client_dns TEXT,
+	default_mtu INTEGER,
+	default_keepalive INTEGER,
+	default_interface TEXT,
+	node_limit INTEGER,
+	is_current BOOLEAN NOT NULL DEFAULT TRUE,
+	last_modified TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+	created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+	data JSONB,
+	PRIMARY KEY (id, version)
+);
+
+CREATE TABLE nodes (
+	id TEXT NOT NULL,
+	version INTEGER NOT NULL,
+	network_id TEXT NOT NULL,
+	name TEXT NOT NULL,
+	address TEXT,
+	address6 TEXT,
+	public_key TEXT,
+	endpoint TEXT,
+	is_egress_gateway BOOLEAN NOT NULL DEFAULT FALSE,
+	is_ingress_gateway BOOLEAN NOT NULL DEFAULT FALSE,
+	is_relay BOOLEAN NOT NULL DEFAULT FALSE,
+	connected BOOLEAN NOT NULL DEFAULT FALSE,
+	is_current BOOLEAN NOT NULL DEFAULT TRUE,
+	last_modified TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+	created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+	data JSONB,
+	PRIMARY KEY (id, version),
+	UNIQUE(network_id, name, version)
+);
+
+CREATE TABLE ext_clients (
+	id TEXT NOT NULL,
+	version INTEGER NOT NULL,
+	network_id TEXT NOT NULL,
+	name TEXT NOT NULL,
+	address TEXT,
+	address6 TEXT,
+	public_key TEXT,
+	enabled BOOLEAN NOT NULL DEFAULT TRUE,
+	is_current BOOLEAN NOT NULL DEFAULT TRUE,
+	last_modified TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+	created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+	data JSONB,
+	PRIMARY KEY (id, version),
+	UNIQUE(network_id, name, version)
+);
+
+-- Unlike the Postgres schema, dns_entries never referenced networks(id): SQLite
+-- cannot drop the constraint later, and networks.id is not unique across versions
+CREATE TABLE dns_entries (
+	id TEXT NOT NULL,
+	version INTEGER NOT NULL,
+	network_id TEXT NOT NULL,
+	name TEXT NOT NULL,
+	address TEXT,
+	address6 TEXT,
+	is_current BOOLEAN NOT NULL DEFAULT TRUE,
+	last_modified TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+	created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+	PRIMARY KEY (id, version),
+	UNIQUE(network_id, name, version)
+);
+
+CREATE TABLE hosts (
+	id TEXT NOT NULL,
+	version INTEGER NOT NULL,
+	name TEXT NOT NULL,
+	endpoint_ip TEXT,
+	endpoint_ipv6 TEXT,
+	public_key TEXT,
+	listen_port INTEGER,
+	mtu INTEGER,
+	persistent_keepalive INTEGER,
+	is_current BOOLEAN NOT NULL DEFAULT TRUE,
+	last_modified TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+	created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+	data JSONB,
+	PRIMARY KEY (id, version)
+);
+
+CREATE TABLE sync_history (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	resource_type TEXT NOT NULL,
+	status TEXT NOT NULL,
+	message TEXT,
+	started_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+	completed_at TIMESTAMP
+);
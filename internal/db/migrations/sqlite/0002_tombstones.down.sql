@@ -0,0 +1,10 @@
+ALTER TABLE hosts DROP COLUMN deleted_at;
+ALTER TABLE hosts DROP COLUMN is_deleted;
+ALTER TABLE dns_entries DROP COLUMN deleted_at;
+ALTER TABLE dns_entries DROP COLUMN is_deleted;
+ALTER TABLE ext_clients DROP COLUMN deleted_at;
+ALTER TABLE ext_clients DROP COLUMN is_deleted;
+ALTER TABLE nodes DROP COLUMN deleted_at;
+ALTER TABLE nodes DROP COLUMN is_deleted;
+ALTER TABLE networks DROP COLUMN deleted_at;
+ALTER TABLE networks DROP COLUMN is_deleted;
@@ -0,0 +1,12 @@
+-- Record deletions in Netmaker as tombstone versions
+
+ALTER TABLE networks ADD COLUMN is_deleted BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE networks ADD COLUMN deleted_at TIMESTAMP;
+ALTER TABLE nodes ADD COLUMN is_deleted BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE nodes ADD COLUMN deleted_at TIMESTAMP;
+ALTER TABLE ext_clients ADD COLUMN is_deleted BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE ext_clients ADD COLUMN deleted_at TIMESTAMP;
+ALTER TABLE dns_entries ADD COLUMN is_deleted BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE dns_entries ADD COLUMN deleted_at TIMESTAMP;
+ALTER TABLE hosts ADD COLUMN is_deleted BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE hosts ADD COLUMN deleted_at TIMESTAMP;
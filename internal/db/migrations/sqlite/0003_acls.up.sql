@@ -0,0 +1,14 @@
+-- Create the acls table that UpsertACLs writes to and point-in-time queries
+-- read from
+
+CREATE TABLE IF NOT EXISTS acls (
+	id INTEGER NOT NULL,
+	version INTEGER NOT NULL,
+	network_id TEXT NOT NULL,
+	node_id TEXT NOT NULL,
+	is_current BOOLEAN NOT NULL DEFAULT TRUE,
+	data JSONB NOT NULL,
+	last_modified TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+	created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+	PRIMARY KEY (id, version)
+);
@@ -0,0 +1,8 @@
+-- Per-network markers of the last successful sync, used to skip networks whose
+-- nodes have not changed in Netmaker since then
+
+CREATE TABLE IF NOT EXISTS sync_cursors (
+	network_id TEXT PRIMARY KEY,
+	nodes_last_modified BIGINT NOT NULL,
+	synced_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now'))
+);
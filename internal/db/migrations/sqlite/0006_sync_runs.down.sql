@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS sync_history_run_id_idx;
+ALTER TABLE sync_history DROP COLUMN run_id;
+DROP TABLE IF EXISTS sync_runs;
@@ -0,0 +1,19 @@
+-- Sync runs group the per-resource sync history of one SyncAll, or of a single
+-- resource sync triggered through the API, and count what they changed
+
+CREATE TABLE IF NOT EXISTS sync_runs (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	scope TEXT NOT NULL,
+	network_id TEXT,
+	status TEXT NOT NULL,
+	message TEXT,
+	counts JSONB NOT NULL DEFAULT '{}',
+	started_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+	completed_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS sync_runs_started_at_idx ON sync_runs (started_at);
+
+ALTER TABLE sync_history ADD COLUMN run_id INTEGER;
+
+CREATE INDEX IF NOT EXISTS sync_history_run_id_idx ON sync_history (run_id);
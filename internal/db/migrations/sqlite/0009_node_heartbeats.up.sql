@@ -0,0 +1,15 @@
+-- Check-in state of every node observed by each sync, kept for a retention period to
+-- tell when nodes last talked to the server and how often they were up
+
+CREATE TABLE IF NOT EXISTS node_heartbeats (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	node_id TEXT NOT NULL,
+	network_id TEXT NOT NULL,
+	connected BOOLEAN NOT NULL,
+	last_check_in TIMESTAMP,
+	last_peer_update TIMESTAMP,
+	observed_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now'))
+);
+
+CREATE INDEX IF NOT EXISTS node_heartbeats_node_id_idx ON node_heartbeats (node_id, observed_at);
+CREATE INDEX IF NOT EXISTS node_heartbeats_observed_at_idx ON node_heartbeats (observed_at);
@@ -0,0 +1,20 @@
+-- Versions of the Netmaker enrollment keys. The id is derived from the key value, and
+-- the value and token are only kept hashed in data.
+
+CREATE TABLE IF NOT EXISTS enrollment_keys (
+	id TEXT NOT NULL,
+	version INTEGER NOT NULL,
+	networks JSONB NOT NULL DEFAULT '[]',
+	tags JSONB NOT NULL DEFAULT '[]',
+	type TEXT NOT NULL,
+	uses_remaining INTEGER NOT NULL DEFAULT 0,
+	unlimited BOOLEAN NOT NULL DEFAULT FALSE,
+	expiration TIMESTAMP,
+	is_current BOOLEAN NOT NULL DEFAULT TRUE,
+	is_deleted BOOLEAN NOT NULL DEFAULT FALSE,
+	deleted_at TIMESTAMP,
+	last_modified TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+	created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+	data JSONB,
+	PRIMARY KEY (id, version)
+);
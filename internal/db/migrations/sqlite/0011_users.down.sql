@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS user_gateway_assignments;
+DROP TABLE IF EXISTS users;
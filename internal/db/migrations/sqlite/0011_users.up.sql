@@ -0,0 +1,32 @@
+-- Versions of the Netmaker users, whose id is the username, and of the remote access
+-- gateways each user can reach, whose id is the username and gateway node id joined by
+-- a colon
+
+CREATE TABLE IF NOT EXISTS users (
+	id TEXT NOT NULL,
+	version INTEGER NOT NULL,
+	is_admin BOOLEAN NOT NULL DEFAULT FALSE,
+	is_super_admin BOOLEAN NOT NULL DEFAULT FALSE,
+	remote_gw_ids JSONB NOT NULL DEFAULT '[]',
+	is_current BOOLEAN NOT NULL DEFAULT TRUE,
+	is_deleted BOOLEAN NOT NULL DEFAULT FALSE,
+	deleted_at TIMESTAMP,
+	last_modified TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+	created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+	data JSONB,
+	PRIMARY KEY (id, version)
+);
+
+CREATE TABLE IF NOT EXISTS user_gateway_assignments (
+	id TEXT NOT NULL,
+	version INTEGER NOT NULL,
+	username TEXT NOT NULL,
+	gateway_id TEXT NOT NULL,
+	is_current BOOLEAN NOT NULL DEFAULT TRUE,
+	is_deleted BOOLEAN NOT NULL DEFAULT FALSE,
+	deleted_at TIMESTAMP,
+	last_modified TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+	created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+	data JSONB,
+	PRIMARY KEY (id, version)
+);
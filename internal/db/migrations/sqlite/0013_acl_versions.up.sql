@@ -0,0 +1,19 @@
+-- Give ACLs a stable id, "<network_id>:<source node>:<destination node>", and tombstones
+-- so that they are versioned like the other resources. The ACLs were replaced on every
+-- sync and had no history, so the table is recreated and filled by the next sync.
+
+DROP TABLE IF EXISTS acls;
+
+CREATE TABLE acls (
+	id TEXT NOT NULL,
+	version INTEGER NOT NULL,
+	network_id TEXT NOT NULL,
+	node_id TEXT NOT NULL,
+	is_current BOOLEAN NOT NULL DEFAULT TRUE,
+	is_deleted BOOLEAN NOT NULL DEFAULT FALSE,
+	deleted_at TIMESTAMP,
+	data JSONB NOT NULL,
+	last_modified TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+	created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+	PRIMARY KEY (id, version)
+);
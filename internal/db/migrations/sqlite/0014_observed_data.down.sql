@@ -0,0 +1,18 @@
+ALTER TABLE server_config DROP COLUMN observed_at;
+ALTER TABLE server_config DROP COLUMN observed_data;
+ALTER TABLE user_gateway_assignments DROP COLUMN observed_at;
+ALTER TABLE user_gateway_assignments DROP COLUMN observed_data;
+ALTER TABLE users DROP COLUMN observed_at;
+ALTER TABLE users DROP COLUMN observed_data;
+ALTER TABLE enrollment_keys DROP COLUMN observed_at;
+ALTER TABLE enrollment_keys DROP COLUMN observed_data;
+ALTER TABLE acls DROP COLUMN observed_at;
+ALTER TABLE acls DROP COLUMN observed_data;
+ALTER TABLE hosts DROP COLUMN observed_at;
+ALTER TABLE hosts DROP COLUMN observed_data;
+ALTER TABLE ext_clients DROP COLUMN observed_at;
+ALTER TABLE ext_clients DROP COLUMN observed_data;
+ALTER TABLE nodes DROP COLUMN observed_at;
+ALTER TABLE nodes DROP COLUMN observed_data;
+ALTER TABLE networks DROP COLUMN observed_at;
+ALTER TABLE networks DROP COLUMN observed_data;
@@ -1,53 +1,62 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"netmaker-sync/internal/models"
-	"reflect"
+	"netmaker-sync/internal/tracing"
 	"time"
 
 	"github.com/sirupsen/logrus"
 )
 
 // UpsertNetwork inserts or updates a network in the database
-func (db *DB) UpsertNetwork(network *models.Network) error {
+func (db *DB) UpsertNetwork(ctx context.Context, network *models.Network) (result UpsertResult, err error) {
+	ctx, span := startSpan(ctx, "UpsertNetwork", "networks", network.ID)
+	defer func() { tracing.End(span, err) }()
+
+	// Protect the sensitive fields before comparing with the current version
+	if err := db.protectFields(ctx, models.ResourceTypeNetwork, "networks", network.ID, network.Data); err != nil {
+		return UpsertUnchanged, err
+	}
+
 	// Check if the network exists with any version
 	var exists bool
-	err := db.QueryRow(`
+	err = db.QueryRowContext(ctx, `
 		SELECT EXISTS(SELECT 1 FROM networks WHERE id = $1)
 	`, network.ID).Scan(&exists)
 
 	if err != nil {
-		return fmt.Errorf("failed to check if network exists: %w", err)
+		return UpsertUnchanged, fmt.Errorf("failed to check if network exists: %w", err)
 	}
 
 	// If network exists, get the current version
 	if exists {
 		var currentNetwork models.Network
-		err := db.Get(&currentNetwork, `
+		err := db.GetContext(ctx, &currentNetwork, `
 			SELECT * FROM networks 
 			WHERE id = $1 AND is_current = true
 		`, network.ID)
 
 		if err != nil && !errors.Is(err, sql.ErrNoRows) {
-			return fmt.Errorf("failed to get current network: %w", err)
+			return UpsertUnchanged, fmt.Errorf("failed to get current network: %w", err)
 		}
 
 		// If we found a current version, check for changes
 		if err == nil {
 			// Network exists, check if there are meaningful changes
-			if networksEqual(currentNetwork, *network) {
-				// No changes, nothing to do
+			if db.networksEqual(currentNetwork, *network) {
+				// No changes but to the volatile fields, whose latest values are recorded apart from the data
 				logrus.Debugf("No changes for network %s, skipping update", network.ID)
-				return nil
+				return UpsertUnchanged, db.RefreshVolatileFields(ctx, "networks", network.ID, currentNetwork.Data, currentNetwork.ObservedData, network.Data)
 			}
 
 			// Start a transaction
-			tx, err := db.Beginx()
+			tx, err := db.BeginTxx(ctx, nil)
 			if err != nil {
-				return fmt.Errorf("failed to begin transaction: %w", err)
+				return UpsertUnchanged, fmt.Errorf("failed to begin transaction: %w", err)
 			}
 			defer tx.Rollback()
 
@@ -59,7 +68,7 @@ func (db *DB) UpsertNetwork(network *models.Network) error {
 			`, network.ID)
 
 			if err != nil {
-				return fmt.Errorf("failed to update current network: %w", err)
+				return UpsertUnchanged, fmt.Errorf("failed to update current network: %w", err)
 			}
 
 			// Get the next version number
@@ -69,7 +78,7 @@ func (db *DB) UpsertNetwork(network *models.Network) error {
 			`, network.ID)
 
 			if err != nil {
-				return fmt.Errorf("failed to get next version: %w", err)
+				return UpsertUnchanged, fmt.Errorf("failed to get next version: %w", err)
 			}
 
 			// Insert the new version
@@ -81,22 +90,32 @@ func (db *DB) UpsertNetwork(network *models.Network) error {
 					is_dual_stack, is_ipv4, is_ipv6, is_local, default_access_control,
 					default_udp_hole_punching, default_ext_client_dns, default_mtu,
 					default_keepalive, default_interface, node_limit, is_current,
-					last_modified, created_at, data
+					is_deleted, deleted_at, last_modified, created_at, data
 				) VALUES (
 					:id, :version, :name, :address_range, :address_range6, :local_range,
 					:is_dual_stack, :is_ipv4, :is_ipv6, :is_local, :default_access_control,
 					:default_udp_hole_punching, :default_ext_client_dns, :default_mtu,
 					:default_keepalive, :default_interface, :node_limit, true,
-					:last_modified, NOW(), :data
+					:is_deleted, :deleted_at, :last_modified, NOW(), :data
 				)
 			`, network)
 
 			if err != nil {
-				return fmt.Errorf("failed to insert new network version: %w", err)
+				return UpsertUnchanged, fmt.Errorf("failed to insert new network version: %w", err)
+			}
+
+			// Notify database listeners once the transaction commits
+			if err := db.notifyChange(tx, "networks", network.ID, nextVersion, network); err != nil {
+				return UpsertUnchanged, err
+			}
+
+			if err := tx.Commit(); err != nil {
+				return UpsertUnchanged, fmt.Errorf("failed to commit transaction: %w", err)
 			}
 
 			logrus.Infof("Updated network %s to version %d", network.ID, nextVersion)
-			return tx.Commit()
+			db.publishChange("networks", network.ID, &currentNetwork, network, currentNetwork.Version, nextVersion)
+			return UpsertUpdated, nil
 		}
 	}
 
@@ -105,9 +124,9 @@ func (db *DB) UpsertNetwork(network *models.Network) error {
 	network.LastModified = time.Now()
 
 	// Start a transaction
-	tx, err := db.Beginx()
+	tx, err := db.BeginTxx(ctx, nil)
 	if err != nil {
-		return fmt.Errorf("failed to begin transaction: %w", err)
+		return UpsertUnchanged, fmt.Errorf("failed to begin transaction: %w", err)
 	}
 	defer tx.Rollback()
 
@@ -117,26 +136,36 @@ func (db *DB) UpsertNetwork(network *models.Network) error {
 			is_dual_stack, is_ipv4, is_ipv6, is_local, default_access_control,
 			default_udp_hole_punching, default_ext_client_dns, default_mtu,
 			default_keepalive, default_interface, node_limit, is_current,
-			last_modified, created_at, data
+			is_deleted, deleted_at, last_modified, created_at, data
 		) VALUES (
 			:id, :version, :name, :address_range, :address_range6, :local_range,
 			:is_dual_stack, :is_ipv4, :is_ipv6, :is_local, :default_access_control,
 			:default_udp_hole_punching, :default_ext_client_dns, :default_mtu,
 			:default_keepalive, :default_interface, :node_limit, true,
-			:last_modified, NOW(), :data
+			:is_deleted, :deleted_at, :last_modified, NOW(), :data
 		)
 	`, network)
 
 	if err != nil {
-		return fmt.Errorf("failed to insert first network version: %w", err)
+		return UpsertUnchanged, fmt.Errorf("failed to insert first network version: %w", err)
+	}
+
+	// Notify database listeners once the transaction commits
+	if err := db.notifyChange(tx, "networks", network.ID, 1, network); err != nil {
+		return UpsertUnchanged, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return UpsertUnchanged, fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
 	logrus.Infof("Created new network %s", network.ID)
-	return tx.Commit()
+	db.publishChange("networks", network.ID, nil, network, 0, 1)
+	return UpsertCreated, nil
 }
 
 // networksEqual compares two networks to determine if there are meaningful changes
-func networksEqual(a, b models.Network) bool {
+func (db *DB) networksEqual(a, b models.Network) bool {
 	// Compare relevant fields, ignoring metadata like LastModified
 	return a.Name == b.Name &&
 		a.AddressRange == b.AddressRange &&
@@ -153,16 +182,17 @@ func networksEqual(a, b models.Network) bool {
 		a.DefaultKeepalive == b.DefaultKeepalive &&
 		a.DefaultInterface == b.DefaultInterface &&
 		a.NodeLimit == b.NodeLimit &&
-		reflect.DeepEqual(a.Data, b.Data)
+		a.IsDeleted == b.IsDeleted &&
+		db.dataEqual(models.ResourceTypeNetwork, a.Data, b.Data)
 }
 
 // GetNetworks retrieves all current networks from the database
 func (db *DB) GetNetworks() ([]models.Network, error) {
-	// Get only the current versions of networks
+	// Get only the current versions of networks that have not been deleted
 	var networks []models.Network
 	err := db.Select(&networks, `
 		SELECT * FROM networks 
-		WHERE is_current = true
+		WHERE is_current = true AND is_deleted = false
 	`)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get networks: %w", err)
@@ -179,9 +209,46 @@ func (db *DB) GetNetwork(networkID string) (*models.Network, error) {
 	`, networkID)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return nil, fmt.Errorf("network not found: %s", networkID)
+			return nil, fmt.Errorf("%w: network %s", ErrRecordNotFound, networkID)
 		}
 		return nil, fmt.Errorf("failed to get network: %w", err)
 	}
 	return &network, nil
 }
+
+// DeleteNetwork records a new, deleted version of a network so that its removal from Netmaker is kept in the history
+func (db *DB) DeleteNetwork(ctx context.Context, networkID string) (err error) {
+	ctx, span := startSpan(ctx, "DeleteNetwork", "networks", networkID)
+	defer func() { tracing.End(span, err) }()
+
+	var network models.Network
+	err = db.GetContext(ctx, &network, `
+		SELECT * FROM networks 
+		WHERE id = $1 AND is_current = true
+	`, networkID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%w: network %s", ErrRecordNotFound, networkID)
+		}
+		return fmt.Errorf("failed to get current network: %w", err)
+	}
+
+	// Nothing to do if the network has already been marked as deleted
+	if network.IsDeleted {
+		return nil
+	}
+
+	deletedAt := time.Now()
+	network.IsDeleted = true
+	network.DeletedAt = &deletedAt
+	_, err = db.UpsertNetwork(ctx, &network)
+	return err
+}
+
+// DeleteMissingNetworks marks networks that are no longer returned by the Netmaker API as deleted
+func (db *DB) DeleteMissingNetworks(ctx context.Context, seenIDs []string) (deletedIDs []string, err error) {
+	ctx, span := startSpan(ctx, "DeleteMissingNetworks", "networks", nil)
+	defer func() { tracing.End(span, err) }()
+
+	return db.GenericTombstoneMissing(ctx, "networks", "", nil, seenIDs, db.DeleteNetwork)
+}
@@ -1,21 +1,30 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"netmaker-sync/internal/models"
-	"reflect"
+	"netmaker-sync/internal/tracing"
 	"time"
 
 	"github.com/jmoiron/sqlx"
 )
 
 // UpsertNode inserts or updates a node in the database
-func (db *DB) UpsertNode(node *models.Node) error {
+func (db *DB) UpsertNode(ctx context.Context, node *models.Node) (result UpsertResult, err error) {
+	ctx, span := startSpan(ctx, "UpsertNode", "nodes", node.ID)
+	defer func() { tracing.End(span, err) }()
+
+	// Protect the sensitive fields before comparing with the current version
+	if err := db.protectFields(ctx, models.ResourceTypeNode, "nodes", node.ID, node.Data); err != nil {
+		return UpsertUnchanged, err
+	}
+
 	// Get the current node if it exists
 	var currentNode models.Node
-	err := db.Get(&currentNode, `
+	err = db.GetContext(ctx, &currentNode, `
 		SELECT * FROM nodes 
 		WHERE id = $1 AND is_current = true
 	`, node.ID)
@@ -25,7 +34,7 @@ func (db *DB) UpsertNode(node *models.Node) error {
 	equalsFn := func(current, new interface{}) bool {
 		currentNode := current.(*models.Node)
 		newNode := new.(*models.Node)
-		return nodesEqual(*currentNode, *newNode)
+		return db.nodesEqual(*currentNode, *newNode)
 	}
 
 	// Function to get the version from a node
@@ -49,11 +58,13 @@ func (db *DB) UpsertNode(node *models.Node) error {
 			INSERT INTO nodes (
 				id, version, network_id, name, address, address6, public_key,
 				endpoint, is_egress_gateway, is_ingress_gateway, is_relay,
-				connected, is_current, last_modified, created_at, data
+				connected, is_current, is_deleted, deleted_at, last_modified,
+				created_at, data
 			) VALUES (
 				:id, :version, :network_id, :name, :address, :address6, :public_key,
 				:endpoint, :is_egress_gateway, :is_ingress_gateway, :is_relay,
-				:connected, true, :last_modified, NOW(), :data
+				:connected, true, :is_deleted, :deleted_at, :last_modified,
+				NOW(), :data
 			)
 		`, record)
 		return err
@@ -65,11 +76,12 @@ func (db *DB) UpsertNode(node *models.Node) error {
 		currentNodePtr = &currentNode
 	} else if !errors.Is(err, sql.ErrNoRows) {
 		// If there was an error other than not finding the record, return it
-		return fmt.Errorf("failed to get current node: %w", err)
+		return UpsertUnchanged, fmt.Errorf("failed to get current node: %w", err)
 	}
 
 	// Call the generic upsert function
-	_, err = db.GenericUpsert(
+	result, err = db.GenericUpsert(
+		ctx,
 		"nodes",
 		"id",
 		node.ID,
@@ -82,11 +94,15 @@ func (db *DB) UpsertNode(node *models.Node) error {
 		insertFn,
 	)
 
-	return err
+	// No changes but to the volatile fields, whose latest values are recorded apart from the data
+	if err == nil && result == UpsertUnchanged && currentNodePtr != nil {
+		err = db.RefreshVolatileFields(ctx, "nodes", node.ID, currentNode.Data, currentNode.ObservedData, node.Data)
+	}
+	return result, err
 }
 
 // nodesEqual compares two nodes to determine if there are meaningful changes
-func nodesEqual(a, b models.Node) bool {
+func (db *DB) nodesEqual(a, b models.Node) bool {
 	// Compare relevant fields, ignoring metadata like LastModified
 	return a.NetworkID == b.NetworkID &&
 		a.Name == b.Name &&
@@ -98,7 +114,8 @@ func nodesEqual(a, b models.Node) bool {
 		a.IsIngressGateway == b.IsIngressGateway &&
 		a.IsRelay == b.IsRelay &&
 		a.Connected == b.Connected &&
-		reflect.DeepEqual(a.Data, b.Data)
+		a.IsDeleted == b.IsDeleted &&
+		db.dataEqual(models.ResourceTypeNode, a.Data, b.Data)
 }
 
 func (db *DB) GetNodes(networkID string) ([]models.Node, error) {
@@ -106,7 +123,7 @@ func (db *DB) GetNodes(networkID string) ([]models.Node, error) {
 	var nodes []models.Node
 	err := db.Select(&nodes, `
 		SELECT * FROM nodes 
-		WHERE network_id = $1 AND is_current = true
+		WHERE network_id = $1 AND is_current = true AND is_deleted = false
 	`, networkID)
 	return nodes, err
 }
@@ -121,3 +138,40 @@ func (db *DB) GetNodeHistory(nodeID string) ([]models.Node, error) {
 	`, nodeID)
 	return nodes, err
 }
+
+// DeleteNode records a new, deleted version of a node so that its removal from Netmaker is kept in the history
+func (db *DB) DeleteNode(ctx context.Context, nodeID string) (err error) {
+	ctx, span := startSpan(ctx, "DeleteNode", "nodes", nodeID)
+	defer func() { tracing.End(span, err) }()
+
+	var node models.Node
+	err = db.GetContext(ctx, &node, `
+		SELECT * FROM nodes 
+		WHERE id = $1 AND is_current = true
+	`, nodeID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("node not found: %s", nodeID)
+		}
+		return fmt.Errorf("failed to get current node: %w", err)
+	}
+
+	// Nothing to do if the node has already been marked as deleted
+	if node.IsDeleted {
+		return nil
+	}
+
+	deletedAt := time.Now()
+	node.IsDeleted = true
+	node.DeletedAt = &deletedAt
+	_, err = db.UpsertNode(ctx, &node)
+	return err
+}
+
+// DeleteMissingNodes marks the nodes of a network that are no longer returned by the Netmaker API as deleted
+func (db *DB) DeleteMissingNodes(ctx context.Context, networkID string, seenIDs []string) (deletedIDs []string, err error) {
+	ctx, span := startSpan(ctx, "DeleteMissingNodes", "nodes", nil)
+	defer func() { tracing.End(span, err) }()
+
+	return db.GenericTombstoneMissing(ctx, "nodes", "network_id", networkID, seenIDs, db.DeleteNode)
+}
@@ -0,0 +1,110 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"netmaker-sync/internal/models"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+)
+
+// notifyChange sends a change notification on the configured channel as part of tx, so
+// listeners only see it once the new version is committed. It is a no-op if no channel
+// is configured.
+func (db *DB) notifyChange(tx *sqlx.Tx, tableName string, id interface{}, version int, record interface{}) error {
+	if db.notifyChannel == "" {
+		return nil
+	}
+
+	kind := models.ChangeKindUpdated
+	switch {
+	case isDeleted(record):
+		kind = models.ChangeKindDeleted
+	case version == 1:
+		kind = models.ChangeKindCreated
+	}
+
+	payload, err := json.Marshal(models.ChangeNotification{
+		Table:   tableName,
+		ID:      fmt.Sprint(id),
+		Version: version,
+		Kind:    kind,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal change notification: %w", err)
+	}
+
+	if _, err := tx.Exec(`SELECT pg_notify($1, $2)`, db.notifyChannel, string(payload)); err != nil {
+		return fmt.Errorf("failed to send change notification: %w", err)
+	}
+	return nil
+}
+
+// Listen subscribes to change notifications on the configured channel and calls handler
+// for each one. It holds a dedicated connection until ctx is cancelled or an error occurs.
+func (db *DB) Listen(ctx context.Context, handler func(models.ChangeNotification)) error {
+	if db.driver != DriverPostgres {
+		return fmt.Errorf("change notifications are not supported by the %s driver", db.driver)
+	}
+	if db.notifyChannel == "" {
+		return fmt.Errorf("no notify channel configured")
+	}
+
+	// Use a connection outside the pool, since it stays in the LISTEN state
+	conn, err := pgx.Connect(ctx, db.dsn)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{db.notifyChannel}.Sanitize()); err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", db.notifyChannel, err)
+	}
+	logrus.Infof("Listening for change notifications on %s", db.notifyChannel)
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to wait for notification: %w", err)
+		}
+
+		var change models.ChangeNotification
+		if err := json.Unmarshal([]byte(notification.Payload), &change); err != nil {
+			logrus.Warnf("Ignoring notification with unexpected payload %q: %v", notification.Payload, err)
+			continue
+		}
+		handler(change)
+	}
+}
+
+// isDeleted reports whether a versioned record is a deletion tombstone
+func isDeleted(record interface{}) bool {
+	switch r := record.(type) {
+	case *models.Network:
+		return r.IsDeleted
+	case *models.Node:
+		return r.IsDeleted
+	case *models.ExtClient:
+		return r.IsDeleted
+	case *models.DNSEntry:
+		return r.IsDeleted
+	case *models.Host:
+		return r.IsDeleted
+	case *models.ACL:
+		return r.IsDeleted
+	case *models.EnrollmentKey:
+		return r.IsDeleted
+	case *models.User:
+		return r.IsDeleted
+	case *models.UserGatewayAssignment:
+		return r.IsDeleted
+	default:
+		return false
+	}
+}
@@ -9,13 +9,14 @@ func (db *DB) CreateSyncHistory(syncHistory *models.SyncHistory) error {
 	// Insert a new sync history record
 	query := `
 		INSERT INTO sync_history (
-			resource_type, status, message, started_at, completed_at
+			run_id, resource_type, status, message, started_at, completed_at
 		) VALUES (
-			$1, $2, $3, $4, $5
+			$1, $2, $3, $4, $5, $6
 		)
 		RETURNING id
 	`
 	return db.QueryRow(query,
+		syncHistory.RunID,
 		syncHistory.ResourceType,
 		syncHistory.Status,
 		syncHistory.Message,
@@ -40,5 +41,27 @@ func (db *DB) UpdateSyncHistory(syncHistory *models.SyncHistory) error {
 		return fmt.Errorf("failed to update sync history: %w", err)
 	}
 
+	failed := syncHistory.Status == models.SyncStatusFailed || syncHistory.Status == models.SyncStatusPartial
+	if failed && db.syncFailurePublisher != nil {
+		db.syncFailurePublisher.PublishSyncFailure(*syncHistory)
+	}
+
+	return nil
+}
+
+// CreateSyncError records the failure of a record, or of a whole resource sync, during a sync run
+func (db *DB) CreateSyncError(syncError *models.SyncError) error {
+	err := db.QueryRow(`
+		INSERT INTO sync_errors (
+			run_id, sync_history_id, resource_type, network_id, resource_id, operation, error, occurred_at
+		) VALUES (
+			$1, $2, $3, $4, $5, $6, $7, $8
+		)
+		RETURNING id
+	`, syncError.RunID, syncError.SyncHistoryID, syncError.ResourceType, syncError.NetworkID,
+		syncError.ResourceID, syncError.Operation, syncError.Error, syncError.OccurredAt).Scan(&syncError.ID)
+	if err != nil {
+		return fmt.Errorf("failed to create sync error: %w", err)
+	}
 	return nil
 }
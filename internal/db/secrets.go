@@ -0,0 +1,123 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"netmaker-sync/internal/models"
+
+	"github.com/sirupsen/logrus"
+)
+
+// FieldProtector drops, hashes or encrypts the sensitive fields of the Netmaker data
+// before it is stored
+type FieldProtector interface {
+	NeedsCurrent(resourceType string) bool
+	Protect(resourceType string, data, current models.JSONB) error
+	Reveal(data models.JSONB) (models.JSONB, error)
+	Rewrap(data models.JSONB) (bool, error)
+}
+
+// protectedTables are the versioned tables whose data column can hold protected fields
+var protectedTables = []string{"networks", "nodes", "ext_clients", "hosts", "enrollment_keys", "users", "server_config"}
+
+// SetFieldProtector sets the protector applied to the data of every record stored
+func (db *DB) SetFieldProtector(protector FieldProtector) {
+	db.fieldProtector = protector
+}
+
+// protectFields applies the field protector, if any, to the data of a record about to
+// be stored. The current data of the record is read when the protector needs it.
+func (db *DB) protectFields(ctx context.Context, resourceType, tableName, id string, data models.JSONB) error {
+	if db.fieldProtector == nil || data == nil {
+		return nil
+	}
+
+	var current models.JSONB
+	if db.fieldProtector.NeedsCurrent(resourceType) {
+		query := fmt.Sprintf("SELECT data FROM %s WHERE id = $1 AND is_current = true", tableName)
+		if err := db.GetContext(ctx, &current, query, id); err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("failed to get current data of %s %s: %w", resourceType, id, err)
+		}
+	}
+
+	if err := db.fieldProtector.Protect(resourceType, data, current); err != nil {
+		return fmt.Errorf("failed to protect fields of %s %s: %w", resourceType, id, err)
+	}
+	return nil
+}
+
+// RevealRecord decrypts in place the encrypted fields of a record returned by the
+// resource readers (e.g. GetResourceVersion)
+func (db *DB) RevealRecord(record interface{}) error {
+	var data *models.JSONB
+	switch r := record.(type) {
+	case *models.Network:
+		data = &r.Data
+	case *models.Node:
+		data = &r.Data
+	case *models.ExtClient:
+		data = &r.Data
+	case *models.Host:
+		data = &r.Data
+	case *models.EnrollmentKey:
+		data = &r.Data
+	case *models.User:
+		data = &r.Data
+	case *models.ServerConfig:
+		data = &r.Data
+	default:
+		return nil
+	}
+	if db.fieldProtector == nil {
+		return nil
+	}
+
+	revealed, err := db.fieldProtector.Reveal(*data)
+	if err != nil {
+		return err
+	}
+	*data = revealed
+	return nil
+}
+
+// RewrapSecrets re-encrypts the data keys of every stored version that are not wrapped
+// under the active encryption key, and returns the number of rows updated. Versions are
+// updated in place since their values do not change.
+func (db *DB) RewrapSecrets() (int, error) {
+	if db.fieldProtector == nil {
+		return 0, fmt.Errorf("no field protector configured")
+	}
+
+	updated := 0
+	for _, tableName := range protectedTables {
+		var rows []struct {
+			ID      string       `db:"id"`
+			Version int          `db:"version"`
+			Data    models.JSONB `db:"data"`
+		}
+		query := fmt.Sprintf("SELECT id, version, data FROM %s", tableName)
+		if err := db.Select(&rows, query); err != nil {
+			return updated, fmt.Errorf("failed to get %s: %w", tableName, err)
+		}
+
+		for _, row := range rows {
+			changed, err := db.fieldProtector.Rewrap(row.Data)
+			if err != nil {
+				return updated, fmt.Errorf("failed to rewrap %s %s version %d: %w", tableName, row.ID, row.Version, err)
+			}
+			if !changed {
+				continue
+			}
+
+			query := fmt.Sprintf("UPDATE %s SET data = $1 WHERE id = $2 AND version = $3", tableName)
+			if _, err := db.Exec(query, row.Data, row.ID, row.Version); err != nil {
+				return updated, fmt.Errorf("failed to update %s %s version %d: %w", tableName, row.ID, row.Version, err)
+			}
+			updated++
+		}
+		logrus.Debugf("Rewrapped secrets of %s", tableName)
+	}
+	return updated, nil
+}
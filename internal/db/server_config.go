@@ -0,0 +1,119 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"netmaker-sync/internal/models"
+	"netmaker-sync/internal/tracing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// UpsertServerConfig inserts or updates the server configuration in the database
+func (db *DB) UpsertServerConfig(ctx context.Context, serverConfig *models.ServerConfig) (result UpsertResult, err error) {
+	ctx, span := startSpan(ctx, "UpsertServerConfig", "server_config", serverConfig.ID)
+	defer func() { tracing.End(span, err) }()
+
+	// Protect the sensitive fields before comparing with the current version
+	if err := db.protectFields(ctx, models.ResourceTypeServerConfig, "server_config", serverConfig.ID, serverConfig.Data); err != nil {
+		return UpsertUnchanged, err
+	}
+
+	// Get the current server configuration if it exists
+	var currentConfig models.ServerConfig
+	err = db.GetContext(ctx, &currentConfig, `
+		SELECT * FROM server_config
+		WHERE id = $1 AND is_current = true
+	`, serverConfig.ID)
+
+	var currentConfigPtr *models.ServerConfig
+	if err == nil {
+		currentConfigPtr = &currentConfig
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return UpsertUnchanged, fmt.Errorf("failed to get current server config: %w", err)
+	}
+
+	result, err = db.GenericUpsert(
+		ctx,
+		"server_config",
+		"id",
+		serverConfig.ID,
+		currentConfigPtr,
+		serverConfig,
+		func(current, new interface{}) bool {
+			return db.serverConfigsEqual(*current.(*models.ServerConfig), *new.(*models.ServerConfig))
+		},
+		func(record interface{}) int {
+			return record.(*models.ServerConfig).Version
+		},
+		func(record interface{}, version int) {
+			record.(*models.ServerConfig).Version = version
+		},
+		func(record interface{}, lastModified time.Time) {
+			record.(*models.ServerConfig).LastModified = lastModified
+		},
+		func(tx interface{}, record interface{}) error {
+			_, err := tx.(*sqlx.Tx).NamedExec(`
+				INSERT INTO server_config (
+					id, version, server_version, is_ee, dns_mode, networks_limit,
+					machines_limit, users_limit, ingresses_limit, egresses_limit,
+					is_current, last_modified, created_at, data
+				) VALUES (
+					:id, :version, :server_version, :is_ee, :dns_mode, :networks_limit,
+					:machines_limit, :users_limit, :ingresses_limit, :egresses_limit,
+					true, :last_modified, NOW(), :data
+				)
+			`, record)
+			return err
+		},
+	)
+
+	// No changes but to the volatile fields, whose latest values are recorded apart from the data
+	if err == nil && result == UpsertUnchanged && currentConfigPtr != nil {
+		err = db.RefreshVolatileFields(ctx, "server_config", serverConfig.ID, currentConfig.Data, currentConfig.ObservedData, serverConfig.Data)
+	}
+	return result, err
+}
+
+// serverConfigsEqual compares two server configurations to determine if there are meaningful changes
+func (db *DB) serverConfigsEqual(a, b models.ServerConfig) bool {
+	return a.ServerVersion == b.ServerVersion &&
+		a.IsEE == b.IsEE &&
+		a.DNSMode == b.DNSMode &&
+		a.NetworksLimit == b.NetworksLimit &&
+		a.MachinesLimit == b.MachinesLimit &&
+		a.UsersLimit == b.UsersLimit &&
+		a.IngressesLimit == b.IngressesLimit &&
+		a.EgressesLimit == b.EgressesLimit &&
+		db.dataEqual(models.ResourceTypeServerConfig, a.Data, b.Data)
+}
+
+// GetServerConfig retrieves the current server configuration, or nil if it has not been synced yet
+func (db *DB) GetServerConfig() (*models.ServerConfig, error) {
+	var serverConfig models.ServerConfig
+	err := db.Get(&serverConfig, `
+		SELECT * FROM server_config
+		WHERE id = $1 AND is_current = true
+	`, models.ServerConfigID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get server config: %w", err)
+	}
+	return &serverConfig, nil
+}
+
+// GetServerConfigHistory retrieves the version history of the server configuration
+func (db *DB) GetServerConfigHistory() ([]models.ServerConfig, error) {
+	var serverConfigs []models.ServerConfig
+	err := db.Select(&serverConfigs, `
+		SELECT * FROM server_config
+		WHERE id = $1
+		ORDER BY version DESC
+	`, models.ServerConfigID)
+	return serverConfigs, err
+}
@@ -0,0 +1,66 @@
+package db
+
+import (
+	"fmt"
+	"netmaker-sync/internal/models"
+)
+
+// GetTopologyStats counts the current nodes, external clients and hosts that are not
+// deleted, and gets the current enrollment keys, server configuration and the usage of
+// its license limits
+func (db *DB) GetTopologyStats() (*models.TopologyStats, error) {
+	stats := &models.TopologyStats{}
+
+	err := db.Select(&stats.Nodes, `
+		SELECT network_id, connected, COUNT(*) AS count FROM nodes
+		WHERE is_current = true AND is_deleted = false
+		GROUP BY network_id, connected
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count nodes: %w", err)
+	}
+
+	err = db.Select(&stats.ExtClients, `
+		SELECT network_id, enabled, COUNT(*) AS count FROM ext_clients
+		WHERE is_current = true AND is_deleted = false
+		GROUP BY network_id, enabled
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count ext clients: %w", err)
+	}
+
+	os := fmt.Sprintf("COALESCE(%s, '')", db.jsonField("data", "os"))
+	err = db.Select(&stats.Hosts, fmt.Sprintf(`
+		SELECT %s AS os, COUNT(*) AS count FROM hosts
+		WHERE is_current = true AND is_deleted = false
+		GROUP BY %s
+	`, os, os))
+	if err != nil {
+		return nil, fmt.Errorf("failed to count hosts: %w", err)
+	}
+
+	stats.EnrollmentKeys, err = db.GetEnrollmentKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	stats.ServerConfig, err = db.GetServerConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	current := "is_current = true AND is_deleted = false"
+	err = db.Get(&stats.LicenseUsage, fmt.Sprintf(`
+		SELECT
+			(SELECT COUNT(*) FROM networks WHERE %[1]s) AS networks,
+			(SELECT COUNT(*) FROM hosts WHERE %[1]s) + (SELECT COUNT(*) FROM ext_clients WHERE %[1]s) AS machines,
+			(SELECT COUNT(*) FROM users WHERE %[1]s) AS users,
+			(SELECT COUNT(*) FROM nodes WHERE %[1]s AND is_ingress_gateway = true) AS ingresses,
+			(SELECT COUNT(*) FROM nodes WHERE %[1]s AND is_egress_gateway = true) AS egresses
+	`, current))
+	if err != nil {
+		return nil, fmt.Errorf("failed to count license usage: %w", err)
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,138 @@
+package db
+
+import (
+	"context"
+	"netmaker-sync/internal/models"
+	"time"
+)
+
+// Store is the storage used by the sync service. *DB implements it for both the
+// Postgres and SQLite drivers.
+type Store interface {
+	// Versioned resources
+	UpsertNetwork(ctx context.Context, network *models.Network) (UpsertResult, error)
+	GetNetworks() ([]models.Network, error)
+	GetNetwork(networkID string) (*models.Network, error)
+	DeleteMissingNetworks(ctx context.Context, seenIDs []string) ([]string, error)
+
+	UpsertNode(ctx context.Context, node *models.Node) (UpsertResult, error)
+	GetNodes(networkID string) ([]models.Node, error)
+	GetNodeHistory(nodeID string) ([]models.Node, error)
+	DeleteMissingNodes(ctx context.Context, networkID string, seenIDs []string) ([]string, error)
+
+	UpsertExtClient(ctx context.Context, extClient *models.ExtClient) (UpsertResult, error)
+	GetExtClients(networkID string) ([]models.ExtClient, error)
+	GetExtClientHistory(extClientID string) ([]models.ExtClient, error)
+	DeleteMissingExtClients(ctx context.Context, networkID string, seenIDs []string) ([]string, error)
+
+	UpsertDNSEntry(ctx context.Context, dnsEntry *models.DNSEntry) (UpsertResult, error)
+	GetDNSEntries(networkID string) ([]models.DNSEntry, error)
+	GetDNSEntryHistory(dnsEntryID string) ([]models.DNSEntry, error)
+	DeleteMissingDNSEntries(ctx context.Context, networkID string, seenIDs []string) ([]string, error)
+
+	UpsertHost(ctx context.Context, host *models.Host) (UpsertResult, error)
+	GetHosts() ([]models.Host, error)
+	GetHostHistory(hostID string) ([]models.Host, error)
+	DeleteMissingHosts(ctx context.Context, seenIDs []string) ([]string, error)
+
+	UpsertEnrollmentKey(ctx context.Context, key *models.EnrollmentKey) (UpsertResult, error)
+	GetEnrollmentKeys() ([]models.EnrollmentKey, error)
+	GetEnrollmentKeyHistory(keyID string) ([]models.EnrollmentKey, error)
+	DeleteMissingEnrollmentKeys(ctx context.Context, seenIDs []string) ([]string, error)
+
+	UpsertUser(ctx context.Context, user *models.User) (UpsertResult, error)
+	GetUsers() ([]models.User, error)
+	GetUserHistory(username string) ([]models.User, error)
+	DeleteMissingUsers(ctx context.Context, seenIDs []string) ([]string, error)
+
+	UpsertUserGatewayAssignment(ctx context.Context, assignment *models.UserGatewayAssignment) (UpsertResult, error)
+	GetUserGatewayAssignments() ([]models.UserGatewayAssignment, error)
+	DeleteMissingUserGatewayAssignments(ctx context.Context, seenIDs []string) ([]string, error)
+
+	UpsertServerConfig(ctx context.Context, serverConfig *models.ServerConfig) (UpsertResult, error)
+	GetServerConfig() (*models.ServerConfig, error)
+	GetServerConfigHistory() ([]models.ServerConfig, error)
+
+	UpsertACLs(ctx context.Context, networkID string, aclsMap map[string]map[string]int) ([]string, models.ResourceCounts, error)
+	GetACLs(networkID string) ([]models.ACL, error)
+	GetACLHistory(aclID string) ([]models.ACL, error)
+	DeleteMissingACLs(ctx context.Context, networkID string, seenIDs []string) ([]string, error)
+
+	RefreshVolatileFields(ctx context.Context, tableName, id string, stored, observed, data models.JSONB) error
+
+	// History
+	GetNetworksAsOf(asOf time.Time) ([]models.Network, error)
+	GetNetworkStateAsOf(networkID string, asOf time.Time) (*models.NetworkState, error)
+	GetNodesAsOf(networkID string, asOf time.Time) ([]models.Node, error)
+	GetExtClientsAsOf(networkID string, asOf time.Time) ([]models.ExtClient, error)
+	GetDNSEntriesAsOf(networkID string, asOf time.Time) ([]models.DNSEntry, error)
+	GetACLsAsOf(networkID string, asOf time.Time) ([]models.ACL, error)
+	GetResourceAsOf(resource string, id string, asOf time.Time) (interface{}, error)
+	GetResourceVersion(resource string, id string, version int) (interface{}, error)
+	GetLatestResourceVersion(resource string, id string) (int, error)
+	GetCurrentResource(resource string, id string) (interface{}, error)
+	GetResourceHistory(resource string, id string) (interface{}, error)
+
+	// Secrets
+	RevealRecord(record interface{}) error
+
+	// Metrics
+	GetTopologyStats() (*models.TopologyStats, error)
+
+	// Listing
+	ListResources(resource string, opts ListOptions) (*models.Page, error)
+
+	// Sync runs and history
+	AcquireSyncLock(ctx context.Context) (func(), error)
+	FailRunningSyncRuns(message string) (int64, error)
+	CreateSyncRun(run *models.SyncRun) error
+	UpdateSyncRun(run *models.SyncRun) error
+	GetSyncRuns(limit int) ([]models.SyncRun, error)
+	GetSyncRun(id int) (*models.SyncRun, error)
+	CreateSyncHistory(syncHistory *models.SyncHistory) error
+	UpdateSyncHistory(syncHistory *models.SyncHistory) error
+	CreateSyncError(syncError *models.SyncError) error
+	GetLastSuccessfulSyncRun(scope string) (*models.SyncRun, error)
+	GetLatestSyncHistory(successful bool) ([]models.SyncHistory, error)
+
+	// Health
+	PingContext(ctx context.Context) error
+	PendingMigrations() ([]Migration, error)
+
+	// Node heartbeats
+	CreateNodeHeartbeats(ctx context.Context, heartbeats []models.NodeHeartbeat) error
+	GetLatestNodeHeartbeats(networkID string) ([]models.NodeHeartbeat, error)
+	GetNodeHeartbeats(nodeID string, since time.Time) ([]models.NodeHeartbeat, error)
+	PruneNodeHeartbeats(before time.Time) (int64, error)
+
+	// Incremental sync
+	GetSyncCursor(networkID string) (*models.SyncCursor, error)
+	SaveSyncCursor(cursor *models.SyncCursor) error
+	DeleteSyncCursor(networkID string) error
+}
+
+var _ Store = (*DB)(nil)
+
+// AuditStore is the storage of the audit log used by the HTTP API authenticator
+type AuditStore interface {
+	CreateAuditEntry(entry *models.AuditEntry) error
+	GetAuditEntries(limit int) ([]models.AuditEntry, error)
+}
+
+var _ AuditStore = (*DB)(nil)
+
+// WebhookStore is the storage of the webhook subscriptions and their delivery queue
+type WebhookStore interface {
+	CreateWebhookSubscription(subscription *models.WebhookSubscription) error
+	UpdateWebhookSubscription(subscription *models.WebhookSubscription) error
+	DeleteWebhookSubscription(id int) error
+	GetWebhookSubscription(id int) (*models.WebhookSubscription, error)
+	GetWebhookSubscriptions(enabledOnly bool) ([]models.WebhookSubscription, error)
+
+	CreateWebhookDelivery(delivery *models.WebhookDelivery) error
+	ClaimDueWebhookDeliveries(limit int, lease time.Duration) ([]models.WebhookDelivery, error)
+	UpdateWebhookDelivery(delivery *models.WebhookDelivery) error
+	GetWebhookDeliveries(subscriptionID int, limit int) ([]models.WebhookDelivery, error)
+}
+
+var _ WebhookStore = (*DB)(nil)
@@ -0,0 +1,43 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"netmaker-sync/internal/models"
+)
+
+// GetSyncCursor retrieves the sync cursor of a network, or nil if it has never been synced
+func (db *DB) GetSyncCursor(networkID string) (*models.SyncCursor, error) {
+	var cursor models.SyncCursor
+	err := db.Get(&cursor, `SELECT * FROM sync_cursors WHERE network_id = $1`, networkID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get sync cursor for network %s: %w", networkID, err)
+	}
+	return &cursor, nil
+}
+
+// SaveSyncCursor creates or replaces the sync cursor of a network
+func (db *DB) SaveSyncCursor(cursor *models.SyncCursor) error {
+	_, err := db.Exec(`
+		INSERT INTO sync_cursors (network_id, nodes_last_modified, synced_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (network_id) DO UPDATE
+		SET nodes_last_modified = excluded.nodes_last_modified, synced_at = excluded.synced_at
+	`, cursor.NetworkID, cursor.NodesLastModified, cursor.SyncedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save sync cursor for network %s: %w", cursor.NetworkID, err)
+	}
+	return nil
+}
+
+// DeleteSyncCursor removes the sync cursor of a network so that its next sync is a full one
+func (db *DB) DeleteSyncCursor(networkID string) error {
+	if _, err := db.Exec(`DELETE FROM sync_cursors WHERE network_id = $1`, networkID); err != nil {
+		return fmt.Errorf("failed to delete sync cursor for network %s: %w", networkID, err)
+	}
+	return nil
+}
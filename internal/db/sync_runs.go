@@ -0,0 +1,171 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"netmaker-sync/internal/models"
+
+	"github.com/sirupsen/logrus"
+)
+
+// syncLockKey is the Postgres advisory lock held by the sync run in progress
+const syncLockKey = 7261636502
+
+// ErrSyncInProgress is returned when another sync run holds the sync lock
+var ErrSyncInProgress = errors.New("sync already in progress")
+
+// ErrSyncRunNotFound is returned when a sync run does not exist
+var ErrSyncRunNotFound = errors.New("sync run not found")
+
+// AcquireSyncLock takes the lock that lets one sync run execute at a time and returns
+// the function that releases it, or ErrSyncInProgress if another run holds the lock.
+// On Postgres this is a session advisory lock, so it is shared by every replica using
+// the database and is dropped with the connection if the holder dies. A SQLite
+// database belongs to a single process, which holds the lock in memory.
+func (db *DB) AcquireSyncLock(ctx context.Context) (func(), error) {
+	if db.driver == DriverSQLite {
+		if !db.syncLock.TryLock() {
+			return nil, ErrSyncInProgress
+		}
+		return db.syncLock.Unlock, nil
+	}
+
+	// Session locks belong to a connection, so keep one aside for the whole run
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection for sync lock: %w", err)
+	}
+
+	var acquired bool
+	if err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, syncLockKey).Scan(&acquired); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to acquire sync lock: %w", err)
+	}
+	if !acquired {
+		conn.Close()
+		return nil, ErrSyncInProgress
+	}
+
+	return func() {
+		if _, err := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, syncLockKey); err != nil {
+			// Discard the connection rather than return it to the pool still holding the lock
+			logrus.Errorf("Failed to release sync lock: %v", err)
+			conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+		}
+		conn.Close()
+	}, nil
+}
+
+// CreateSyncRun records the start of a sync run
+func (db *DB) CreateSyncRun(run *models.SyncRun) error {
+	err := db.QueryRow(`
+		INSERT INTO sync_runs (scope, network_id, status, message, counts, started_at, completed_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id
+	`, run.Scope, run.NetworkID, run.Status, run.Message, run.Counts, run.StartedAt, run.CompletedAt).Scan(&run.ID)
+	if err != nil {
+		return fmt.Errorf("failed to create sync run: %w", err)
+	}
+	return nil
+}
+
+// UpdateSyncRun saves the status, message and counts of a sync run
+func (db *DB) UpdateSyncRun(run *models.SyncRun) error {
+	_, err := db.Exec(`
+		UPDATE sync_runs
+		SET status = $1, message = $2, counts = $3, completed_at = $4
+		WHERE id = $5
+	`, run.Status, run.Message, run.Counts, run.CompletedAt, run.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update sync run %d: %w", run.ID, err)
+	}
+	return nil
+}
+
+// FailRunningSyncRuns marks the runs still recorded as running as failed. It is called
+// with the sync lock held, when any such run must have been interrupted.
+func (db *DB) FailRunningSyncRuns(message string) (int64, error) {
+	result, err := db.Exec(`
+		UPDATE sync_runs
+		SET status = $1, message = $2, completed_at = NOW()
+		WHERE status = $3
+	`, models.SyncStatusFailed, message, models.SyncStatusRunning)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fail interrupted sync runs: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// GetSyncRuns retrieves the most recent sync runs, newest first
+func (db *DB) GetSyncRuns(limit int) ([]models.SyncRun, error) {
+	runs := []models.SyncRun{}
+	err := db.Select(&runs, `SELECT * FROM sync_runs ORDER BY id DESC LIMIT $1`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sync runs: %w", err)
+	}
+	return runs, nil
+}
+
+// GetSyncRun retrieves a sync run together with the sync history it grouped and the errors it recorded
+func (db *DB) GetSyncRun(id int) (*models.SyncRun, error) {
+	var run models.SyncRun
+	err := db.Get(&run, `SELECT * FROM sync_runs WHERE id = $1`, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w: %d", ErrSyncRunNotFound, id)
+		}
+		return nil, fmt.Errorf("failed to get sync run: %w", err)
+	}
+
+	run.History = []models.SyncHistory{}
+	err = db.Select(&run.History, `SELECT * FROM sync_history WHERE run_id = $1 ORDER BY id`, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sync history of run %d: %w", id, err)
+	}
+
+	run.Errors = []models.SyncError{}
+	err = db.Select(&run.Errors, `SELECT * FROM sync_errors WHERE run_id = $1 ORDER BY id`, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sync errors of run %d: %w", id, err)
+	}
+	return &run, nil
+}
+
+// GetLastSuccessfulSyncRun retrieves the most recent completed or partial sync run of a
+// scope, or nil if there is none
+func (db *DB) GetLastSuccessfulSyncRun(scope string) (*models.SyncRun, error) {
+	var run models.SyncRun
+	err := db.Get(&run, `
+		SELECT * FROM sync_runs
+		WHERE scope = $1 AND status IN ($2, $3)
+		ORDER BY id DESC LIMIT 1
+	`, scope, models.SyncStatusCompleted, models.SyncStatusPartial)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get last successful sync run: %w", err)
+	}
+	return &run, nil
+}
+
+// GetLatestSyncHistory retrieves the most recent sync history of each resource type. When
+// successful is true, only completed and partial syncs are considered.
+func (db *DB) GetLatestSyncHistory(successful bool) ([]models.SyncHistory, error) {
+	query := `SELECT MAX(id) FROM sync_history`
+	var args []interface{}
+	if successful {
+		query += ` WHERE status IN ($1, $2)`
+		args = append(args, models.SyncStatusCompleted, models.SyncStatusPartial)
+	}
+	query = `SELECT * FROM sync_history WHERE id IN (` + query + ` GROUP BY resource_type) ORDER BY resource_type`
+
+	history := []models.SyncHistory{}
+	if err := db.Select(&history, query, args...); err != nil {
+		return nil, fmt.Errorf("failed to get latest sync history: %w", err)
+	}
+	return history, nil
+}
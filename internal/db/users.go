@@ -0,0 +1,264 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"netmaker-sync/internal/models"
+	"netmaker-sync/internal/tracing"
+	"slices"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// UpsertUser inserts or updates a user in the database
+func (db *DB) UpsertUser(ctx context.Context, user *models.User) (result UpsertResult, err error) {
+	ctx, span := startSpan(ctx, "UpsertUser", "users", user.ID)
+	defer func() { tracing.End(span, err) }()
+
+	// Protect the sensitive fields before comparing with the current version
+	if err := db.protectFields(ctx, models.ResourceTypeUser, "users", user.ID, user.Data); err != nil {
+		return UpsertUnchanged, err
+	}
+
+	// Get the current user if it exists
+	var currentUser models.User
+	err = db.GetContext(ctx, &currentUser, `
+		SELECT * FROM users
+		WHERE id = $1 AND is_current = true
+	`, user.ID)
+
+	var currentUserPtr *models.User
+	if err == nil {
+		currentUserPtr = &currentUser
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return UpsertUnchanged, fmt.Errorf("failed to get current user: %w", err)
+	}
+
+	result, err = db.GenericUpsert(
+		ctx,
+		"users",
+		"id",
+		user.ID,
+		currentUserPtr,
+		user,
+		func(current, new interface{}) bool {
+			return db.usersEqual(*current.(*models.User), *new.(*models.User))
+		},
+		func(record interface{}) int {
+			return record.(*models.User).Version
+		},
+		func(record interface{}, version int) {
+			record.(*models.User).Version = version
+		},
+		func(record interface{}, lastModified time.Time) {
+			record.(*models.User).LastModified = lastModified
+		},
+		func(tx interface{}, record interface{}) error {
+			_, err := tx.(*sqlx.Tx).NamedExec(`
+				INSERT INTO users (
+					id, version, is_admin, is_super_admin, remote_gw_ids, is_current,
+					is_deleted, deleted_at, last_modified, created_at, data
+				) VALUES (
+					:id, :version, :is_admin, :is_super_admin, :remote_gw_ids, true,
+					:is_deleted, :deleted_at, :last_modified, NOW(), :data
+				)
+			`, record)
+			return err
+		},
+	)
+
+	// No changes but to the volatile fields, whose latest values are recorded apart from the data
+	if err == nil && result == UpsertUnchanged && currentUserPtr != nil {
+		err = db.RefreshVolatileFields(ctx, "users", user.ID, currentUser.Data, currentUser.ObservedData, user.Data)
+	}
+	return result, err
+}
+
+// usersEqual compares two users to determine if there are meaningful changes
+func (db *DB) usersEqual(a, b models.User) bool {
+	return a.IsAdmin == b.IsAdmin &&
+		a.IsSuperAdmin == b.IsSuperAdmin &&
+		slices.Equal(a.RemoteGatewayIDs, b.RemoteGatewayIDs) &&
+		a.IsDeleted == b.IsDeleted &&
+		db.dataEqual(models.ResourceTypeUser, a.Data, b.Data)
+}
+
+// GetUsers retrieves the current versions of the users
+func (db *DB) GetUsers() ([]models.User, error) {
+	users := []models.User{}
+	err := db.Select(&users, `
+		SELECT * FROM users
+		WHERE is_current = true AND is_deleted = false
+		ORDER BY id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get users: %w", err)
+	}
+	return users, nil
+}
+
+// GetUserHistory retrieves the version history of a user
+func (db *DB) GetUserHistory(username string) ([]models.User, error) {
+	var users []models.User
+	err := db.Select(&users, `
+		SELECT * FROM users
+		WHERE id = $1
+		ORDER BY version DESC
+	`, username)
+	return users, err
+}
+
+// DeleteUser records a new, deleted version of a user so that its removal from Netmaker is kept in the history
+func (db *DB) DeleteUser(ctx context.Context, username string) (err error) {
+	ctx, span := startSpan(ctx, "DeleteUser", "users", username)
+	defer func() { tracing.End(span, err) }()
+
+	var user models.User
+	err = db.GetContext(ctx, &user, `
+		SELECT * FROM users
+		WHERE id = $1 AND is_current = true
+	`, username)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("user not found: %s", username)
+		}
+		return fmt.Errorf("failed to get current user: %w", err)
+	}
+
+	// Nothing to do if the user has already been marked as deleted
+	if user.IsDeleted {
+		return nil
+	}
+
+	deletedAt := time.Now()
+	user.IsDeleted = true
+	user.DeletedAt = &deletedAt
+	_, err = db.UpsertUser(ctx, &user)
+	return err
+}
+
+// DeleteMissingUsers marks users that are no longer returned by the Netmaker API as deleted
+func (db *DB) DeleteMissingUsers(ctx context.Context, seenIDs []string) (deletedIDs []string, err error) {
+	ctx, span := startSpan(ctx, "DeleteMissingUsers", "users", nil)
+	defer func() { tracing.End(span, err) }()
+
+	return db.GenericTombstoneMissing(ctx, "users", "", nil, seenIDs, db.DeleteUser)
+}
+
+// UpsertUserGatewayAssignment inserts or updates the assignment of a user to a remote
+// access gateway in the database
+func (db *DB) UpsertUserGatewayAssignment(ctx context.Context, assignment *models.UserGatewayAssignment) (result UpsertResult, err error) {
+	ctx, span := startSpan(ctx, "UpsertUserGatewayAssignment", "user_gateway_assignments", assignment.ID)
+	defer func() { tracing.End(span, err) }()
+
+	// Get the current assignment if it exists
+	var currentAssignment models.UserGatewayAssignment
+	err = db.GetContext(ctx, &currentAssignment, `
+		SELECT * FROM user_gateway_assignments
+		WHERE id = $1 AND is_current = true
+	`, assignment.ID)
+
+	var currentAssignmentPtr *models.UserGatewayAssignment
+	if err == nil {
+		currentAssignmentPtr = &currentAssignment
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return UpsertUnchanged, fmt.Errorf("failed to get current user gateway assignment: %w", err)
+	}
+
+	return db.GenericUpsert(
+		ctx,
+		"user_gateway_assignments",
+		"id",
+		assignment.ID,
+		currentAssignmentPtr,
+		assignment,
+		func(current, new interface{}) bool {
+			return userGatewayAssignmentsEqual(*current.(*models.UserGatewayAssignment), *new.(*models.UserGatewayAssignment))
+		},
+		func(record interface{}) int {
+			return record.(*models.UserGatewayAssignment).Version
+		},
+		func(record interface{}, version int) {
+			record.(*models.UserGatewayAssignment).Version = version
+		},
+		func(record interface{}, lastModified time.Time) {
+			record.(*models.UserGatewayAssignment).LastModified = lastModified
+		},
+		func(tx interface{}, record interface{}) error {
+			_, err := tx.(*sqlx.Tx).NamedExec(`
+				INSERT INTO user_gateway_assignments (
+					id, version, username, gateway_id, is_current, is_deleted,
+					deleted_at, last_modified, created_at, data
+				) VALUES (
+					:id, :version, :username, :gateway_id, true, :is_deleted,
+					:deleted_at, :last_modified, NOW(), :data
+				)
+			`, record)
+			return err
+		},
+	)
+}
+
+// userGatewayAssignmentsEqual compares two assignments to determine if there are meaningful changes
+func userGatewayAssignmentsEqual(a, b models.UserGatewayAssignment) bool {
+	return a.Username == b.Username &&
+		a.GatewayID == b.GatewayID &&
+		a.IsDeleted == b.IsDeleted
+}
+
+// GetUserGatewayAssignments retrieves the current assignments of users to remote access
+// gateways
+func (db *DB) GetUserGatewayAssignments() ([]models.UserGatewayAssignment, error) {
+	assignments := []models.UserGatewayAssignment{}
+	err := db.Select(&assignments, `
+		SELECT * FROM user_gateway_assignments
+		WHERE is_current = true AND is_deleted = false
+		ORDER BY username, gateway_id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user gateway assignments: %w", err)
+	}
+	return assignments, nil
+}
+
+// DeleteUserGatewayAssignment records a new, deleted version of an assignment so that
+// the time a user lost access to a gateway is kept in the history
+func (db *DB) DeleteUserGatewayAssignment(ctx context.Context, assignmentID string) (err error) {
+	ctx, span := startSpan(ctx, "DeleteUserGatewayAssignment", "user_gateway_assignments", assignmentID)
+	defer func() { tracing.End(span, err) }()
+
+	var assignment models.UserGatewayAssignment
+	err = db.GetContext(ctx, &assignment, `
+		SELECT * FROM user_gateway_assignments
+		WHERE id = $1 AND is_current = true
+	`, assignmentID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("user gateway assignment not found: %s", assignmentID)
+		}
+		return fmt.Errorf("failed to get current user gateway assignment: %w", err)
+	}
+
+	// Nothing to do if the assignment has already been marked as deleted
+	if assignment.IsDeleted {
+		return nil
+	}
+
+	deletedAt := time.Now()
+	assignment.IsDeleted = true
+	assignment.DeletedAt = &deletedAt
+	_, err = db.UpsertUserGatewayAssignment(ctx, &assignment)
+	return err
+}
+
+// DeleteMissingUserGatewayAssignments marks the assignments that the users no longer
+// have as deleted
+func (db *DB) DeleteMissingUserGatewayAssignments(ctx context.Context, seenIDs []string) (deletedIDs []string, err error) {
+	ctx, span := startSpan(ctx, "DeleteMissingUserGatewayAssignments", "user_gateway_assignments", nil)
+	defer func() { tracing.End(span, err) }()
+
+	return db.GenericTombstoneMissing(ctx, "user_gateway_assignments", "", nil, seenIDs, db.DeleteUserGatewayAssignment)
+}
@@ -0,0 +1,82 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"netmaker-sync/internal/models"
+)
+
+var (
+	// ErrUnknownResource is returned when a resource name does not map to a versioned table
+	ErrUnknownResource = errors.New("unknown resource")
+	// ErrVersionNotFound is returned when a requested version of a record does not exist
+	ErrVersionNotFound = errors.New("version not found")
+)
+
+// versionedResource describes a versioned table that can be read by resource name
+type versionedResource struct {
+	tableName  string
+	newRecord  func() interface{}
+	tombstoned bool
+}
+
+// versionedResources maps the resource names used by the HTTP API to their versioned tables
+var versionedResources = map[string]versionedResource{
+	"networks":                 {tableName: "networks", newRecord: func() interface{} { return &models.Network{} }, tombstoned: true},
+	"nodes":                    {tableName: "nodes", newRecord: func() interface{} { return &models.Node{} }, tombstoned: true},
+	"ext_clients":              {tableName: "ext_clients", newRecord: func() interface{} { return &models.ExtClient{} }, tombstoned: true},
+	"hosts":                    {tableName: "hosts", newRecord: func() interface{} { return &models.Host{} }, tombstoned: true},
+	"dns":                      {tableName: "dns_entries", newRecord: func() interface{} { return &models.DNSEntry{} }, tombstoned: true},
+	"acls":                     {tableName: "acls", newRecord: func() interface{} { return &models.ACL{} }, tombstoned: true},
+	"enrollment_keys":          {tableName: "enrollment_keys", newRecord: func() interface{} { return &models.EnrollmentKey{} }, tombstoned: true},
+	"users":                    {tableName: "users", newRecord: func() interface{} { return &models.User{} }, tombstoned: true},
+	"user_gateway_assignments": {tableName: "user_gateway_assignments", newRecord: func() interface{} { return &models.UserGatewayAssignment{} }, tombstoned: true},
+	"server_config":            {tableName: "server_config", newRecord: func() interface{} { return &models.ServerConfig{} }},
+}
+
+// lookupResource returns the versioned table for a resource name
+func lookupResource(resource string) (versionedResource, error) {
+	r, ok := versionedResources[resource]
+	if !ok {
+		return versionedResource{}, fmt.Errorf("%w: %s", ErrUnknownResource, resource)
+	}
+	return r, nil
+}
+
+// GetResourceVersion retrieves a specific version of a record by resource name.
+// The returned value is a pointer to the model for the resource (e.g. *models.Node).
+func (db *DB) GetResourceVersion(resource string, id string, version int) (interface{}, error) {
+	r, err := lookupResource(resource)
+	if err != nil {
+		return nil, err
+	}
+
+	record := r.newRecord()
+	query := fmt.Sprintf("SELECT * FROM %s WHERE id = $1 AND version = $2", r.tableName)
+	if err := db.Get(record, query, id, version); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w: %s %s version %d", ErrVersionNotFound, resource, id, version)
+		}
+		return nil, fmt.Errorf("failed to get %s %s version %d: %w", resource, id, version, err)
+	}
+	return record, nil
+}
+
+// GetLatestResourceVersion retrieves the latest version number of a record by resource name
+func (db *DB) GetLatestResourceVersion(resource string, id string) (int, error) {
+	r, err := lookupResource(resource)
+	if err != nil {
+		return 0, err
+	}
+
+	var version int
+	query := fmt.Sprintf("SELECT COALESCE(MAX(version), 0) FROM %s WHERE id = $1", r.tableName)
+	if err := db.Get(&version, query, id); err != nil {
+		return 0, fmt.Errorf("failed to get latest version of %s %s: %w", resource, id, err)
+	}
+	if version == 0 {
+		return 0, fmt.Errorf("%w: no versions of %s %s", ErrVersionNotFound, resource, id)
+	}
+	return version, nil
+}
@@ -0,0 +1,113 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"netmaker-sync/internal/config"
+	"netmaker-sync/internal/models"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// SetVolatileFields sets the JSON paths of the data of each resource type that are
+// ignored when comparing a record with its current version
+func (db *DB) SetVolatileFields(fields []config.VolatileFieldConfig) {
+	db.volatileFields = make(map[string][]string)
+	for _, field := range fields {
+		db.volatileFields[field.ResourceType] = append(db.volatileFields[field.ResourceType], field.Path)
+	}
+}
+
+// dataEqual compares the data of two versions of a record of a resource type, ignoring
+// its volatile fields
+func (db *DB) dataEqual(resourceType string, a, b models.JSONB) bool {
+	paths := db.volatileFields[resourceType]
+	if len(paths) == 0 {
+		return reflect.DeepEqual(a, b)
+	}
+	return reflect.DeepEqual(withoutPaths(a, paths), withoutPaths(b, paths))
+}
+
+// withoutPaths returns a copy of data without the values at the dotted paths. Only the
+// objects along the paths are copied.
+func withoutPaths(data map[string]interface{}, paths []string) map[string]interface{} {
+	if data == nil {
+		return nil
+	}
+
+	stripped := make(map[string]interface{}, len(data))
+	for key, value := range data {
+		stripped[key] = value
+	}
+	for _, path := range paths {
+		key, rest, nested := strings.Cut(path, ".")
+		if !nested {
+			delete(stripped, key)
+			continue
+		}
+		if child, ok := stripped[key].(map[string]interface{}); ok {
+			stripped[key] = withoutPaths(child, []string{rest})
+		}
+	}
+	return stripped
+}
+
+// onlyPaths returns the values at the dotted paths of data, or nil if there are none
+func onlyPaths(data map[string]interface{}, paths []string) map[string]interface{} {
+	var values map[string]interface{}
+	for _, path := range paths {
+		key, rest, nested := strings.Cut(path, ".")
+		value, ok := data[key]
+		if !ok {
+			continue
+		}
+		if nested {
+			child, isObject := value.(map[string]interface{})
+			if !isObject {
+				continue
+			}
+			if value = onlyPaths(child, []string{rest}); value == nil {
+				continue
+			}
+			if existing, ok := values[key].(map[string]interface{}); ok {
+				value = models.LatestData(existing, value.(map[string]interface{}))
+			}
+		}
+		if values == nil {
+			values = make(map[string]interface{})
+		}
+		values[key] = value
+	}
+	return values
+}
+
+// RefreshVolatileFields records the values of the volatile fields of data in the
+// observed_data of the current version of a record that did not otherwise change, so
+// that they stay up to date without creating a new version. stored and observed are
+// the data and observed data of the current version, which are left as they were
+// stored for point-in-time and history reads.
+func (db *DB) RefreshVolatileFields(ctx context.Context, tableName, id string, stored, observed, data models.JSONB) error {
+	paths := db.volatileFields[tableResourceTypes[tableName]]
+	if len(paths) == 0 {
+		return nil
+	}
+
+	// The values last observed, or those the version was stored with
+	latest := onlyPaths(data, paths)
+	if latest == nil {
+		return nil
+	}
+	if observed == nil {
+		observed = onlyPaths(stored, paths)
+	}
+	if reflect.DeepEqual(map[string]interface{}(observed), latest) {
+		return nil
+	}
+
+	query := fmt.Sprintf("UPDATE %s SET observed_data = $1, observed_at = $2 WHERE id = $3 AND is_current = true", tableName)
+	if _, err := db.ExecContext(ctx, query, models.JSONB(latest), time.Now(), id); err != nil {
+		return fmt.Errorf("failed to refresh volatile fields of %s %s: %w", tableName, id, err)
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"netmaker-sync/internal/models"
+	"time"
+)
+
+// ErrWebhookNotFound is returned when a webhook subscription does not exist
+var ErrWebhookNotFound = errors.New("webhook subscription not found")
+
+// CreateWebhookSubscription inserts a new webhook subscription
+func (db *DB) CreateWebhookSubscription(subscription *models.WebhookSubscription) error {
+	query := `
+		INSERT INTO webhook_subscriptions (
+			name, url, secret, event_types, resource_types, network_ids,
+			changed_fields, enabled, created_at, updated_at
+		) VALUES (
+			$1, $2, $3, $4, $5, $6, $7, $8, NOW(), NOW()
+		)
+		RETURNING id, created_at, updated_at
+	`
+	return db.QueryRow(query,
+		subscription.Name,
+		subscription.URL,
+		subscription.Secret,
+		subscription.EventTypes,
+		subscription.ResourceTypes,
+		subscription.NetworkIDs,
+		subscription.ChangedFields,
+		subscription.Enabled).Scan(&subscription.ID, &subscription.CreatedAt, &subscription.UpdatedAt)
+}
+
+// UpdateWebhookSubscription updates an existing webhook subscription
+func (db *DB) UpdateWebhookSubscription(subscription *models.WebhookSubscription) error {
+	query := `
+		UPDATE webhook_subscriptions
+		SET name = $1, url = $2, secret = $3, event_types = $4, resource_types = $5,
+			network_ids = $6, changed_fields = $7, enabled = $8, updated_at = NOW()
+		WHERE id = $9
+		RETURNING updated_at
+	`
+	err := db.QueryRow(query,
+		subscription.Name,
+		subscription.URL,
+		subscription.Secret,
+		subscription.EventTypes,
+		subscription.ResourceTypes,
+		subscription.NetworkIDs,
+		subscription.ChangedFields,
+		subscription.Enabled,
+		subscription.ID).Scan(&subscription.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%w: %d", ErrWebhookNotFound, subscription.ID)
+		}
+		return fmt.Errorf("failed to update webhook subscription: %w", err)
+	}
+	return nil
+}
+
+// DeleteWebhookSubscription deletes a webhook subscription and its delivery log
+func (db *DB) DeleteWebhookSubscription(id int) error {
+	result, err := db.Exec(`DELETE FROM webhook_subscriptions WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete webhook subscription: %w", err)
+	}
+
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return fmt.Errorf("%w: %d", ErrWebhookNotFound, id)
+	}
+	return nil
+}
+
+// GetWebhookSubscription retrieves a webhook subscription by ID
+func (db *DB) GetWebhookSubscription(id int) (*models.WebhookSubscription, error) {
+	var subscription models.WebhookSubscription
+	err := db.Get(&subscription, `SELECT * FROM webhook_subscriptions WHERE id = $1`, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w: %d", ErrWebhookNotFound, id)
+		}
+		return nil, fmt.Errorf("failed to get webhook subscription: %w", err)
+	}
+	return &subscription, nil
+}
+
+// GetWebhookSubscriptions retrieves all webhook subscriptions, optionally only the enabled ones
+func (db *DB) GetWebhookSubscriptions(enabledOnly bool) ([]models.WebhookSubscription, error) {
+	var subscriptions []models.WebhookSubscription
+	err := db.Select(&subscriptions, `
+		SELECT * FROM webhook_subscriptions
+		WHERE enabled = true OR $1 = false
+		ORDER BY id
+	`, enabledOnly)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get webhook subscriptions: %w", err)
+	}
+	return subscriptions, nil
+}
+
+// CreateWebhookDelivery queues a new delivery for immediate sending
+func (db *DB) CreateWebhookDelivery(delivery *models.WebhookDelivery) error {
+	query := `
+		INSERT INTO webhook_deliveries (
+			subscription_id, event_type, payload, status, attempts, next_attempt_at, created_at
+		) VALUES (
+			$1, $2, $3, $4, 0, NOW(), NOW()
+		)
+		RETURNING id, next_attempt_at, created_at
+	`
+	return db.QueryRow(query,
+		delivery.SubscriptionID,
+		delivery.EventType,
+		delivery.Payload,
+		delivery.Status).Scan(&delivery.ID, &delivery.NextAttemptAt, &delivery.CreatedAt)
+}
+
+// ClaimDueWebhookDeliveries claims up to limit pending deliveries whose next attempt is due.
+// Claimed deliveries have their next attempt pushed back by lease so that other replicas
+// skip them while they are being sent.
+func (db *DB) ClaimDueWebhookDeliveries(limit int, lease time.Duration) ([]models.WebhookDelivery, error) {
+	// SQLite has a single writer, so the claim cannot race and needs no row locks
+	query := `
+		UPDATE webhook_deliveries
+		SET next_attempt_at = NOW() + make_interval(secs => $3)
+		WHERE id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE status = $1 AND next_attempt_at <= NOW()
+			ORDER BY next_attempt_at
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *
+	`
+	if db.driver == DriverSQLite {
+		query = `
+			UPDATE webhook_deliveries
+			SET next_attempt_at = strftime('%Y-%m-%d %H:%M:%f+00:00', 'now', '+' || $3 || ' seconds')
+			WHERE id IN (
+				SELECT id FROM webhook_deliveries
+				WHERE status = $1 AND julianday(next_attempt_at) <= julianday('now')
+				ORDER BY julianday(next_attempt_at)
+				LIMIT $2
+			)
+			RETURNING *
+		`
+	}
+
+	var deliveries []models.WebhookDelivery
+	err := db.Select(&deliveries, query, models.DeliveryStatusPending, limit, lease.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim webhook deliveries: %w", err)
+	}
+	return deliveries, nil
+}
+
+// UpdateWebhookDelivery records the outcome of a delivery attempt
+func (db *DB) UpdateWebhookDelivery(delivery *models.WebhookDelivery) error {
+	_, err := db.Exec(`
+		UPDATE webhook_deliveries
+		SET status = $1, attempts = $2, last_status_code = $3, last_error = $4,
+			next_attempt_at = $5, delivered_at = $6
+		WHERE id = $7
+	`,
+		delivery.Status,
+		delivery.Attempts,
+		delivery.LastStatusCode,
+		delivery.LastError,
+		delivery.NextAttemptAt,
+		delivery.DeliveredAt,
+		delivery.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update webhook delivery: %w", err)
+	}
+	return nil
+}
+
+// GetWebhookDeliveries retrieves the most recent deliveries for a subscription
+func (db *DB) GetWebhookDeliveries(subscriptionID int, limit int) ([]models.WebhookDelivery, error) {
+	var deliveries []models.WebhookDelivery
+	err := db.Select(&deliveries, `
+		SELECT * FROM webhook_deliveries
+		WHERE subscription_id = $1
+		ORDER BY id DESC
+		LIMIT $2
+	`, subscriptionID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get webhook deliveries: %w", err)
+	}
+	return deliveries, nil
+}
@@ -0,0 +1,160 @@
+// Package diff computes field-level differences between two versions of a resource
+package diff
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Operation constants, following JSON Patch (RFC 6902) naming
+const (
+	OpAdd     = "add"
+	OpRemove  = "remove"
+	OpReplace = "replace"
+)
+
+// DefaultIgnoredFields are the top-level fields of a versioned record that change on
+// every version, or are refreshed in place, and therefore never represent a change in Netmaker
+var DefaultIgnoredFields = []string{"version", "is_current", "lastmodified", "created_at", "observed_data", "observed_at"}
+
+// Change represents a single field-level change between two versions
+type Change struct {
+	Op       string      `json:"op"`
+	Path     string      `json:"path"`
+	OldValue interface{} `json:"old_value,omitempty"`
+	Value    interface{} `json:"value,omitempty"`
+}
+
+// Result represents the changes between two versions of a resource
+type Result struct {
+	Resource         string    `json:"resource"`
+	ID               string    `json:"id"`
+	FromVersion      int       `json:"from_version"`
+	ToVersion        int       `json:"to_version"`
+	FromLastModified time.Time `json:"from_lastmodified"`
+	ToLastModified   time.Time `json:"to_lastmodified"`
+	Changes          []Change  `json:"changes"`
+}
+
+// Compare returns the changes that turn a into b. Both values are compared through
+// their JSON representation, so paths use the JSON field names (e.g. "/data/isegressgateway").
+// Top-level fields listed in ignore are skipped.
+func Compare(a, b interface{}, ignore ...string) ([]Change, error) {
+	aValue, err := toJSONValue(a)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert old record: %w", err)
+	}
+
+	bValue, err := toJSONValue(b)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert new record: %w", err)
+	}
+
+	// Drop ignored top-level fields from both sides
+	if aMap, ok := aValue.(map[string]interface{}); ok {
+		for _, field := range ignore {
+			delete(aMap, field)
+		}
+	}
+	if bMap, ok := bValue.(map[string]interface{}); ok {
+		for _, field := range ignore {
+			delete(bMap, field)
+		}
+	}
+
+	changes := []Change{}
+	walk("", aValue, bValue, &changes)
+	return changes, nil
+}
+
+// toJSONValue converts a value to its generic JSON representation
+func toJSONValue(v interface{}) (interface{}, error) {
+	bytes, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(bytes, &value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// walk recursively compares two JSON values and appends the differences to changes
+func walk(path string, a, b interface{}, changes *[]Change) {
+	switch aTyped := a.(type) {
+	case map[string]interface{}:
+		bTyped, ok := b.(map[string]interface{})
+		if !ok {
+			break
+		}
+
+		// Visit keys in a stable order so the output is deterministic
+		keys := make([]string, 0, len(aTyped)+len(bTyped))
+		for key := range aTyped {
+			keys = append(keys, key)
+		}
+		for key := range bTyped {
+			if _, ok := aTyped[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			childPath := path + "/" + escapePointer(key)
+			aChild, inA := aTyped[key]
+			bChild, inB := bTyped[key]
+			switch {
+			case !inA:
+				*changes = append(*changes, Change{Op: OpAdd, Path: childPath, Value: bChild})
+			case !inB:
+				*changes = append(*changes, Change{Op: OpRemove, Path: childPath, OldValue: aChild})
+			default:
+				walk(childPath, aChild, bChild, changes)
+			}
+		}
+		return
+
+	case []interface{}:
+		bTyped, ok := b.([]interface{})
+		if !ok {
+			break
+		}
+
+		// Compare elements by position, then report any added or removed tail
+		common := len(aTyped)
+		if len(bTyped) < common {
+			common = len(bTyped)
+		}
+		for i := 0; i < common; i++ {
+			walk(fmt.Sprintf("%s/%d", path, i), aTyped[i], bTyped[i], changes)
+		}
+		for i := common; i < len(bTyped); i++ {
+			*changes = append(*changes, Change{Op: OpAdd, Path: fmt.Sprintf("%s/%d", path, i), Value: bTyped[i]})
+		}
+		for i := len(aTyped) - 1; i >= common; i-- {
+			*changes = append(*changes, Change{Op: OpRemove, Path: fmt.Sprintf("%s/%d", path, i), OldValue: aTyped[i]})
+		}
+		return
+	}
+
+	// Scalars, or values whose type changed
+	if !reflect.DeepEqual(a, b) {
+		if path == "" {
+			path = "/"
+		}
+		*changes = append(*changes, Change{Op: OpReplace, Path: path, OldValue: a, Value: b})
+	}
+}
+
+// escapePointer escapes a key for use in a JSON Pointer (RFC 6901)
+func escapePointer(key string) string {
+	key = strings.ReplaceAll(key, "~", "~0")
+	return strings.ReplaceAll(key, "/", "~1")
+}
@@ -0,0 +1,117 @@
+package diff
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		name   string
+		a, b   interface{}
+		ignore []string
+		want   []Change
+	}{
+		{
+			name: "equal",
+			a:    map[string]interface{}{"name": "n1", "data": map[string]interface{}{"connected": true}},
+			b:    map[string]interface{}{"name": "n1", "data": map[string]interface{}{"connected": true}},
+			want: []Change{},
+		},
+		{
+			name: "nested replace",
+			a:    map[string]interface{}{"data": map[string]interface{}{"connected": true}},
+			b:    map[string]interface{}{"data": map[string]interface{}{"connected": false}},
+			want: []Change{{Op: OpReplace, Path: "/data/connected", OldValue: true, Value: false}},
+		},
+		{
+			name: "added and removed keys in key order",
+			a:    map[string]interface{}{"b": 1.0, "c": "x"},
+			b:    map[string]interface{}{"a": "y", "b": 1.0},
+			want: []Change{
+				{Op: OpAdd, Path: "/a", Value: "y"},
+				{Op: OpRemove, Path: "/c", OldValue: "x"},
+			},
+		},
+		{
+			name: "array grows",
+			a:    map[string]interface{}{"ips": []interface{}{"10.0.0.1"}},
+			b:    map[string]interface{}{"ips": []interface{}{"10.0.0.2", "10.0.0.3"}},
+			want: []Change{
+				{Op: OpReplace, Path: "/ips/0", OldValue: "10.0.0.1", Value: "10.0.0.2"},
+				{Op: OpAdd, Path: "/ips/1", Value: "10.0.0.3"},
+			},
+		},
+		{
+			name: "array shrinks from the end",
+			a:    map[string]interface{}{"ips": []interface{}{"a", "b", "c"}},
+			b:    map[string]interface{}{"ips": []interface{}{"a"}},
+			want: []Change{
+				{Op: OpRemove, Path: "/ips/2", OldValue: "c"},
+				{Op: OpRemove, Path: "/ips/1", OldValue: "b"},
+			},
+		},
+		{
+			name: "type change",
+			a:    map[string]interface{}{"data": map[string]interface{}{"port": 51821.0}},
+			b:    map[string]interface{}{"data": "none"},
+			want: []Change{{Op: OpReplace, Path: "/data", OldValue: map[string]interface{}{"port": 51821.0}, Value: "none"}},
+		},
+		{
+			name: "null and missing differ",
+			a:    map[string]interface{}{"expiration": nil},
+			b:    map[string]interface{}{},
+			want: []Change{{Op: OpRemove, Path: "/expiration", OldValue: nil}},
+		},
+		{
+			name: "escaped keys",
+			a:    map[string]interface{}{"a/b": 1.0, "c~d": 1.0},
+			b:    map[string]interface{}{"a/b": 2.0, "c~d": 2.0},
+			want: []Change{
+				{Op: OpReplace, Path: "/a~1b", OldValue: 1.0, Value: 2.0},
+				{Op: OpReplace, Path: "/c~0d", OldValue: 1.0, Value: 2.0},
+			},
+		},
+		{
+			name:   "ignored top-level fields",
+			a:      map[string]interface{}{"version": 1.0, "lastmodified": "t1", "data": map[string]interface{}{"version": "v1"}},
+			b:      map[string]interface{}{"version": 2.0, "lastmodified": "t2", "data": map[string]interface{}{"version": "v2"}},
+			ignore: DefaultIgnoredFields,
+			want:   []Change{{Op: OpReplace, Path: "/data/version", OldValue: "v1", Value: "v2"}},
+		},
+		{
+			name: "structs use their JSON field names",
+			a: struct {
+				Name string `json:"name"`
+			}{Name: "n1"},
+			b: struct {
+				Name string `json:"name"`
+			}{Name: "n2"},
+			want: []Change{{Op: OpReplace, Path: "/name", OldValue: "n1", Value: "n2"}},
+		},
+		{
+			name: "scalar root",
+			a:    1,
+			b:    2,
+			want: []Change{{Op: OpReplace, Path: "/", OldValue: 1.0, Value: 2.0}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Compare(tt.a, tt.b, tt.ignore...)
+			if err != nil {
+				t.Fatalf("Compare() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Compare() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompareRejectsUnmarshalableValues(t *testing.T) {
+	if _, err := Compare(make(chan int), 1); err == nil {
+		t.Error("Compare() with a channel succeeded, want an error")
+	}
+}
@@ -0,0 +1,68 @@
+// Package events provides an in-process publish/subscribe broker for change events
+package events
+
+import (
+	"netmaker-sync/internal/models"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultBufferSize is the number of events buffered per subscriber before events are dropped
+const DefaultBufferSize = 256
+
+// Broker fans out change events to any number of subscribers
+type Broker struct {
+	mu          sync.RWMutex
+	subscribers map[chan models.ChangeEvent]struct{}
+	bufferSize  int
+}
+
+// NewBroker creates a new broker with the given per-subscriber buffer size
+func NewBroker(bufferSize int) *Broker {
+	if bufferSize <= 0 {
+		bufferSize = DefaultBufferSize
+	}
+
+	return &Broker{
+		subscribers: make(map[chan models.ChangeEvent]struct{}),
+		bufferSize:  bufferSize,
+	}
+}
+
+// Subscribe registers a new subscriber. It returns the channel events are delivered on
+// and a function that must be called to unsubscribe once the subscriber is done.
+func (b *Broker) Subscribe() (<-chan models.ChangeEvent, func()) {
+	ch := make(chan models.ChangeEvent, b.bufferSize)
+
+	b.mu.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subscribers, ch)
+			b.mu.Unlock()
+			close(ch)
+		})
+	}
+
+	return ch, unsubscribe
+}
+
+// Publish delivers an event to every subscriber. It never blocks: if a subscriber's
+// buffer is full the event is dropped for that subscriber.
+func (b *Broker) Publish(event models.ChangeEvent) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+			logrus.Warnf("Dropping %s event for %s %s, subscriber is too slow", event.Kind, event.ResourceType, event.ID)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package metrics
+
+import (
+	"net/http"
+	"netmaker-sync/internal/models"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// namespace prefixes the names of every metric
+const namespace = "netmaker_sync"
+
+var (
+	syncRuns = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "sync_runs_total",
+		Help:      "Sync runs by scope and final status.",
+	}, []string{"scope", "status"})
+
+	syncRunDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "sync_run_duration_seconds",
+		Help:      "Duration of sync runs by scope and final status.",
+		Buckets:   []float64{1, 2.5, 5, 10, 30, 60, 120, 300, 600, 1800},
+	}, []string{"scope", "status"})
+
+	lastSuccessfulRun = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "last_successful_sync_run_timestamp_seconds",
+		Help:      "Unix time at which the last completed or partial sync run of each scope finished.",
+	}, []string{"scope"})
+
+	resourceSyncDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "resource_sync_duration_seconds",
+		Help:      "Duration of syncing one resource type, for one network or all of them, by status.",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 12),
+	}, []string{"resource_type", "status"})
+
+	lastSuccessfulResourceSync = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "last_successful_resource_sync_timestamp_seconds",
+		Help:      "Unix time at which a sync of each resource type last completed, fully or partially.",
+	}, []string{"resource_type"})
+
+	records = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "records_total",
+		Help:      "Records synced by resource type and result (created, updated, unchanged, deleted or failed).",
+	}, []string{"resource_type", "result"})
+
+	apiRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "netmaker_api_requests_total",
+		Help:      "Requests to the Netmaker API by operation, method and status code (error when no response was received).",
+	}, []string{"operation", "method", "status_code"})
+
+	apiRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "netmaker_api_request_duration_seconds",
+		Help:      "Latency of the requests to the Netmaker API by operation and method, excluding rate limiting.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation", "method"})
+
+	dbQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_query_duration_seconds",
+		Help:      "Latency of the database queries run outside transactions, by statement (select, insert, update or delete).",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 14),
+	}, []string{"operation"})
+
+	nodes = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "nodes",
+		Help:      "Current nodes by network and whether they are connected.",
+	}, []string{"network", "connected"})
+
+	extClients = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "ext_clients",
+		Help:      "Current external clients by network and whether they are enabled.",
+	}, []string{"network", "enabled"})
+
+	hosts = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "hosts",
+		Help:      "Current hosts by operating system.",
+	}, []string{"os"})
+
+	enrollmentKeyExpiration = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "enrollment_key_expiration_timestamp_seconds",
+		Help:      "When the current enrollment keys that expire do so, by key ID and type.",
+	}, []string{"key", "type"})
+
+	enrollmentKeyUsesRemaining = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "enrollment_key_uses_remaining",
+		Help:      "Uses left of the current enrollment keys limited to a number of uses, by key ID.",
+	}, []string{"key"})
+
+	serverInfo = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "server_info",
+		Help:      "Always 1, labelled with the version of the Netmaker server and whether it is the Enterprise Edition.",
+	}, []string{"version", "ee"})
+
+	licenseLimit = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "license_limit",
+		Help:      "License limits of the Netmaker server by resource, for the limits that are set.",
+	}, []string{"resource"})
+
+	licenseUsage = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "license_usage",
+		Help:      "Current records counted against the license limits of the Netmaker server, by resource.",
+	}, []string{"resource"})
+)
+
+// Handler serves the metrics in the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// ObserveSyncRun records the outcome and duration of a sync run
+func ObserveSyncRun(run *models.SyncRun) {
+	if run.CompletedAt == nil {
+		return
+	}
+	syncRuns.WithLabelValues(run.Scope, run.Status).Inc()
+	syncRunDuration.WithLabelValues(run.Scope, run.Status).Observe(run.CompletedAt.Sub(run.StartedAt).Seconds())
+	if run.Status != models.SyncStatusFailed {
+		lastSuccessfulRun.WithLabelValues(run.Scope).Set(float64(run.CompletedAt.Unix()))
+	}
+}
+
+// ObserveResourceSync records the outcome and duration of syncing one resource type
+func ObserveResourceSync(syncHistory *models.SyncHistory) {
+	if syncHistory.CompletedAt == nil {
+		return
+	}
+	resourceSyncDuration.WithLabelValues(syncHistory.ResourceType, syncHistory.Status).
+		Observe(syncHistory.CompletedAt.Sub(syncHistory.StartedAt).Seconds())
+	if syncHistory.Status != models.SyncStatusFailed {
+		lastSuccessfulResourceSync.WithLabelValues(syncHistory.ResourceType).Set(float64(syncHistory.CompletedAt.Unix()))
+	}
+}
+
+// AddRecords counts what a sync did to the records of a resource type
+func AddRecords(resourceType string, counts models.ResourceCounts) {
+	for result, n := range map[string]int{
+		"created":   counts.Created,
+		"updated":   counts.Updated,
+		"unchanged": counts.Unchanged,
+		"deleted":   counts.Deleted,
+		"failed":    counts.Failed,
+	} {
+		if n > 0 {
+			records.WithLabelValues(resourceType, result).Add(float64(n))
+		}
+	}
+}
+
+// ObserveAPIRequest records a request to the Netmaker API. statusCode is 0 when no
+// response was received.
+func ObserveAPIRequest(operation, method string, statusCode int, duration time.Duration) {
+	status := "error"
+	if statusCode > 0 {
+		status = strconv.Itoa(statusCode)
+	}
+	apiRequests.WithLabelValues(operation, method, status).Inc()
+	apiRequestDuration.WithLabelValues(operation, method).Observe(duration.Seconds())
+}
+
+// ObserveDBQuery records the latency of a database query
+func ObserveDBQuery(operation string, duration time.Duration) {
+	dbQueryDuration.WithLabelValues(operation).Observe(duration.Seconds())
+}
+
+// SetTopology replaces the gauges of the current records with stats. Label values of
+// networks, operating systems, enrollment keys, server versions and limits that
+// disappeared are removed.
+func SetTopology(stats *models.TopologyStats) {
+	nodes.Reset()
+	for _, count := range stats.Nodes {
+		nodes.WithLabelValues(count.NetworkID, strconv.FormatBool(count.Connected)).Set(float64(count.Count))
+	}
+	extClients.Reset()
+	for _, count := range stats.ExtClients {
+		extClients.WithLabelValues(count.NetworkID, strconv.FormatBool(count.Enabled)).Set(float64(count.Count))
+	}
+	hosts.Reset()
+	for _, count := range stats.Hosts {
+		hosts.WithLabelValues(count.OS).Set(float64(count.Count))
+	}
+	enrollmentKeyExpiration.Reset()
+	enrollmentKeyUsesRemaining.Reset()
+	for _, key := range stats.EnrollmentKeys {
+		if key.Expiration != nil {
+			enrollmentKeyExpiration.WithLabelValues(key.ID, key.Type).Set(float64(key.Expiration.Unix()))
+		}
+		if key.Type == models.EnrollmentKeyTypeUses {
+			enrollmentKeyUsesRemaining.WithLabelValues(key.ID).Set(float64(key.UsesRemaining))
+		}
+	}
+
+	// Without a synced server configuration, no limit is known
+	serverInfo.Reset()
+	serverConfig := models.ServerConfig{}
+	if stats.ServerConfig != nil {
+		serverConfig = *stats.ServerConfig
+		serverInfo.WithLabelValues(serverConfig.ServerVersion, strconv.FormatBool(serverConfig.IsEE)).Set(1)
+	}
+	licenseLimit.Reset()
+	usage := stats.LicenseUsage
+	for _, resource := range []struct {
+		name         string
+		limit, usage int
+	}{
+		{"networks", serverConfig.NetworksLimit, usage.Networks},
+		{"machines", serverConfig.MachinesLimit, usage.Machines},
+		{"users", serverConfig.UsersLimit, usage.Users},
+		{"ingresses", serverConfig.IngressesLimit, usage.Ingresses},
+		{"egresses", serverConfig.EgressesLimit, usage.Egresses},
+	} {
+		licenseUsage.WithLabelValues(resource.name).Set(float64(resource.usage))
+		if resource.limit > 0 {
+			licenseLimit.WithLabelValues(resource.name).Set(float64(resource.limit))
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"database/sql/driver"
 	"encoding/json"
 	"errors"
+	"netmaker-sync/internal/diff"
 	"time"
 )
 
@@ -24,139 +25,626 @@ func (j *JSONB) Scan(value interface{}) error {
 		*j = nil
 		return nil
 	}
-	
-	bytes, ok := value.([]byte)
-	if !ok {
-		return errors.New("type assertion to []byte failed")
+
+	bytes, err := jsonBytes(value)
+	if err != nil {
+		return err
 	}
-	
+
 	return json.Unmarshal(bytes, j)
 }
 
+// LatestData returns the data of a version of a record with the values of its volatile
+// fields observed since the version was stored laid over it
+func LatestData(data, observed JSONB) JSONB {
+	if observed == nil {
+		return data
+	}
+	return JSONB(overlay(data, observed))
+}
+
+// overlay returns a copy of data with the values of patch set in it, merging nested objects
+func overlay(data, patch map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(data)+len(patch))
+	for key, value := range data {
+		merged[key] = value
+	}
+	for key, value := range patch {
+		child, isObject := value.(map[string]interface{})
+		current, hasObject := merged[key].(map[string]interface{})
+		if isObject && hasObject {
+			merged[key] = overlay(current, child)
+			continue
+		}
+		merged[key] = value
+	}
+	return merged
+}
+
+// jsonBytes returns the raw JSON of a scanned column. Drivers return JSON columns
+// as []byte, or as string when the value was stored as text (e.g. a SQLite default).
+func jsonBytes(value interface{}) ([]byte, error) {
+	switch v := value.(type) {
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	default:
+		return nil, errors.New("type assertion to []byte failed")
+	}
+}
+
+// StringList is a wrapper around []string that is stored as a JSONB array
+type StringList []string
+
+// Value implements the driver.Valuer interface
+func (l StringList) Value() (driver.Value, error) {
+	if l == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(l)
+}
+
+// Scan implements the sql.Scanner interface
+func (l *StringList) Scan(value interface{}) error {
+	if value == nil {
+		*l = nil
+		return nil
+	}
+
+	bytes, err := jsonBytes(value)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(bytes, l)
+}
+
 // Network represents a Netmaker network
 type Network struct {
-	ID                     string    `json:"id" db:"id"`
-	Version                int       `json:"version" db:"version"`
-	Name                   string    `json:"name" db:"name"`
-	AddressRange           string    `json:"addressrange" db:"address_range"`
-	AddressRange6          string    `json:"addressrange6" db:"address_range6"`
-	LocalRange             string    `json:"localrange" db:"local_range"`
-	IsDualStack            bool      `json:"isdualstack" db:"is_dual_stack"`
-	IsIPv4                 bool      `json:"isipv4" db:"is_ipv4"`
-	IsIPv6                 bool      `json:"isipv6" db:"is_ipv6"`
-	IsLocal                bool      `json:"islocal" db:"is_local"`
-	DefaultAccessControl   string    `json:"defaultacl" db:"default_access_control"`
-	DefaultUDPHolePunching bool      `json:"defaultudphp" db:"default_udp_hole_punching"`
-	DefaultExtClientDNS    string    `json:"defaultextclientdns" db:"default_ext_client_dns"`
-	DefaultMTU             int       `json:"defaultmtu" db:"default_mtu"`
-	DefaultKeepalive       int       `json:"defaultkeepalive" db:"default_keepalive"`
-	DefaultInterface       string    `json:"defaultinterface" db:"default_interface"`
-	NodeLimit              int       `json:"nodelimit" db:"node_limit"`
-	IsCurrent              bool      `json:"is_current" db:"is_current"`
-	LastModified           time.Time `json:"lastmodified" db:"last_modified"`
-	CreatedAt              time.Time `json:"created_at" db:"created_at"`
-	Data                   JSONB     `json:"data" db:"data"`
+	ID                     string     `json:"id" db:"id"`
+	Version                int        `json:"version" db:"version"`
+	Name                   string     `json:"name" db:"name"`
+	AddressRange           string     `json:"addressrange" db:"address_range"`
+	AddressRange6          string     `json:"addressrange6" db:"address_range6"`
+	LocalRange             string     `json:"localrange" db:"local_range"`
+	IsDualStack            bool       `json:"isdualstack" db:"is_dual_stack"`
+	IsIPv4                 bool       `json:"isipv4" db:"is_ipv4"`
+	IsIPv6                 bool       `json:"isipv6" db:"is_ipv6"`
+	IsLocal                bool       `json:"islocal" db:"is_local"`
+	DefaultAccessControl   string     `json:"defaultacl" db:"default_access_control"`
+	DefaultUDPHolePunching bool       `json:"defaultudphp" db:"default_udp_hole_punching"`
+	DefaultExtClientDNS    string     `json:"defaultextclientdns" db:"default_ext_client_dns"`
+	DefaultMTU             int        `json:"defaultmtu" db:"default_mtu"`
+	DefaultKeepalive       int        `json:"defaultkeepalive" db:"default_keepalive"`
+	DefaultInterface       string     `json:"defaultinterface" db:"default_interface"`
+	NodeLimit              int        `json:"nodelimit" db:"node_limit"`
+	IsCurrent              bool       `json:"is_current" db:"is_current"`
+	IsDeleted              bool       `json:"is_deleted" db:"is_deleted"`
+	DeletedAt              *time.Time `json:"deleted_at,omitempty" db:"deleted_at"`
+	LastModified           time.Time  `json:"lastmodified" db:"last_modified"`
+	CreatedAt              time.Time  `json:"created_at" db:"created_at"`
+	Data                   JSONB      `json:"data" db:"data"`
+	ObservedData           JSONB      `json:"observed_data,omitempty" db:"observed_data"`
+	ObservedAt             *time.Time `json:"observed_at,omitempty" db:"observed_at"`
 }
 
 // Node represents a Netmaker node
 type Node struct {
-	ID               string    `json:"id" db:"id"`
-	Version          int       `json:"version" db:"version"`
-	NetworkID        string    `json:"network" db:"network_id"`
-	Name             string    `json:"name" db:"name"`
-	Address          string    `json:"address" db:"address"`
-	Address6         string    `json:"address6" db:"address6"`
-	PublicKey        string    `json:"publickey" db:"public_key"`
-	Endpoint         string    `json:"endpoint" db:"endpoint"`
-	IsEgressGateway  bool      `json:"isegressgateway" db:"is_egress_gateway"`
-	IsIngressGateway bool      `json:"isingressgateway" db:"is_ingress_gateway"`
-	IsRelay          bool      `json:"isrelay" db:"is_relay"`
-	Connected        bool      `json:"connected" db:"connected"`
-	IsCurrent        bool      `json:"is_current" db:"is_current"`
-	LastModified     time.Time `json:"lastmodified" db:"last_modified"`
-	CreatedAt        time.Time `json:"created_at" db:"created_at"`
-	Data             JSONB     `json:"data" db:"data"`
+	ID               string     `json:"id" db:"id"`
+	Version          int        `json:"version" db:"version"`
+	NetworkID        string     `json:"network" db:"network_id"`
+	Name             string     `json:"name" db:"name"`
+	Address          string     `json:"address" db:"address"`
+	Address6         string     `json:"address6" db:"address6"`
+	PublicKey        string     `json:"publickey" db:"public_key"`
+	Endpoint         string     `json:"endpoint" db:"endpoint"`
+	IsEgressGateway  bool       `json:"isegressgateway" db:"is_egress_gateway"`
+	IsIngressGateway bool       `json:"isingressgateway" db:"is_ingress_gateway"`
+	IsRelay          bool       `json:"isrelay" db:"is_relay"`
+	Connected        bool       `json:"connected" db:"connected"`
+	IsCurrent        bool       `json:"is_current" db:"is_current"`
+	IsDeleted        bool       `json:"is_deleted" db:"is_deleted"`
+	DeletedAt        *time.Time `json:"deleted_at,omitempty" db:"deleted_at"`
+	LastModified     time.Time  `json:"lastmodified" db:"last_modified"`
+	CreatedAt        time.Time  `json:"created_at" db:"created_at"`
+	Data             JSONB      `json:"data" db:"data"`
+	ObservedData     JSONB      `json:"observed_data,omitempty" db:"observed_data"`
+	ObservedAt       *time.Time `json:"observed_at,omitempty" db:"observed_at"`
 }
 
 // ExtClient represents a Netmaker external client
 type ExtClient struct {
-	ID           string    `json:"clientid" db:"id"`
-	Version      int       `json:"version" db:"version"`
-	NetworkID    string    `json:"network" db:"network_id"`
-	Name         string    `json:"name" db:"name"`
-	Address      string    `json:"address" db:"address"`
-	Address6     string    `json:"address6" db:"address6"`
-	PublicKey    string    `json:"publickey" db:"public_key"`
-	Enabled      bool      `json:"enabled" db:"enabled"`
-	IsCurrent    bool      `json:"is_current" db:"is_current"`
-	LastModified time.Time `json:"lastmodified" db:"last_modified"`
-	CreatedAt    time.Time `json:"created_at" db:"created_at"`
-	Data         JSONB     `json:"data" db:"data"`
+	ID           string     `json:"clientid" db:"id"`
+	Version      int        `json:"version" db:"version"`
+	NetworkID    string     `json:"network" db:"network_id"`
+	Name         string     `json:"name" db:"name"`
+	Address      string     `json:"address" db:"address"`
+	Address6     string     `json:"address6" db:"address6"`
+	PublicKey    string     `json:"publickey" db:"public_key"`
+	Enabled      bool       `json:"enabled" db:"enabled"`
+	IsCurrent    bool       `json:"is_current" db:"is_current"`
+	IsDeleted    bool       `json:"is_deleted" db:"is_deleted"`
+	DeletedAt    *time.Time `json:"deleted_at,omitempty" db:"deleted_at"`
+	LastModified time.Time  `json:"lastmodified" db:"last_modified"`
+	CreatedAt    time.Time  `json:"created_at" db:"created_at"`
+	Data         JSONB      `json:"data" db:"data"`
+	ObservedData JSONB      `json:"observed_data,omitempty" db:"observed_data"`
+	ObservedAt   *time.Time `json:"observed_at,omitempty" db:"observed_at"`
 }
 
 // DNSEntry represents a Netmaker DNS entry
 type DNSEntry struct {
-	ID           int       `json:"id" db:"id"`
-	NetworkID    string    `json:"network" db:"network_id"`
-	Name         string    `json:"name" db:"name"`
-	Address      string    `json:"address" db:"address"`
-	Address6     string    `json:"address6" db:"address6"`
-	LastModified time.Time `json:"lastmodified" db:"last_modified"`
-	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+	ID           string     `json:"id" db:"id"`
+	Version      int        `json:"version" db:"version"`
+	NetworkID    string     `json:"network" db:"network_id"`
+	Name         string     `json:"name" db:"name"`
+	Address      string     `json:"address" db:"address"`
+	Address6     string     `json:"address6" db:"address6"`
+	IsCurrent    bool       `json:"is_current" db:"is_current"`
+	IsDeleted    bool       `json:"is_deleted" db:"is_deleted"`
+	DeletedAt    *time.Time `json:"deleted_at,omitempty" db:"deleted_at"`
+	LastModified time.Time  `json:"lastmodified" db:"last_modified"`
+	CreatedAt    time.Time  `json:"created_at" db:"created_at"`
 }
 
 // Host represents a Netmaker host
 type Host struct {
-	ID                  string    `json:"id" db:"id"`
-	Version             int       `json:"version" db:"version"`
-	Name                string    `json:"name" db:"name"`
-	EndpointIP          string    `json:"endpointip" db:"endpoint_ip"`
-	EndpointIPv6        string    `json:"endpointipv6" db:"endpoint_ipv6"`
-	PublicKey           string    `json:"publickey" db:"public_key"`
-	ListenPort          int       `json:"listenport" db:"listen_port"`
-	MTU                 int       `json:"mtu" db:"mtu"`
-	PersistentKeepalive int       `json:"persistentkeepalive" db:"persistent_keepalive"`
-	IsCurrent           bool      `json:"is_current" db:"is_current"`
-	LastModified        time.Time `json:"lastmodified" db:"last_modified"`
-	CreatedAt           time.Time `json:"created_at" db:"created_at"`
-	Data                JSONB     `json:"data" db:"data"`
-}
-
-// ACL represents a Netmaker ACL
+	ID                  string     `json:"id" db:"id"`
+	Version             int        `json:"version" db:"version"`
+	Name                string     `json:"name" db:"name"`
+	EndpointIP          string     `json:"endpointip" db:"endpoint_ip"`
+	EndpointIPv6        string     `json:"endpointipv6" db:"endpoint_ipv6"`
+	PublicKey           string     `json:"publickey" db:"public_key"`
+	ListenPort          int        `json:"listenport" db:"listen_port"`
+	MTU                 int        `json:"mtu" db:"mtu"`
+	PersistentKeepalive int        `json:"persistentkeepalive" db:"persistent_keepalive"`
+	IsCurrent           bool       `json:"is_current" db:"is_current"`
+	IsDeleted           bool       `json:"is_deleted" db:"is_deleted"`
+	DeletedAt           *time.Time `json:"deleted_at,omitempty" db:"deleted_at"`
+	LastModified        time.Time  `json:"lastmodified" db:"last_modified"`
+	CreatedAt           time.Time  `json:"created_at" db:"created_at"`
+	Data                JSONB      `json:"data" db:"data"`
+	ObservedData        JSONB      `json:"observed_data,omitempty" db:"observed_data"`
+	ObservedAt          *time.Time `json:"observed_at,omitempty" db:"observed_at"`
+}
+
+// EnrollmentKey represents a Netmaker enrollment key. Its ID is derived from the key
+// value, which is stored only as a hash together with the token.
+type EnrollmentKey struct {
+	ID            string     `json:"id" db:"id"`
+	Version       int        `json:"version" db:"version"`
+	Networks      StringList `json:"networks" db:"networks"`
+	Tags          StringList `json:"tags" db:"tags"`
+	Type          string     `json:"type" db:"type"`
+	UsesRemaining int        `json:"uses_remaining" db:"uses_remaining"`
+	Unlimited     bool       `json:"unlimited" db:"unlimited"`
+	Expiration    *time.Time `json:"expiration,omitempty" db:"expiration"`
+	IsCurrent     bool       `json:"is_current" db:"is_current"`
+	IsDeleted     bool       `json:"is_deleted" db:"is_deleted"`
+	DeletedAt     *time.Time `json:"deleted_at,omitempty" db:"deleted_at"`
+	LastModified  time.Time  `json:"lastmodified" db:"last_modified"`
+	CreatedAt     time.Time  `json:"created_at" db:"created_at"`
+	Data          JSONB      `json:"data" db:"data"`
+	ObservedData  JSONB      `json:"observed_data,omitempty" db:"observed_data"`
+	ObservedAt    *time.Time `json:"observed_at,omitempty" db:"observed_at"`
+}
+
+// EnrollmentKey types, named after the numbers Netmaker uses
+const (
+	EnrollmentKeyTypeUndefined      = "undefined"
+	EnrollmentKeyTypeTimeExpiration = "time_expiration"
+	EnrollmentKeyTypeUses           = "uses"
+	EnrollmentKeyTypeUnlimited      = "unlimited"
+)
+
+// User represents a Netmaker user. Its ID is the username.
+type User struct {
+	ID               string     `json:"id" db:"id"`
+	Version          int        `json:"version" db:"version"`
+	IsAdmin          bool       `json:"isadmin" db:"is_admin"`
+	IsSuperAdmin     bool       `json:"issuperadmin" db:"is_super_admin"`
+	RemoteGatewayIDs StringList `json:"remote_gw_ids" db:"remote_gw_ids"`
+	IsCurrent        bool       `json:"is_current" db:"is_current"`
+	IsDeleted        bool       `json:"is_deleted" db:"is_deleted"`
+	DeletedAt        *time.Time `json:"deleted_at,omitempty" db:"deleted_at"`
+	LastModified     time.Time  `json:"lastmodified" db:"last_modified"`
+	CreatedAt        time.Time  `json:"created_at" db:"created_at"`
+	Data             JSONB      `json:"data" db:"data"`
+	ObservedData     JSONB      `json:"observed_data,omitempty" db:"observed_data"`
+	ObservedAt       *time.Time `json:"observed_at,omitempty" db:"observed_at"`
+}
+
+// UserGatewayAssignment represents a user being allowed to reach a remote access
+// (ingress) gateway node. Its ID is the username and the gateway node ID joined by a
+// colon, and its deleted versions record when the user lost access.
+type UserGatewayAssignment struct {
+	ID           string     `json:"id" db:"id"`
+	Version      int        `json:"version" db:"version"`
+	Username     string     `json:"username" db:"username"`
+	GatewayID    string     `json:"gateway_id" db:"gateway_id"`
+	IsCurrent    bool       `json:"is_current" db:"is_current"`
+	IsDeleted    bool       `json:"is_deleted" db:"is_deleted"`
+	DeletedAt    *time.Time `json:"deleted_at,omitempty" db:"deleted_at"`
+	LastModified time.Time  `json:"lastmodified" db:"last_modified"`
+	CreatedAt    time.Time  `json:"created_at" db:"created_at"`
+	Data         JSONB      `json:"data" db:"data"`
+	ObservedData JSONB      `json:"observed_data,omitempty" db:"observed_data"`
+	ObservedAt   *time.Time `json:"observed_at,omitempty" db:"observed_at"`
+}
+
+// ServerConfigID is the ID of the only server configuration record
+const ServerConfigID = "server"
+
+// ServerConfig represents the configuration of the Netmaker server. License limits are
+// 0 when there is no limit.
+type ServerConfig struct {
+	ID             string     `json:"id" db:"id"`
+	Version        int        `json:"version" db:"version"`
+	ServerVersion  string     `json:"server_version" db:"server_version"`
+	IsEE           bool       `json:"is_ee" db:"is_ee"`
+	DNSMode        string     `json:"dns_mode" db:"dns_mode"`
+	NetworksLimit  int        `json:"networks_limit" db:"networks_limit"`
+	MachinesLimit  int        `json:"machines_limit" db:"machines_limit"`
+	UsersLimit     int        `json:"users_limit" db:"users_limit"`
+	IngressesLimit int        `json:"ingresses_limit" db:"ingresses_limit"`
+	EgressesLimit  int        `json:"egresses_limit" db:"egresses_limit"`
+	IsCurrent      bool       `json:"is_current" db:"is_current"`
+	LastModified   time.Time  `json:"lastmodified" db:"last_modified"`
+	CreatedAt      time.Time  `json:"created_at" db:"created_at"`
+	Data           JSONB      `json:"data" db:"data"`
+	ObservedData   JSONB      `json:"observed_data,omitempty" db:"observed_data"`
+	ObservedAt     *time.Time `json:"observed_at,omitempty" db:"observed_at"`
+}
+
+// ACL represents a Netmaker ACL between a source and a destination node. Its ID is
+// the network ID and the source and destination nodes joined by colons.
 type ACL struct {
-	ID           int       `json:"id" db:"id"`
-	Version      int       `json:"version" db:"version"`
-	NetworkID    string    `json:"network" db:"network_id"`
-	NodeID       string    `json:"nodeid" db:"node_id"`
-	IsCurrent    bool      `json:"is_current" db:"is_current"`
-	Data         JSONB     `json:"data" db:"data"`
-	LastModified time.Time `json:"lastmodified" db:"last_modified"`
-	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+	ID           string     `json:"id" db:"id"`
+	Version      int        `json:"version" db:"version"`
+	NetworkID    string     `json:"network" db:"network_id"`
+	NodeID       string     `json:"nodeid" db:"node_id"`
+	IsCurrent    bool       `json:"is_current" db:"is_current"`
+	IsDeleted    bool       `json:"is_deleted" db:"is_deleted"`
+	DeletedAt    *time.Time `json:"deleted_at,omitempty" db:"deleted_at"`
+	Data         JSONB      `json:"data" db:"data"`
+	ObservedData JSONB      `json:"observed_data,omitempty" db:"observed_data"`
+	ObservedAt   *time.Time `json:"observed_at,omitempty" db:"observed_at"`
+	LastModified time.Time  `json:"lastmodified" db:"last_modified"`
+	CreatedAt    time.Time  `json:"created_at" db:"created_at"`
+}
+
+// NetworkState represents the full state of a network at a point in time
+type NetworkState struct {
+	AsOf       time.Time   `json:"as_of"`
+	Network    Network     `json:"network"`
+	Nodes      []Node      `json:"nodes"`
+	ExtClients []ExtClient `json:"ext_clients"`
+	DNSEntries []DNSEntry  `json:"dns_entries"`
+	ACLs       []ACL       `json:"acls"`
+	Hosts      []Host      `json:"hosts"`
+}
+
+// Page is one page of a list of records. NextCursor is empty on the last page.
+type Page struct {
+	Items      interface{} `json:"items"`
+	NextCursor string      `json:"next_cursor,omitempty"`
 }
 
 // SyncHistory represents a record of a sync operation
 type SyncHistory struct {
-	ID          int        `json:"id" db:"id"`
+	ID           int        `json:"id" db:"id"`
+	RunID        *int       `json:"run_id" db:"run_id"`
 	ResourceType string     `json:"resource_type" db:"resource_type"`
-	Status      string     `json:"status" db:"status"`
-	Message     string     `json:"message" db:"message"`
-	StartedAt   time.Time  `json:"started_at" db:"started_at"`
-	CompletedAt *time.Time `json:"completed_at" db:"completed_at"`
+	Status       string     `json:"status" db:"status"`
+	Message      string     `json:"message" db:"message"`
+	StartedAt    time.Time  `json:"started_at" db:"started_at"`
+	CompletedAt  *time.Time `json:"completed_at" db:"completed_at"`
+}
+
+// SyncRun represents one sync run: a full sync, or a single resource sync triggered
+// through the API. Every SyncHistory row written by the run refers to it.
+type SyncRun struct {
+	ID          int           `json:"id" db:"id"`
+	Scope       string        `json:"scope" db:"scope"`
+	NetworkID   *string       `json:"network_id" db:"network_id"`
+	Status      string        `json:"status" db:"status"`
+	Message     string        `json:"message" db:"message"`
+	Counts      SyncRunCounts `json:"counts" db:"counts"`
+	StartedAt   time.Time     `json:"started_at" db:"started_at"`
+	CompletedAt *time.Time    `json:"completed_at" db:"completed_at"`
+	History     []SyncHistory `json:"history,omitempty" db:"-"`
+	Errors      []SyncError   `json:"errors,omitempty" db:"-"`
+}
+
+// SyncError records the failure of one record, or of a whole resource sync when
+// ResourceID is empty, during a sync run
+type SyncError struct {
+	ID            int       `json:"id" db:"id"`
+	RunID         int       `json:"run_id" db:"run_id"`
+	SyncHistoryID *int      `json:"sync_history_id" db:"sync_history_id"`
+	ResourceType  string    `json:"resource_type" db:"resource_type"`
+	NetworkID     *string   `json:"network_id" db:"network_id"`
+	ResourceID    *string   `json:"resource_id" db:"resource_id"`
+	Operation     string    `json:"operation" db:"operation"`
+	Error         string    `json:"error" db:"error"`
+	OccurredAt    time.Time `json:"occurred_at" db:"occurred_at"`
+}
+
+// SyncOperation constants, the operations a SyncError can record
+const (
+	SyncOperationFetch  = "fetch"
+	SyncOperationUpsert = "upsert"
+	SyncOperationDelete = "delete"
+)
+
+// ReadinessCheck is the outcome of checking one dependency of the service
+type ReadinessCheck struct {
+	Name    string `json:"name"`
+	Healthy bool   `json:"healthy"`
+	Message string `json:"message,omitempty"`
+}
+
+// Readiness tells whether the service can serve traffic, which requires every check
+// to be healthy
+type Readiness struct {
+	Ready  bool             `json:"ready"`
+	Checks []ReadinessCheck `json:"checks"`
+}
+
+// ResourceSyncStatus summarizes the syncs of one resource type
+type ResourceSyncStatus struct {
+	ResourceType     string      `json:"resource_type"`
+	LastSync         SyncHistory `json:"last_sync"`
+	LastSuccessfulAt *time.Time  `json:"last_successful_at"`
+}
+
+// SyncStatusSummary summarizes the state of the mirror: the last full sync run and
+// the last sync of each resource type
+type SyncStatusSummary struct {
+	LastSuccessfulRun *SyncRun             `json:"last_successful_run"`
+	Resources         []ResourceSyncStatus `json:"resources"`
+}
+
+// ResourceCounts counts what a sync run did to the records of one resource type
+type ResourceCounts struct {
+	Created   int `json:"created"`
+	Updated   int `json:"updated"`
+	Unchanged int `json:"unchanged"`
+	Deleted   int `json:"deleted"`
+	Failed    int `json:"failed"`
+}
+
+// Add adds other to the counts
+func (c *ResourceCounts) Add(other ResourceCounts) {
+	c.Created += other.Created
+	c.Updated += other.Updated
+	c.Unchanged += other.Unchanged
+	c.Deleted += other.Deleted
+	c.Failed += other.Failed
+}
+
+// SyncRunCounts maps resource types to the counts of a sync run, stored as JSONB
+type SyncRunCounts map[string]ResourceCounts
+
+// Value implements the driver.Valuer interface
+func (c SyncRunCounts) Value() (driver.Value, error) {
+	if c == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(c)
+}
+
+// Scan implements the sql.Scanner interface
+func (c *SyncRunCounts) Scan(value interface{}) error {
+	if value == nil {
+		*c = nil
+		return nil
+	}
+
+	bytes, err := jsonBytes(value)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(bytes, c)
+}
+
+// SyncRun scope constants. A run syncing a single resource type uses its ResourceType.
+const SyncScopeAll = "all"
+
+// SyncJob is a sync requested through the HTTP API that runs in the background.
+// Counts are those of its run so far.
+type SyncJob struct {
+	ID          string        `json:"id"`
+	Scope       string        `json:"scope"`
+	NetworkID   *string       `json:"network_id"`
+	Status      string        `json:"status"`
+	Message     string        `json:"message,omitempty"`
+	RunID       *int          `json:"run_id"`
+	Counts      SyncRunCounts `json:"counts"`
+	CreatedAt   time.Time     `json:"created_at"`
+	StartedAt   *time.Time    `json:"started_at"`
+	CompletedAt *time.Time    `json:"completed_at"`
+}
+
+// SyncCursor records the Netmaker change marker of a network at its last successful sync
+type SyncCursor struct {
+	NetworkID         string    `json:"network_id" db:"network_id"`
+	NodesLastModified int64     `json:"nodes_last_modified" db:"nodes_last_modified"`
+	SyncedAt          time.Time `json:"synced_at" db:"synced_at"`
+}
+
+// NodeHeartbeat is the check-in state of a node observed by a sync
+type NodeHeartbeat struct {
+	ID             int64      `json:"id" db:"id"`
+	NodeID         string     `json:"node_id" db:"node_id"`
+	NetworkID      string     `json:"network_id" db:"network_id"`
+	Connected      bool       `json:"connected" db:"connected"`
+	LastCheckIn    *time.Time `json:"last_check_in" db:"last_check_in"`
+	LastPeerUpdate *time.Time `json:"last_peer_update" db:"last_peer_update"`
+	ObservedAt     time.Time  `json:"observed_at" db:"observed_at"`
+}
+
+// StaleNode is a current node whose last check-in is older than a threshold, or that
+// never checked in
+type StaleNode struct {
+	NodeHeartbeat
+	SilentSeconds *int64 `json:"silent_seconds"`
+}
+
+// NodeUptime is the share of the heartbeats of a node observed on one UTC day in which
+// it was connected and had checked in recently
+type NodeUptime struct {
+	Date          string  `json:"date"`
+	Heartbeats    int     `json:"heartbeats"`
+	UptimePercent float64 `json:"uptime_percent"`
 }
 
 // SyncStatus constants
 const (
 	SyncStatusPending   = "pending"
+	SyncStatusRunning   = "running"
 	SyncStatusCompleted = "completed"
+	SyncStatusPartial   = "partial"
 	SyncStatusFailed    = "failed"
+	SyncStatusCancelled = "cancelled"
 )
 
 // ResourceType constants
 const (
-	ResourceTypeNetwork   = "network"
-	ResourceTypeNode      = "node"
-	ResourceTypeExtClient = "ext_client"
-	ResourceTypeDNS       = "dns"
-	ResourceTypeHost      = "host"
-	ResourceTypeACL       = "acl"
+	ResourceTypeNetwork               = "network"
+	ResourceTypeNode                  = "node"
+	ResourceTypeExtClient             = "ext_client"
+	ResourceTypeDNS                   = "dns"
+	ResourceTypeHost                  = "host"
+	ResourceTypeACL                   = "acl"
+	ResourceTypeEnrollmentKey         = "enrollment_key"
+	ResourceTypeUser                  = "user"
+	ResourceTypeUserGatewayAssignment = "user_gateway_assignment"
+	ResourceTypeServerConfig          = "server_config"
+)
+
+// ChangeEvent represents a new version of a resource being written to the database
+type ChangeEvent struct {
+	ResourceType  string        `json:"resource_type"`
+	ID            string        `json:"id"`
+	NetworkID     string        `json:"network_id,omitempty"`
+	Kind          string        `json:"kind"`
+	OldVersion    int           `json:"old_version"`
+	NewVersion    int           `json:"new_version"`
+	ChangedFields []string      `json:"changed_fields"`
+	Changes       []diff.Change `json:"changes,omitempty"`
+	Timestamp     time.Time     `json:"timestamp"`
+}
+
+// ChangeNotification is the compact payload sent with pg_notify for every new version
+type ChangeNotification struct {
+	Table   string `json:"table"`
+	ID      string `json:"id"`
+	Version int    `json:"version"`
+	Kind    string `json:"kind"`
+}
+
+// ChangeKind constants
+const (
+	ChangeKindCreated = "created"
+	ChangeKindUpdated = "updated"
+	ChangeKindDeleted = "deleted"
+)
+
+// WebhookSubscription represents a subscriber that receives change events and sync failures
+type WebhookSubscription struct {
+	ID            int        `json:"id" db:"id"`
+	Name          string     `json:"name" db:"name"`
+	URL           string     `json:"url" db:"url"`
+	Secret        string     `json:"secret,omitempty" db:"secret"`
+	EventTypes    StringList `json:"event_types" db:"event_types"`
+	ResourceTypes StringList `json:"resource_types" db:"resource_types"`
+	NetworkIDs    StringList `json:"network_ids" db:"network_ids"`
+	ChangedFields StringList `json:"changed_fields" db:"changed_fields"`
+	Enabled       bool       `json:"enabled" db:"enabled"`
+	CreatedAt     time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+// WebhookDelivery represents an attempt, or series of attempts, to deliver an event to a subscriber
+type WebhookDelivery struct {
+	ID             int        `json:"id" db:"id"`
+	SubscriptionID int        `json:"subscription_id" db:"subscription_id"`
+	EventType      string     `json:"event_type" db:"event_type"`
+	Payload        JSONB      `json:"payload" db:"payload"`
+	Status         string     `json:"status" db:"status"`
+	Attempts       int        `json:"attempts" db:"attempts"`
+	LastStatusCode *int       `json:"last_status_code" db:"last_status_code"`
+	LastError      *string    `json:"last_error" db:"last_error"`
+	NextAttemptAt  time.Time  `json:"next_attempt_at" db:"next_attempt_at"`
+	CreatedAt      time.Time  `json:"created_at" db:"created_at"`
+	DeliveredAt    *time.Time `json:"delivered_at" db:"delivered_at"`
+}
+
+// WebhookEventSyncFailed is the webhook event type for a failed sync. The other
+// webhook event types are the ChangeKind constants.
+const WebhookEventSyncFailed = "sync_failed"
+
+// DeliveryStatus constants
+const (
+	DeliveryStatusPending   = "pending"
+	DeliveryStatusDelivered = "delivered"
+	DeliveryStatusFailed    = "failed"
 )
+
+// AuditEntry records a request to the HTTP API that changed something, or was denied,
+// and who made it
+type AuditEntry struct {
+	ID         int       `json:"id" db:"id"`
+	Principal  string    `json:"principal" db:"principal"`
+	AuthMethod string    `json:"auth_method" db:"auth_method"`
+	Role       string    `json:"role" db:"role"`
+	Method     string    `json:"method" db:"method"`
+	Path       string    `json:"path" db:"path"`
+	StatusCode int       `json:"status_code" db:"status_code"`
+	RemoteAddr string    `json:"remote_addr" db:"remote_addr"`
+	OccurredAt time.Time `json:"occurred_at" db:"occurred_at"`
+}
+
+// TopologyStats counts the current records of the mirrored topology
+type TopologyStats struct {
+	Nodes          []NodeCount
+	ExtClients     []ExtClientCount
+	Hosts          []HostCount
+	EnrollmentKeys []EnrollmentKey
+	ServerConfig   *ServerConfig
+	LicenseUsage   LicenseUsage
+}
+
+// LicenseUsage counts the current records that the license limits of the Netmaker
+// server apply to. Machines are the hosts and the external clients.
+type LicenseUsage struct {
+	Networks  int `db:"networks"`
+	Machines  int `db:"machines"`
+	Users     int `db:"users"`
+	Ingresses int `db:"ingresses"`
+	Egresses  int `db:"egresses"`
+}
+
+// NodeCount is the number of current nodes of a network that are, or are not, connected
+type NodeCount struct {
+	NetworkID string `db:"network_id"`
+	Connected bool   `db:"connected"`
+	Count     int    `db:"count"`
+}
+
+// ExtClientCount is the number of current external clients of a network that are, or
+// are not, enabled
+type ExtClientCount struct {
+	NetworkID string `db:"network_id"`
+	Enabled   bool   `db:"enabled"`
+	Count     int    `db:"count"`
+}
+
+// HostCount is the number of current hosts running an operating system
+type HostCount struct {
+	OS    string `db:"os"`
+	Count int    `db:"count"`
+}
@@ -0,0 +1,447 @@
+package secrets
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"netmaker-sync/internal/config"
+	"netmaker-sync/internal/models"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Actions of a field policy
+const (
+	// ActionKeep stores the field as it is
+	ActionKeep = "keep"
+	// ActionDrop removes the field
+	ActionDrop = "drop"
+	// ActionHash replaces the field with a keyed hash, so that it can be compared but not read
+	ActionHash = "hash"
+	// ActionEncrypt replaces the field with an envelope encrypted under the active key
+	ActionEncrypt = "encrypt"
+)
+
+// DefaultPolicies protect the sensitive fields of the synced resources. secrets.policies
+// entries for the same resource type and path override them.
+var DefaultPolicies = []config.FieldPolicyConfig{
+	{ResourceType: models.ResourceTypeExtClient, Path: "privatekey", Action: ActionEncrypt},
+	{ResourceType: models.ResourceTypeEnrollmentKey, Path: "value", Action: ActionHash},
+	{ResourceType: models.ResourceTypeEnrollmentKey, Path: "token", Action: ActionHash},
+	{ResourceType: models.ResourceTypeUser, Path: "password", Action: ActionDrop},
+	{ResourceType: models.ResourceTypeServerConfig, Path: "MasterKey", Action: ActionDrop},
+	{ResourceType: models.ResourceTypeServerConfig, Path: "MQPassword", Action: ActionDrop},
+	{ResourceType: models.ResourceTypeServerConfig, Path: "TurnPassword", Action: ActionDrop},
+	{ResourceType: models.ResourceTypeServerConfig, Path: "ClientSecret", Action: ActionDrop},
+	{ResourceType: models.ResourceTypeServerConfig, Path: "DNSKey", Action: ActionDrop},
+	{ResourceType: models.ResourceTypeServerConfig, Path: "LicenseValue", Action: ActionDrop},
+	{ResourceType: models.ResourceTypeServerConfig, Path: "SQLConn", Action: ActionDrop},
+}
+
+// envelopeMarker is the key that identifies an encrypted value in the stored data
+const envelopeMarker = "$enc"
+
+// envelopeVersion is the format of the envelopes written: AES-256-GCM with a random data
+// key per value, wrapped with AES-256-GCM under the key named by kid
+const envelopeVersion = "v1"
+
+// Prefixes of the hashes written, with and without a hash key
+const (
+	hashPrefix = "sha256:"
+	hmacPrefix = "hmac-sha256:"
+)
+
+// ErrUnknownKey is returned when a value is encrypted under a key that is not configured
+var ErrUnknownKey = errors.New("unknown encryption key")
+
+// Engine applies the field policies to the data of the records before they are stored,
+// and decrypts it for privileged readers
+type Engine struct {
+	policies    map[string]map[string]string
+	keys        map[string]cipher.AEAD
+	activeKeyID string
+	hashKey     []byte
+}
+
+// New creates an engine from the secrets configuration. Encrypted fields are dropped
+// when no encryption key is configured.
+func New(cfg *config.SecretsConfig) (*Engine, error) {
+	e := &Engine{
+		policies: make(map[string]map[string]string),
+		keys:     make(map[string]cipher.AEAD),
+		hashKey:  []byte(cfg.HashKey),
+	}
+
+	for _, key := range cfg.EncryptionKeys {
+		if _, ok := e.keys[key.ID]; ok {
+			return nil, fmt.Errorf("duplicate encryption key %s", key.ID)
+		}
+		aead, err := newAEAD(key.Key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption key %s: %w", key.ID, err)
+		}
+		e.keys[key.ID] = aead
+		if e.activeKeyID == "" {
+			e.activeKeyID = key.ID
+		}
+	}
+
+	for _, policy := range append(append([]config.FieldPolicyConfig{}, DefaultPolicies...), cfg.Policies...) {
+		switch policy.Action {
+		case ActionKeep, ActionDrop, ActionHash, ActionEncrypt:
+		default:
+			return nil, fmt.Errorf("invalid action %q for %s %s, expected keep, drop, hash or encrypt", policy.Action, policy.ResourceType, policy.Path)
+		}
+
+		action := policy.Action
+		if action == ActionEncrypt && e.activeKeyID == "" {
+			logrus.Warnf("No encryption key configured, dropping %s %s instead of encrypting it", policy.ResourceType, policy.Path)
+			action = ActionDrop
+		}
+		if e.policies[policy.ResourceType] == nil {
+			e.policies[policy.ResourceType] = make(map[string]string)
+		}
+		e.policies[policy.ResourceType][policy.Path] = action
+	}
+	return e, nil
+}
+
+// newAEAD creates an AES-256-GCM cipher from a base64-encoded key
+func newAEAD(encoded string) (cipher.AEAD, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode key: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("key is %d bytes, expected 32", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// NeedsCurrent reports whether Protect needs the stored data of a record of the given
+// type. Encrypted fields are only re-encrypted when their value changes, so that the
+// random nonces do not create a new version on every sync.
+func (e *Engine) NeedsCurrent(resourceType string) bool {
+	for _, action := range e.policies[resourceType] {
+		if action == ActionEncrypt {
+			return true
+		}
+	}
+	return false
+}
+
+// Protect applies the policies of a resource type to data in place. current is the
+// stored data of the record, or nil if it has none.
+func (e *Engine) Protect(resourceType string, data, current models.JSONB) error {
+	for path, action := range e.policies[resourceType] {
+		parent, key, ok := lookup(data, path)
+		if !ok {
+			continue
+		}
+		value := parent[key]
+		// Values read back from the database, e.g. when a deleted version is recorded, are
+		// already protected
+		if value == nil || value == "" || isEnvelope(value) || isHash(value) {
+			continue
+		}
+
+		switch action {
+		case ActionDrop:
+			delete(parent, key)
+		case ActionHash:
+			hashed, err := e.hash(value)
+			if err != nil {
+				return fmt.Errorf("failed to hash %s %s: %w", resourceType, path, err)
+			}
+			parent[key] = hashed
+		case ActionEncrypt:
+			plaintext, err := json.Marshal(value)
+			if err != nil {
+				return fmt.Errorf("failed to encode %s %s: %w", resourceType, path, err)
+			}
+
+			// Keep the stored envelope if it still holds the same value
+			if storedParent, storedKey, ok := lookup(current, path); ok && isEnvelope(storedParent[storedKey]) {
+				stored, err := e.decrypt(path, storedParent[storedKey].(map[string]interface{}))
+				if err == nil && bytes.Equal(stored, plaintext) {
+					parent[key] = storedParent[storedKey]
+					continue
+				}
+			}
+
+			envelope, err := e.encrypt(path, plaintext)
+			if err != nil {
+				return fmt.Errorf("failed to encrypt %s %s: %w", resourceType, path, err)
+			}
+			parent[key] = envelope
+		}
+	}
+	return nil
+}
+
+// Reveal returns a copy of data with the encrypted values decrypted
+func (e *Engine) Reveal(data models.JSONB) (models.JSONB, error) {
+	if data == nil {
+		return nil, nil
+	}
+
+	// Copy through JSON so that the stored record is left untouched
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy data: %w", err)
+	}
+	var revealed models.JSONB
+	if err := json.Unmarshal(encoded, &revealed); err != nil {
+		return nil, fmt.Errorf("failed to copy data: %w", err)
+	}
+
+	err = walkEnvelopes(revealed, "", func(parent map[string]interface{}, key, path string) error {
+		plaintext, err := e.decrypt(path, parent[key].(map[string]interface{}))
+		if err != nil {
+			return fmt.Errorf("failed to decrypt %s: %w", path, err)
+		}
+		var value interface{}
+		if err := json.Unmarshal(plaintext, &value); err != nil {
+			return fmt.Errorf("failed to decode %s: %w", path, err)
+		}
+		parent[key] = value
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return revealed, nil
+}
+
+// Rewrap re-encrypts in place the data keys of the envelopes in data that are not
+// wrapped under the active key, and reports whether any were. The values themselves
+// are not re-encrypted.
+func (e *Engine) Rewrap(data models.JSONB) (bool, error) {
+	if e.activeKeyID == "" {
+		return false, fmt.Errorf("no encryption key configured")
+	}
+
+	changed := false
+	err := walkEnvelopes(data, "", func(parent map[string]interface{}, key, path string) error {
+		envelope := parent[key].(map[string]interface{})
+		keyID, _ := envelope["kid"].(string)
+		if keyID == e.activeKeyID {
+			return nil
+		}
+
+		dataKey, err := e.unwrapKey(envelope)
+		if err != nil {
+			return fmt.Errorf("failed to unwrap the data key of %s: %w", path, err)
+		}
+		wrapped, err := e.wrapKey(dataKey)
+		if err != nil {
+			return fmt.Errorf("failed to wrap the data key of %s: %w", path, err)
+		}
+		envelope["kid"] = e.activeKeyID
+		envelope["dek"] = wrapped
+		changed = true
+		return nil
+	})
+	return changed, err
+}
+
+// hash returns the HMAC-SHA256 of a value under the hash key, or its SHA-256 when no
+// hash key is configured. Strings are hashed as they are, other values as JSON.
+func (e *Engine) hash(value interface{}) (string, error) {
+	input, ok := value.(string)
+	if !ok {
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return "", err
+		}
+		input = string(encoded)
+	}
+
+	if len(e.hashKey) == 0 {
+		sum := sha256.Sum256([]byte(input))
+		return hashPrefix + hex.EncodeToString(sum[:]), nil
+	}
+	mac := hmac.New(sha256.New, e.hashKey)
+	mac.Write([]byte(input))
+	return hmacPrefix + hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// isHash reports whether a stored value is a hash written by the engine
+func isHash(value interface{}) bool {
+	s, ok := value.(string)
+	if !ok {
+		return false
+	}
+	for _, prefix := range []string{hashPrefix, hmacPrefix} {
+		if digest, ok := strings.CutPrefix(s, prefix); ok && len(digest) == 2*sha256.Size {
+			_, err := hex.DecodeString(digest)
+			return err == nil
+		}
+	}
+	return false
+}
+
+// encrypt seals a JSON value under a new data key. The path is authenticated so that an
+// envelope cannot be moved to another field.
+func (e *Engine) encrypt(path string, plaintext []byte) (map[string]interface{}, error) {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, fmt.Errorf("failed to generate data key: %w", err)
+	}
+	aead, err := newDataCipher(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	wrapped, err := e.wrapKey(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		envelopeMarker: envelopeVersion,
+		"kid":          e.activeKeyID,
+		"dek":          wrapped,
+		"nonce":        base64.StdEncoding.EncodeToString(nonce),
+		"ct":           base64.StdEncoding.EncodeToString(aead.Seal(nil, nonce, plaintext, []byte(path))),
+	}, nil
+}
+
+// decrypt opens an envelope found at path
+func (e *Engine) decrypt(path string, envelope map[string]interface{}) ([]byte, error) {
+	if version, _ := envelope[envelopeMarker].(string); version != envelopeVersion {
+		return nil, fmt.Errorf("unsupported envelope version %q", version)
+	}
+	dataKey, err := e.unwrapKey(envelope)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newDataCipher(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := decodeField(envelope, "nonce")
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := decodeField(envelope, "ct")
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("invalid nonce")
+	}
+	return aead.Open(nil, nonce, ciphertext, []byte(path))
+}
+
+// wrapKey encrypts a data key under the active key, as base64 of the nonce and ciphertext
+func (e *Engine) wrapKey(dataKey []byte) (string, error) {
+	aead := e.keys[e.activeKeyID]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	sealed := aead.Seal(nonce, nonce, dataKey, []byte(e.activeKeyID))
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// unwrapKey decrypts the data key of an envelope with the key it names
+func (e *Engine) unwrapKey(envelope map[string]interface{}) ([]byte, error) {
+	keyID, _ := envelope["kid"].(string)
+	aead, ok := e.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, keyID)
+	}
+	sealed, err := decodeField(envelope, "dek")
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("invalid data key")
+	}
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(keyID))
+}
+
+// newDataCipher creates the AES-256-GCM cipher of a data key
+func newDataCipher(dataKey []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// decodeField decodes a base64 field of an envelope
+func decodeField(envelope map[string]interface{}, name string) ([]byte, error) {
+	value, _ := envelope[name].(string)
+	decoded, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return decoded, nil
+}
+
+// isEnvelope reports whether a stored value is an encrypted envelope
+func isEnvelope(value interface{}) bool {
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	_, ok = object[envelopeMarker]
+	return ok
+}
+
+// lookup finds the object holding a dotted path, e.g. wireguard.privatekey, and the key
+// of the path in it
+func lookup(data map[string]interface{}, path string) (map[string]interface{}, string, bool) {
+	keys := strings.Split(path, ".")
+	parent := data
+	for _, key := range keys[:len(keys)-1] {
+		child, ok := parent[key].(map[string]interface{})
+		if !ok {
+			return nil, "", false
+		}
+		parent = child
+	}
+	key := keys[len(keys)-1]
+	if _, ok := parent[key]; !ok {
+		return nil, "", false
+	}
+	return parent, key, true
+}
+
+// walkEnvelopes calls fn for every envelope in the objects nested in data, with the
+// dotted path of the envelope
+func walkEnvelopes(data map[string]interface{}, prefix string, fn func(parent map[string]interface{}, key, path string) error) error {
+	for key, value := range data {
+		path := prefix + key
+		if isEnvelope(value) {
+			if err := fn(data, key, path); err != nil {
+				return err
+			}
+			continue
+		}
+		if child, ok := value.(map[string]interface{}); ok {
+			if err := walkEnvelopes(child, path+".", fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,311 @@
+package secrets
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"netmaker-sync/internal/config"
+	"netmaker-sync/internal/models"
+)
+
+// testKey returns a base64-encoded 256-bit key filled with b
+func testKey(b byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, 32))
+}
+
+// newTestEngine creates an engine, failing the test if the configuration is rejected
+func newTestEngine(t *testing.T, cfg config.SecretsConfig) *Engine {
+	t.Helper()
+
+	engine, err := New(&cfg)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	return engine
+}
+
+// mustJSON encodes a value, failing the test if it cannot be
+func mustJSON(t *testing.T, v interface{}) []byte {
+	t.Helper()
+
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("failed to encode %v: %v", v, err)
+	}
+	return encoded
+}
+
+func TestNewRejectsInvalidConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.SecretsConfig
+	}{
+		{
+			name: "unknown action",
+			cfg:  config.SecretsConfig{Policies: []config.FieldPolicyConfig{{ResourceType: "node", Path: "name", Action: "shred"}}},
+		},
+		{
+			name: "duplicate key",
+			cfg:  config.SecretsConfig{EncryptionKeys: []config.EncryptionKeyConfig{{ID: "a", Key: testKey(1)}, {ID: "a", Key: testKey(2)}}},
+		},
+		{
+			name: "short key",
+			cfg:  config.SecretsConfig{EncryptionKeys: []config.EncryptionKeyConfig{{ID: "a", Key: base64.StdEncoding.EncodeToString([]byte("short"))}}},
+		},
+		{
+			name: "key not in base64",
+			cfg:  config.SecretsConfig{EncryptionKeys: []config.EncryptionKeyConfig{{ID: "a", Key: "not base64!"}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(&tt.cfg); err == nil {
+				t.Error("New() succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestProtect(t *testing.T) {
+	policies := []config.FieldPolicyConfig{
+		{ResourceType: "node", Path: "wireguard.privatekey", Action: ActionHash},
+		{ResourceType: "node", Path: "secret", Action: ActionEncrypt},
+		{ResourceType: models.ResourceTypeUser, Path: "password", Action: ActionKeep},
+	}
+	alreadyHashed := hashPrefix + strings.Repeat("ab", 32)
+
+	tests := []struct {
+		name         string
+		hashKey      string
+		resourceType string
+		data         models.JSONB
+		want         models.JSONB
+	}{
+		{
+			name:         "default drop",
+			resourceType: models.ResourceTypeServerConfig,
+			data:         models.JSONB{"MasterKey": "k", "Version": "v1"},
+			want:         models.JSONB{"Version": "v1"},
+		},
+		{
+			name:         "default overridden by keep",
+			resourceType: models.ResourceTypeUser,
+			data:         models.JSONB{"password": "p"},
+			want:         models.JSONB{"password": "p"},
+		},
+		{
+			name:         "hash without key",
+			resourceType: models.ResourceTypeEnrollmentKey,
+			data:         models.JSONB{"value": "abc"},
+			want:         models.JSONB{"value": "sha256:ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+		},
+		{
+			name:         "hash with key",
+			hashKey:      "key",
+			resourceType: models.ResourceTypeEnrollmentKey,
+			data:         models.JSONB{"value": "The quick brown fox jumps over the lazy dog"},
+			want:         models.JSONB{"value": "hmac-sha256:f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8"},
+		},
+		{
+			name:         "nested path",
+			resourceType: "node",
+			data:         models.JSONB{"wireguard": map[string]interface{}{"privatekey": "abc", "port": 51821.0}},
+			want: models.JSONB{"wireguard": map[string]interface{}{
+				"privatekey": "sha256:ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+				"port":       51821.0,
+			}},
+		},
+		{
+			name:         "encrypt without key drops",
+			resourceType: "node",
+			data:         models.JSONB{"secret": "s", "name": "n1"},
+			want:         models.JSONB{"name": "n1"},
+		},
+		{
+			name:         "already protected and empty values are kept",
+			resourceType: models.ResourceTypeEnrollmentKey,
+			data:         models.JSONB{"value": alreadyHashed, "token": ""},
+			want:         models.JSONB{"value": alreadyHashed, "token": ""},
+		},
+		{
+			name:         "missing fields are ignored",
+			resourceType: "node",
+			data:         models.JSONB{"name": "n1"},
+			want:         models.JSONB{"name": "n1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := newTestEngine(t, config.SecretsConfig{HashKey: tt.hashKey, Policies: policies})
+			if err := engine.Protect(tt.resourceType, tt.data, nil); err != nil {
+				t.Fatalf("Protect() error = %v", err)
+			}
+			if !reflect.DeepEqual(tt.data, tt.want) {
+				t.Errorf("Protect() = %v, want %v", tt.data, tt.want)
+			}
+		})
+	}
+}
+
+func TestProtectEncryptsAndRevealDecrypts(t *testing.T) {
+	engine := newTestEngine(t, config.SecretsConfig{EncryptionKeys: []config.EncryptionKeyConfig{{ID: "k1", Key: testKey(1)}}})
+	if !engine.NeedsCurrent(models.ResourceTypeExtClient) {
+		t.Error("NeedsCurrent() = false for a resource type with encrypted fields")
+	}
+
+	data := models.JSONB{"privatekey": "wg-private", "clientid": "c1"}
+	if err := engine.Protect(models.ResourceTypeExtClient, data, nil); err != nil {
+		t.Fatalf("Protect() error = %v", err)
+	}
+	if !isEnvelope(data["privatekey"]) {
+		t.Fatalf("privatekey = %v, want an envelope", data["privatekey"])
+	}
+	if strings.Contains(string(mustJSON(t, data)), "wg-private") {
+		t.Fatal("the protected data contains the plaintext")
+	}
+
+	revealed, err := engine.Reveal(data)
+	if err != nil {
+		t.Fatalf("Reveal() error = %v", err)
+	}
+	if want := (models.JSONB{"privatekey": "wg-private", "clientid": "c1"}); !reflect.DeepEqual(revealed, want) {
+		t.Errorf("Reveal() = %v, want %v", revealed, want)
+	}
+	if !isEnvelope(data["privatekey"]) {
+		t.Error("Reveal() decrypted the stored data in place")
+	}
+
+	// The same value keeps the stored envelope, so that no new version is recorded
+	unchanged := models.JSONB{"privatekey": "wg-private", "clientid": "c1"}
+	if err := engine.Protect(models.ResourceTypeExtClient, unchanged, data); err != nil {
+		t.Fatalf("Protect() error = %v", err)
+	}
+	if !reflect.DeepEqual(unchanged, data) {
+		t.Errorf("Protect() of an unchanged value = %v, want the stored %v", unchanged, data)
+	}
+
+	// A new value is encrypted again
+	changed := models.JSONB{"privatekey": "wg-rotated", "clientid": "c1"}
+	if err := engine.Protect(models.ResourceTypeExtClient, changed, data); err != nil {
+		t.Fatalf("Protect() error = %v", err)
+	}
+	if reflect.DeepEqual(changed["privatekey"], data["privatekey"]) {
+		t.Error("Protect() kept the stored envelope of a changed value")
+	}
+}
+
+func TestRevealRejectsTamperedEnvelopes(t *testing.T) {
+	engine := newTestEngine(t, config.SecretsConfig{EncryptionKeys: []config.EncryptionKeyConfig{{ID: "k1", Key: testKey(1)}}})
+
+	tests := []struct {
+		name    string
+		tamper  func(data models.JSONB)
+		wantErr error
+	}{
+		{
+			name: "moved to another field",
+			tamper: func(data models.JSONB) {
+				data["address"] = data["privatekey"]
+				delete(data, "privatekey")
+			},
+		},
+		{
+			name: "altered ciphertext",
+			tamper: func(data models.JSONB) {
+				envelope := data["privatekey"].(map[string]interface{})
+				ciphertext, _ := base64.StdEncoding.DecodeString(envelope["ct"].(string))
+				ciphertext[0] ^= 0xff
+				envelope["ct"] = base64.StdEncoding.EncodeToString(ciphertext)
+			},
+		},
+		{
+			name: "unknown key",
+			tamper: func(data models.JSONB) {
+				data["privatekey"].(map[string]interface{})["kid"] = "k9"
+			},
+			wantErr: ErrUnknownKey,
+		},
+		{
+			name: "unsupported version",
+			tamper: func(data models.JSONB) {
+				data["privatekey"].(map[string]interface{})[envelopeMarker] = "v0"
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := models.JSONB{"privatekey": "wg-private"}
+			if err := engine.Protect(models.ResourceTypeExtClient, data, nil); err != nil {
+				t.Fatalf("Protect() error = %v", err)
+			}
+			tt.tamper(data)
+
+			_, err := engine.Reveal(data)
+			if err == nil {
+				t.Fatal("Reveal() succeeded, want an error")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("Reveal() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRewrapMovesEnvelopesToTheActiveKey(t *testing.T) {
+	oldKey := config.EncryptionKeyConfig{ID: "old", Key: testKey(1)}
+	newKey := config.EncryptionKeyConfig{ID: "new", Key: testKey(2)}
+
+	before := newTestEngine(t, config.SecretsConfig{EncryptionKeys: []config.EncryptionKeyConfig{oldKey}})
+	data := models.JSONB{"privatekey": "wg-private"}
+	if err := before.Protect(models.ResourceTypeExtClient, data, nil); err != nil {
+		t.Fatalf("Protect() error = %v", err)
+	}
+	ciphertext := data["privatekey"].(map[string]interface{})["ct"]
+
+	// The first configured key is the active one
+	rotated := newTestEngine(t, config.SecretsConfig{EncryptionKeys: []config.EncryptionKeyConfig{newKey, oldKey}})
+	changed, err := rotated.Rewrap(data)
+	if err != nil {
+		t.Fatalf("Rewrap() error = %v", err)
+	}
+	if !changed {
+		t.Fatal("Rewrap() = false, want true for an envelope under the old key")
+	}
+	envelope := data["privatekey"].(map[string]interface{})
+	if envelope["kid"] != "new" {
+		t.Errorf("kid = %v, want new", envelope["kid"])
+	}
+	if envelope["ct"] != ciphertext {
+		t.Error("Rewrap() re-encrypted the value, want only the data key rewrapped")
+	}
+
+	changed, err = rotated.Rewrap(data)
+	if err != nil || changed {
+		t.Errorf("second Rewrap() = %v, %v, want false, nil", changed, err)
+	}
+
+	// The old key is no longer needed to read the value
+	after := newTestEngine(t, config.SecretsConfig{EncryptionKeys: []config.EncryptionKeyConfig{newKey}})
+	revealed, err := after.Reveal(data)
+	if err != nil {
+		t.Fatalf("Reveal() error = %v", err)
+	}
+	if revealed["privatekey"] != "wg-private" {
+		t.Errorf("privatekey = %v, want wg-private", revealed["privatekey"])
+	}
+}
+
+func TestRewrapRequiresAnEncryptionKey(t *testing.T) {
+	engine := newTestEngine(t, config.SecretsConfig{})
+	if _, err := engine.Rewrap(models.JSONB{}); err == nil {
+		t.Error("Rewrap() without a key succeeded, want an error")
+	}
+}
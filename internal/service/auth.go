@@ -0,0 +1,95 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"netmaker-sync/internal/auth"
+	"strconv"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultAuditLimit is the number of audit log entries returned when no limit is given
+const defaultAuditLimit = 100
+
+// authenticate rejects requests without valid credentials and stores the principal
+// of the others in the request context
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, err := s.authenticator.Authenticate(r)
+		if err != nil {
+			if !errors.Is(err, auth.ErrUnauthenticated) {
+				logrus.Errorf("Failed to authenticate request: %v", err)
+			}
+			w.Header().Set("WWW-Authenticate", `Bearer realm="netmaker-sync"`)
+			http.Error(w, "Authentication required", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(auth.WithPrincipal(r.Context(), principal)))
+	})
+}
+
+// authorize rejects requests whose principal does not have at least the given role
+func (s *Server) authorize(role auth.Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal := auth.PrincipalFromContext(r.Context())
+			if principal == nil || principal.Role < role {
+				logrus.Warnf("Denied %s %s to %s: %s role required", r.Method, r.URL.Path, principalName(principal), role)
+				http.Error(w, fmt.Sprintf("The %s role is required", role), http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// audit records the requests it handles, and their outcome, in the audit log. It
+// wraps authorize so that denied requests are recorded too.
+func (s *Server) audit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		principal := auth.PrincipalFromContext(r.Context())
+		if principal == nil {
+			return
+		}
+		if err := s.authenticator.RecordAudit(principal, r, ww.Status()); err != nil {
+			logrus.Errorf("Failed to record %s %s by %s in the audit log: %v", r.Method, r.URL.Path, principal.Subject, err)
+		}
+	})
+}
+
+// principalName returns the subject of a principal for log messages
+func principalName(principal *auth.Principal) string {
+	if principal == nil {
+		return "unauthenticated caller"
+	}
+	return principal.Subject
+}
+
+// handleGetAuditLog handles a request to list the most recent entries of the audit log
+func (s *Server) handleGetAuditLog(w http.ResponseWriter, r *http.Request) {
+	limit := defaultAuditLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			http.Error(w, fmt.Sprintf("Invalid limit %q", value), http.StatusBadRequest)
+			return
+		}
+	}
+
+	entries, err := s.authenticator.GetAuditLog(limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, entries)
+}
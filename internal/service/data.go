@@ -0,0 +1,121 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"netmaker-sync/internal/db"
+	"netmaker-sync/internal/sync"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// dataErrorStatus maps errors of the data endpoints to HTTP status codes
+func dataErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, db.ErrInvalidListOptions), errors.Is(err, sync.ErrInvalidVersionRange):
+		return http.StatusBadRequest
+	case errors.Is(err, db.ErrUnknownResource), errors.Is(err, db.ErrRecordNotFound), errors.Is(err, db.ErrVersionNotFound):
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// parseListOptions reads the sort, limit, cursor and include_deleted query parameters.
+// Every other query parameter is a filter.
+func parseListOptions(r *http.Request) (db.ListOptions, error) {
+	opts := db.ListOptions{Filters: make(map[string]string)}
+	for name, values := range r.URL.Query() {
+		value := values[0]
+		switch name {
+		case "sort":
+			opts.Sort = value
+		case "cursor":
+			opts.Cursor = value
+		case "limit":
+			limit, err := strconv.Atoi(value)
+			if err != nil || limit < 1 {
+				return opts, fmt.Errorf("invalid limit %q", value)
+			}
+			opts.Limit = limit
+		case "include_deleted":
+			includeDeleted, err := strconv.ParseBool(value)
+			if err != nil {
+				return opts, fmt.Errorf("invalid include_deleted %q", value)
+			}
+			opts.IncludeDeleted = includeDeleted
+		default:
+			opts.Filters[name] = value
+		}
+	}
+	return opts, nil
+}
+
+// handleListResources handles a request to list the current records of a resource
+func (s *Server) handleListResources(w http.ResponseWriter, r *http.Request) {
+	opts, err := parseListOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, err := s.syncService.ListResources(r.Context(), chi.URLParam(r, "resource"), opts)
+	if err != nil {
+		http.Error(w, err.Error(), dataErrorStatus(err))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, page)
+}
+
+// handleGetResource handles a request to get the current version of a record
+func (s *Server) handleGetResource(w http.ResponseWriter, r *http.Request) {
+	record, err := s.syncService.GetResource(r.Context(), chi.URLParam(r, "resource"), chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, err.Error(), dataErrorStatus(err))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, record)
+}
+
+// handleGetResourceHistory handles a request to get every version of a record
+func (s *Server) handleGetResourceHistory(w http.ResponseWriter, r *http.Request) {
+	history, err := s.syncService.GetResourceHistory(r.Context(), chi.URLParam(r, "resource"), chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, err.Error(), dataErrorStatus(err))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, history)
+}
+
+// handleRevealResource handles a request to get a record with its encrypted fields
+// decrypted, at a version or as of a point in time when given
+func (s *Server) handleRevealResource(w http.ResponseWriter, r *http.Request) {
+	version := 0
+	if value := r.URL.Query().Get("version"); value != "" {
+		var err error
+		version, err = strconv.Atoi(value)
+		if err != nil || version < 1 {
+			http.Error(w, fmt.Sprintf("Invalid version %q", value), http.StatusBadRequest)
+			return
+		}
+	}
+
+	asOf, err := parseAsOf(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	record, err := s.syncService.RevealResource(r.Context(), chi.URLParam(r, "resource"), chi.URLParam(r, "id"), version, asOf)
+	if err != nil {
+		http.Error(w, err.Error(), dataErrorStatus(err))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, record)
+}
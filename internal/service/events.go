@@ -0,0 +1,204 @@
+// service/events.go
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"netmaker-sync/internal/models"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+)
+
+// eventsKeepaliveInterval is how often an idle event stream is pinged so proxies keep it open
+const eventsKeepaliveInterval = 30 * time.Second
+
+// newUpgrader creates the upgrader of event stream requests to WebSocket connections
+func (s *Server) newUpgrader() websocket.Upgrader {
+	return websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		CheckOrigin:     s.checkOrigin,
+	}
+}
+
+// checkOrigin reports whether a WebSocket handshake may proceed. Browsers do not apply
+// CORS to WebSockets, so the Origin header is checked against API_CORS_ORIGINS here.
+// Requests without an Origin do not come from a browser and same-origin requests are
+// not cross-origin, so both are allowed.
+func (s *Server) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+
+	for _, allowed := range s.cfg.API.CORSOrigins {
+		if originMatches(origin, allowed) {
+			return true
+		}
+	}
+	logrus.Warnf("Rejected WebSocket event stream from origin %s", origin)
+	return false
+}
+
+// originMatches reports whether an origin matches an allowed origin, which may be "*"
+// or contain one "*" wildcard, as in the CORS configuration
+func originMatches(origin, allowed string) bool {
+	origin = strings.ToLower(origin)
+	allowed = strings.ToLower(allowed)
+	prefix, suffix, wildcard := strings.Cut(allowed, "*")
+	if !wildcard {
+		return origin == allowed
+	}
+	return len(origin) >= len(prefix)+len(suffix) &&
+		strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix)
+}
+
+// eventFilter selects the change events a client is interested in
+type eventFilter struct {
+	resourceTypes map[string]bool
+	id            string
+}
+
+// newEventFilter builds a filter from the resource_type (comma separated) and id query parameters
+func newEventFilter(r *http.Request) eventFilter {
+	filter := eventFilter{
+		resourceTypes: make(map[string]bool),
+		id:            r.URL.Query().Get("id"),
+	}
+	for _, resourceType := range strings.Split(r.URL.Query().Get("resource_type"), ",") {
+		if resourceType = strings.TrimSpace(resourceType); resourceType != "" {
+			filter.resourceTypes[resourceType] = true
+		}
+	}
+	return filter
+}
+
+// matches reports whether an event passes the filter
+func (f eventFilter) matches(event models.ChangeEvent) bool {
+	if len(f.resourceTypes) > 0 && !f.resourceTypes[event.ResourceType] {
+		return false
+	}
+	if f.id != "" && f.id != event.ID {
+		return false
+	}
+	return true
+}
+
+// handleEvents streams change events to the client using Server-Sent Events
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	filter := newEventFilter(r)
+	events, unsubscribe := s.broker.Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepalive := time.NewTicker(eventsKeepaliveInterval)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if !filter.matches(event) {
+				continue
+			}
+
+			payload, err := json.Marshal(event)
+			if err != nil {
+				logrus.Errorf("Failed to marshal change event: %v", err)
+				continue
+			}
+
+			if _, err := fmt.Fprintf(w, "event: change\ndata: %s\n\n", payload); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// handleEventsWebSocket streams change events to the client over a WebSocket
+func (s *Server) handleEventsWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader has already written an error response
+		logrus.Warnf("Failed to upgrade event stream to WebSocket: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	filter := newEventFilter(r)
+	events, unsubscribe := s.broker.Subscribe()
+	defer unsubscribe()
+
+	// Read from the connection so close frames are processed, and stop when the client goes away
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	keepalive := time.NewTicker(eventsKeepaliveInterval)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case <-closed:
+			return
+
+		case <-r.Context().Done():
+			return
+
+		case <-keepalive.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second)); err != nil {
+				return
+			}
+
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if !filter.matches(event) {
+				continue
+			}
+
+			if err := conn.WriteJSON(event); err != nil {
+				logrus.Debugf("Failed to write change event to WebSocket: %v", err)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	gosync "sync"
+	"time"
+
+	"github.com/graph-gophers/graphql-go"
+)
+
+// graphqlMaxDepth limits how deeply queries can nest relationships
+const graphqlMaxDepth = 10
+
+// graphqlSchema models the mirrored topology. Records are read at their current version
+// unless a version or asOf argument is given. The relationships of a record read asOf a
+// time are read as of the same time, unless they are given arguments of their own.
+const graphqlSchema = `
+	scalar Time
+	scalar JSON
+
+	schema {
+		query: Query
+	}
+
+	type Query {
+		networks(asOf: Time): [Network!]!
+		network(id: ID!, version: Int, asOf: Time): Network
+		node(id: ID!, version: Int, asOf: Time): Node
+		host(id: ID!, version: Int, asOf: Time): Host
+		extClient(id: ID!, version: Int, asOf: Time): ExtClient
+		dnsEntry(id: ID!, version: Int, asOf: Time): DNSEntry
+		acl(id: ID!, version: Int, asOf: Time): ACL
+	}
+
+	type Network {
+		id: ID!
+		version: Int!
+		name: String!
+		addressRange: String!
+		addressRange6: String!
+		localRange: String!
+		isDualStack: Boolean!
+		isIPv4: Boolean!
+		isIPv6: Boolean!
+		isLocal: Boolean!
+		defaultAccessControl: String!
+		defaultUDPHolePunching: Boolean!
+		defaultExtClientDNS: String!
+		defaultMTU: Int!
+		defaultKeepalive: Int!
+		defaultInterface: String!
+		nodeLimit: Int!
+		isCurrent: Boolean!
+		isDeleted: Boolean!
+		deletedAt: Time
+		lastModified: Time!
+		createdAt: Time!
+		data: JSON!
+		nodes(asOf: Time): [Node!]!
+		extClients(asOf: Time): [ExtClient!]!
+		dnsEntries(asOf: Time): [DNSEntry!]!
+		acls(asOf: Time): [ACL!]!
+	}
+
+	type Node {
+		id: ID!
+		version: Int!
+		networkId: String!
+		name: String!
+		address: String!
+		address6: String!
+		publicKey: String!
+		endpoint: String!
+		isEgressGateway: Boolean!
+		isIngressGateway: Boolean!
+		isRelay: Boolean!
+		connected: Boolean!
+		isCurrent: Boolean!
+		isDeleted: Boolean!
+		deletedAt: Time
+		lastModified: Time!
+		createdAt: Time!
+		data: JSON!
+		network(version: Int, asOf: Time): Network
+		host(version: Int, asOf: Time): Host
+		# The external clients attached to the node when it is an ingress gateway
+		extClients(asOf: Time): [ExtClient!]!
+		# The ACLs with the node as source
+		acls(asOf: Time): [ACL!]!
+	}
+
+	type Host {
+		id: ID!
+		version: Int!
+		name: String!
+		endpointIP: String!
+		endpointIPv6: String!
+		publicKey: String!
+		listenPort: Int!
+		mtu: Int!
+		persistentKeepalive: Int!
+		isCurrent: Boolean!
+		isDeleted: Boolean!
+		deletedAt: Time
+		lastModified: Time!
+		createdAt: Time!
+		data: JSON!
+	}
+
+	type ExtClient {
+		id: ID!
+		version: Int!
+		networkId: String!
+		name: String!
+		address: String!
+		address6: String!
+		publicKey: String!
+		enabled: Boolean!
+		isCurrent: Boolean!
+		isDeleted: Boolean!
+		deletedAt: Time
+		lastModified: Time!
+		createdAt: Time!
+		data: JSON!
+		ingressGatewayId: String
+		ingressGateway(version: Int, asOf: Time): Node
+	}
+
+	type DNSEntry {
+		id: ID!
+		version: Int!
+		networkId: String!
+		name: String!
+		address: String!
+		address6: String!
+		isCurrent: Boolean!
+		isDeleted: Boolean!
+		deletedAt: Time
+		lastModified: Time!
+		createdAt: Time!
+	}
+
+	type ACL {
+		id: ID!
+		version: Int!
+		networkId: String!
+		nodeId: String!
+		sourceNode: String!
+		destNode: String!
+		isAllowed: Boolean!
+		isCurrent: Boolean!
+		isDeleted: Boolean!
+		deletedAt: Time
+		lastModified: Time!
+		createdAt: Time!
+		node(version: Int, asOf: Time): Node
+	}
+`
+
+// graphqlRequest is the body of a GraphQL request
+type graphqlRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// newGraphQLSchema parses the GraphQL schema and binds it to its resolvers
+func (s *Server) newGraphQLSchema() *graphql.Schema {
+	return graphql.MustParseSchema(graphqlSchema, &graphqlResolver{syncService: s.syncService},
+		graphql.UseFieldResolvers(), graphql.MaxDepth(graphqlMaxDepth))
+}
+
+// handleGraphQL handles a GraphQL query
+func (s *Server) handleGraphQL(w http.ResponseWriter, r *http.Request) {
+	var request graphqlRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), graphqlCacheKey{}, &graphqlCache{entries: make(map[string]*graphqlCacheEntry)})
+	response := s.graphqlSchema.Exec(ctx, request.Query, request.OperationName, request.Variables)
+	writeJSON(w, http.StatusOK, response)
+}
+
+// graphqlCacheKey is the context key of the cache of a GraphQL request
+type graphqlCacheKey struct{}
+
+// graphqlCache memoizes the reads of a GraphQL request, so that relationships shared
+// by many records (e.g. the ext clients of a network) are read once
+type graphqlCache struct {
+	mu      gosync.Mutex
+	entries map[string]*graphqlCacheEntry
+}
+
+// graphqlCacheEntry is a read shared by the resolvers of a GraphQL request
+type graphqlCacheEntry struct {
+	once  gosync.Once
+	value interface{}
+	err   error
+}
+
+// cachedLoad returns the result of load, calling it once per key and request
+func cachedLoad(ctx context.Context, key string, load func() (interface{}, error)) (interface{}, error) {
+	cache, ok := ctx.Value(graphqlCacheKey{}).(*graphqlCache)
+	if !ok {
+		return load()
+	}
+
+	cache.mu.Lock()
+	entry, ok := cache.entries[key]
+	if !ok {
+		entry = &graphqlCacheEntry{}
+		cache.entries[key] = entry
+	}
+	cache.mu.Unlock()
+
+	entry.once.Do(func() {
+		entry.value, entry.err = load()
+	})
+	return entry.value, entry.err
+}
+
+// jsonScalar is the JSON scalar of the GraphQL schema, used for the raw Netmaker data
+type jsonScalar map[string]interface{}
+
+// ImplementsGraphQLType maps jsonScalar to the JSON scalar
+func (jsonScalar) ImplementsGraphQLType(name string) bool {
+	return name == "JSON"
+}
+
+// UnmarshalGraphQL decodes a JSON input value
+func (j *jsonScalar) UnmarshalGraphQL(input interface{}) error {
+	value, ok := input.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("invalid JSON object %v", input)
+	}
+	*j = value
+	return nil
+}
+
+// graphqlTime converts a timestamp to the Time scalar
+func graphqlTime(t time.Time) graphql.Time {
+	return graphql.Time{Time: t}
+}
+
+// graphqlTimePtr converts an optional timestamp to the Time scalar
+func graphqlTimePtr(t *time.Time) *graphql.Time {
+	if t == nil {
+		return nil
+	}
+	return &graphql.Time{Time: *t}
+}
@@ -0,0 +1,446 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"netmaker-sync/internal/db"
+	"netmaker-sync/internal/models"
+	"netmaker-sync/internal/sync"
+	"time"
+
+	"github.com/graph-gophers/graphql-go"
+)
+
+// graphqlResolver resolves the root queries of the GraphQL schema. The string and
+// boolean fields of the types are resolved from the embedded models; the other
+// fields have resolver methods.
+type graphqlResolver struct {
+	syncService *sync.Service
+}
+
+// atArgs select the version of a record to read, or the time to read it as of
+type atArgs struct {
+	Version *int32
+	AsOf    *graphql.Time
+}
+
+// recordArgs select a record by ID and the version of it to read
+type recordArgs struct {
+	ID graphql.ID
+	atArgs
+}
+
+// asOfArgs select the time to read a list of records as of
+type asOfArgs struct {
+	AsOf *graphql.Time
+}
+
+// resolveAt returns the version and time to read a record at. An asOf given on the
+// field overrides the one inherited from the parent record.
+func resolveAt(args atArgs, inherited *time.Time) (int, *time.Time, error) {
+	switch {
+	case args.Version != nil && args.AsOf != nil:
+		return 0, nil, fmt.Errorf("version and asOf cannot be combined")
+	case args.Version != nil:
+		return int(*args.Version), nil, nil
+	case args.AsOf != nil:
+		return 0, &args.AsOf.Time, nil
+	default:
+		return 0, inherited, nil
+	}
+}
+
+// resolveAsOf returns the time to read a list of records as of
+func resolveAsOf(args asOfArgs, inherited *time.Time) *time.Time {
+	if args.AsOf != nil {
+		return &args.AsOf.Time
+	}
+	return inherited
+}
+
+// asOfKey formats the time records are read as of for cache keys
+func asOfKey(asOf *time.Time) string {
+	if asOf == nil {
+		return "current"
+	}
+	return asOf.UTC().Format(time.RFC3339Nano)
+}
+
+// record reads a record by resource name, returning nil if it does not exist at the
+// requested version or time
+func (g *graphqlResolver) record(ctx context.Context, resource string, id string, args atArgs, inherited *time.Time) (interface{}, *time.Time, error) {
+	version, asOf, err := resolveAt(args, inherited)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key := fmt.Sprintf("%s/%s/%d/%s", resource, id, version, asOfKey(asOf))
+	record, err := cachedLoad(ctx, key, func() (interface{}, error) {
+		return g.syncService.GetResourceAt(ctx, resource, id, version, asOf)
+	})
+	if errors.Is(err, db.ErrRecordNotFound) || errors.Is(err, db.ErrVersionNotFound) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return record, asOf, nil
+}
+
+// network resolves a network by ID
+func (g *graphqlResolver) network(ctx context.Context, id string, args atArgs, inherited *time.Time) (*networkResolver, error) {
+	record, asOf, err := g.record(ctx, "networks", id, args, inherited)
+	if record == nil || err != nil {
+		return nil, err
+	}
+	return &networkResolver{Network: *record.(*models.Network), g: g, asOf: asOf}, nil
+}
+
+// node resolves a node by ID
+func (g *graphqlResolver) node(ctx context.Context, id string, args atArgs, inherited *time.Time) (*nodeResolver, error) {
+	record, asOf, err := g.record(ctx, "nodes", id, args, inherited)
+	if record == nil || err != nil {
+		return nil, err
+	}
+	return &nodeResolver{Node: *record.(*models.Node), g: g, asOf: asOf}, nil
+}
+
+// host resolves a host by ID
+func (g *graphqlResolver) host(ctx context.Context, id string, args atArgs, inherited *time.Time) (*hostResolver, error) {
+	record, _, err := g.record(ctx, "hosts", id, args, inherited)
+	if record == nil || err != nil {
+		return nil, err
+	}
+	return &hostResolver{Host: *record.(*models.Host)}, nil
+}
+
+// nodes resolves the nodes of a network
+func (g *graphqlResolver) nodes(ctx context.Context, networkID string, asOf *time.Time) ([]models.Node, error) {
+	nodes, err := cachedLoad(ctx, "nodes/network/"+networkID+"/"+asOfKey(asOf), func() (interface{}, error) {
+		return g.syncService.GetNodes(ctx, networkID, asOf)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return nodes.([]models.Node), nil
+}
+
+// extClients resolves the external clients of a network
+func (g *graphqlResolver) extClients(ctx context.Context, networkID string, asOf *time.Time) ([]models.ExtClient, error) {
+	extClients, err := cachedLoad(ctx, "ext_clients/network/"+networkID+"/"+asOfKey(asOf), func() (interface{}, error) {
+		return g.syncService.GetExtClients(ctx, networkID, asOf)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return extClients.([]models.ExtClient), nil
+}
+
+// acls resolves the ACLs of a network
+func (g *graphqlResolver) acls(ctx context.Context, networkID string, asOf *time.Time) ([]models.ACL, error) {
+	acls, err := cachedLoad(ctx, "acls/network/"+networkID+"/"+asOfKey(asOf), func() (interface{}, error) {
+		return g.syncService.GetACLs(ctx, networkID, asOf)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return acls.([]models.ACL), nil
+}
+
+// Networks resolves the networks, optionally as they were at a point in time
+func (g *graphqlResolver) Networks(ctx context.Context, args asOfArgs) ([]*networkResolver, error) {
+	asOf := resolveAsOf(args, nil)
+
+	var networks []models.Network
+	var err error
+	if asOf != nil {
+		networks, err = g.syncService.GetNetworksAsOf(ctx, *asOf)
+	} else {
+		networks, err = g.syncService.GetNetworks(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	resolvers := make([]*networkResolver, len(networks))
+	for i := range networks {
+		resolvers[i] = &networkResolver{Network: networks[i], g: g, asOf: asOf}
+	}
+	return resolvers, nil
+}
+
+// Network resolves a network by ID
+func (g *graphqlResolver) Network(ctx context.Context, args recordArgs) (*networkResolver, error) {
+	return g.network(ctx, string(args.ID), args.atArgs, nil)
+}
+
+// Node resolves a node by ID
+func (g *graphqlResolver) Node(ctx context.Context, args recordArgs) (*nodeResolver, error) {
+	return g.node(ctx, string(args.ID), args.atArgs, nil)
+}
+
+// Host resolves a host by ID
+func (g *graphqlResolver) Host(ctx context.Context, args recordArgs) (*hostResolver, error) {
+	return g.host(ctx, string(args.ID), args.atArgs, nil)
+}
+
+// ExtClient resolves an external client by ID
+func (g *graphqlResolver) ExtClient(ctx context.Context, args recordArgs) (*extClientResolver, error) {
+	record, asOf, err := g.record(ctx, "ext_clients", string(args.ID), args.atArgs, nil)
+	if record == nil || err != nil {
+		return nil, err
+	}
+	return &extClientResolver{ExtClient: *record.(*models.ExtClient), g: g, asOf: asOf}, nil
+}
+
+// DNSEntry resolves a DNS entry by ID
+func (g *graphqlResolver) DNSEntry(ctx context.Context, args recordArgs) (*dnsEntryResolver, error) {
+	record, _, err := g.record(ctx, "dns", string(args.ID), args.atArgs, nil)
+	if record == nil || err != nil {
+		return nil, err
+	}
+	return &dnsEntryResolver{DNSEntry: *record.(*models.DNSEntry)}, nil
+}
+
+// ACL resolves an ACL by ID
+func (g *graphqlResolver) ACL(ctx context.Context, args recordArgs) (*aclResolver, error) {
+	record, asOf, err := g.record(ctx, "acls", string(args.ID), args.atArgs, nil)
+	if record == nil || err != nil {
+		return nil, err
+	}
+	return &aclResolver{ACL: *record.(*models.ACL), g: g, asOf: asOf}, nil
+}
+
+// networkResolver resolves a network and its relationships
+type networkResolver struct {
+	models.Network
+	g    *graphqlResolver
+	asOf *time.Time
+}
+
+func (r *networkResolver) ID() graphql.ID             { return graphql.ID(r.Network.ID) }
+func (r *networkResolver) Version() int32             { return int32(r.Network.Version) }
+func (r *networkResolver) DefaultMTU() int32          { return int32(r.Network.DefaultMTU) }
+func (r *networkResolver) DefaultKeepalive() int32    { return int32(r.Network.DefaultKeepalive) }
+func (r *networkResolver) NodeLimit() int32           { return int32(r.Network.NodeLimit) }
+func (r *networkResolver) DeletedAt() *graphql.Time   { return graphqlTimePtr(r.Network.DeletedAt) }
+func (r *networkResolver) LastModified() graphql.Time { return graphqlTime(r.Network.LastModified) }
+func (r *networkResolver) CreatedAt() graphql.Time    { return graphqlTime(r.Network.CreatedAt) }
+func (r *networkResolver) Data() jsonScalar           { return jsonScalar(r.Network.Data) }
+
+// Nodes resolves the nodes of the network
+func (r *networkResolver) Nodes(ctx context.Context, args asOfArgs) ([]*nodeResolver, error) {
+	asOf := resolveAsOf(args, r.asOf)
+	nodes, err := r.g.nodes(ctx, r.Network.ID, asOf)
+	if err != nil {
+		return nil, err
+	}
+
+	resolvers := make([]*nodeResolver, len(nodes))
+	for i := range nodes {
+		resolvers[i] = &nodeResolver{Node: nodes[i], g: r.g, asOf: asOf}
+	}
+	return resolvers, nil
+}
+
+// ExtClients resolves the external clients of the network
+func (r *networkResolver) ExtClients(ctx context.Context, args asOfArgs) ([]*extClientResolver, error) {
+	asOf := resolveAsOf(args, r.asOf)
+	extClients, err := r.g.extClients(ctx, r.Network.ID, asOf)
+	if err != nil {
+		return nil, err
+	}
+
+	resolvers := make([]*extClientResolver, len(extClients))
+	for i := range extClients {
+		resolvers[i] = &extClientResolver{ExtClient: extClients[i], g: r.g, asOf: asOf}
+	}
+	return resolvers, nil
+}
+
+// DNSEntries resolves the DNS entries of the network
+func (r *networkResolver) DNSEntries(ctx context.Context, args asOfArgs) ([]*dnsEntryResolver, error) {
+	dnsEntries, err := r.g.syncService.GetDNSEntries(ctx, r.Network.ID, resolveAsOf(args, r.asOf))
+	if err != nil {
+		return nil, err
+	}
+
+	resolvers := make([]*dnsEntryResolver, len(dnsEntries))
+	for i := range dnsEntries {
+		resolvers[i] = &dnsEntryResolver{DNSEntry: dnsEntries[i]}
+	}
+	return resolvers, nil
+}
+
+// ACLs resolves the ACL matrix of the network
+func (r *networkResolver) ACLs(ctx context.Context, args asOfArgs) ([]*aclResolver, error) {
+	asOf := resolveAsOf(args, r.asOf)
+	acls, err := r.g.acls(ctx, r.Network.ID, asOf)
+	if err != nil {
+		return nil, err
+	}
+
+	resolvers := make([]*aclResolver, len(acls))
+	for i := range acls {
+		resolvers[i] = &aclResolver{ACL: acls[i], g: r.g, asOf: asOf}
+	}
+	return resolvers, nil
+}
+
+// nodeResolver resolves a node and its relationships
+type nodeResolver struct {
+	models.Node
+	g    *graphqlResolver
+	asOf *time.Time
+}
+
+func (r *nodeResolver) ID() graphql.ID             { return graphql.ID(r.Node.ID) }
+func (r *nodeResolver) Version() int32             { return int32(r.Node.Version) }
+func (r *nodeResolver) DeletedAt() *graphql.Time   { return graphqlTimePtr(r.Node.DeletedAt) }
+func (r *nodeResolver) LastModified() graphql.Time { return graphqlTime(r.Node.LastModified) }
+func (r *nodeResolver) CreatedAt() graphql.Time    { return graphqlTime(r.Node.CreatedAt) }
+func (r *nodeResolver) Data() jsonScalar           { return jsonScalar(r.Node.Data) }
+
+// Network resolves the network of the node
+func (r *nodeResolver) Network(ctx context.Context, args atArgs) (*networkResolver, error) {
+	return r.g.network(ctx, r.Node.NetworkID, args, r.asOf)
+}
+
+// Host resolves the host the node runs on, joined on the hostid of the Netmaker data
+func (r *nodeResolver) Host(ctx context.Context, args atArgs) (*hostResolver, error) {
+	hostID, _ := r.Node.Data["hostid"].(string)
+	if hostID == "" {
+		return nil, nil
+	}
+	return r.g.host(ctx, hostID, args, r.asOf)
+}
+
+// ExtClients resolves the external clients attached to the node, joined on the
+// ingressgatewayid of their Netmaker data
+func (r *nodeResolver) ExtClients(ctx context.Context, args asOfArgs) ([]*extClientResolver, error) {
+	asOf := resolveAsOf(args, r.asOf)
+	extClients, err := r.g.extClients(ctx, r.Node.NetworkID, asOf)
+	if err != nil {
+		return nil, err
+	}
+
+	resolvers := []*extClientResolver{}
+	for i := range extClients {
+		if gatewayID, _ := extClients[i].Data["ingressgatewayid"].(string); gatewayID == r.Node.ID {
+			resolvers = append(resolvers, &extClientResolver{ExtClient: extClients[i], g: r.g, asOf: asOf})
+		}
+	}
+	return resolvers, nil
+}
+
+// ACLs resolves the ACLs with the node as source
+func (r *nodeResolver) ACLs(ctx context.Context, args asOfArgs) ([]*aclResolver, error) {
+	asOf := resolveAsOf(args, r.asOf)
+	acls, err := r.g.acls(ctx, r.Node.NetworkID, asOf)
+	if err != nil {
+		return nil, err
+	}
+
+	resolvers := []*aclResolver{}
+	for i := range acls {
+		if acls[i].NodeID == r.Node.ID {
+			resolvers = append(resolvers, &aclResolver{ACL: acls[i], g: r.g, asOf: asOf})
+		}
+	}
+	return resolvers, nil
+}
+
+// hostResolver resolves a host
+type hostResolver struct {
+	models.Host
+}
+
+func (r *hostResolver) ID() graphql.ID             { return graphql.ID(r.Host.ID) }
+func (r *hostResolver) Version() int32             { return int32(r.Host.Version) }
+func (r *hostResolver) ListenPort() int32          { return int32(r.Host.ListenPort) }
+func (r *hostResolver) MTU() int32                 { return int32(r.Host.MTU) }
+func (r *hostResolver) PersistentKeepalive() int32 { return int32(r.Host.PersistentKeepalive) }
+func (r *hostResolver) DeletedAt() *graphql.Time   { return graphqlTimePtr(r.Host.DeletedAt) }
+func (r *hostResolver) LastModified() graphql.Time { return graphqlTime(r.Host.LastModified) }
+func (r *hostResolver) CreatedAt() graphql.Time    { return graphqlTime(r.Host.CreatedAt) }
+func (r *hostResolver) Data() jsonScalar           { return jsonScalar(r.Host.Data) }
+
+// extClientResolver resolves an external client and its ingress gateway
+type extClientResolver struct {
+	models.ExtClient
+	g    *graphqlResolver
+	asOf *time.Time
+}
+
+func (r *extClientResolver) ID() graphql.ID             { return graphql.ID(r.ExtClient.ID) }
+func (r *extClientResolver) Version() int32             { return int32(r.ExtClient.Version) }
+func (r *extClientResolver) DeletedAt() *graphql.Time   { return graphqlTimePtr(r.ExtClient.DeletedAt) }
+func (r *extClientResolver) LastModified() graphql.Time { return graphqlTime(r.ExtClient.LastModified) }
+func (r *extClientResolver) CreatedAt() graphql.Time    { return graphqlTime(r.ExtClient.CreatedAt) }
+func (r *extClientResolver) Data() jsonScalar           { return jsonScalar(r.ExtClient.Data) }
+
+// IngressGatewayID resolves the ID of the node the external client is attached to
+func (r *extClientResolver) IngressGatewayID() *string {
+	gatewayID, _ := r.ExtClient.Data["ingressgatewayid"].(string)
+	if gatewayID == "" {
+		return nil
+	}
+	return &gatewayID
+}
+
+// IngressGateway resolves the node the external client is attached to
+func (r *extClientResolver) IngressGateway(ctx context.Context, args atArgs) (*nodeResolver, error) {
+	gatewayID := r.IngressGatewayID()
+	if gatewayID == nil {
+		return nil, nil
+	}
+	return r.g.node(ctx, *gatewayID, args, r.asOf)
+}
+
+// dnsEntryResolver resolves a DNS entry
+type dnsEntryResolver struct {
+	models.DNSEntry
+}
+
+func (r *dnsEntryResolver) ID() graphql.ID             { return graphql.ID(r.DNSEntry.ID) }
+func (r *dnsEntryResolver) Version() int32             { return int32(r.DNSEntry.Version) }
+func (r *dnsEntryResolver) DeletedAt() *graphql.Time   { return graphqlTimePtr(r.DNSEntry.DeletedAt) }
+func (r *dnsEntryResolver) LastModified() graphql.Time { return graphqlTime(r.DNSEntry.LastModified) }
+func (r *dnsEntryResolver) CreatedAt() graphql.Time    { return graphqlTime(r.DNSEntry.CreatedAt) }
+
+// aclResolver resolves one source and destination of the ACL matrix of a network
+type aclResolver struct {
+	models.ACL
+	g    *graphqlResolver
+	asOf *time.Time
+}
+
+func (r *aclResolver) ID() graphql.ID             { return graphql.ID(r.ACL.ID) }
+func (r *aclResolver) Version() int32             { return int32(r.ACL.Version) }
+func (r *aclResolver) DeletedAt() *graphql.Time   { return graphqlTimePtr(r.ACL.DeletedAt) }
+func (r *aclResolver) LastModified() graphql.Time { return graphqlTime(r.ACL.LastModified) }
+func (r *aclResolver) CreatedAt() graphql.Time    { return graphqlTime(r.ACL.CreatedAt) }
+
+// SourceNode resolves the name of the source node
+func (r *aclResolver) SourceNode() string {
+	sourceNode, _ := r.ACL.Data["source_node"].(string)
+	return sourceNode
+}
+
+// DestNode resolves the name of the destination node
+func (r *aclResolver) DestNode() string {
+	destNode, _ := r.ACL.Data["dest_node"].(string)
+	return destNode
+}
+
+// IsAllowed resolves whether traffic from the source to the destination node is allowed
+func (r *aclResolver) IsAllowed() bool {
+	isAllowed, _ := r.ACL.Data["is_allowed"].(bool)
+	return isAllowed
+}
+
+// Node resolves the source node
+func (r *aclResolver) Node(ctx context.Context, args atArgs) (*nodeResolver, error) {
+	return r.g.node(ctx, r.ACL.NodeID, args, r.asOf)
+}
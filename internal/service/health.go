@@ -0,0 +1,41 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// readinessTimeout bounds the dependency checks of a readiness probe
+const readinessTimeout = 5 * time.Second
+
+// handleHealthz handles a liveness probe, which succeeds as long as the process serves
+// requests
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// handleReadyz handles a readiness probe, which fails with 503 Service Unavailable if a
+// dependency is down or the mirror is stale
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	readiness := s.syncService.Readiness(ctx)
+	status := http.StatusOK
+	if !readiness.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, readiness)
+}
+
+// handleStatus handles a request to summarize the last sync of each resource type
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	summary, err := s.syncService.GetSyncStatus(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, summary)
+}
@@ -0,0 +1,85 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Bounds of the days of uptime returned for a node
+const (
+	defaultUptimeDays = 7
+	maxUptimeDays     = 90
+)
+
+// defaultHeartbeatWindow is how far back the heartbeats of a node are returned when no
+// since parameter is given
+const defaultHeartbeatWindow = 24 * time.Hour
+
+// handleGetStaleNodes handles a request to list the current nodes whose last check-in
+// is older than the older_than duration, optionally in one network
+func (s *Server) handleGetStaleNodes(w http.ResponseWriter, r *http.Request) {
+	var olderThan time.Duration
+	if value := r.URL.Query().Get("older_than"); value != "" {
+		var err error
+		olderThan, err = time.ParseDuration(value)
+		if err != nil || olderThan <= 0 {
+			http.Error(w, fmt.Sprintf("Invalid older_than duration %q, expected e.g. 15m or 2h", value), http.StatusBadRequest)
+			return
+		}
+	}
+
+	nodes, err := s.syncService.GetStaleNodes(r.Context(), r.URL.Query().Get("network_id"), olderThan)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, nodes)
+}
+
+// handleGetNodeHeartbeats handles a request to list the heartbeats of a node observed
+// since a time, by default over the last day
+func (s *Server) handleGetNodeHeartbeats(w http.ResponseWriter, r *http.Request) {
+	since := time.Now().Add(-defaultHeartbeatWindow)
+	if value := r.URL.Query().Get("since"); value != "" {
+		var err error
+		since, err = time.Parse(time.RFC3339, value)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid since timestamp %q, expected RFC 3339 (e.g. 2026-09-01T12:00:00Z)", value), http.StatusBadRequest)
+			return
+		}
+	}
+
+	heartbeats, err := s.syncService.GetNodeHeartbeats(r.Context(), chi.URLParam(r, "nodeID"), since)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, heartbeats)
+}
+
+// handleGetNodeUptime handles a request to get the daily uptime of a node
+func (s *Server) handleGetNodeUptime(w http.ResponseWriter, r *http.Request) {
+	days := defaultUptimeDays
+	if value := r.URL.Query().Get("days"); value != "" {
+		var err error
+		days, err = strconv.Atoi(value)
+		if err != nil || days < 1 || days > maxUptimeDays {
+			http.Error(w, fmt.Sprintf("Invalid days %q, expected 1 to %d", value, maxUptimeDays), http.StatusBadRequest)
+			return
+		}
+	}
+
+	uptime, err := s.syncService.GetNodeUptime(r.Context(), chi.URLParam(r, "nodeID"), days)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, uptime)
+}
@@ -0,0 +1,86 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"netmaker-sync/internal/models"
+	"netmaker-sync/internal/sync"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// jobErrorStatus maps sync job errors to HTTP status codes
+func jobErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, sync.ErrJobNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, sync.ErrJobFinished):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// startSyncJob queues a sync job and responds with 202 Accepted and the location of
+// the job. With ?wait=true it instead waits for the job to finish and responds with
+// its outcome, cancelling the job if the client goes away first.
+func (s *Server) startSyncJob(w http.ResponseWriter, r *http.Request, scope string, networkID string) {
+	wait := false
+	if value := r.URL.Query().Get("wait"); value != "" {
+		var err error
+		wait, err = strconv.ParseBool(value)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid wait parameter %q", value), http.StatusBadRequest)
+			return
+		}
+	}
+
+	job, err := s.syncService.StartJob(scope, networkID, s.cfg.Sync.IncludeAcls)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if !wait {
+		w.Header().Set("Location", "/api/sync/jobs/"+job.ID)
+		writeJSON(w, http.StatusAccepted, job)
+		return
+	}
+
+	finished, err := s.syncService.WaitJob(r.Context(), job.ID)
+	if err != nil {
+		s.syncService.CancelJob(job.ID)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	status := http.StatusOK
+	if finished.Status != models.SyncStatusCompleted {
+		status = http.StatusInternalServerError
+	}
+	writeJSON(w, status, finished)
+}
+
+// handleGetSyncJob handles a request to get the progress of a sync job
+func (s *Server) handleGetSyncJob(w http.ResponseWriter, r *http.Request) {
+	job, err := s.syncService.GetJob(chi.URLParam(r, "jobID"))
+	if err != nil {
+		http.Error(w, err.Error(), jobErrorStatus(err))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, job)
+}
+
+// handleCancelSyncJob handles a request to cancel a sync job
+func (s *Server) handleCancelSyncJob(w http.ResponseWriter, r *http.Request) {
+	job, err := s.syncService.CancelJob(chi.URLParam(r, "jobID"))
+	if err != nil {
+		http.Error(w, err.Error(), jobErrorStatus(err))
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, job)
+}
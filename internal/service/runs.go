@@ -0,0 +1,56 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"netmaker-sync/internal/db"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// defaultRunLimit is the number of sync runs returned when no limit is given
+const defaultRunLimit = 50
+
+// handleGetSyncRuns handles a request to list the most recent sync runs
+func (s *Server) handleGetSyncRuns(w http.ResponseWriter, r *http.Request) {
+	limit := defaultRunLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			http.Error(w, fmt.Sprintf("Invalid limit %q", value), http.StatusBadRequest)
+			return
+		}
+	}
+
+	runs, err := s.syncService.GetSyncRuns(r.Context(), limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, runs)
+}
+
+// handleGetSyncRun handles a request to get a sync run and its per-resource sync history
+func (s *Server) handleGetSyncRun(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "runID"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid sync run ID %q", chi.URLParam(r, "runID")), http.StatusBadRequest)
+		return
+	}
+
+	run, err := s.syncService.GetSyncRun(r.Context(), id)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, db.ErrSyncRunNotFound) {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, run)
+}
@@ -5,29 +5,48 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"netmaker-sync/internal/auth"
 	"netmaker-sync/internal/config"
+	"netmaker-sync/internal/events"
+	"netmaker-sync/internal/metrics"
+	"netmaker-sync/internal/models"
 	"netmaker-sync/internal/sync"
+	"netmaker-sync/internal/webhooks"
+	"strconv"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
+	"github.com/gorilla/websocket"
+	"github.com/graph-gophers/graphql-go"
 )
 
 // Server represents the HTTP API server
 type Server struct {
-	router      *chi.Mux
-	syncService *sync.Service
-	cfg         *config.Config
+	router        *chi.Mux
+	syncService   *sync.Service
+	broker        *events.Broker
+	dispatcher    *webhooks.Dispatcher
+	authenticator *auth.Authenticator
+	cfg           *config.Config
+	graphqlSchema *graphql.Schema
+	upgrader      websocket.Upgrader
 }
 
 // New creates a new HTTP API server
-func New(syncService *sync.Service, cfg *config.Config) *Server {
+func New(syncService *sync.Service, broker *events.Broker, dispatcher *webhooks.Dispatcher, authenticator *auth.Authenticator, cfg *config.Config) *Server {
 	s := &Server{
-		router:      chi.NewRouter(),
-		syncService: syncService,
-		cfg:         cfg,
+		router:        chi.NewRouter(),
+		syncService:   syncService,
+		broker:        broker,
+		dispatcher:    dispatcher,
+		authenticator: authenticator,
+		cfg:           cfg,
 	}
 
+	s.graphqlSchema = s.newGraphQLSchema()
+	s.upgrader = s.newUpgrader()
 	s.setupRoutes()
 	return s
 }
@@ -37,32 +56,99 @@ func (s *Server) setupRoutes() {
 	s.router.Use(middleware.Logger)
 	s.router.Use(middleware.Recoverer)
 
-	// CORS middleware
-	s.router.Use(cors.Handler(cors.Options{
-		AllowedOrigins:   []string{"*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type"},
-		ExposedHeaders:   []string{"Link"},
-		AllowCredentials: true,
-		MaxAge:           300,
-	}))
-
-	// API routes
+	// CORS middleware, only for the configured origins. Credentials are sent in
+	// headers rather than cookies, so browsers do not need to send cookies.
+	if len(s.cfg.API.CORSOrigins) > 0 {
+		s.router.Use(cors.Handler(cors.Options{
+			AllowedOrigins: s.cfg.API.CORSOrigins,
+			AllowedMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+			AllowedHeaders: []string{"Accept", "Authorization", "Content-Type", "X-API-Key"},
+			ExposedHeaders: []string{"Link", "Location"},
+			MaxAge:         300,
+		}))
+	}
+
+	// Prometheus metrics, outside /api so that scrapers need no credentials
+	s.router.Handle("/metrics", metrics.Handler())
+
+	// Probes and status page, outside /api so that orchestrators need no credentials
+	s.router.Get("/healthz", s.handleHealthz)
+	s.router.Get("/readyz", s.handleReadyz)
+	s.router.Get("/status", s.handleStatus)
+
+	// API routes. Every route requires authentication and at least the reader role;
+	// the routes that change something require a higher role and are audited.
 	s.router.Route("/api", func(r chi.Router) {
+		r.Use(s.authenticate)
+
 		// Sync routes
 		r.Route("/sync", func(r chi.Router) {
-			r.Post("/", s.handleSyncAll)
-			r.Post("/networks", s.handleSyncNetworks)
-			r.Post("/networks/{networkID}/nodes", s.handleSyncNodes)
-			// More sync routes
+			r.Group(func(r chi.Router) {
+				r.Use(s.audit, s.authorize(auth.RoleSyncer))
+				r.Post("/", s.handleSyncAll)
+				r.Post("/networks", s.handleSyncNetworks)
+				r.Post("/networks/{networkID}/nodes", s.handleSyncNodes)
+				r.Delete("/jobs/{jobID}", s.handleCancelSyncJob)
+			})
+			r.Group(func(r chi.Router) {
+				r.Use(s.authorize(auth.RoleReader))
+				r.Get("/jobs/{jobID}", s.handleGetSyncJob)
+				r.Get("/runs", s.handleGetSyncRuns)
+				r.Get("/runs/{runID}", s.handleGetSyncRun)
+			})
 		})
 
-		// Data routes
-		r.Route("/data", func(r chi.Router) {
-			r.Get("/networks", s.handleGetNetworks)
-			r.Get("/networks/{networkID}", s.handleGetNetwork)
-			// More data routes
+		// Read routes
+		r.Group(func(r chi.Router) {
+			r.Use(s.authorize(auth.RoleReader))
+
+			// Data routes
+			r.Route("/data", func(r chi.Router) {
+				r.Get("/networks", s.handleGetNetworks)
+				r.Get("/networks/{networkID}", s.handleGetNetwork)
+				r.Get("/{resource}", s.handleListResources)
+				r.Get("/{resource}/{id}", s.handleGetResource)
+				r.Get("/{resource}/{id}/history", s.handleGetResourceHistory)
+				r.Get("/{resource}/{id}/diff", s.handleGetDiff)
+
+				// Encrypted fields are only revealed to admins, and every read is audited
+				r.With(s.audit, s.authorize(auth.RoleAdmin)).Get("/{resource}/{id}/secrets", s.handleRevealResource)
+			})
+
+			// Node check-in routes
+			r.Route("/heartbeats", func(r chi.Router) {
+				r.Get("/stale", s.handleGetStaleNodes)
+				r.Get("/{nodeID}", s.handleGetNodeHeartbeats)
+				r.Get("/{nodeID}/uptime", s.handleGetNodeUptime)
+			})
+
+			// GraphQL route, read-only
+			r.Post("/graphql", s.handleGraphQL)
+
+			// Change event routes
+			r.Get("/events", s.handleEvents)
+			r.Get("/events/ws", s.handleEventsWebSocket)
+		})
+
+		// Webhook routes. Subscriptions include their signing secret, so reading them
+		// requires the admin role too.
+		r.Route("/webhooks", func(r chi.Router) {
+			r.Group(func(r chi.Router) {
+				r.Use(s.audit, s.authorize(auth.RoleAdmin))
+				r.Post("/", s.handleCreateWebhook)
+				r.Put("/{webhookID}", s.handleUpdateWebhook)
+				r.Delete("/{webhookID}", s.handleDeleteWebhook)
+			})
+			r.Group(func(r chi.Router) {
+				r.Use(s.authorize(auth.RoleAdmin))
+				r.Get("/", s.handleGetWebhooks)
+				r.Get("/{webhookID}", s.handleGetWebhook)
+				r.Get("/{webhookID}/deliveries", s.handleGetWebhookDeliveries)
+			})
 		})
+
+		// Audit log route
+		r.With(s.authorize(auth.RoleAdmin)).Get("/audit", s.handleGetAuditLog)
 	})
 }
 
@@ -75,31 +161,12 @@ func (s *Server) Start(host string, port int) error {
 
 // handleSyncAll handles a request to sync all resources
 func (s *Server) handleSyncAll(w http.ResponseWriter, r *http.Request) {
-
-	// We need to get the value from config
-	includeAcls := s.cfg.Sync.IncludeAcls
-	err := s.syncService.SyncAll(r.Context(), includeAcls)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(`{"status":"success","message":"Sync completed successfully"}`))
+	s.startSyncJob(w, r, models.SyncScopeAll, "")
 }
 
 // handleSyncNetworks handles a request to sync networks
 func (s *Server) handleSyncNetworks(w http.ResponseWriter, r *http.Request) {
-	err := s.syncService.SyncNetworks(r.Context())
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(`{"status":"success","message":"Networks sync completed successfully"}`))
+	s.startSyncJob(w, r, models.ResourceTypeNetwork, "")
 }
 
 // handleSyncNodes handles a request to sync nodes for a specific network
@@ -110,20 +177,37 @@ func (s *Server) handleSyncNodes(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := s.syncService.SyncNodes(r.Context(), networkID)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	s.startSyncJob(w, r, models.ResourceTypeNode, networkID)
+}
+
+// parseAsOf parses the optional as_of query parameter as an RFC 3339 timestamp
+func parseAsOf(r *http.Request) (*time.Time, error) {
+	value := r.URL.Query().Get("as_of")
+	if value == "" {
+		return nil, nil
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(`{"status":"success","message":"Nodes sync completed successfully"}`))
+	asOf, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid as_of timestamp %q, expected RFC 3339 (e.g. 2026-09-01T12:00:00Z)", value)
+	}
+	return &asOf, nil
 }
 
-// handleGetNetworks handles a request to get all networks
+// handleGetNetworks handles a request to get all networks, optionally as they were at a point in time
 func (s *Server) handleGetNetworks(w http.ResponseWriter, r *http.Request) {
-	networks, err := s.syncService.GetNetworks(r.Context())
+	asOf, err := parseAsOf(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var networks interface{}
+	if asOf != nil {
+		networks, err = s.syncService.GetNetworksAsOf(r.Context(), *asOf)
+	} else {
+		networks, err = s.syncService.GetNetworks(r.Context())
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -140,7 +224,9 @@ func (s *Server) handleGetNetworks(w http.ResponseWriter, r *http.Request) {
 	w.Write(responseJSON)
 }
 
-// handleGetNetwork handles a request to get a specific network
+// handleGetNetwork handles a request to get a specific network. When as_of is given,
+// the full state of the network (nodes, ext clients, DNS, ACLs and hosts) at that
+// time is returned instead.
 func (s *Server) handleGetNetwork(w http.ResponseWriter, r *http.Request) {
 	networkID := chi.URLParam(r, "networkID")
 	if networkID == "" {
@@ -148,9 +234,20 @@ func (s *Server) handleGetNetwork(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	network, err := s.syncService.GetNetwork(r.Context(), networkID)
+	asOf, err := parseAsOf(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var network interface{}
+	if asOf != nil {
+		network, err = s.syncService.GetNetworkStateAsOf(r.Context(), networkID, *asOf)
+	} else {
+		network, err = s.syncService.GetNetwork(r.Context(), networkID)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), dataErrorStatus(err))
 		return
 	}
 
@@ -164,3 +261,45 @@ func (s *Server) handleGetNetwork(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	w.Write(responseJSON)
 }
+
+// handleGetDiff handles a request to get the field-level changes between two versions of a resource
+func (s *Server) handleGetDiff(w http.ResponseWriter, r *http.Request) {
+	resource := chi.URLParam(r, "resource")
+	id := chi.URLParam(r, "id")
+
+	// Both versions are optional, see sync.Service.DiffResourceVersions for the defaults
+	versions := make(map[string]int, 2)
+	for _, param := range []string{"from", "to"} {
+		value := r.URL.Query().Get(param)
+		if value == "" {
+			continue
+		}
+
+		version, err := strconv.Atoi(value)
+		if err != nil || version < 1 {
+			http.Error(w, fmt.Sprintf("Invalid %s version %q", param, value), http.StatusBadRequest)
+			return
+		}
+		versions[param] = version
+	}
+	if from, to := versions["from"], versions["to"]; from > 0 && to > 0 && from >= to {
+		http.Error(w, fmt.Sprintf("From version %d must be lower than to version %d", from, to), http.StatusBadRequest)
+		return
+	}
+
+	result, err := s.syncService.DiffResourceVersions(r.Context(), resource, id, versions["from"], versions["to"])
+	if err != nil {
+		http.Error(w, err.Error(), dataErrorStatus(err))
+		return
+	}
+
+	responseJSON, err := json.Marshal(result)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(responseJSON)
+}
@@ -0,0 +1,201 @@
+// service/webhooks.go
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"netmaker-sync/internal/db"
+	"netmaker-sync/internal/models"
+	"netmaker-sync/internal/webhooks"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// defaultDeliveryLimit is the number of deliveries returned when no limit is given
+const defaultDeliveryLimit = 100
+
+// webhookRequest is the body of a request to create or update a webhook subscription
+type webhookRequest struct {
+	Name          string   `json:"name"`
+	URL           string   `json:"url"`
+	Secret        string   `json:"secret"`
+	EventTypes    []string `json:"event_types"`
+	ResourceTypes []string `json:"resource_types"`
+	NetworkIDs    []string `json:"network_ids"`
+	ChangedFields []string `json:"changed_fields"`
+	Enabled       *bool    `json:"enabled"`
+}
+
+// toSubscription converts the request into a subscription, defaulting to enabled
+func (req webhookRequest) toSubscription() *models.WebhookSubscription {
+	enabled := true
+	if req.Enabled != nil {
+		enabled = *req.Enabled
+	}
+
+	return &models.WebhookSubscription{
+		Name:          req.Name,
+		URL:           req.URL,
+		Secret:        req.Secret,
+		EventTypes:    req.EventTypes,
+		ResourceTypes: req.ResourceTypes,
+		NetworkIDs:    req.NetworkIDs,
+		ChangedFields: req.ChangedFields,
+		Enabled:       enabled,
+	}
+}
+
+// writeJSON writes a value as a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	responseJSON, err := json.Marshal(value)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(responseJSON)
+}
+
+// webhookErrorStatus maps webhook errors to HTTP status codes
+func webhookErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, webhooks.ErrInvalidSubscription):
+		return http.StatusBadRequest
+	case errors.Is(err, db.ErrWebhookNotFound):
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// webhookID parses the webhook ID URL parameter
+func webhookID(r *http.Request) (int, error) {
+	id, err := strconv.Atoi(chi.URLParam(r, "webhookID"))
+	if err != nil {
+		return 0, fmt.Errorf("invalid webhook ID %q", chi.URLParam(r, "webhookID"))
+	}
+	return id, nil
+}
+
+// handleGetWebhooks handles a request to list webhook subscriptions
+func (s *Server) handleGetWebhooks(w http.ResponseWriter, r *http.Request) {
+	subscriptions, err := s.dispatcher.GetSubscriptions()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Secrets are only returned when a subscription is created
+	for i := range subscriptions {
+		subscriptions[i].Secret = ""
+	}
+
+	writeJSON(w, http.StatusOK, subscriptions)
+}
+
+// handleCreateWebhook handles a request to register a webhook subscription
+func (s *Server) handleCreateWebhook(w http.ResponseWriter, r *http.Request) {
+	var req webhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	subscription := req.toSubscription()
+	if err := s.dispatcher.CreateSubscription(subscription); err != nil {
+		http.Error(w, err.Error(), webhookErrorStatus(err))
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, subscription)
+}
+
+// handleGetWebhook handles a request to get a webhook subscription
+func (s *Server) handleGetWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := webhookID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	subscription, err := s.dispatcher.GetSubscription(id)
+	if err != nil {
+		http.Error(w, err.Error(), webhookErrorStatus(err))
+		return
+	}
+
+	subscription.Secret = ""
+	writeJSON(w, http.StatusOK, subscription)
+}
+
+// handleUpdateWebhook handles a request to replace a webhook subscription
+func (s *Server) handleUpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := webhookID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var req webhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	subscription := req.toSubscription()
+	subscription.ID = id
+	if err := s.dispatcher.UpdateSubscription(subscription); err != nil {
+		http.Error(w, err.Error(), webhookErrorStatus(err))
+		return
+	}
+
+	subscription.Secret = ""
+	writeJSON(w, http.StatusOK, subscription)
+}
+
+// handleDeleteWebhook handles a request to delete a webhook subscription
+func (s *Server) handleDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := webhookID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.dispatcher.DeleteSubscription(id); err != nil {
+		http.Error(w, err.Error(), webhookErrorStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleGetWebhookDeliveries handles a request to get the delivery log of a webhook subscription
+func (s *Server) handleGetWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	id, err := webhookID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	limit := defaultDeliveryLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			http.Error(w, fmt.Sprintf("Invalid limit %q", value), http.StatusBadRequest)
+			return
+		}
+	}
+
+	deliveries, err := s.dispatcher.GetDeliveries(id, limit)
+	if err != nil {
+		http.Error(w, err.Error(), webhookErrorStatus(err))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, deliveries)
+}
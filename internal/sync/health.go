@@ -0,0 +1,121 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"netmaker-sync/internal/db"
+	"netmaker-sync/internal/models"
+	gosync "sync"
+	"time"
+)
+
+// errNoSuccessfulRun is reported by the sync check until a full sync run succeeds
+var errNoSuccessfulRun = errors.New("no full sync run has succeeded yet")
+
+// Readiness checks the dependencies needed to serve traffic: the database is reachable
+// and fully migrated, a full sync run succeeded less than StaleIntervals sync intervals
+// ago, and the Netmaker API is reachable. The checks run concurrently.
+func (s *Service) Readiness(ctx context.Context) *models.Readiness {
+	checks := []struct {
+		name  string
+		check func(ctx context.Context) error
+	}{
+		{"database", s.db.PingContext},
+		{"migrations", s.checkMigrations},
+		{"sync", s.checkFreshness},
+		{"netmaker_api", s.apiClient.GetStatus},
+	}
+
+	readiness := &models.Readiness{Ready: true, Checks: make([]models.ReadinessCheck, len(checks))}
+	var wg gosync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, name string, check func(ctx context.Context) error) {
+			defer wg.Done()
+			result := models.ReadinessCheck{Name: name, Healthy: true}
+			if err := check(ctx); err != nil {
+				result.Healthy = false
+				result.Message = err.Error()
+			}
+			readiness.Checks[i] = result
+		}(i, c.name, c.check)
+	}
+	wg.Wait()
+
+	for _, check := range readiness.Checks {
+		if !check.Healthy {
+			readiness.Ready = false
+		}
+	}
+	return readiness
+}
+
+// checkMigrations fails if the schema is behind the migrations of this binary
+func (s *Service) checkMigrations(ctx context.Context) error {
+	pending, err := s.db.PendingMigrations()
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%w: %d migration(s) to apply", db.ErrPendingMigrations, len(pending))
+	}
+	return nil
+}
+
+// checkFreshness fails if no full sync run has succeeded within StaleIntervals sync
+// intervals, so that a replica serving a stale mirror stops receiving traffic
+func (s *Service) checkFreshness(ctx context.Context) error {
+	run, err := s.db.GetLastSuccessfulSyncRun(models.SyncScopeAll)
+	if err != nil {
+		return err
+	}
+	if run == nil || run.CompletedAt == nil {
+		return errNoSuccessfulRun
+	}
+
+	intervals := s.cfg.StaleIntervals
+	if intervals < 1 {
+		intervals = 1
+	}
+	maxAge := time.Duration(intervals) * s.cfg.Interval
+	if age := time.Since(*run.CompletedAt); age > maxAge {
+		return fmt.Errorf("last successful sync run %d finished %s ago, more than %s", run.ID, age.Round(time.Second), maxAge)
+	}
+	return nil
+}
+
+// GetSyncStatus summarizes the last successful full sync run and the last sync of each
+// resource type
+func (s *Service) GetSyncStatus(ctx context.Context) (*models.SyncStatusSummary, error) {
+	run, err := s.db.GetLastSuccessfulSyncRun(models.SyncScopeAll)
+	if err != nil {
+		return nil, err
+	}
+	latest, err := s.db.GetLatestSyncHistory(false)
+	if err != nil {
+		return nil, err
+	}
+	successful, err := s.db.GetLatestSyncHistory(true)
+	if err != nil {
+		return nil, err
+	}
+
+	lastSuccessfulAt := make(map[string]*time.Time, len(successful))
+	for _, syncHistory := range successful {
+		lastSuccessfulAt[syncHistory.ResourceType] = syncHistory.CompletedAt
+	}
+
+	summary := &models.SyncStatusSummary{
+		LastSuccessfulRun: run,
+		Resources:         make([]models.ResourceSyncStatus, 0, len(latest)),
+	}
+	for _, syncHistory := range latest {
+		summary.Resources = append(summary.Resources, models.ResourceSyncStatus{
+			ResourceType:     syncHistory.ResourceType,
+			LastSync:         syncHistory,
+			LastSuccessfulAt: lastSuccessfulAt[syncHistory.ResourceType],
+		})
+	}
+	return summary, nil
+}
@@ -0,0 +1,133 @@
+package sync
+
+import (
+	"context"
+	"math"
+	"netmaker-sync/internal/models"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Netmaker check-in times, in Unix seconds, found in the data of nodes
+const (
+	markerLastCheckIn    = "lastcheckin"
+	markerLastPeerUpdate = "lastpeerupdate"
+)
+
+// unixTime converts a Netmaker time in Unix seconds, or nil if it is unset
+func unixTime(seconds int64) *time.Time {
+	if seconds <= 0 {
+		return nil
+	}
+	t := time.Unix(seconds, 0).UTC()
+	return &t
+}
+
+// recordHeartbeats appends the check-in state of the nodes of a network fetched from
+// the API. A failure is logged rather than failing the sync of the nodes.
+func (s *Service) recordHeartbeats(ctx context.Context, networkID string, nodes []models.Node) {
+	observedAt := time.Now()
+	heartbeats := make([]models.NodeHeartbeat, 0, len(nodes))
+	for _, node := range nodes {
+		heartbeats = append(heartbeats, models.NodeHeartbeat{
+			NodeID:         node.ID,
+			NetworkID:      networkID,
+			Connected:      node.Connected,
+			LastCheckIn:    unixTime(changeMarker(node.Data, markerLastCheckIn)),
+			LastPeerUpdate: unixTime(changeMarker(node.Data, markerLastPeerUpdate)),
+			ObservedAt:     observedAt,
+		})
+	}
+
+	if err := s.db.CreateNodeHeartbeats(ctx, heartbeats); err != nil {
+		logrus.Errorf("Failed to record heartbeats of the nodes of network %s: %v", networkID, err)
+	}
+}
+
+// pruneHeartbeats deletes the heartbeats older than HeartbeatRetention
+func (s *Service) pruneHeartbeats() {
+	if s.cfg.HeartbeatRetention <= 0 {
+		return
+	}
+
+	pruned, err := s.db.PruneNodeHeartbeats(time.Now().Add(-s.cfg.HeartbeatRetention))
+	if err != nil {
+		logrus.Errorf("Failed to prune node heartbeats: %v", err)
+		return
+	}
+	if pruned > 0 {
+		logrus.Debugf("Pruned %d node heartbeat(s)", pruned)
+	}
+}
+
+// nodeUp reports whether a node was connected and had checked in within
+// HeartbeatStaleAfter when a heartbeat was observed
+func (s *Service) nodeUp(heartbeat models.NodeHeartbeat) bool {
+	return heartbeat.Connected && heartbeat.LastCheckIn != nil &&
+		heartbeat.ObservedAt.Sub(*heartbeat.LastCheckIn) <= s.cfg.HeartbeatStaleAfter
+}
+
+// GetStaleNodes retrieves the current nodes, of one network or of all of them when
+// networkID is empty, whose last observed check-in is older than olderThan, or than
+// HeartbeatStaleAfter when olderThan is 0
+func (s *Service) GetStaleNodes(ctx context.Context, networkID string, olderThan time.Duration) ([]models.StaleNode, error) {
+	if olderThan <= 0 {
+		olderThan = s.cfg.HeartbeatStaleAfter
+	}
+
+	heartbeats, err := s.db.GetLatestNodeHeartbeats(networkID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	stale := []models.StaleNode{}
+	for _, heartbeat := range heartbeats {
+		if heartbeat.LastCheckIn == nil {
+			stale = append(stale, models.StaleNode{NodeHeartbeat: heartbeat})
+			continue
+		}
+		if silent := now.Sub(*heartbeat.LastCheckIn); silent > olderThan {
+			seconds := int64(silent.Seconds())
+			stale = append(stale, models.StaleNode{NodeHeartbeat: heartbeat, SilentSeconds: &seconds})
+		}
+	}
+	return stale, nil
+}
+
+// GetNodeHeartbeats retrieves the heartbeats of a node observed since a time, oldest first
+func (s *Service) GetNodeHeartbeats(ctx context.Context, nodeID string, since time.Time) ([]models.NodeHeartbeat, error) {
+	return s.db.GetNodeHeartbeats(nodeID, since)
+}
+
+// GetNodeUptime computes the daily uptime of a node over the last days UTC days,
+// including today, as the share of its heartbeats in which it was up. Days without
+// heartbeats are left out.
+func (s *Service) GetNodeUptime(ctx context.Context, nodeID string, days int) ([]models.NodeUptime, error) {
+	since := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, 1-days)
+	heartbeats, err := s.db.GetNodeHeartbeats(nodeID, since)
+	if err != nil {
+		return nil, err
+	}
+
+	uptime := []models.NodeUptime{}
+	up := []int{}
+	for _, heartbeat := range heartbeats {
+		date := heartbeat.ObservedAt.UTC().Format(time.DateOnly)
+		if len(uptime) == 0 || uptime[len(uptime)-1].Date != date {
+			uptime = append(uptime, models.NodeUptime{Date: date})
+			up = append(up, 0)
+		}
+		uptime[len(uptime)-1].Heartbeats++
+		if s.nodeUp(heartbeat) {
+			up[len(up)-1]++
+		}
+	}
+
+	for i := range uptime {
+		percent := 100 * float64(up[i]) / float64(uptime[i].Heartbeats)
+		uptime[i].UptimePercent = math.Round(percent*100) / 100
+	}
+	return uptime, nil
+}
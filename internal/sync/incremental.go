@@ -0,0 +1,121 @@
+package sync
+
+import (
+	"context"
+	"netmaker-sync/internal/models"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Netmaker change markers, in Unix seconds, found in the data of synced records
+const (
+	markerNodesLastModified = "nodeslastmodified"
+	markerLastModified      = "lastmodified"
+)
+
+// changeMarker returns a Netmaker change marker from the data of a record, or 0 if it is missing
+func changeMarker(data models.JSONB, key string) int64 {
+	switch value := data[key].(type) {
+	case float64:
+		return int64(value)
+	case int64:
+		return value
+	case int:
+		return int64(value)
+	default:
+		return 0
+	}
+}
+
+// networkUnchanged reports whether the nodes, external clients and DNS entries of a
+// network can be skipped because its nodeslastmodified marker has not moved since the
+// last successful sync. A full sync is still forced once FullSyncInterval has passed.
+func (s *Service) networkUnchanged(network models.Network) bool {
+	if !s.cfg.Incremental {
+		return false
+	}
+
+	marker := changeMarker(models.LatestData(network.Data, network.ObservedData), markerNodesLastModified)
+	if marker == 0 {
+		return false
+	}
+
+	cursor, err := s.db.GetSyncCursor(network.ID)
+	if err != nil {
+		logrus.Warnf("Failed to get sync cursor for network %s, doing a full sync: %v", network.ID, err)
+		return false
+	}
+	if cursor == nil || cursor.NodesLastModified != marker {
+		return false
+	}
+
+	if s.cfg.FullSyncInterval > 0 && time.Since(cursor.SyncedAt) >= s.cfg.FullSyncInterval {
+		logrus.Debugf("Full sync of network %s is due", network.ID)
+		return false
+	}
+
+	return true
+}
+
+// saveCursor records the marker a network was successfully synced at
+func (s *Service) saveCursor(network models.Network) {
+	marker := changeMarker(models.LatestData(network.Data, network.ObservedData), markerNodesLastModified)
+	if marker == 0 {
+		return
+	}
+
+	cursor := &models.SyncCursor{
+		NetworkID:         network.ID,
+		NodesLastModified: marker,
+		SyncedAt:          time.Now(),
+	}
+	if err := s.db.SaveSyncCursor(cursor); err != nil {
+		logrus.Errorf("Failed to save sync cursor for network %s: %v", network.ID, err)
+	}
+}
+
+// unchangedRecord reports whether a record from the API carries the same lastmodified
+// marker as its stored version in markers, so it can be skipped without comparing it
+func (s *Service) unchangedRecord(markers map[string]int64, id string, data models.JSONB) bool {
+	if !s.cfg.Incremental {
+		return false
+	}
+
+	marker := changeMarker(data, markerLastModified)
+	return marker != 0 && markers[id] == marker
+}
+
+// refreshSkippedNodes fetches the nodes of a network skipped by an incremental sync to
+// record their heartbeats and refresh their volatile fields, since check-ins do not move
+// the network's nodeslastmodified marker. Failures are logged rather than failing the
+// sync of the network.
+func (s *Service) refreshSkippedNodes(ctx context.Context, networkID string) {
+	nodes, err := s.apiClient.GetNodes(ctx, networkID)
+	if err != nil {
+		logrus.Errorf("Failed to get nodes of skipped network %s: %v", networkID, err)
+		return
+	}
+	s.recordHeartbeats(ctx, networkID, nodes)
+
+	currentNodes, err := s.db.GetNodes(networkID)
+	if err != nil {
+		logrus.Errorf("Failed to get current nodes of skipped network %s: %v", networkID, err)
+		return
+	}
+	stored := make(map[string]models.Node, len(currentNodes))
+	for _, node := range currentNodes {
+		stored[node.ID] = node
+	}
+
+	// Nodes that are not stored yet are left to the next full fetch
+	for _, node := range nodes {
+		current, ok := stored[node.ID]
+		if !ok {
+			continue
+		}
+		if err := s.db.RefreshVolatileFields(ctx, "nodes", node.ID, current.Data, current.ObservedData, node.Data); err != nil {
+			logrus.Errorf("Failed to refresh node %s of skipped network %s: %v", node.ID, networkID, err)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package sync
+
+import (
+	"slices"
+	"testing"
+)
+
+// network returns the API body of a network with a nodeslastmodified marker
+func network(id string, nodesLastModified int64) map[string]interface{} {
+	return map[string]interface{}{"netid": id, "nodeslastmodified": nodesLastModified}
+}
+
+// node returns the API body of a node
+func node(id, networkID string, lastModified int64) map[string]interface{} {
+	return map[string]interface{}{"id": id, "network": networkID, "lastmodified": lastModified, "connected": true}
+}
+
+func TestIncrementalSyncFollowsMarkerChanges(t *testing.T) {
+	s, database, fake := newTestService(t)
+
+	fake.respond("/api/networks", []interface{}{network("net1", 1000)})
+	fake.respond("/api/nodes/net1", []interface{}{node("n1", "net1", 1000)})
+	syncAll(t, s, false)
+
+	// Nothing changed, so the network is skipped
+	fake.respond("/api/nodes/net1", []interface{}{node("n1", "net1", 1000), node("n2", "net1", 1500)})
+	syncAll(t, s, false)
+	if nodes, _ := database.GetNodes("net1"); len(nodes) != 1 {
+		t.Fatalf("got %d nodes after a sync with an unchanged marker, want 1", len(nodes))
+	}
+
+	tests := []struct {
+		name   string
+		marker int64
+		nodes  []interface{}
+		want   []string
+	}{
+		{
+			name:   "node added",
+			marker: 2000,
+			nodes:  []interface{}{node("n1", "net1", 1000), node("n2", "net1", 2000)},
+			want:   []string{"n1", "n2"},
+		},
+		{
+			name:   "node removed",
+			marker: 3000,
+			nodes:  []interface{}{node("n2", "net1", 2000)},
+			want:   []string{"n2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake.respond("/api/networks", []interface{}{network("net1", tt.marker)})
+			fake.respond("/api/nodes/net1", tt.nodes)
+			syncAll(t, s, false)
+
+			nodes, err := database.GetNodes("net1")
+			if err != nil {
+				t.Fatalf("failed to get nodes: %v", err)
+			}
+			var got []string
+			for _, n := range nodes {
+				got = append(got, n.ID)
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("got nodes %v, want %v", got, tt.want)
+			}
+
+			cursor, err := database.GetSyncCursor("net1")
+			if err != nil || cursor == nil {
+				t.Fatalf("failed to get sync cursor: %v", err)
+			}
+			if cursor.NodesLastModified != tt.marker {
+				t.Errorf("got cursor %d, want %d", cursor.NodesLastModified, tt.marker)
+			}
+		})
+	}
+}
@@ -0,0 +1,250 @@
+package sync
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"netmaker-sync/internal/db"
+	"netmaker-sync/internal/models"
+	gosync "sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrJobNotFound is returned when a sync job does not exist or has expired
+var ErrJobNotFound = errors.New("sync job not found")
+
+// ErrJobFinished is returned when cancelling a sync job that has already finished
+var ErrJobFinished = errors.New("sync job already finished")
+
+// syncLockPollInterval is how often a queued job retries taking the sync lock
+const syncLockPollInterval = time.Second
+
+// jobRetention is how long finished jobs can still be looked up
+const jobRetention = 24 * time.Hour
+
+// jobKey is the context key of the job a sync runs for
+type jobKey struct{}
+
+// job is a sync requested through the HTTP API, run in the background
+type job struct {
+	mu     gosync.Mutex
+	model  models.SyncJob
+	run    *run
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// jobFromContext returns the job a sync runs for, or nil
+func jobFromContext(ctx context.Context) *job {
+	j, _ := ctx.Value(jobKey{}).(*job)
+	return j
+}
+
+// started records that the job's run has taken the sync lock
+func (j *job) started(r *run) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.run = r
+	j.model.Status = models.SyncStatusRunning
+	j.model.StartedAt = timePtr(r.model.StartedAt)
+}
+
+// finish records the outcome of the job
+func (j *job) finish(err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	switch {
+	case err == nil:
+		j.model.Status = models.SyncStatusCompleted
+	case errors.Is(err, ErrPartialSync):
+		j.model.Status = models.SyncStatusPartial
+		j.model.Message = err.Error()
+	case errors.Is(err, context.Canceled):
+		j.model.Status = models.SyncStatusCancelled
+		j.model.Message = err.Error()
+	default:
+		j.model.Status = models.SyncStatusFailed
+		j.model.Message = err.Error()
+	}
+	j.model.CompletedAt = timePtr(time.Now())
+}
+
+// snapshot returns a copy of the job and the counts of its run so far
+func (j *job) snapshot() *models.SyncJob {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	snapshot := j.model
+	snapshot.Counts = models.SyncRunCounts{}
+	if j.run != nil {
+		j.run.mu.Lock()
+		runID := j.run.model.ID
+		snapshot.RunID = &runID
+		for resourceType, counts := range j.run.model.Counts {
+			snapshot.Counts[resourceType] = counts
+		}
+		j.run.mu.Unlock()
+	}
+	return &snapshot
+}
+
+// StartJob starts a sync in the background and returns it as a job. scope is
+// models.SyncScopeAll, or the resource type to sync on its own. A job waits for
+// any run in progress to finish before starting its own.
+func (s *Service) StartJob(scope string, networkID string, includeAcls bool) (*models.SyncJob, error) {
+	var fn func(ctx context.Context) error
+	switch scope {
+	case models.SyncScopeAll:
+		fn = func(ctx context.Context) error { return s.SyncAll(ctx, includeAcls) }
+	case models.ResourceTypeNetwork:
+		fn = s.SyncNetworks
+	case models.ResourceTypeNode:
+		fn = func(ctx context.Context) error { return s.SyncNodes(ctx, networkID) }
+	default:
+		return nil, fmt.Errorf("unsupported sync job scope %q", scope)
+	}
+
+	id, err := generateJobID()
+	if err != nil {
+		return nil, err
+	}
+
+	j := &job{
+		model: models.SyncJob{
+			ID:        id,
+			Scope:     scope,
+			Status:    models.SyncStatusPending,
+			CreatedAt: time.Now(),
+		},
+		done: make(chan struct{}),
+	}
+	if networkID != "" {
+		j.model.NetworkID = &networkID
+	}
+
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), jobKey{}, j))
+	j.cancel = cancel
+
+	s.jobsMu.Lock()
+	s.pruneJobs()
+	s.jobs[id] = j
+	s.jobsMu.Unlock()
+
+	go func() {
+		defer close(j.done)
+		defer cancel()
+		err := fn(ctx)
+		j.finish(err)
+		if err != nil {
+			logrus.Errorf("Sync job %s failed: %v", id, err)
+		}
+	}()
+
+	logrus.Infof("Queued sync job %s (%s)", id, scope)
+	return j.snapshot(), nil
+}
+
+// GetJob retrieves the progress of a sync job
+func (s *Service) GetJob(id string) (*models.SyncJob, error) {
+	j, err := s.job(id)
+	if err != nil {
+		return nil, err
+	}
+	return j.snapshot(), nil
+}
+
+// WaitJob waits for a sync job to finish, or for ctx to be done
+func (s *Service) WaitJob(ctx context.Context, id string) (*models.SyncJob, error) {
+	j, err := s.job(id)
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case <-j.done:
+		return j.snapshot(), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// CancelJob cancels a sync job. The job stops asynchronously; its status becomes
+// cancelled once it has.
+func (s *Service) CancelJob(id string) (*models.SyncJob, error) {
+	j, err := s.job(id)
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case <-j.done:
+		return nil, fmt.Errorf("%w: %s", ErrJobFinished, id)
+	default:
+	}
+
+	j.cancel()
+	logrus.Infof("Cancelling sync job %s", id)
+	return j.snapshot(), nil
+}
+
+// CancelJobs cancels every sync job in progress
+func (s *Service) CancelJobs() {
+	s.jobsMu.Lock()
+	defer s.jobsMu.Unlock()
+	for _, j := range s.jobs {
+		j.cancel()
+	}
+}
+
+// job looks up a sync job by ID
+func (s *Service) job(id string) (*job, error) {
+	s.jobsMu.Lock()
+	defer s.jobsMu.Unlock()
+	j, ok := s.jobs[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrJobNotFound, id)
+	}
+	return j, nil
+}
+
+// pruneJobs forgets the jobs that finished more than jobRetention ago. The caller
+// must hold jobsMu.
+func (s *Service) pruneJobs() {
+	for id, j := range s.jobs {
+		j.mu.Lock()
+		expired := j.model.CompletedAt != nil && time.Since(*j.model.CompletedAt) > jobRetention
+		j.mu.Unlock()
+		if expired {
+			delete(s.jobs, id)
+		}
+	}
+}
+
+// acquireSyncLock takes the sync lock for a run. Jobs queue for it, retrying until
+// the run in progress finishes or the job is cancelled; other syncs give up at once.
+func (s *Service) acquireSyncLock(ctx context.Context) (func(), error) {
+	for {
+		release, err := s.db.AcquireSyncLock(ctx)
+		if !errors.Is(err, db.ErrSyncInProgress) || jobFromContext(ctx) == nil {
+			return release, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(syncLockPollInterval):
+		}
+	}
+}
+
+// generateJobID returns a random ID for a sync job
+func generateJobID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("failed to generate sync job ID: %w", err)
+	}
+	return hex.EncodeToString(id), nil
+}
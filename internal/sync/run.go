@@ -0,0 +1,236 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"netmaker-sync/internal/db"
+	"netmaker-sync/internal/metrics"
+	"netmaker-sync/internal/models"
+	"netmaker-sync/internal/tracing"
+	gosync "sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates the spans of the sync runs and of the resources synced in them
+var tracer = otel.Tracer("netmaker-sync/internal/sync")
+
+// run is the sync run in progress. It is shared by the goroutines syncing its
+// resources, which record what they did to each record in its counts.
+type run struct {
+	mu    gosync.Mutex
+	model models.SyncRun
+
+	// errors counts the errors recorded by the run, in total and by sync history ID
+	errors        int
+	historyErrors map[int]int
+}
+
+// ErrPartialSync is returned by a sync run that completed but recorded errors
+var ErrPartialSync = errors.New("sync completed with errors")
+
+// id returns the ID of the run for the sync history rows it groups
+func (r *run) id() *int {
+	id := r.model.ID
+	return &id
+}
+
+// count records the outcome of upserting one record
+func (r *run) count(resourceType string, result db.UpsertResult, err error) {
+	var counts models.ResourceCounts
+	switch {
+	case err != nil:
+		counts.Failed = 1
+	case result == db.UpsertCreated:
+		counts.Created = 1
+	case result == db.UpsertUpdated:
+		counts.Updated = 1
+	default:
+		counts.Unchanged = 1
+	}
+	r.add(resourceType, counts)
+}
+
+// errorsOf returns the number of errors recorded by a resource sync of the run
+func (r *run) errorsOf(syncHistory *models.SyncHistory) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.historyErrors[syncHistory.ID]
+}
+
+// add adds counts to those of a resource type
+func (r *run) add(resourceType string, counts models.ResourceCounts) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	total := r.model.Counts[resourceType]
+	total.Add(counts)
+	r.model.Counts[resourceType] = total
+	metrics.AddRecords(resourceType, counts)
+}
+
+// withRun runs fn as a new sync run, traced by a sync.run span. Unless the sync runs
+// for a job, it returns db.ErrSyncInProgress without running fn if another run, in this process or
+// another replica, is in progress.
+func (s *Service) withRun(ctx context.Context, scope string, networkID string, fn func(ctx context.Context, r *run) error) (err error) {
+	release, err := s.acquireSyncLock(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	// A run still marked as running cannot be, now that we hold the lock
+	if n, err := s.db.FailRunningSyncRuns("interrupted before completing"); err != nil {
+		logrus.Warnf("Failed to close interrupted sync runs: %v", err)
+	} else if n > 0 {
+		logrus.Warnf("Marked %d interrupted sync run(s) as failed", n)
+	}
+
+	r := &run{model: models.SyncRun{
+		Scope:     scope,
+		Status:    models.SyncStatusRunning,
+		Counts:    models.SyncRunCounts{},
+		StartedAt: time.Now(),
+	}, historyErrors: make(map[int]int)}
+	if networkID != "" {
+		r.model.NetworkID = &networkID
+	}
+	if err := s.db.CreateSyncRun(&r.model); err != nil {
+		return err
+	}
+	logrus.Infof("Started sync run %d (%s)", r.model.ID, scope)
+	ctx, span := tracer.Start(ctx, "sync.run", trace.WithAttributes(
+		attribute.Int("netmaker_sync.run_id", r.model.ID),
+		attribute.String("netmaker_sync.scope", scope),
+		attribute.String("netmaker.network_id", networkID),
+	))
+	defer func() { tracing.End(span, err) }()
+	if j := jobFromContext(ctx); j != nil {
+		j.started(r)
+	}
+
+	err = fn(ctx, r)
+	if err == nil && r.errors > 0 {
+		err = fmt.Errorf("%w: %d error(s) in sync run %d", ErrPartialSync, r.errors, r.model.ID)
+	}
+	s.finishRun(r, err)
+	return err
+}
+
+// recordError stores the failure of a record, or of a whole resource sync when
+// resourceID is empty, against the run and the sync history it happened in
+func (s *Service) recordError(r *run, syncHistory *models.SyncHistory, resourceType, networkID, resourceID, operation string, err error) {
+	syncError := &models.SyncError{
+		RunID:         r.model.ID,
+		SyncHistoryID: &syncHistory.ID,
+		ResourceType:  resourceType,
+		Operation:     operation,
+		Error:         err.Error(),
+		OccurredAt:    time.Now(),
+	}
+	if networkID != "" {
+		syncError.NetworkID = &networkID
+	}
+	if resourceID != "" {
+		syncError.ResourceID = &resourceID
+	}
+
+	r.mu.Lock()
+	r.errors++
+	r.historyErrors[syncHistory.ID]++
+	r.mu.Unlock()
+
+	if err := s.db.CreateSyncError(syncError); err != nil {
+		logrus.Errorf("Failed to record sync error of run %d: %v", r.model.ID, err)
+	}
+}
+
+// recordItemErrors records each record listed by db.ItemErrors, or the failure of the
+// whole operation for any other error. It returns the number of records that failed.
+func (s *Service) recordItemErrors(r *run, syncHistory *models.SyncHistory, resourceType, networkID, operation string, err error) int {
+	var items db.ItemErrors
+	if !errors.As(err, &items) {
+		s.recordError(r, syncHistory, resourceType, networkID, "", operation, err)
+		return 0
+	}
+
+	for _, item := range items {
+		s.recordError(r, syncHistory, resourceType, networkID, item.ID, operation, item.Err)
+	}
+	return len(items)
+}
+
+// deleteMissing runs one of the DeleteMissing functions of the store, counting the
+// records it marked as deleted and recording those it failed to. It returns the IDs
+// of the deleted records.
+func (s *Service) deleteMissing(r *run, syncHistory *models.SyncHistory, resourceType, networkID string, deleteFn func() ([]string, error)) []string {
+	deletedIDs, err := deleteFn()
+	counts := models.ResourceCounts{Deleted: len(deletedIDs)}
+	if err != nil {
+		logrus.Errorf("Failed to mark deleted %s records: %v", resourceType, err)
+		counts.Failed = s.recordItemErrors(r, syncHistory, resourceType, networkID, models.SyncOperationDelete, err)
+	}
+	r.add(resourceType, counts)
+	return deletedIDs
+}
+
+// saveRun stores the counts of the run so far
+func (s *Service) saveRun(r *run) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := s.db.UpdateSyncRun(&r.model); err != nil {
+		logrus.Errorf("Failed to save sync run %d: %v", r.model.ID, err)
+	}
+}
+
+// finishRun records the outcome of the run
+func (s *Service) finishRun(r *run, err error) {
+	r.mu.Lock()
+	switch {
+	case err == nil:
+		r.model.Status = models.SyncStatusCompleted
+	case errors.Is(err, ErrPartialSync):
+		r.model.Status = models.SyncStatusPartial
+		r.model.Message = err.Error()
+	default:
+		r.model.Status = models.SyncStatusFailed
+		r.model.Message = err.Error()
+	}
+	r.model.CompletedAt = timePtr(time.Now())
+	r.mu.Unlock()
+	s.saveRun(r)
+
+	for resourceType, counts := range r.model.Counts {
+		logrus.Infof("Sync run %d %s: %d created, %d updated, %d unchanged, %d deleted, %d failed",
+			r.model.ID, resourceType, counts.Created, counts.Updated, counts.Unchanged, counts.Deleted, counts.Failed)
+	}
+	logrus.Infof("Sync run %d %s in %s", r.model.ID, r.model.Status, r.model.CompletedAt.Sub(r.model.StartedAt).Round(time.Millisecond))
+	metrics.ObserveSyncRun(&r.model)
+	s.UpdateTopologyMetrics()
+}
+
+// UpdateTopologyMetrics refreshes the gauges of the current nodes, external clients,
+// hosts, enrollment keys and server configuration. Sync runs refresh them when they
+// finish.
+func (s *Service) UpdateTopologyMetrics() {
+	stats, err := s.db.GetTopologyStats()
+	if err != nil {
+		logrus.Errorf("Failed to update topology metrics: %v", err)
+		return
+	}
+	metrics.SetTopology(stats)
+}
+
+// GetSyncRuns retrieves the most recent sync runs, newest first
+func (s *Service) GetSyncRuns(ctx context.Context, limit int) ([]models.SyncRun, error) {
+	return s.db.GetSyncRuns(limit)
+}
+
+// GetSyncRun retrieves a sync run together with its per-resource sync history
+func (s *Service) GetSyncRun(ctx context.Context, id int) (*models.SyncRun, error) {
+	return s.db.GetSyncRun(id)
+}
@@ -3,12 +3,22 @@ package sync
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"netmaker-sync/internal/api"
+	"netmaker-sync/internal/config"
 	"netmaker-sync/internal/db"
+	"netmaker-sync/internal/diff"
+	"netmaker-sync/internal/metrics"
 	"netmaker-sync/internal/models"
+	"netmaker-sync/internal/tracing"
+	gosync "sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // timePtr returns a pointer to the given time
@@ -16,24 +26,84 @@ func timePtr(t time.Time) *time.Time {
 	return &t
 }
 
+// failSync records a sync as failed with the given error and returns the error
+func (s *Service) failSync(syncHistory *models.SyncHistory, err error) error {
+	syncHistory.Status = models.SyncStatusFailed
+	syncHistory.Message = err.Error()
+	syncHistory.CompletedAt = timePtr(time.Now())
+	s.db.UpdateSyncHistory(syncHistory)
+	metrics.ObserveResourceSync(syncHistory)
+	return err
+}
+
+// startSync records the start of syncing one resource type as part of a run
+func (s *Service) startSync(r *run, resourceType string) (*models.SyncHistory, error) {
+	syncHistory := &models.SyncHistory{
+		RunID:        r.id(),
+		ResourceType: resourceType,
+		Status:       models.SyncStatusPending,
+		StartedAt:    time.Now(),
+	}
+	if err := s.db.CreateSyncHistory(syncHistory); err != nil {
+		return nil, err
+	}
+	return syncHistory, nil
+}
+
+// completeSync records a sync as completed and saves the counts of its run. A sync
+// that recorded errors is recorded as partial, and returns ErrPartialSync.
+func (s *Service) completeSync(r *run, syncHistory *models.SyncHistory) error {
+	syncHistory.Status = models.SyncStatusCompleted
+	failures := r.errorsOf(syncHistory)
+	if failures > 0 {
+		syncHistory.Status = models.SyncStatusPartial
+		syncHistory.Message = fmt.Sprintf("%d error(s), see the errors of sync run %d", failures, r.model.ID)
+	}
+	syncHistory.CompletedAt = timePtr(time.Now())
+	s.saveRun(r)
+	metrics.ObserveResourceSync(syncHistory)
+	if err := s.db.UpdateSyncHistory(syncHistory); err != nil {
+		return err
+	}
+
+	if failures > 0 {
+		return fmt.Errorf("%w: %s", ErrPartialSync, syncHistory.Message)
+	}
+	return nil
+}
+
 // Service handles syncing data from Netmaker API to the database
 type Service struct {
 	apiClient *api.Client
-	db        *db.DB
+	db        db.Store
+	cfg       *config.SyncConfig
+	jobs      map[string]*job
+	jobsMu    gosync.Mutex
 }
 
 // New creates a new sync service
-func New(apiClient *api.Client, db *db.DB) *Service {
+func New(apiClient *api.Client, db db.Store, cfg *config.SyncConfig) *Service {
 	return &Service{
 		apiClient: apiClient,
 		db:        db,
+		cfg:       cfg,
+		jobs:      make(map[string]*job),
 	}
 }
 
-// SyncAll syncs all resources from Netmaker API to the database
+// SyncAll syncs all resources from Netmaker API to the database. Networks are synced
+// in parallel by up to Concurrency workers, and hosts alongside them. Cancelling ctx
+// stops dispatching networks and aborts the syncs in progress.
 func (s *Service) SyncAll(ctx context.Context, includeAcls bool) error {
-	// Start with networks
-	if err := s.SyncNetworks(ctx); err != nil {
+	return s.withRun(ctx, models.SyncScopeAll, "", func(ctx context.Context, r *run) error {
+		return s.syncAll(ctx, r, includeAcls)
+	})
+}
+
+// syncAll syncs every resource as part of a run
+func (s *Service) syncAll(ctx context.Context, r *run, includeAcls bool) error {
+	// Start with networks. Their resources are still synced if only some networks failed.
+	if err := s.syncNetworks(ctx, r); err != nil && !errors.Is(err, ErrPartialSync) {
 		return err
 	}
 
@@ -43,247 +113,725 @@ func (s *Service) SyncAll(ctx context.Context, includeAcls bool) error {
 		return err
 	}
 
+	var wg gosync.WaitGroup
+
+	// Hosts, enrollment keys, users and the server configuration are not tied to a
+	// network, so sync them alongside the networks
+	wg.Add(4)
+	go func() {
+		defer wg.Done()
+		if err := s.syncServerConfig(ctx, r); err != nil {
+			logrus.Errorf("Failed to sync server config: %v", err)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		if err := s.syncHosts(ctx, r); err != nil {
+			logrus.Errorf("Failed to sync hosts: %v", err)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		if err := s.syncEnrollmentKeys(ctx, r); err != nil {
+			logrus.Errorf("Failed to sync enrollment keys: %v", err)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		// The gateway assignments are derived from the users, so they are still synced if
+		// only some users failed
+		if err := s.syncUsers(ctx, r); err != nil {
+			logrus.Errorf("Failed to sync users: %v", err)
+			if !errors.Is(err, ErrPartialSync) {
+				return
+			}
+		}
+		if err := s.syncUserGatewayAssignments(ctx, r); err != nil {
+			logrus.Errorf("Failed to sync user gateway assignments: %v", err)
+		}
+	}()
+
 	// For each network, sync nodes, ext clients, DNS entries, and ACLs
+	var skipped atomic.Int32
+	workers := make(chan struct{}, s.concurrency())
+dispatch:
 	for _, network := range networks {
-		if err := s.SyncNodes(ctx, network.ID); err != nil {
-			logrus.Errorf("Failed to sync nodes for network %s: %v", network.ID, err)
+		select {
+		case <-ctx.Done():
+			break dispatch
+		case workers <- struct{}{}:
 		}
 
-		if err := s.SyncExtClients(ctx, network.ID); err != nil {
-			logrus.Errorf("Failed to sync ext clients for network %s: %v", network.ID, err)
-		}
+		wg.Add(1)
+		go func(network models.Network) {
+			defer wg.Done()
+			defer func() { <-workers }()
+			if !s.syncNetworkResources(ctx, r, network, includeAcls) {
+				skipped.Add(1)
+			}
+		}(network)
+	}
+	wg.Wait()
 
-		if err := s.SyncDNSEntries(ctx, network.ID); err != nil {
-			logrus.Errorf("Failed to sync DNS entries for network %s: %v", network.ID, err)
+	if skipped.Load() > 0 {
+		logrus.Infof("Skipped %d of %d unchanged networks", skipped.Load(), len(networks))
+	}
+
+	s.pruneHeartbeats()
+	return ctx.Err()
+}
+
+// concurrency returns the number of networks synced in parallel
+func (s *Service) concurrency() int {
+	if s.cfg.Concurrency < 1 {
+		return 1
+	}
+	return s.cfg.Concurrency
+}
+
+// syncNetworkResources syncs the nodes, external clients, DNS entries and, optionally, the
+// ACLs of a network concurrently. It returns false if the network was skipped because its
+// nodes have not changed since the last sync; ACLs are still synced in that case.
+func (s *Service) syncNetworkResources(ctx context.Context, r *run, network models.Network, includeAcls bool) bool {
+	// Skip networks whose nodes have not changed in Netmaker since the last sync
+	unchanged := s.networkUnchanged(network)
+	ctx, span := tracer.Start(ctx, "sync.network", trace.WithAttributes(
+		attribute.String("netmaker.network_id", network.ID),
+		attribute.Bool("netmaker_sync.skipped", unchanged),
+	))
+	defer span.End()
+	if unchanged {
+		logrus.Debugf("Network %s unchanged since last sync, skipping nodes, ext clients and DNS entries", network.ID)
+	}
+
+	var wg gosync.WaitGroup
+	var failed atomic.Bool
+
+	// ACLs refer to nodes by name, so they are synced once the nodes are stored
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if !unchanged {
+			if err := s.syncNodes(ctx, r, network.ID); err != nil {
+				logrus.Errorf("Failed to sync nodes for network %s: %v", network.ID, err)
+				failed.Store(true)
+			}
+		} else {
+			// Nodes check in without changing, so their check-ins are recorded regardless
+			s.refreshSkippedNodes(ctx, network.ID)
 		}
 
 		if includeAcls {
-			if err := s.SyncACLs(ctx, network.ID); err != nil {
+			if err := s.syncACLs(ctx, r, network.ID); err != nil {
 				logrus.Errorf("Failed to sync ACLs for network %s: %v", network.ID, err)
 			}
 		}
+	}()
+
+	if !unchanged {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if err := s.syncExtClients(ctx, r, network.ID); err != nil {
+				logrus.Errorf("Failed to sync ext clients for network %s: %v", network.ID, err)
+				failed.Store(true)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if err := s.syncDNSEntries(ctx, r, network.ID); err != nil {
+				logrus.Errorf("Failed to sync DNS entries for network %s: %v", network.ID, err)
+				failed.Store(true)
+			}
+		}()
 	}
+	wg.Wait()
 
-	// Sync hosts
-	if err := s.SyncHosts(ctx); err != nil {
-		logrus.Errorf("Failed to sync hosts: %v", err)
+	// Only advance the cursor once everything behind it has been synced
+	if !unchanged && !failed.Load() && ctx.Err() == nil {
+		s.saveCursor(network)
 	}
 
-	return nil
+	return !unchanged
 }
 
-// SyncNetworks syncs networks from Netmaker API to the database
+// SyncNetworks syncs networks from Netmaker API to the database as a sync run of its own
 func (s *Service) SyncNetworks(ctx context.Context) error {
+	return s.withRun(ctx, models.ResourceTypeNetwork, "", func(ctx context.Context, r *run) error {
+		return s.syncNetworks(ctx, r)
+	})
+}
+
+// syncNetworks syncs the networks as part of a run
+func (s *Service) syncNetworks(ctx context.Context, r *run) (err error) {
+	ctx, span := tracer.Start(ctx, "sync.networks")
+	defer func() { tracing.End(span, err) }()
+
 	// Record sync start
-	syncHistory := &models.SyncHistory{
-		ResourceType: models.ResourceTypeNetwork,
-		Status:       models.SyncStatusPending,
-		StartedAt:    time.Now(),
-	}
-	if err := s.db.CreateSyncHistory(syncHistory); err != nil {
+	syncHistory, err := s.startSync(r, models.ResourceTypeNetwork)
+	if err != nil {
 		return err
 	}
 
 	// Get networks from API
-	networks, err := s.apiClient.GetNetworks()
+	networks, err := s.apiClient.GetNetworks(ctx)
 	if err != nil {
 		// Record sync failure
-		syncHistory.Status = models.SyncStatusFailed
-		syncHistory.Message = err.Error()
-		syncHistory.CompletedAt = timePtr(time.Now())
-		s.db.UpdateSyncHistory(syncHistory)
-		return err
+		s.recordError(r, syncHistory, models.ResourceTypeNetwork, "", "", models.SyncOperationFetch, err)
+		return s.failSync(syncHistory, err)
 	}
 
 	// Upsert networks to database
+	seenIDs := make([]string, 0, len(networks))
 	for _, network := range networks {
-		if err := s.db.UpsertNetwork(&network); err != nil {
+		// Stop without marking anything as deleted if the sync is cancelled part way through
+		if err := ctx.Err(); err != nil {
+			return s.failSync(syncHistory, err)
+		}
+		seenIDs = append(seenIDs, network.ID)
+		result, err := s.db.UpsertNetwork(ctx, &network)
+		r.count(models.ResourceTypeNetwork, result, err)
+		if err != nil {
 			logrus.Errorf("Failed to upsert network %s: %v", network.ID, err)
+			s.recordError(r, syncHistory, models.ResourceTypeNetwork, "", network.ID, models.SyncOperationUpsert, err)
 		}
 	}
 
+	// Mark networks that are no longer returned by the API as deleted
+	deletedIDs := s.deleteMissing(r, syncHistory, models.ResourceTypeNetwork, "", func() ([]string, error) {
+		return s.db.DeleteMissingNetworks(ctx, seenIDs)
+	})
+
+	// Resources of a deleted network are gone with it
+	for _, networkID := range deletedIDs {
+		s.deleteNetworkResources(ctx, r, syncHistory, networkID)
+	}
+
 	// Record sync completion
-	syncHistory.Status = models.SyncStatusCompleted
-	syncHistory.CompletedAt = timePtr(time.Now())
-	return s.db.UpdateSyncHistory(syncHistory)
+	return s.completeSync(r, syncHistory)
+}
+
+// deleteNetworkResources marks every node, external client, DNS entry and ACL of a deleted network as deleted
+func (s *Service) deleteNetworkResources(ctx context.Context, r *run, syncHistory *models.SyncHistory, networkID string) {
+	s.deleteMissing(r, syncHistory, models.ResourceTypeNode, networkID, func() ([]string, error) {
+		return s.db.DeleteMissingNodes(ctx, networkID, nil)
+	})
+	s.deleteMissing(r, syncHistory, models.ResourceTypeExtClient, networkID, func() ([]string, error) {
+		return s.db.DeleteMissingExtClients(ctx, networkID, nil)
+	})
+	s.deleteMissing(r, syncHistory, models.ResourceTypeDNS, networkID, func() ([]string, error) {
+		return s.db.DeleteMissingDNSEntries(ctx, networkID, nil)
+	})
+	s.deleteMissing(r, syncHistory, models.ResourceTypeACL, networkID, func() ([]string, error) {
+		return s.db.DeleteMissingACLs(ctx, networkID, nil)
+	})
+
+	if err := s.db.DeleteSyncCursor(networkID); err != nil {
+		logrus.Errorf("Failed to delete sync cursor of deleted network %s: %v", networkID, err)
+	}
 }
 
-// SyncNodes syncs nodes for a network from Netmaker API to the database
+// SyncNodes syncs nodes for a network from Netmaker API to the database as a sync run of its own
 func (s *Service) SyncNodes(ctx context.Context, networkID string) error {
+	return s.withRun(ctx, models.ResourceTypeNode, networkID, func(ctx context.Context, r *run) error {
+		return s.syncNodes(ctx, r, networkID)
+	})
+}
+
+// syncNodes syncs the nodes as part of a run
+func (s *Service) syncNodes(ctx context.Context, r *run, networkID string) (err error) {
+	ctx, span := tracer.Start(ctx, "sync.nodes", trace.WithAttributes(attribute.String("netmaker.network_id", networkID)))
+	defer func() { tracing.End(span, err) }()
+
 	// Record sync start
-	syncHistory := &models.SyncHistory{
-		ResourceType: models.ResourceTypeNode,
-		Status:       models.SyncStatusPending,
-		StartedAt:    time.Now(),
-	}
-	if err := s.db.CreateSyncHistory(syncHistory); err != nil {
+	syncHistory, err := s.startSync(r, models.ResourceTypeNode)
+	if err != nil {
 		return err
 	}
 
 	// Get nodes from API
-	nodes, err := s.apiClient.GetNodes(networkID)
+	nodes, err := s.apiClient.GetNodes(ctx, networkID)
 	if err != nil {
 		// Record sync failure
-		syncHistory.Status = models.SyncStatusFailed
-		syncHistory.Message = err.Error()
-		syncHistory.CompletedAt = timePtr(time.Now())
-		s.db.UpdateSyncHistory(syncHistory)
-		return err
+		s.recordError(r, syncHistory, models.ResourceTypeNode, networkID, "", models.SyncOperationFetch, err)
+		return s.failSync(syncHistory, err)
+	}
+
+	// Record when each node last checked in, whether or not it changed
+	s.recordHeartbeats(ctx, networkID, nodes)
+
+	// Skip the comparison of nodes Netmaker has not modified since they were stored
+	markers := make(map[string]int64)
+	stored := make(map[string]models.Node)
+	if currentNodes, err := s.db.GetNodes(networkID); err == nil {
+		for _, node := range currentNodes {
+			markers[node.ID] = changeMarker(models.LatestData(node.Data, node.ObservedData), markerLastModified)
+			stored[node.ID] = node
+		}
+	} else {
+		logrus.Warnf("Failed to get current nodes for network %s, comparing every node: %v", networkID, err)
 	}
 
 	// Upsert nodes to database
+	seenIDs := make([]string, 0, len(nodes))
 	for _, node := range nodes {
-		if err := s.db.UpsertNode(&node); err != nil {
+		// Stop without marking anything as deleted if the sync is cancelled part way through
+		if err := ctx.Err(); err != nil {
+			return s.failSync(syncHistory, err)
+		}
+		seenIDs = append(seenIDs, node.ID)
+
+		var (
+			result db.UpsertResult
+			err    error
+		)
+		if s.unchangedRecord(markers, node.ID, node.Data) {
+			// Only the volatile fields can have changed
+			current := stored[node.ID]
+			err = s.db.RefreshVolatileFields(ctx, "nodes", node.ID, current.Data, current.ObservedData, node.Data)
+		} else {
+			result, err = s.db.UpsertNode(ctx, &node)
+		}
+		r.count(models.ResourceTypeNode, result, err)
+		if err != nil {
 			logrus.Errorf("Failed to upsert node %s: %v", node.ID, err)
+			s.recordError(r, syncHistory, models.ResourceTypeNode, networkID, node.ID, models.SyncOperationUpsert, err)
 		}
 	}
 
+	// Mark nodes that are no longer returned by the API as deleted
+	s.deleteMissing(r, syncHistory, models.ResourceTypeNode, networkID, func() ([]string, error) {
+		return s.db.DeleteMissingNodes(ctx, networkID, seenIDs)
+	})
+
 	// Record sync completion
-	syncHistory.Status = models.SyncStatusCompleted
-	syncHistory.CompletedAt = timePtr(time.Now())
-	return s.db.UpdateSyncHistory(syncHistory)
+	return s.completeSync(r, syncHistory)
 }
 
-// SyncExtClients syncs external clients for a network from Netmaker API to the database
+// SyncExtClients syncs external clients for a network from Netmaker API to the database as a sync run of its own
 func (s *Service) SyncExtClients(ctx context.Context, networkID string) error {
+	return s.withRun(ctx, models.ResourceTypeExtClient, networkID, func(ctx context.Context, r *run) error {
+		return s.syncExtClients(ctx, r, networkID)
+	})
+}
+
+// syncExtClients syncs the external clients as part of a run
+func (s *Service) syncExtClients(ctx context.Context, r *run, networkID string) (err error) {
+	ctx, span := tracer.Start(ctx, "sync.ext_clients", trace.WithAttributes(attribute.String("netmaker.network_id", networkID)))
+	defer func() { tracing.End(span, err) }()
+
 	// Record sync start
-	syncHistory := &models.SyncHistory{
-		ResourceType: models.ResourceTypeExtClient,
-		Status:       models.SyncStatusPending,
-		StartedAt:    time.Now(),
-	}
-	if err := s.db.CreateSyncHistory(syncHistory); err != nil {
+	syncHistory, err := s.startSync(r, models.ResourceTypeExtClient)
+	if err != nil {
 		return err
 	}
 
 	// Get external clients from API
-	extClients, err := s.apiClient.GetExtClients(networkID)
+	extClients, err := s.apiClient.GetExtClients(ctx, networkID)
 	if err != nil {
 		// Record sync failure
-		syncHistory.Status = models.SyncStatusFailed
-		syncHistory.Message = err.Error()
-		syncHistory.CompletedAt = timePtr(time.Now())
-		s.db.UpdateSyncHistory(syncHistory)
-		return err
+		s.recordError(r, syncHistory, models.ResourceTypeExtClient, networkID, "", models.SyncOperationFetch, err)
+		return s.failSync(syncHistory, err)
+	}
+
+	// Skip the comparison of external clients Netmaker has not modified since they were stored
+	markers := make(map[string]int64)
+	stored := make(map[string]models.ExtClient)
+	if currentExtClients, err := s.db.GetExtClients(networkID); err == nil {
+		for _, extClient := range currentExtClients {
+			markers[extClient.ID] = changeMarker(models.LatestData(extClient.Data, extClient.ObservedData), markerLastModified)
+			stored[extClient.ID] = extClient
+		}
+	} else {
+		logrus.Warnf("Failed to get current external clients for network %s, comparing every client: %v", networkID, err)
 	}
 
 	// Upsert external clients to database
+	seenIDs := make([]string, 0, len(extClients))
 	for _, extClient := range extClients {
-		if err := s.db.UpsertExtClient(&extClient); err != nil {
+		// Stop without marking anything as deleted if the sync is cancelled part way through
+		if err := ctx.Err(); err != nil {
+			return s.failSync(syncHistory, err)
+		}
+		seenIDs = append(seenIDs, extClient.ID)
+
+		var (
+			result db.UpsertResult
+			err    error
+		)
+		if s.unchangedRecord(markers, extClient.ID, extClient.Data) {
+			// Only the volatile fields can have changed
+			current := stored[extClient.ID]
+			err = s.db.RefreshVolatileFields(ctx, "ext_clients", extClient.ID, current.Data, current.ObservedData, extClient.Data)
+		} else {
+			result, err = s.db.UpsertExtClient(ctx, &extClient)
+		}
+		r.count(models.ResourceTypeExtClient, result, err)
+		if err != nil {
 			logrus.Errorf("Failed to upsert external client %s: %v", extClient.ID, err)
+			s.recordError(r, syncHistory, models.ResourceTypeExtClient, networkID, extClient.ID, models.SyncOperationUpsert, err)
 		}
 	}
 
+	// Mark external clients that are no longer returned by the API as deleted
+	s.deleteMissing(r, syncHistory, models.ResourceTypeExtClient, networkID, func() ([]string, error) {
+		return s.db.DeleteMissingExtClients(ctx, networkID, seenIDs)
+	})
+
 	// Record sync completion
-	syncHistory.Status = models.SyncStatusCompleted
-	syncHistory.CompletedAt = timePtr(time.Now())
-	return s.db.UpdateSyncHistory(syncHistory)
+	return s.completeSync(r, syncHistory)
 }
 
-// SyncDNSEntries syncs DNS entries for a network from Netmaker API to the database
+// SyncDNSEntries syncs DNS entries for a network from Netmaker API to the database as a sync run of its own
 func (s *Service) SyncDNSEntries(ctx context.Context, networkID string) error {
+	return s.withRun(ctx, models.ResourceTypeDNS, networkID, func(ctx context.Context, r *run) error {
+		return s.syncDNSEntries(ctx, r, networkID)
+	})
+}
+
+// syncDNSEntries syncs the DNS entries as part of a run
+func (s *Service) syncDNSEntries(ctx context.Context, r *run, networkID string) (err error) {
+	ctx, span := tracer.Start(ctx, "sync.dns_entries", trace.WithAttributes(attribute.String("netmaker.network_id", networkID)))
+	defer func() { tracing.End(span, err) }()
+
 	// Record sync start
-	syncHistory := &models.SyncHistory{
-		ResourceType: models.ResourceTypeDNS,
-		Status:       models.SyncStatusPending,
-		StartedAt:    time.Now(),
-	}
-	if err := s.db.CreateSyncHistory(syncHistory); err != nil {
+	syncHistory, err := s.startSync(r, models.ResourceTypeDNS)
+	if err != nil {
 		return err
 	}
 
 	// Get DNS entries from API
-	dnsEntries, err := s.apiClient.GetDNSEntries(networkID)
+	dnsEntries, err := s.apiClient.GetDNSEntries(ctx, networkID)
 	if err != nil {
 		// Record sync failure
-		syncHistory.Status = models.SyncStatusFailed
-		syncHistory.Message = err.Error()
-		syncHistory.CompletedAt = timePtr(time.Now())
-		s.db.UpdateSyncHistory(syncHistory)
-		return err
+		s.recordError(r, syncHistory, models.ResourceTypeDNS, networkID, "", models.SyncOperationFetch, err)
+		return s.failSync(syncHistory, err)
 	}
 
 	// Upsert DNS entries to database
+	seenIDs := make([]string, 0, len(dnsEntries))
 	for _, dnsEntry := range dnsEntries {
-		if err := s.db.UpsertDNSEntry(&dnsEntry); err != nil {
+		// Stop without marking anything as deleted if the sync is cancelled part way through
+		if err := ctx.Err(); err != nil {
+			return s.failSync(syncHistory, err)
+		}
+		seenIDs = append(seenIDs, dnsEntry.ID)
+		result, err := s.db.UpsertDNSEntry(ctx, &dnsEntry)
+		r.count(models.ResourceTypeDNS, result, err)
+		if err != nil {
 			logrus.Errorf("Failed to upsert DNS entry %s: %v", dnsEntry.Name, err)
+			s.recordError(r, syncHistory, models.ResourceTypeDNS, networkID, dnsEntry.ID, models.SyncOperationUpsert, err)
 		}
 	}
 
+	// Mark DNS entries that are no longer returned by the API as deleted
+	s.deleteMissing(r, syncHistory, models.ResourceTypeDNS, networkID, func() ([]string, error) {
+		return s.db.DeleteMissingDNSEntries(ctx, networkID, seenIDs)
+	})
+
 	// Record sync completion
-	syncHistory.Status = models.SyncStatusCompleted
-	syncHistory.CompletedAt = timePtr(time.Now())
-	return s.db.UpdateSyncHistory(syncHistory)
+	return s.completeSync(r, syncHistory)
 }
 
-// SyncACLs syncs ACLs for a network from Netmaker API to the database
+// SyncACLs syncs ACLs for a network from Netmaker API to the database as a sync run of its own
 func (s *Service) SyncACLs(ctx context.Context, networkID string) error {
+	return s.withRun(ctx, models.ResourceTypeACL, networkID, func(ctx context.Context, r *run) error {
+		return s.syncACLs(ctx, r, networkID)
+	})
+}
+
+// syncACLs syncs the ACLs as part of a run
+func (s *Service) syncACLs(ctx context.Context, r *run, networkID string) (err error) {
+	ctx, span := tracer.Start(ctx, "sync.acls", trace.WithAttributes(attribute.String("netmaker.network_id", networkID)))
+	defer func() { tracing.End(span, err) }()
+
 	// Record sync start
-	syncHistory := &models.SyncHistory{
-		ResourceType: models.ResourceTypeACL,
-		Status:       models.SyncStatusPending,
-		StartedAt:    time.Now(),
-	}
-	if err := s.db.CreateSyncHistory(syncHistory); err != nil {
+	syncHistory, err := s.startSync(r, models.ResourceTypeACL)
+	if err != nil {
 		return err
 	}
 
 	// Get ACLs from API
-	acls, err := s.apiClient.GetACLs(networkID)
+	acls, err := s.apiClient.GetACLs(ctx, networkID)
 	if err != nil {
 		// Record sync failure
-		syncHistory.Status = models.SyncStatusFailed
-		syncHistory.Message = err.Error()
-		syncHistory.CompletedAt = timePtr(time.Now())
-		s.db.UpdateSyncHistory(syncHistory)
-		return err
+		s.recordError(r, syncHistory, models.ResourceTypeACL, networkID, "", models.SyncOperationFetch, err)
+		return s.failSync(syncHistory, err)
 	}
 
 	// Upsert ACLs to database
-	if err := s.db.UpsertACLs(networkID, acls); err != nil {
+	seenIDs, counts, err := s.db.UpsertACLs(ctx, networkID, acls)
+	if err != nil {
 		logrus.Errorf("Failed to upsert ACLs for network %s: %v", networkID, err)
+		s.recordItemErrors(r, syncHistory, models.ResourceTypeACL, networkID, models.SyncOperationUpsert, err)
+	}
+	r.add(models.ResourceTypeACL, counts)
+
+	// Without the ACLs that were seen there is nothing to compare against
+	var itemErrs db.ItemErrors
+	if err != nil && !errors.As(err, &itemErrs) {
+		return s.completeSync(r, syncHistory)
 	}
 
+	// Mark ACLs that are no longer returned by the API as deleted
+	s.deleteMissing(r, syncHistory, models.ResourceTypeACL, networkID, func() ([]string, error) {
+		return s.db.DeleteMissingACLs(ctx, networkID, seenIDs)
+	})
+
 	// Record sync completion
-	syncHistory.Status = models.SyncStatusCompleted
-	syncHistory.CompletedAt = timePtr(time.Now())
-	return s.db.UpdateSyncHistory(syncHistory)
+	return s.completeSync(r, syncHistory)
 }
 
-// SyncHosts syncs hosts from Netmaker API to the database
+// SyncHosts syncs hosts from Netmaker API to the database as a sync run of its own
 func (s *Service) SyncHosts(ctx context.Context) error {
+	return s.withRun(ctx, models.ResourceTypeHost, "", func(ctx context.Context, r *run) error {
+		return s.syncHosts(ctx, r)
+	})
+}
+
+// syncHosts syncs the hosts as part of a run
+func (s *Service) syncHosts(ctx context.Context, r *run) (err error) {
+	ctx, span := tracer.Start(ctx, "sync.hosts")
+	defer func() { tracing.End(span, err) }()
+
 	// Record sync start
-	syncHistory := &models.SyncHistory{
-		ResourceType: models.ResourceTypeHost,
-		Status:       models.SyncStatusPending,
-		StartedAt:    time.Now(),
-	}
-	if err := s.db.CreateSyncHistory(syncHistory); err != nil {
+	syncHistory, err := s.startSync(r, models.ResourceTypeHost)
+	if err != nil {
 		return err
 	}
 
 	// Get hosts from API
-	hosts, err := s.apiClient.GetHosts()
+	hosts, err := s.apiClient.GetHosts(ctx)
 	if err != nil {
 		// Record sync failure
-		syncHistory.Status = models.SyncStatusFailed
-		syncHistory.Message = err.Error()
-		syncHistory.CompletedAt = timePtr(time.Now())
-		s.db.UpdateSyncHistory(syncHistory)
-		return err
+		s.recordError(r, syncHistory, models.ResourceTypeHost, "", "", models.SyncOperationFetch, err)
+		return s.failSync(syncHistory, err)
 	}
 
 	// Upsert hosts to database
+	seenIDs := make([]string, 0, len(hosts))
 	for _, host := range hosts {
-		if err := s.db.UpsertHost(&host); err != nil {
+		// Stop without marking anything as deleted if the sync is cancelled part way through
+		if err := ctx.Err(); err != nil {
+			return s.failSync(syncHistory, err)
+		}
+		seenIDs = append(seenIDs, host.ID)
+		result, err := s.db.UpsertHost(ctx, &host)
+		r.count(models.ResourceTypeHost, result, err)
+		if err != nil {
 			logrus.Errorf("Failed to upsert host %s: %v", host.ID, err)
+			s.recordError(r, syncHistory, models.ResourceTypeHost, "", host.ID, models.SyncOperationUpsert, err)
 		}
 	}
 
+	// Mark hosts that are no longer returned by the API as deleted
+	s.deleteMissing(r, syncHistory, models.ResourceTypeHost, "", func() ([]string, error) {
+		return s.db.DeleteMissingHosts(ctx, seenIDs)
+	})
+
 	// Record sync completion
-	syncHistory.Status = models.SyncStatusCompleted
-	syncHistory.CompletedAt = timePtr(time.Now())
-	return s.db.UpdateSyncHistory(syncHistory)
+	return s.completeSync(r, syncHistory)
+}
+
+// SyncEnrollmentKeys syncs enrollment keys from Netmaker API to the database as a sync
+// run of its own
+func (s *Service) SyncEnrollmentKeys(ctx context.Context) error {
+	return s.withRun(ctx, models.ResourceTypeEnrollmentKey, "", func(ctx context.Context, r *run) error {
+		return s.syncEnrollmentKeys(ctx, r)
+	})
+}
+
+// syncEnrollmentKeys syncs the enrollment keys as part of a run
+func (s *Service) syncEnrollmentKeys(ctx context.Context, r *run) (err error) {
+	ctx, span := tracer.Start(ctx, "sync.enrollment_keys")
+	defer func() { tracing.End(span, err) }()
+
+	// Record sync start
+	syncHistory, err := s.startSync(r, models.ResourceTypeEnrollmentKey)
+	if err != nil {
+		return err
+	}
+
+	// Get enrollment keys from API
+	keys, err := s.apiClient.GetEnrollmentKeys(ctx)
+	if err != nil {
+		// Record sync failure
+		s.recordError(r, syncHistory, models.ResourceTypeEnrollmentKey, "", "", models.SyncOperationFetch, err)
+		return s.failSync(syncHistory, err)
+	}
+
+	// Upsert enrollment keys to database
+	seenIDs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		// Stop without marking anything as deleted if the sync is cancelled part way through
+		if err := ctx.Err(); err != nil {
+			return s.failSync(syncHistory, err)
+		}
+		seenIDs = append(seenIDs, key.ID)
+		result, err := s.db.UpsertEnrollmentKey(ctx, &key)
+		r.count(models.ResourceTypeEnrollmentKey, result, err)
+		if err != nil {
+			logrus.Errorf("Failed to upsert enrollment key %s: %v", key.ID, err)
+			s.recordError(r, syncHistory, models.ResourceTypeEnrollmentKey, "", key.ID, models.SyncOperationUpsert, err)
+		}
+	}
+
+	// Mark enrollment keys that are no longer returned by the API as deleted
+	s.deleteMissing(r, syncHistory, models.ResourceTypeEnrollmentKey, "", func() ([]string, error) {
+		return s.db.DeleteMissingEnrollmentKeys(ctx, seenIDs)
+	})
+
+	// Record sync completion
+	return s.completeSync(r, syncHistory)
+}
+
+// SyncUsers syncs users, then the remote access gateways they are assigned to, from
+// Netmaker API to the database as a sync run of its own
+func (s *Service) SyncUsers(ctx context.Context) error {
+	return s.withRun(ctx, models.ResourceTypeUser, "", func(ctx context.Context, r *run) error {
+		if err := s.syncUsers(ctx, r); err != nil && !errors.Is(err, ErrPartialSync) {
+			return err
+		}
+		return s.syncUserGatewayAssignments(ctx, r)
+	})
+}
+
+// syncUsers syncs the users as part of a run
+func (s *Service) syncUsers(ctx context.Context, r *run) (err error) {
+	ctx, span := tracer.Start(ctx, "sync.users")
+	defer func() { tracing.End(span, err) }()
+
+	// Record sync start
+	syncHistory, err := s.startSync(r, models.ResourceTypeUser)
+	if err != nil {
+		return err
+	}
+
+	// Get users from API
+	users, err := s.apiClient.GetUsers(ctx)
+	if err != nil {
+		// Record sync failure
+		s.recordError(r, syncHistory, models.ResourceTypeUser, "", "", models.SyncOperationFetch, err)
+		return s.failSync(syncHistory, err)
+	}
+
+	// Upsert users to database
+	seenIDs := make([]string, 0, len(users))
+	for _, user := range users {
+		// Stop without marking anything as deleted if the sync is cancelled part way through
+		if err := ctx.Err(); err != nil {
+			return s.failSync(syncHistory, err)
+		}
+		seenIDs = append(seenIDs, user.ID)
+		result, err := s.db.UpsertUser(ctx, &user)
+		r.count(models.ResourceTypeUser, result, err)
+		if err != nil {
+			logrus.Errorf("Failed to upsert user %s: %v", user.ID, err)
+			s.recordError(r, syncHistory, models.ResourceTypeUser, "", user.ID, models.SyncOperationUpsert, err)
+		}
+	}
+
+	// Mark users that are no longer returned by the API as deleted
+	s.deleteMissing(r, syncHistory, models.ResourceTypeUser, "", func() ([]string, error) {
+		return s.db.DeleteMissingUsers(ctx, seenIDs)
+	})
+
+	// Record sync completion
+	return s.completeSync(r, syncHistory)
+}
+
+// syncUserGatewayAssignments derives the assignments of users to remote access gateways
+// from the remote_gw_ids of the current users, as part of a run
+func (s *Service) syncUserGatewayAssignments(ctx context.Context, r *run) (err error) {
+	ctx, span := tracer.Start(ctx, "sync.user_gateway_assignments")
+	defer func() { tracing.End(span, err) }()
+
+	// Record sync start
+	syncHistory, err := s.startSync(r, models.ResourceTypeUserGatewayAssignment)
+	if err != nil {
+		return err
+	}
+
+	users, err := s.db.GetUsers()
+	if err != nil {
+		s.recordError(r, syncHistory, models.ResourceTypeUserGatewayAssignment, "", "", models.SyncOperationFetch, err)
+		return s.failSync(syncHistory, err)
+	}
+
+	// Upsert the assignments of every user to database
+	seenIDs := []string{}
+	for _, user := range users {
+		for _, gatewayID := range user.RemoteGatewayIDs {
+			// Stop without marking anything as deleted if the sync is cancelled part way through
+			if err := ctx.Err(); err != nil {
+				return s.failSync(syncHistory, err)
+			}
+			assignment := models.UserGatewayAssignment{
+				ID:           fmt.Sprintf("%s:%s", user.ID, gatewayID),
+				Version:      1,
+				Username:     user.ID,
+				GatewayID:    gatewayID,
+				IsCurrent:    true,
+				LastModified: time.Now(),
+				CreatedAt:    time.Now(),
+				Data:         models.JSONB{"username": user.ID, "gateway_id": gatewayID},
+			}
+			seenIDs = append(seenIDs, assignment.ID)
+			result, err := s.db.UpsertUserGatewayAssignment(ctx, &assignment)
+			r.count(models.ResourceTypeUserGatewayAssignment, result, err)
+			if err != nil {
+				logrus.Errorf("Failed to upsert user gateway assignment %s: %v", assignment.ID, err)
+				s.recordError(r, syncHistory, models.ResourceTypeUserGatewayAssignment, "", assignment.ID, models.SyncOperationUpsert, err)
+			}
+		}
+	}
+
+	// Mark the assignments that the users no longer have as deleted
+	s.deleteMissing(r, syncHistory, models.ResourceTypeUserGatewayAssignment, "", func() ([]string, error) {
+		return s.db.DeleteMissingUserGatewayAssignments(ctx, seenIDs)
+	})
+
+	// Record sync completion
+	return s.completeSync(r, syncHistory)
+}
+
+// SyncServerConfig syncs the server configuration from Netmaker API to the database as
+// a sync run of its own
+func (s *Service) SyncServerConfig(ctx context.Context) error {
+	return s.withRun(ctx, models.ResourceTypeServerConfig, "", func(ctx context.Context, r *run) error {
+		return s.syncServerConfig(ctx, r)
+	})
+}
+
+// syncServerConfig syncs the server configuration as part of a run
+func (s *Service) syncServerConfig(ctx context.Context, r *run) (err error) {
+	ctx, span := tracer.Start(ctx, "sync.server_config")
+	defer func() { tracing.End(span, err) }()
+
+	// Record sync start
+	syncHistory, err := s.startSync(r, models.ResourceTypeServerConfig)
+	if err != nil {
+		return err
+	}
+
+	// Get the server configuration from API
+	serverConfig, err := s.apiClient.GetServerConfig(ctx)
+	if err != nil {
+		// Record sync failure
+		s.recordError(r, syncHistory, models.ResourceTypeServerConfig, "", "", models.SyncOperationFetch, err)
+		return s.failSync(syncHistory, err)
+	}
+
+	// Upsert the server configuration to database
+	result, err := s.db.UpsertServerConfig(ctx, serverConfig)
+	r.count(models.ResourceTypeServerConfig, result, err)
+	if err != nil {
+		logrus.Errorf("Failed to upsert server config: %v", err)
+		s.recordError(r, syncHistory, models.ResourceTypeServerConfig, "", serverConfig.ID, models.SyncOperationUpsert, err)
+	}
+
+	// Record sync completion
+	return s.completeSync(r, syncHistory)
 }
 
 // GetNetworks retrieves all networks from the database
@@ -295,3 +843,163 @@ func (s *Service) GetNetworks(ctx context.Context) ([]models.Network, error) {
 func (s *Service) GetNetwork(ctx context.Context, networkID string) (*models.Network, error) {
 	return s.db.GetNetwork(networkID)
 }
+
+// GetNetworksAsOf retrieves all networks from the database as they were at the given time
+func (s *Service) GetNetworksAsOf(ctx context.Context, asOf time.Time) ([]models.Network, error) {
+	return s.db.GetNetworksAsOf(asOf)
+}
+
+// GetNetworkStateAsOf retrieves the full state of a network from the database as it was at the given time
+func (s *Service) GetNetworkStateAsOf(ctx context.Context, networkID string, asOf time.Time) (*models.NetworkState, error) {
+	return s.db.GetNetworkStateAsOf(networkID, asOf)
+}
+
+// ListResources retrieves a filtered, sorted page of the current records of a resource
+func (s *Service) ListResources(ctx context.Context, resource string, opts db.ListOptions) (*models.Page, error) {
+	return s.db.ListResources(resource, opts)
+}
+
+// GetResource retrieves the current version of a record
+func (s *Service) GetResource(ctx context.Context, resource string, id string) (interface{}, error) {
+	return s.db.GetCurrentResource(resource, id)
+}
+
+// GetResourceHistory retrieves every version of a record, oldest first
+func (s *Service) GetResourceHistory(ctx context.Context, resource string, id string) (interface{}, error) {
+	return s.db.GetResourceHistory(resource, id)
+}
+
+// GetResourceAt retrieves a record at a specific version, as it was at asOf, or its
+// current version when neither is given
+func (s *Service) GetResourceAt(ctx context.Context, resource string, id string, version int, asOf *time.Time) (interface{}, error) {
+	switch {
+	case version > 0:
+		return s.db.GetResourceVersion(resource, id, version)
+	case asOf != nil:
+		return s.db.GetResourceAsOf(resource, id, *asOf)
+	default:
+		return s.db.GetCurrentResource(resource, id)
+	}
+}
+
+// RevealResource retrieves a record like GetResourceAt, with its encrypted fields decrypted
+func (s *Service) RevealResource(ctx context.Context, resource string, id string, version int, asOf *time.Time) (interface{}, error) {
+	record, err := s.GetResourceAt(ctx, resource, id, version, asOf)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.db.RevealRecord(record); err != nil {
+		return nil, fmt.Errorf("failed to decrypt %s %s: %w", resource, id, err)
+	}
+	return record, nil
+}
+
+// GetNodes retrieves the nodes of a network, as they were at asOf when given
+func (s *Service) GetNodes(ctx context.Context, networkID string, asOf *time.Time) ([]models.Node, error) {
+	if asOf != nil {
+		return s.db.GetNodesAsOf(networkID, *asOf)
+	}
+	return s.db.GetNodes(networkID)
+}
+
+// GetExtClients retrieves the external clients of a network, as they were at asOf when given
+func (s *Service) GetExtClients(ctx context.Context, networkID string, asOf *time.Time) ([]models.ExtClient, error) {
+	if asOf != nil {
+		return s.db.GetExtClientsAsOf(networkID, *asOf)
+	}
+	return s.db.GetExtClients(networkID)
+}
+
+// GetDNSEntries retrieves the DNS entries of a network, as they were at asOf when given
+func (s *Service) GetDNSEntries(ctx context.Context, networkID string, asOf *time.Time) ([]models.DNSEntry, error) {
+	if asOf != nil {
+		return s.db.GetDNSEntriesAsOf(networkID, *asOf)
+	}
+	return s.db.GetDNSEntries(networkID)
+}
+
+// GetACLs retrieves the ACLs of a network, as they were at asOf when given
+func (s *Service) GetACLs(ctx context.Context, networkID string, asOf *time.Time) ([]models.ACL, error) {
+	if asOf != nil {
+		return s.db.GetACLsAsOf(networkID, *asOf)
+	}
+	return s.db.GetACLs(networkID)
+}
+
+// ErrInvalidVersionRange is returned when the from version of a diff is not lower than its to version
+var ErrInvalidVersionRange = errors.New("invalid version range")
+
+// DiffResourceVersions computes the field-level changes between two versions of a resource.
+// A zero toVersion means the latest version, and a zero fromVersion means the version
+// immediately before toVersion.
+func (s *Service) DiffResourceVersions(ctx context.Context, resource string, id string, fromVersion, toVersion int) (*diff.Result, error) {
+	if toVersion == 0 {
+		latest, err := s.db.GetLatestResourceVersion(resource, id)
+		if err != nil {
+			return nil, err
+		}
+		toVersion = latest
+	}
+	if fromVersion == 0 {
+		if toVersion == 1 {
+			return nil, fmt.Errorf("%w: %s %s has no version before version 1", db.ErrVersionNotFound, resource, id)
+		}
+		fromVersion = toVersion - 1
+	}
+	if fromVersion >= toVersion {
+		return nil, fmt.Errorf("%w: from version %d must be lower than to version %d", ErrInvalidVersionRange, fromVersion, toVersion)
+	}
+
+	fromRecord, err := s.db.GetResourceVersion(resource, id, fromVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	toRecord, err := s.db.GetResourceVersion(resource, id, toVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	changes, err := diff.Compare(fromRecord, toRecord, diff.DefaultIgnoredFields...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to diff %s %s: %w", resource, id, err)
+	}
+
+	return &diff.Result{
+		Resource:         resource,
+		ID:               id,
+		FromVersion:      fromVersion,
+		ToVersion:        toVersion,
+		FromLastModified: lastModifiedOf(fromRecord),
+		ToLastModified:   lastModifiedOf(toRecord),
+		Changes:          changes,
+	}, nil
+}
+
+// lastModifiedOf returns the LastModified field of a versioned record
+func lastModifiedOf(record interface{}) time.Time {
+	switch r := record.(type) {
+	case *models.Network:
+		return r.LastModified
+	case *models.Node:
+		return r.LastModified
+	case *models.ExtClient:
+		return r.LastModified
+	case *models.Host:
+		return r.LastModified
+	case *models.EnrollmentKey:
+		return r.LastModified
+	case *models.User:
+		return r.LastModified
+	case *models.UserGatewayAssignment:
+		return r.LastModified
+	case *models.ServerConfig:
+		return r.LastModified
+	case *models.DNSEntry:
+		return r.LastModified
+	case *models.ACL:
+		return r.LastModified
+	default:
+		return time.Time{}
+	}
+}
@@ -0,0 +1,141 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	gosync "sync"
+	"testing"
+	"time"
+
+	"netmaker-sync/internal/api"
+	"netmaker-sync/internal/config"
+	"netmaker-sync/internal/db"
+
+	"github.com/sirupsen/logrus"
+)
+
+// fakeNetmaker serves the parts of the Netmaker API used by a full sync. Responses
+// default to an empty list, and the handlers of a path can be replaced between syncs.
+type fakeNetmaker struct {
+	mu        gosync.Mutex
+	responses map[string]func(w http.ResponseWriter)
+}
+
+// respond sets the JSON body returned for a path
+func (f *fakeNetmaker) respond(path string, body interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.responses[path] = func(w http.ResponseWriter) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(body)
+	}
+}
+
+// fail makes a path return an error status
+func (f *fakeNetmaker) fail(path string, status int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.responses[path] = func(w http.ResponseWriter) {
+		http.Error(w, http.StatusText(status), status)
+	}
+}
+
+func (f *fakeNetmaker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	respond, ok := f.responses[r.URL.Path]
+	f.mu.Unlock()
+	if ok {
+		respond(w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if r.URL.Path == "/api/server/getconfig" {
+		w.Write([]byte(`{}`))
+		return
+	}
+	w.Write([]byte(`[]`))
+}
+
+// newTestService creates a sync service over a fake Netmaker API and a SQLite database
+func newTestService(t *testing.T) (*Service, *db.DB, *fakeNetmaker) {
+	t.Helper()
+	logrus.SetLevel(logrus.ErrorLevel)
+
+	fake := &fakeNetmaker{responses: make(map[string]func(w http.ResponseWriter))}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	database, err := db.New(&config.DatabaseConfig{Driver: db.DriverSQLite, Path: filepath.Join(t.TempDir(), "sync.db")})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() { database.Close() })
+	if err := database.Initialize(true); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+
+	cfg := &config.SyncConfig{
+		Incremental:      true,
+		FullSyncInterval: time.Hour,
+		Concurrency:      1,
+		VolatileFields: []config.VolatileFieldConfig{
+			{ResourceType: "network", Path: "nodeslastmodified"},
+			{ResourceType: "node", Path: "lastcheckin"},
+			{ResourceType: "node", Path: "lastmodified"},
+		},
+		HeartbeatStaleAfter: 15 * time.Minute,
+	}
+	database.SetVolatileFields(cfg.VolatileFields)
+
+	apiClient := api.New(&config.NetmakerAPIConfig{URL: server.URL, Key: "test"}, &config.LoggingConfig{DisableRestyDebug: true})
+	return New(apiClient, database, cfg), database, fake
+}
+
+// syncAll runs a full sync and fails the test if it could not run
+func syncAll(t *testing.T, s *Service, includeAcls bool) error {
+	t.Helper()
+	err := s.SyncAll(context.Background(), includeAcls)
+	if err != nil && !errors.Is(err, ErrPartialSync) {
+		t.Fatalf("sync failed: %v", err)
+	}
+	return err
+}
+
+func TestSyncACLsKeepsACLsWhenTheAPIFails(t *testing.T) {
+	s, database, fake := newTestService(t)
+	fake.respond("/api/networks", []interface{}{network("net1", 1000)})
+	fake.respond("/api/nodes/net1", []interface{}{node("n1", "net1", 1000)})
+	fake.respond("/api/networks/net1/acls", map[string]map[string]int{"n1": {"n1": 2}})
+	syncAll(t, s, true)
+
+	tests := []struct {
+		name   string
+		status int
+	}{
+		{name: "server error", status: http.StatusInternalServerError},
+		{name: "not found", status: http.StatusNotFound},
+		{name: "unauthorized", status: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake.fail("/api/networks/net1/acls", tt.status)
+			if err := s.SyncACLs(context.Background(), "net1"); err == nil {
+				t.Fatal("got no error for a failed ACL fetch")
+			}
+
+			history, err := database.GetACLHistory("net1:n1:n1")
+			if err != nil {
+				t.Fatalf("failed to get ACL history: %v", err)
+			}
+			if len(history) != 1 || history[0].IsDeleted {
+				t.Fatalf("got ACL history %+v, want a single live version", history)
+			}
+		})
+	}
+}
@@ -0,0 +1,339 @@
+// Package webhooks delivers change events and sync failures to subscribed HTTP endpoints
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"netmaker-sync/internal/config"
+	"netmaker-sync/internal/db"
+	"netmaker-sync/internal/models"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Headers sent with every delivery
+const (
+	HeaderEvent     = "X-Netmaker-Sync-Event"
+	HeaderDelivery  = "X-Netmaker-Sync-Delivery"
+	HeaderTimestamp = "X-Netmaker-Sync-Timestamp"
+	HeaderSignature = "X-Netmaker-Sync-Signature"
+)
+
+// claimBatchSize is the maximum number of deliveries sent per poll
+const claimBatchSize = 50
+
+// subscriptionCacheTTL is how long the enabled subscriptions are matched against events
+// before they are loaded again, which picks up changes made through other replicas
+const subscriptionCacheTTL = 30 * time.Second
+
+// Dispatcher matches events against webhook subscriptions, queues deliveries and sends them
+type Dispatcher struct {
+	db     db.WebhookStore
+	cfg    *config.WebhooksConfig
+	client *http.Client
+
+	// The enabled subscriptions, cached so that events are not each matched with a query
+	mu                  sync.Mutex
+	subscriptions       []models.WebhookSubscription
+	subscriptionsLoaded time.Time
+}
+
+// New creates a new webhook dispatcher
+func New(database db.WebhookStore, cfg *config.WebhooksConfig) *Dispatcher {
+	return &Dispatcher{
+		db:     database,
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.Timeout},
+	}
+}
+
+// Start sends queued deliveries until ctx is cancelled
+func (d *Dispatcher) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(d.cfg.PollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				d.sendDue(ctx)
+			}
+		}
+	}()
+}
+
+// Publish queues a delivery of a change event for every matching subscription. The
+// database calls it once a new version is committed.
+func (d *Dispatcher) Publish(event models.ChangeEvent) {
+	d.enqueue(event.Kind, func(subscription models.WebhookSubscription) bool {
+		return matchesChange(subscription, event)
+	}, map[string]interface{}{
+		"change": event,
+	})
+}
+
+// PublishSyncFailure queues a delivery of a sync failure for every matching subscription
+func (d *Dispatcher) PublishSyncFailure(history models.SyncHistory) {
+	d.enqueue(models.WebhookEventSyncFailed, func(subscription models.WebhookSubscription) bool {
+		return matchesSyncFailure(subscription, history)
+	}, map[string]interface{}{
+		"sync": history,
+	})
+}
+
+// enqueue stores a pending delivery of the payload for each enabled subscription accepted by match
+func (d *Dispatcher) enqueue(eventType string, match func(models.WebhookSubscription) bool, body map[string]interface{}) {
+	subscriptions, err := d.enabledSubscriptions()
+	if err != nil {
+		logrus.Errorf("Failed to load webhook subscriptions: %v", err)
+		return
+	}
+
+	body["event_type"] = eventType
+	body["timestamp"] = time.Now().UTC()
+
+	// Round-trip through JSON so the payload can be stored as JSONB
+	payload := models.JSONB{}
+	bodyBytes, err := json.Marshal(body)
+	if err == nil {
+		err = json.Unmarshal(bodyBytes, &payload)
+	}
+	if err != nil {
+		logrus.Errorf("Failed to build %s webhook payload: %v", eventType, err)
+		return
+	}
+
+	for _, subscription := range subscriptions {
+		if !matchesEventType(subscription, eventType) || !match(subscription) {
+			continue
+		}
+
+		delivery := &models.WebhookDelivery{
+			SubscriptionID: subscription.ID,
+			EventType:      eventType,
+			Payload:        payload,
+			Status:         models.DeliveryStatusPending,
+		}
+		if err := d.db.CreateWebhookDelivery(delivery); err != nil {
+			logrus.Errorf("Failed to queue %s webhook for subscription %d: %v", eventType, subscription.ID, err)
+			continue
+		}
+		logrus.Debugf("Queued %s webhook delivery %d for subscription %d", eventType, delivery.ID, subscription.ID)
+	}
+}
+
+// enabledSubscriptions returns the enabled subscriptions, loading them again once the cache has expired
+func (d *Dispatcher) enabledSubscriptions() ([]models.WebhookSubscription, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.subscriptions != nil && time.Since(d.subscriptionsLoaded) < subscriptionCacheTTL {
+		return d.subscriptions, nil
+	}
+
+	subscriptions, err := d.db.GetWebhookSubscriptions(true)
+	if err != nil {
+		return nil, err
+	}
+	if subscriptions == nil {
+		subscriptions = []models.WebhookSubscription{}
+	}
+	d.subscriptions = subscriptions
+	d.subscriptionsLoaded = time.Now()
+	return subscriptions, nil
+}
+
+// invalidateSubscriptions makes the next event load the enabled subscriptions again
+func (d *Dispatcher) invalidateSubscriptions() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.subscriptions = nil
+}
+
+// sendDue claims and sends every delivery whose next attempt is due
+func (d *Dispatcher) sendDue(ctx context.Context) {
+	// Hold the claim for longer than a single request can take
+	deliveries, err := d.db.ClaimDueWebhookDeliveries(claimBatchSize, 2*d.cfg.Timeout)
+	if err != nil {
+		logrus.Errorf("Failed to claim webhook deliveries: %v", err)
+		return
+	}
+
+	for i := range deliveries {
+		if ctx.Err() != nil {
+			return
+		}
+		d.send(ctx, &deliveries[i])
+	}
+}
+
+// send makes one delivery attempt and records its outcome
+func (d *Dispatcher) send(ctx context.Context, delivery *models.WebhookDelivery) {
+	subscription, err := d.db.GetWebhookSubscription(delivery.SubscriptionID)
+	if err != nil {
+		logrus.Errorf("Failed to load webhook subscription %d for delivery %d: %v", delivery.SubscriptionID, delivery.ID, err)
+		return
+	}
+
+	delivery.Attempts++
+	statusCode, err := d.post(ctx, subscription, delivery)
+	if statusCode != 0 {
+		delivery.LastStatusCode = &statusCode
+	}
+
+	switch {
+	case err == nil:
+		now := time.Now()
+		delivery.Status = models.DeliveryStatusDelivered
+		delivery.DeliveredAt = &now
+		delivery.LastError = nil
+		logrus.Infof("Delivered %s webhook %d to subscription %d", delivery.EventType, delivery.ID, subscription.ID)
+
+	case delivery.Attempts >= d.cfg.MaxAttempts:
+		message := err.Error()
+		delivery.Status = models.DeliveryStatusFailed
+		delivery.LastError = &message
+		logrus.Errorf("Giving up on %s webhook %d to subscription %d after %d attempts: %v",
+			delivery.EventType, delivery.ID, subscription.ID, delivery.Attempts, err)
+
+	default:
+		message := err.Error()
+		delivery.LastError = &message
+		delivery.NextAttemptAt = time.Now().Add(d.backoff(delivery.Attempts))
+		logrus.Warnf("Failed to deliver %s webhook %d to subscription %d (attempt %d), retrying at %s: %v",
+			delivery.EventType, delivery.ID, subscription.ID, delivery.Attempts,
+			delivery.NextAttemptAt.Format(time.RFC3339), err)
+	}
+
+	if err := d.db.UpdateWebhookDelivery(delivery); err != nil {
+		logrus.Errorf("Failed to record outcome of webhook delivery %d: %v", delivery.ID, err)
+	}
+}
+
+// post sends the signed payload to the subscriber and returns the response status code
+func (d *Dispatcher) post(ctx context.Context, subscription *models.WebhookSubscription, delivery *models.WebhookDelivery) (int, error) {
+	body, err := json.Marshal(delivery.Payload)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal payload: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, subscription.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "netmaker-sync")
+	req.Header.Set(HeaderEvent, delivery.EventType)
+	req.Header.Set(HeaderDelivery, strconv.Itoa(delivery.ID))
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, Sign(subscription.Secret, timestamp, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("subscriber responded with %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// backoff returns the delay before the next attempt, doubling from the initial backoff up to the maximum
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	delay := d.cfg.InitialBackoff
+	for i := 1; i < attempts && delay < d.cfg.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > d.cfg.MaxBackoff {
+		delay = d.cfg.MaxBackoff
+	}
+	return delay
+}
+
+// Sign computes the signature header value for a payload. Subscribers verify a delivery by
+// computing the HMAC-SHA256 of "<timestamp>.<body>" with their secret and comparing it to
+// the hex digest after the "sha256=" prefix.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// matchesEventType reports whether a subscription wants events of the given type.
+// An empty list matches every event type.
+func matchesEventType(subscription models.WebhookSubscription, eventType string) bool {
+	return len(subscription.EventTypes) == 0 || contains(subscription.EventTypes, eventType)
+}
+
+// matchesChange reports whether a change event passes the resource type, network and changed field filters
+func matchesChange(subscription models.WebhookSubscription, event models.ChangeEvent) bool {
+	if len(subscription.ResourceTypes) > 0 && !contains(subscription.ResourceTypes, event.ResourceType) {
+		return false
+	}
+
+	if len(subscription.NetworkIDs) > 0 && !contains(subscription.NetworkIDs, event.NetworkID) {
+		return false
+	}
+
+	if len(subscription.ChangedFields) > 0 {
+		for _, field := range subscription.ChangedFields {
+			if changedField(event.ChangedFields, field) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return true
+}
+
+// matchesSyncFailure reports whether a sync failure passes the resource type filter. The
+// network and changed field filters only apply to change events.
+func matchesSyncFailure(subscription models.WebhookSubscription, history models.SyncHistory) bool {
+	return len(subscription.ResourceTypes) == 0 || contains(subscription.ResourceTypes, history.ResourceType)
+}
+
+// changedField reports whether a field filter matches any changed path. Filters may be
+// given with or without the leading slash (e.g. "connected" or "/data/connected"), and a
+// filter also matches changes nested below it.
+func changedField(changedPaths []string, field string) bool {
+	if !strings.HasPrefix(field, "/") {
+		field = "/" + field
+	}
+
+	for _, path := range changedPaths {
+		if path == field || strings.HasPrefix(path, field+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// contains reports whether a list contains a value
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,243 @@
+package webhooks
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"netmaker-sync/internal/config"
+	"netmaker-sync/internal/db"
+	"netmaker-sync/internal/events"
+	"netmaker-sync/internal/models"
+
+	"github.com/sirupsen/logrus"
+)
+
+// newTestDispatcher creates a dispatcher over a migrated SQLite database that is
+// notified of every new version written to it
+func newTestDispatcher(t *testing.T) (*Dispatcher, *db.DB) {
+	t.Helper()
+	logrus.SetLevel(logrus.ErrorLevel)
+
+	database, err := db.New(&config.DatabaseConfig{Driver: db.DriverSQLite, Path: filepath.Join(t.TempDir(), "sync.db")})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() { database.Close() })
+	if err := database.Initialize(true); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+
+	dispatcher := New(database, &config.WebhooksConfig{MaxAttempts: 1, Timeout: time.Second, PollInterval: time.Hour})
+	database.SetSyncFailurePublisher(dispatcher)
+	return dispatcher, database
+}
+
+// createSubscription registers a subscription to the created events of a resource type
+func createSubscription(t *testing.T, d *Dispatcher, resourceType string) int {
+	t.Helper()
+
+	subscription := &models.WebhookSubscription{
+		Name:          resourceType,
+		URL:           "http://127.0.0.1:1/hook",
+		EventTypes:    models.StringList{models.ChangeKindCreated},
+		ResourceTypes: models.StringList{resourceType},
+		Enabled:       true,
+	}
+	if err := d.CreateSubscription(subscription); err != nil {
+		t.Fatalf("failed to create subscription: %v", err)
+	}
+	return subscription.ID
+}
+
+// upsertNetworks writes the first version of count networks
+func upsertNetworks(t *testing.T, database *db.DB, prefix string, count int) {
+	t.Helper()
+
+	for i := 0; i < count; i++ {
+		network := &models.Network{ID: fmt.Sprintf("%s%d", prefix, i), Name: "net", Data: models.JSONB{"index": i}}
+		if _, err := database.UpsertNetwork(context.Background(), network); err != nil {
+			t.Fatalf("failed to upsert network: %v", err)
+		}
+	}
+}
+
+// countDeliveries returns the number of deliveries queued for a subscription
+func countDeliveries(t *testing.T, d *Dispatcher, subscriptionID int) int {
+	t.Helper()
+
+	deliveries, err := d.GetDeliveries(subscriptionID, 10000)
+	if err != nil {
+		t.Fatalf("failed to get deliveries: %v", err)
+	}
+	return len(deliveries)
+}
+
+func TestDispatcherQueuesEveryChangeDespiteSlowBrokerSubscribers(t *testing.T) {
+	dispatcher, database := newTestDispatcher(t)
+
+	// A broker subscriber that never reads drops all but the first event
+	broker := events.NewBroker(1)
+	_, unsubscribe := broker.Subscribe()
+	defer unsubscribe()
+	database.SetPublisher(dispatcher, broker)
+
+	subscriptionID := createSubscription(t, dispatcher, models.ResourceTypeNetwork)
+	count := 2*events.DefaultBufferSize + 1
+	upsertNetworks(t, database, "net", count)
+
+	if got := countDeliveries(t, dispatcher, subscriptionID); got != count {
+		t.Errorf("got %d deliveries, want one for each of the %d networks", got, count)
+	}
+}
+
+func TestDispatcherMatchesSubscriptionChangesImmediately(t *testing.T) {
+	dispatcher, database := newTestDispatcher(t)
+	database.SetPublisher(dispatcher)
+
+	first := createSubscription(t, dispatcher, models.ResourceTypeNetwork)
+	upsertNetworks(t, database, "a", 1)
+
+	// The cached subscriptions must not hide a subscription created afterwards
+	second := createSubscription(t, dispatcher, models.ResourceTypeNetwork)
+	upsertNetworks(t, database, "b", 1)
+
+	// Nor keep matching one that has been deleted
+	if err := dispatcher.DeleteSubscription(first); err != nil {
+		t.Fatalf("failed to delete subscription: %v", err)
+	}
+	upsertNetworks(t, database, "c", 1)
+
+	if got := countDeliveries(t, dispatcher, second); got != 2 {
+		t.Errorf("got %d deliveries for the second subscription, want 2", got)
+	}
+}
+
+func TestSign(t *testing.T) {
+	body := `{"event_type":"updated"}`
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp string
+		body      string
+		want      string
+	}{
+		{
+			name:      "payload",
+			secret:    "secret",
+			timestamp: "1700000000",
+			body:      body,
+			want:      "sha256=3fd7e1ddd3cb8ec40aeb188dd47f745e15814ca8c978ff359addb02e06abe0ad",
+		},
+		{
+			name:      "other timestamp",
+			secret:    "secret",
+			timestamp: "1700000001",
+			body:      body,
+			want:      "sha256=4849b9eb62a8a61116b543b065236b75b0a67bf2cf95378f130c859d62a3a6e8",
+		},
+		{
+			name:      "other secret",
+			secret:    "other",
+			timestamp: "1700000000",
+			body:      body,
+			want:      "sha256=b0de7d0b761ebbd017c9fde98fd968d7f88a68ac34b2e4ea911b7c3959509c11",
+		},
+		{
+			name:      "empty",
+			timestamp: "0",
+			want:      "sha256=b849d5a581847b281957065739df36df2463d1977ea8d6e1e4e6cf33fadc68c3",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Sign(tt.secret, tt.timestamp, []byte(tt.body)); got != tt.want {
+				t.Errorf("Sign() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchesChange(t *testing.T) {
+	event := models.ChangeEvent{
+		ResourceType:  models.ResourceTypeNode,
+		NetworkID:     "net1",
+		Kind:          models.ChangeKindUpdated,
+		ChangedFields: []string{"/data/connected", "/data/wireguard/port"},
+	}
+
+	tests := []struct {
+		name         string
+		subscription models.WebhookSubscription
+		want         bool
+	}{
+		{name: "no filters", want: true},
+		{name: "event type", subscription: models.WebhookSubscription{EventTypes: models.StringList{models.ChangeKindUpdated}}, want: true},
+		{name: "other event type", subscription: models.WebhookSubscription{EventTypes: models.StringList{models.ChangeKindDeleted}}},
+		{name: "resource type", subscription: models.WebhookSubscription{ResourceTypes: models.StringList{models.ResourceTypeHost, models.ResourceTypeNode}}, want: true},
+		{name: "other resource type", subscription: models.WebhookSubscription{ResourceTypes: models.StringList{models.ResourceTypeHost}}},
+		{name: "network", subscription: models.WebhookSubscription{NetworkIDs: models.StringList{"net1"}}, want: true},
+		{name: "other network", subscription: models.WebhookSubscription{NetworkIDs: models.StringList{"net2"}}},
+		{name: "changed field without slash", subscription: models.WebhookSubscription{ChangedFields: models.StringList{"data/connected"}}, want: true},
+		{name: "changed field with slash", subscription: models.WebhookSubscription{ChangedFields: models.StringList{"/data/connected"}}, want: true},
+		{name: "parent of a changed field", subscription: models.WebhookSubscription{ChangedFields: models.StringList{"data/wireguard"}}, want: true},
+		{name: "any of the changed fields", subscription: models.WebhookSubscription{ChangedFields: models.StringList{"name", "data/connected"}}, want: true},
+		{name: "unchanged field", subscription: models.WebhookSubscription{ChangedFields: models.StringList{"data/name"}}},
+		{name: "field sharing a prefix", subscription: models.WebhookSubscription{ChangedFields: models.StringList{"data/connect"}}},
+		{
+			name: "every filter",
+			subscription: models.WebhookSubscription{
+				EventTypes:    models.StringList{models.ChangeKindUpdated},
+				ResourceTypes: models.StringList{models.ResourceTypeNode},
+				NetworkIDs:    models.StringList{"net1"},
+				ChangedFields: models.StringList{"data/connected"},
+			},
+			want: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := matchesEventType(tt.subscription, event.Kind) && matchesChange(tt.subscription, event)
+			if got != tt.want {
+				t.Errorf("matches = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchesSyncFailure(t *testing.T) {
+	history := models.SyncHistory{ResourceType: models.ResourceTypeNode}
+
+	tests := []struct {
+		name         string
+		subscription models.WebhookSubscription
+		want         bool
+	}{
+		{name: "no filters", want: true},
+		{name: "sync failures", subscription: models.WebhookSubscription{EventTypes: models.StringList{models.WebhookEventSyncFailed}}, want: true},
+		{name: "change events only", subscription: models.WebhookSubscription{EventTypes: models.StringList{models.ChangeKindCreated}}},
+		{name: "resource type", subscription: models.WebhookSubscription{ResourceTypes: models.StringList{models.ResourceTypeNode}}, want: true},
+		{name: "other resource type", subscription: models.WebhookSubscription{ResourceTypes: models.StringList{models.ResourceTypeHost}}},
+		{
+			name: "change filters do not apply",
+			subscription: models.WebhookSubscription{
+				NetworkIDs:    models.StringList{"net2"},
+				ChangedFields: models.StringList{"data/connected"},
+			},
+			want: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := matchesEventType(tt.subscription, models.WebhookEventSyncFailed) && matchesSyncFailure(tt.subscription, history)
+			if got != tt.want {
+				t.Errorf("matches = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package webhooks
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"netmaker-sync/internal/models"
+)
+
+// ErrInvalidSubscription is returned when a webhook subscription fails validation
+var ErrInvalidSubscription = errors.New("invalid webhook subscription")
+
+// validEventTypes are the event types a subscription can filter on
+var validEventTypes = []string{
+	models.ChangeKindCreated,
+	models.ChangeKindUpdated,
+	models.ChangeKindDeleted,
+	models.WebhookEventSyncFailed,
+}
+
+// CreateSubscription validates and stores a new subscription. A random secret is
+// generated if none is given.
+func (d *Dispatcher) CreateSubscription(subscription *models.WebhookSubscription) error {
+	if err := validate(subscription); err != nil {
+		return err
+	}
+
+	if subscription.Secret == "" {
+		secret, err := generateSecret()
+		if err != nil {
+			return err
+		}
+		subscription.Secret = secret
+	}
+
+	if err := d.db.CreateWebhookSubscription(subscription); err != nil {
+		return err
+	}
+	d.invalidateSubscriptions()
+	return nil
+}
+
+// UpdateSubscription validates and stores changes to an existing subscription. The
+// existing secret is kept if none is given.
+func (d *Dispatcher) UpdateSubscription(subscription *models.WebhookSubscription) error {
+	if err := validate(subscription); err != nil {
+		return err
+	}
+
+	if subscription.Secret == "" {
+		existing, err := d.db.GetWebhookSubscription(subscription.ID)
+		if err != nil {
+			return err
+		}
+		subscription.Secret = existing.Secret
+	}
+
+	if err := d.db.UpdateWebhookSubscription(subscription); err != nil {
+		return err
+	}
+	d.invalidateSubscriptions()
+	return nil
+}
+
+// DeleteSubscription deletes a subscription and its delivery log
+func (d *Dispatcher) DeleteSubscription(id int) error {
+	if err := d.db.DeleteWebhookSubscription(id); err != nil {
+		return err
+	}
+	d.invalidateSubscriptions()
+	return nil
+}
+
+// GetSubscription retrieves a subscription by ID
+func (d *Dispatcher) GetSubscription(id int) (*models.WebhookSubscription, error) {
+	return d.db.GetWebhookSubscription(id)
+}
+
+// GetSubscriptions retrieves all subscriptions
+func (d *Dispatcher) GetSubscriptions() ([]models.WebhookSubscription, error) {
+	return d.db.GetWebhookSubscriptions(false)
+}
+
+// GetDeliveries retrieves the most recent deliveries for a subscription
+func (d *Dispatcher) GetDeliveries(subscriptionID int, limit int) ([]models.WebhookDelivery, error) {
+	if _, err := d.db.GetWebhookSubscription(subscriptionID); err != nil {
+		return nil, err
+	}
+	return d.db.GetWebhookDeliveries(subscriptionID, limit)
+}
+
+// validate checks that a subscription has a usable URL and known event types
+func validate(subscription *models.WebhookSubscription) error {
+	parsed, err := url.Parse(subscription.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("%w: url must be an absolute http or https URL", ErrInvalidSubscription)
+	}
+
+	for _, eventType := range subscription.EventTypes {
+		if !contains(validEventTypes, eventType) {
+			return fmt.Errorf("%w: unknown event type %q, expected one of %v", ErrInvalidSubscription, eventType, validEventTypes)
+		}
+	}
+
+	return nil
+}
+
+// generateSecret returns a random secret for signing payloads
+func generateSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("failed to generate webhook secret: %w", err)
+	}
+	return hex.EncodeToString(secret), nil
+}
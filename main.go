@@ -3,14 +3,25 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"netmaker-sync/internal/api"
+	"netmaker-sync/internal/auth"
 	"netmaker-sync/internal/config"
 	"netmaker-sync/internal/db"
+	"netmaker-sync/internal/events"
+	"netmaker-sync/internal/models"
+	"netmaker-sync/internal/secrets"
 	"netmaker-sync/internal/service"
 	"netmaker-sync/internal/sync"
+	"netmaker-sync/internal/tracing"
+	"netmaker-sync/internal/webhooks"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
+	"text/tabwriter"
 	"time"
 
 	"github.com/robfig/cron/v3"
@@ -56,6 +67,9 @@ func main() {
 
 	serveCommand := serveCmd()
 	rootCmd.AddCommand(serveCommand)
+	rootCmd.AddCommand(listenCmd())
+	rootCmd.AddCommand(migrateCmd())
+	rootCmd.AddCommand(secretsCmd())
 
 	if err := rootCmd.Execute(); err != nil {
 		logrus.Fatal(err)
@@ -81,29 +95,69 @@ func serveCmd() *cobra.Command {
 			}
 			setLogLevel(logLevel)
 
+			// Export the traces of sync runs, Netmaker API calls and database writes
+			shutdownTracing, err := tracing.Setup(context.Background(), &cfg.Tracing)
+			if err != nil {
+				logrus.Fatal(err)
+			}
+
 			// Initialize database
 			database, err := db.New(&cfg.Database)
 			if err != nil {
 				logrus.Fatal(err)
 			}
 
-			if err := database.Initialize(); err != nil {
+			if err := database.Initialize(cfg.Database.AutoMigrate); err != nil {
 				logrus.Fatal(err)
 			}
 
+			// Drop, hash or encrypt the sensitive fields before they are stored
+			fieldProtector, err := secrets.New(&cfg.Secrets)
+			if err != nil {
+				logrus.Fatal(err)
+			}
+			database.SetFieldProtector(fieldProtector)
+
+			// Keep heartbeats and change markers out of the version history
+			database.SetVolatileFields(cfg.Sync.VolatileFields)
+
+			// Deliver change events and sync failures to webhook subscribers. Deliveries are
+			// queued as soon as a new version is written, so none are lost to a slow consumer.
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			dispatcher := webhooks.New(database, &cfg.Webhooks)
+			database.SetSyncFailurePublisher(dispatcher)
+			dispatcher.Start(ctx)
+
+			// Publish a change event for every new version written to the database
+			broker := events.NewBroker(events.DefaultBufferSize)
+			database.SetPublisher(dispatcher, broker)
+
 			// Initialize API client
 			apiClient := api.New(&cfg.NetmakerAPI, &cfg.Logging)
 
 			// Initialize sync service
-			syncService := sync.New(apiClient, database)
+			syncService := sync.New(apiClient, database, &cfg.Sync)
+			syncService.UpdateTopologyMetrics()
+
+			// Initialize HTTP API authentication
+			authenticator, err := auth.New(ctx, database, &cfg.Auth)
+			if err != nil {
+				logrus.Fatal(err)
+			}
 
 			// Initialize HTTP server
-			server := service.New(syncService, cfg)
+			server := service.New(syncService, broker, dispatcher, authenticator, cfg)
 
-			// Initialize cron scheduler
+			// Initialize cron scheduler. A scheduled sync is skipped while another run, from
+			// this replica or another one, is still going, and shutting down cancels it.
 			c := cron.New()
 			_, err = c.AddFunc("@every "+cfg.Sync.Interval.String(), func() {
-				if err := syncService.SyncAll(context.Background(), cfg.Sync.IncludeAcls); err != nil {
+				if err := syncService.SyncAll(ctx, cfg.Sync.IncludeAcls); err != nil {
+					if errors.Is(err, db.ErrSyncInProgress) {
+						logrus.Warn("Another sync run is still in progress, skipping this one")
+						return
+					}
 					logrus.Errorf("Scheduled sync failed: %v", err)
 				}
 			})
@@ -128,12 +182,249 @@ func serveCmd() *cobra.Command {
 			// Shutdown gracefully
 			logrus.Info("Shutting down...")
 			c.Stop()
+			cancel()
+			syncService.CancelJobs()
 
 			// Allow some time for ongoing operations to complete
 			time.Sleep(1 * time.Second)
+
+			// Flush the spans not exported yet
+			shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancelShutdown()
+			if err := shutdownTracing(shutdownCtx); err != nil {
+				logrus.Warnf("Failed to flush traces: %v", err)
+			}
 		},
 	}
 
 	cmd.Flags().StringVarP(&logLevel, "log-level", "l", "info", "Set the log level (trace, debug, info, warn, error, fatal)")
 	return cmd
 }
+
+func listenCmd() *cobra.Command {
+	var logLevel string
+	var channel string
+
+	cmd := &cobra.Command{
+		Use:   "listen",
+		Short: "Tail change notifications sent by the sync daemon through Postgres LISTEN/NOTIFY",
+		Run: func(cmd *cobra.Command, args []string) {
+			// Load config
+			cfg, err := config.Load()
+			if err != nil {
+				logrus.Fatal(err)
+			}
+
+			// Set log level from config if not overridden by flag
+			if !cmd.Flags().Changed("log-level") {
+				logLevel = cfg.Logging.Level
+			}
+			setLogLevel(logLevel)
+
+			// Override the notify channel if requested
+			if cmd.Flags().Changed("channel") {
+				cfg.Database.NotifyChannel = channel
+			}
+
+			// Initialize database
+			database, err := db.New(&cfg.Database)
+			if err != nil {
+				logrus.Fatal(err)
+			}
+			defer database.Close()
+
+			// Stop listening on interrupt
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			// Print each notification as a JSON line
+			err = database.Listen(ctx, func(notification models.ChangeNotification) {
+				line, err := json.Marshal(notification)
+				if err != nil {
+					logrus.Errorf("Failed to marshal notification: %v", err)
+					return
+				}
+				fmt.Println(string(line))
+			})
+			if err != nil {
+				logrus.Fatal(err)
+			}
+		},
+	}
+
+	cmd.Flags().StringVarP(&logLevel, "log-level", "l", "info", "Set the log level (trace, debug, info, warn, error, fatal)")
+	cmd.Flags().StringVarP(&channel, "channel", "c", "", "Notification channel to listen on (defaults to database.notify_channel)")
+	return cmd
+}
+
+func migrateCmd() *cobra.Command {
+	var logLevel string
+
+	cmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Manage the database schema",
+	}
+	cmd.PersistentFlags().StringVarP(&logLevel, "log-level", "l", "info", "Set the log level (trace, debug, info, warn, error, fatal)")
+
+	// openDatabase loads the config and connects to the database
+	openDatabase := func(cmd *cobra.Command) *db.DB {
+		cfg, err := config.Load()
+		if err != nil {
+			logrus.Fatal(err)
+		}
+
+		// Set log level from config if not overridden by flag
+		if !cmd.Flags().Changed("log-level") {
+			logLevel = cfg.Logging.Level
+		}
+		setLogLevel(logLevel)
+
+		database, err := db.New(&cfg.Database)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		return database
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "up",
+		Short: "Apply all pending migrations",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			database := openDatabase(cmd)
+			defer database.Close()
+
+			applied, err := database.MigrateUp()
+			if err != nil {
+				logrus.Fatal(err)
+			}
+			logrus.Infof("Applied %d migration(s)", applied)
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "down [N]",
+		Short: "Revert the last N applied migrations (default 1)",
+		Args:  cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			steps := 1
+			if len(args) == 1 {
+				n, err := strconv.Atoi(args[0])
+				if err != nil || n < 1 {
+					logrus.Fatalf("Invalid number of migrations '%s'", args[0])
+				}
+				steps = n
+			}
+
+			database := openDatabase(cmd)
+			defer database.Close()
+
+			reverted, err := database.MigrateDown(steps)
+			if err != nil {
+				logrus.Fatal(err)
+			}
+			logrus.Infof("Reverted %d migration(s)", reverted)
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "goto N",
+		Short: "Apply or revert migrations until the schema is at version N (0 reverts everything)",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			version, err := strconv.Atoi(args[0])
+			if err != nil || version < 0 {
+				logrus.Fatalf("Invalid migration version '%s'", args[0])
+			}
+
+			database := openDatabase(cmd)
+			defer database.Close()
+
+			changed, err := database.MigrateTo(version)
+			if err != nil {
+				logrus.Fatal(err)
+			}
+			logrus.Infof("Schema at version %d (%d migration(s) applied or reverted)", version, changed)
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "status",
+		Short: "Show which migrations have been applied",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			database := openDatabase(cmd)
+			defer database.Close()
+
+			states, err := database.MigrationStatus()
+			if err != nil {
+				logrus.Fatal(err)
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "VERSION\tNAME\tSTATUS\tAPPLIED AT")
+			for _, state := range states {
+				status, appliedAt := "pending", ""
+				if state.Applied {
+					status = "applied"
+					appliedAt = state.AppliedAt.Format(time.RFC3339)
+					if state.AppliedChecksum != state.Checksum {
+						status = "checksum mismatch"
+					}
+				}
+				fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", state.Version, state.Name, status, appliedAt)
+			}
+			w.Flush()
+		},
+	})
+
+	return cmd
+}
+
+func secretsCmd() *cobra.Command {
+	var logLevel string
+
+	cmd := &cobra.Command{
+		Use:   "secrets",
+		Short: "Manage the encrypted fields",
+	}
+	cmd.PersistentFlags().StringVarP(&logLevel, "log-level", "l", "info", "Set the log level (trace, debug, info, warn, error, fatal)")
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "rotate",
+		Short: "Re-encrypt the stored data keys under the first key of secrets.encryption_keys",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			cfg, err := config.Load()
+			if err != nil {
+				logrus.Fatal(err)
+			}
+
+			// Set log level from config if not overridden by flag
+			if !cmd.Flags().Changed("log-level") {
+				logLevel = cfg.Logging.Level
+			}
+			setLogLevel(logLevel)
+
+			fieldProtector, err := secrets.New(&cfg.Secrets)
+			if err != nil {
+				logrus.Fatal(err)
+			}
+
+			database, err := db.New(&cfg.Database)
+			if err != nil {
+				logrus.Fatal(err)
+			}
+			defer database.Close()
+			database.SetFieldProtector(fieldProtector)
+
+			updated, err := database.RewrapSecrets()
+			if err != nil {
+				logrus.Fatal(err)
+			}
+			logrus.Infof("Re-encrypted the data keys of %d record version(s)", updated)
+		},
+	})
+
+	return cmd
+}